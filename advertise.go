@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"net"
+	"net/url"
+	"os"
+)
+
+// advertiseHostEnv names the environment variable an orchestrator can set to
+// the address other hosts reach this instance on, like a pod IP from the
+// Kubernetes downward API.
+const advertiseHostEnv = "ETCDB_ADVERTISE_HOST"
+
+// isFlagSet reports whether the flag was given on the command line
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// detectAdvertiseUrls returns the client URLs to advertise when
+// -advertise-client-urls is left at the default: each listen URL on all
+// interfaces, with the host replaced by $ETCDB_ADVERTISE_HOST or the primary
+// interface's address. It returns nil when no listen URL is on all
+// interfaces, so the default is kept.
+func detectAdvertiseUrls(listen UrlsValue) (UrlsValue, error) {
+	var wildcard UrlsValue
+	for _, u := range listen {
+		host, _, _ := net.SplitHostPort(u.Host)
+		if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+			wildcard = append(wildcard, u)
+		}
+	}
+	if len(wildcard) == 0 {
+		return nil, nil
+	}
+
+	host := os.Getenv(advertiseHostEnv)
+	if host == "" {
+		ip, err := primaryIP()
+		if err != nil {
+			return nil, err
+		}
+		host = ip.String()
+	}
+
+	urls := make(UrlsValue, len(wildcard))
+	for i, u := range wildcard {
+		_, port, _ := net.SplitHostPort(u.Host)
+		urls[i] = url.URL{Scheme: u.Scheme, Host: net.JoinHostPort(host, port)}
+	}
+	return urls, nil
+}
+
+// primaryIP returns the first IPv4 address of an interface that is up and not
+// a loopback, or else the first such IPv6 address.
+func primaryIP() (net.IP, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	var ipv6 net.IP
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if !ok || !ipnet.IP.IsGlobalUnicast() {
+				continue
+			}
+			if ip4 := ipnet.IP.To4(); ip4 != nil {
+				return ip4, nil
+			}
+			if ipv6 == nil {
+				ipv6 = ipnet.IP
+			}
+		}
+	}
+	if ipv6 != nil {
+		return ipv6, nil
+	}
+	return nil, errors.New("no network interface address to advertise, set -advertise-client-urls or $" + advertiseHostEnv)
+}
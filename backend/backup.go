@@ -0,0 +1,203 @@
+package backend
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// BackupStats counts the snapshots written and removed by Backups, and their
+// errors, with expvar.
+var BackupStats = expvar.NewMap("backups")
+
+// A Snapshot is a copy of all of the keys as of an index, with their values
+// and TTLs in the format of BulkSet
+type Snapshot struct {
+	Index int64                `json:"index"`
+	Time  time.Time            `json:"time"`
+	Keys  map[string]BulkValue `json:"keys"`
+}
+
+// Snapshot returns a copy of the keys exactly as of the current index.
+// Directories are left out, like for Export.
+func (b *SqlBackend) Snapshot() (*Snapshot, error) {
+	root, index, err := b.GetConsistent("/", true)
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{Index: index, Time: time.Now().UTC(), Keys: exportNodes(root)}, nil
+}
+
+const snapshotTimeFormat = "20060102T150405Z"
+
+// snapshotName names the snapshot's object after its time and index, so that
+// the names sort in the order the snapshots were taken
+func snapshotName(s *Snapshot) string {
+	return fmt.Sprintf("etcdb-%s-%d.json", s.Time.Format(snapshotTimeFormat), s.Index)
+}
+
+// snapshotTime returns the time in the name of a snapshot's object, and false
+// for the names of other objects
+func snapshotTime(name string) (time.Time, bool) {
+	if !strings.HasPrefix(name, "etcdb-") || !strings.HasSuffix(name, ".json") {
+		return time.Time{}, false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(name, "etcdb-"), "-", 2)
+	t, err := time.Parse(snapshotTimeFormat, parts[0])
+	return t, err == nil
+}
+
+// WriteSnapshot writes a snapshot of the store to the object store, and
+// returns its name
+func WriteSnapshot(store *SqlBackend, objects ObjectStore) (string, error) {
+	s, err := store.Snapshot()
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	name := snapshotName(s)
+	return name, objects.Put(name, data)
+}
+
+// ListSnapshots returns the names of the snapshots in the object store, from
+// the oldest to the newest
+func ListSnapshots(objects ObjectStore) ([]string, error) {
+	names, err := objects.List()
+	if err != nil {
+		return nil, err
+	}
+	var snapshots []string
+	for _, name := range names {
+		if _, ok := snapshotTime(name); ok {
+			snapshots = append(snapshots, name)
+		}
+	}
+	return snapshots, nil
+}
+
+// ReadSnapshot reads the named snapshot from the object store, or the newest
+// one if the name is empty
+func ReadSnapshot(objects ObjectStore, name string) (*Snapshot, error) {
+	if name == "" {
+		names, err := ListSnapshots(objects)
+		if err != nil {
+			return nil, err
+		}
+		if len(names) == 0 {
+			return nil, fmt.Errorf("no snapshots found")
+		}
+		name = names[len(names)-1]
+	}
+
+	data, err := objects.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	var s Snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("invalid snapshot %s: %v", name, err)
+	}
+	return &s, nil
+}
+
+// BackupRetention limits the snapshots kept in the object store. The newest
+// snapshot is always kept.
+type BackupRetention struct {
+	// Keep is the number of the newest snapshots to keep, or all if 0
+	Keep int
+	// MaxAge removes snapshots older than it, if positive
+	MaxAge time.Duration
+}
+
+// PruneSnapshots removes the snapshots that the retention doesn't keep, and
+// returns their names
+func PruneSnapshots(objects ObjectStore, retention BackupRetention, now time.Time) ([]string, error) {
+	names, err := ListSnapshots(objects)
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	// the newest is last, and always kept
+	for i := 0; i < len(names)-1; i++ {
+		t, _ := snapshotTime(names[i])
+		tooMany := retention.Keep > 0 && len(names)-i > retention.Keep
+		tooOld := retention.MaxAge > 0 && now.Sub(t) > retention.MaxAge
+		if !tooMany && !tooOld {
+			continue
+		}
+		if err := objects.Delete(names[i]); err != nil {
+			return removed, err
+		}
+		removed = append(removed, names[i])
+	}
+	return removed, nil
+}
+
+// Backups periodically writes snapshots of the store to an object store in
+// the background, and removes the old ones.
+type Backups struct {
+	store     *SqlBackend
+	objects   ObjectStore
+	interval  time.Duration
+	retention BackupRetention
+	stop      chan struct{}
+}
+
+// StartBackups creates and starts Backups writing a snapshot every interval.
+func StartBackups(store *SqlBackend, objects ObjectStore, interval time.Duration, retention BackupRetention) *Backups {
+	bk := &Backups{
+		store:     store,
+		objects:   objects,
+		interval:  interval,
+		retention: retention,
+		stop:      make(chan struct{}),
+	}
+	go bk.Run()
+	return bk
+}
+
+// Stop stops the backup loop
+func (bk *Backups) Stop() {
+	close(bk.stop)
+}
+
+// Run writes the snapshots on schedule until stopped
+func (bk *Backups) Run() {
+	ticker := time.NewTicker(bk.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-bk.stop:
+			return
+		case <-ticker.C:
+			bk.backup()
+		}
+	}
+}
+
+func (bk *Backups) backup() {
+	start := time.Now()
+	name, err := WriteSnapshot(bk.store, bk.objects)
+	if err != nil {
+		log.Println("error writing snapshot:", err)
+		BackupStats.Add("errors", 1)
+		return
+	}
+	log.Printf("wrote snapshot %s in %v", name, time.Since(start))
+	BackupStats.Add("snapshots", 1)
+
+	removed, err := PruneSnapshots(bk.objects, bk.retention, time.Now())
+	BackupStats.Add("removed", int64(len(removed)))
+	if err != nil {
+		log.Println("error removing old snapshots:", err)
+		BackupStats.Add("errors", 1)
+	}
+}
@@ -0,0 +1,41 @@
+package backend
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func Test_WriteSnapshot(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+	dir, err := ioutil.TempDir("", "etcdb-objects")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+	objects := &fileStore{dir: dir}
+
+	_, _, err = store.Set("/dir/foo", "bar", Always)
+	ok(t, err)
+	_, _, err = store.SetTTL("/ttl", "baz", 100, Always)
+	ok(t, err)
+
+	name, err := WriteSnapshot(store, objects)
+	ok(t, err)
+
+	snapshot, err := ReadSnapshot(objects, "")
+	ok(t, err)
+	equals(t, name, snapshotName(snapshot))
+	equals(t, currIndex(store), snapshot.Index)
+	equals(t, "bar", snapshot.Keys["/dir/foo"].Value)
+	equals(t, "baz", snapshot.Keys["/ttl"].Value)
+	equals(t, true, snapshot.Keys["/ttl"].TTL != nil)
+}
+
+func Test_ReadSnapshot_None(t *testing.T) {
+	dir, err := ioutil.TempDir("", "etcdb-objects")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+
+	_, err = ReadSnapshot(&fileStore{dir: dir}, "")
+	equals(t, "no snapshots found", err.Error())
+}
@@ -0,0 +1,325 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/rancher/etcdb/models"
+)
+
+// A BulkValue is a value for BulkSet, with an optional TTL. In JSON it is
+// either the value string, or an object with the value and ttl.
+type BulkValue struct {
+	Value string `json:"value"`
+	TTL   *int64 `json:"ttl,omitempty"`
+}
+
+// UnmarshalJSON accepts either a string or an object
+func (v *BulkValue) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &v.Value); err == nil {
+		return nil
+	}
+	type bulkValue BulkValue
+	return json.Unmarshal(data, (*bulkValue)(v))
+}
+
+// Export returns all of the keys with their values and TTLs, in the format of
+// BulkSet. Directories are left out, so empty ones aren't exported.
+func (b *SqlBackend) Export() (map[string]BulkValue, error) {
+	root, err := b.Get("/", true)
+	if err != nil {
+		return nil, err
+	}
+
+	return exportNodes(root), nil
+}
+
+// exportNodes returns the keys under the root node with their values and TTLs
+func exportNodes(root *models.Node) map[string]BulkValue {
+	values := make(map[string]BulkValue)
+	var export func(nodes []*models.Node)
+	export = func(nodes []*models.Node) {
+		for _, node := range nodes {
+			if node.Dir {
+				export(node.Nodes)
+				continue
+			}
+			values[node.Key] = BulkValue{Value: node.Value, TTL: node.TTL}
+		}
+	}
+	export(root.Nodes)
+	return values
+}
+
+// MarshalJSON writes just the value string if there is no TTL
+func (v BulkValue) MarshalJSON() ([]byte, error) {
+	if v.TTL == nil {
+		return json.Marshal(v.Value)
+	}
+	type bulkValue BulkValue
+	return json.Marshal(bulkValue(v))
+}
+
+// bulkBatch is the number of keys looked up or replaced per query
+const bulkBatch = 1000
+
+// BulkSet sets all of the keys in a single transaction, in sorted order and
+// each at its own index like a series of sets. If any of them fails, none are
+// set. Instead of a series of sets, the nodes and changes are written with the
+// database's bulk loading, COPY for Postgres and multi-row INSERTs for MySQL,
+// so that restoring or importing many keys is fast.
+func (b *SqlBackend) BulkSet(values map[string]BulkValue) (res *models.BulkResult, err error) {
+	if _, ok := values["/"]; ok {
+		return nil, b.readOnlyError()
+	}
+
+	tx, err := b.Begin()
+	if err != nil {
+		return nil, err
+	}
+	qt := &quotaTx{}
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+			if err == nil {
+				b.commitQuota(qt)
+			}
+		} else {
+			tx.Rollback()
+		}
+	}()
+
+	startIndex, err := b.incrementIndex(tx)
+	if err != nil {
+		return nil, err
+	}
+	prevIndex := startIndex - 1
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// the parent directories of all the keys, which may not exist yet
+	dirs := make(map[string]bool)
+	for _, key := range keys {
+		for dir := splitKey(key); dir != "/" && dir != "" && !dirs[dir]; dir = splitKey(dir) {
+			dirs[dir] = true
+		}
+	}
+	for _, key := range keys {
+		if dirs[key] {
+			return nil, models.NotADirectory(key, prevIndex)
+		}
+	}
+
+	lookup := make([]string, 0, len(keys)+len(dirs))
+	lookup = append(lookup, keys...)
+	for dir := range dirs {
+		lookup = append(lookup, dir)
+	}
+	existing, err := b.existingNodes(tx, lookup)
+	if err != nil {
+		return nil, err
+	}
+
+	var replaced []string
+	for _, key := range keys {
+		if node, ok := existing[key]; ok {
+			if node.Dir {
+				return nil, models.NotAFile(key, prevIndex)
+			}
+			replaced = append(replaced, key)
+		}
+	}
+	var newDirs []string
+	for dir := range dirs {
+		if node, ok := existing[dir]; !ok {
+			newDirs = append(newDirs, dir)
+		} else if !node.Dir {
+			return nil, models.NotADirectory(dir, prevIndex)
+		}
+	}
+	sort.Strings(newDirs)
+
+	// mysql.NullTime is more portable and works with the Postgres driver
+	var now mysql.NullTime
+	err = tx.QueryRow(`SELECT ` + b.dialect.Now()).Scan(&now)
+	if err != nil {
+		return nil, err
+	}
+
+	// each key is set at its own index, in sorted order
+	keyIndex := make(map[string]int64, len(keys))
+	for i, key := range keys {
+		keyIndex[key] = startIndex + int64(i)
+	}
+	lastIndex := startIndex + int64(len(keys)) - 1
+
+	var changes []*Change
+	if len(b.hooks) > 0 {
+		for _, key := range keys {
+			change := &Change{
+				Action:   "set",
+				Index:    keyIndex[key],
+				Key:      key,
+				Value:    values[key].Value,
+				TTL:      values[key].TTL,
+				PrevNode: existing[key],
+			}
+			if err := b.beforeSet(tx, change); err != nil {
+				return nil, err
+			}
+			changes = append(changes, change)
+		}
+	}
+
+	for _, batch := range batches(replaced) {
+		query := b.Query().Text(`UPDATE nodes SET "deleted" = CASE "key"`)
+		for _, key := range batch {
+			query.Extend(` WHEN `, key, fmt.Sprintf(` THEN %d`, keyIndex[key]))
+		}
+		query.Text(` END WHERE "deleted" = 0 AND "key" IN (`)
+		for j, key := range batch {
+			if j > 0 {
+				query.Text(", ")
+			}
+			query.Param(key)
+		}
+		query.Text(")")
+		if _, err := query.Exec(tx); err != nil {
+			return nil, err
+		}
+	}
+
+	nodeColumns := []string{"key", "value", "dir", "created", "modified", "path_depth", "expiration"}
+	if b.checksums {
+		nodeColumns = append(nodeColumns, "checksum")
+	}
+	nodeRows := make([][]interface{}, 0, len(newDirs)+len(keys))
+	for _, dir := range newDirs {
+		nodeRows = append(nodeRows, b.withChecksum([]interface{}{dir, "", true, startIndex, startIndex, pathDepth(dir), nil}, dir, ""))
+	}
+
+	changeColumns := []string{"index", "key", "action", "prev_node_modified"}
+	changeRows := make([][]interface{}, 0, len(keys))
+
+	for _, key := range keys {
+		index := keyIndex[key]
+		v := values[key]
+		var expiration interface{}
+		if v.TTL != nil {
+			expiration = now.Time.Add(time.Duration(*v.TTL) * time.Second)
+		}
+		nodeRows = append(nodeRows, b.withChecksum([]interface{}{key, v.Value, false, index, index, pathDepth(key), expiration}, key, v.Value))
+
+		var prevModified interface{}
+		if node, ok := existing[key]; ok {
+			prevModified = node.ModifiedIndex
+		}
+		changeRows = append(changeRows, []interface{}{index, key, "set", prevModified})
+	}
+
+	if err := b.dialect.BulkInsert(tx, "nodes", nodeColumns, nodeRows); err != nil {
+		return nil, err
+	}
+	if err := b.dialect.BulkInsert(tx, "changes", changeColumns, changeRows); err != nil {
+		return nil, err
+	}
+
+	// the nodes aren't read back, so the hooks get them as inserted
+	for _, change := range changes {
+		change.Node = &models.Node{
+			Key:           change.Key,
+			Value:         change.Value,
+			CreatedIndex:  change.Index,
+			ModifiedIndex: change.Index,
+			TTL:           change.TTL,
+		}
+		if err := b.afterSet(tx, change); err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = b.Query().Extend(`UPDATE "index" SET "index" = `, lastIndex).Exec(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	deltas := make(map[string]Usage, len(keys))
+	for _, key := range keys {
+		deltas[key] = usageDelta(existing[key], values[key].Value, false)
+	}
+	if err := b.checkQuota(tx, prevIndex, qt, deltas); err != nil {
+		return nil, err
+	}
+
+	if !b.deferTrim {
+		if _, err := b.trimHistory(tx, lastIndex); err != nil {
+			return nil, err
+		}
+	}
+
+	return &models.BulkResult{Count: len(keys), Index: lastIndex}, nil
+}
+
+// existingNodes returns the current nodes for any of the keys that exist.
+func (b *SqlBackend) existingNodes(db Querier, keys []string) (map[string]*models.Node, error) {
+	nodes := make(map[string]*models.Node)
+	for _, batch := range batches(keys) {
+		query := b.queryNode().Text(` AND "key" IN (`)
+		for j, key := range batch {
+			if j > 0 {
+				query.Text(", ")
+			}
+			query.Param(key)
+		}
+		query.Text(")")
+
+		rows, err := query.Query(db)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			node, err := scanNode(rows)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			nodes[node.Key] = node
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nodes, nil
+}
+
+// batches splits the keys into batches of at most bulkBatch
+func batches(keys []string) [][]string {
+	var batches [][]string
+	for len(keys) > bulkBatch {
+		batches = append(batches, keys[:bulkBatch])
+		keys = keys[bulkBatch:]
+	}
+	if len(keys) > 0 {
+		batches = append(batches, keys)
+	}
+	return batches
+}
+
+// columnList quotes and joins the column names
+func columnList(columns []string) string {
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = `"` + column + `"`
+	}
+	return strings.Join(quoted, ", ")
+}
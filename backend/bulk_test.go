@@ -0,0 +1,150 @@
+package backend
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/rancher/etcdb/models"
+)
+
+func Test_BulkValue_Unmarshal(t *testing.T) {
+	var values map[string]BulkValue
+	err := json.Unmarshal([]byte(`{"/a": "1", "/b": {"value": "2", "ttl": 60}}`), &values)
+	ok(t, err)
+
+	ttl := int64(60)
+	equals(t, map[string]BulkValue{
+		"/a": {Value: "1"},
+		"/b": {Value: "2", TTL: &ttl},
+	}, values)
+}
+
+func Test_BulkValue_Marshal(t *testing.T) {
+	ttl := int64(60)
+	data, err := json.Marshal(map[string]BulkValue{
+		"/a": {Value: "1"},
+		"/b": {Value: "2", TTL: &ttl},
+	})
+	ok(t, err)
+
+	equals(t, `{"/a":"1","/b":{"value":"2","ttl":60}}`, string(data))
+}
+
+func Test_Export(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	ttl := int64(100)
+	_, err := store.BulkSet(map[string]BulkValue{
+		"/config/a":   {Value: "1"},
+		"/config/b/c": {Value: "2", TTL: &ttl},
+		"/d":          {Value: "3"},
+	})
+	ok(t, err)
+	_, _, err = store.MkDir("/empty", nil, Always)
+	ok(t, err)
+
+	values, err := store.Export()
+	ok(t, err)
+
+	equals(t, 3, len(values))
+	equals(t, BulkValue{Value: "1"}, values["/config/a"])
+	equals(t, "2", values["/config/b/c"].Value)
+	equals(t, true, values["/config/b/c"].TTL != nil)
+	equals(t, BulkValue{Value: "3"}, values["/d"])
+}
+
+func Test_BulkSet(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	ttl := int64(100)
+	res, err := store.BulkSet(map[string]BulkValue{
+		"/config/b": {Value: "2", TTL: &ttl},
+		"/config/a": {Value: "1"},
+	})
+	ok(t, err)
+	equals(t, &models.BulkResult{Count: 2, Index: currIndex(store)}, res)
+
+	node, err := store.Get("/config/a", false)
+	ok(t, err)
+	equals(t, "1", node.Value)
+	equals(t, res.Index-1, node.ModifiedIndex)
+
+	node, err = store.Get("/config/b", false)
+	ok(t, err)
+	equals(t, "2", node.Value)
+	equals(t, true, node.TTL != nil)
+}
+
+func Test_BulkSet_FailureSetsNone(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	_, _, err := store.Set("/file", "value", Always)
+	ok(t, err)
+
+	_, err = store.BulkSet(map[string]BulkValue{
+		"/a":        {Value: "1"},
+		"/file/key": {Value: "2"},
+	})
+	expectError(t, "Not a directory", "/file", err)
+
+	_, err = store.Get("/a", false)
+	expectError(t, "Key not found", "/a", err)
+}
+
+func Test_BulkSet_ReplacesKeys(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	prev, _, err := store.Set("/config/a", "old", Always)
+	ok(t, err)
+	_, _, err = store.MkDir("/config/dir", nil, Always)
+	ok(t, err)
+
+	res, err := store.BulkSet(map[string]BulkValue{
+		"/config/a":     {Value: "new"},
+		"/config/dir/b": {Value: "2"},
+		"/other/c":      {Value: "3"},
+	})
+	ok(t, err)
+	equals(t, int64(5), res.Index)
+
+	node, err := store.Get("/config/a", false)
+	ok(t, err)
+	equals(t, "new", node.Value)
+	equals(t, int64(3), node.ModifiedIndex)
+
+	node, err = store.Get("/other", false)
+	ok(t, err)
+	equals(t, true, node.Dir)
+
+	// the replaced version is still readable before its index
+	node, err = store.GetAt("/config/a", false, 2)
+	ok(t, err)
+	equals(t, prev.Value, node.Value)
+}
+
+func Test_BulkSet_KeyIsDir(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	_, _, err := store.MkDir("/dir", nil, Always)
+	ok(t, err)
+
+	_, err = store.BulkSet(map[string]BulkValue{"/dir": {Value: "1"}})
+	expectError(t, "Not a file", "/dir", err)
+
+	_, err = store.BulkSet(map[string]BulkValue{"/a": {Value: "1"}, "/a/b": {Value: "2"}})
+	expectError(t, "Not a directory", "/a", err)
+}
+
+func Test_Batches(t *testing.T) {
+	keys := make([]string, 2*bulkBatch+1)
+	b := batches(keys)
+	equals(t, 3, len(b))
+	equals(t, bulkBatch, len(b[0]))
+	equals(t, 1, len(b[2]))
+	equals(t, 0, len(batches(nil)))
+}
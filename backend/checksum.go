@@ -0,0 +1,294 @@
+package backend
+
+import (
+	"database/sql"
+	"fmt"
+	"hash/crc32"
+	"sort"
+
+	"github.com/rancher/etcdb/models"
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// SetValueChecksums sets whether the checksum of each node's key and value is
+// stored with it, for Fsck to detect values corrupted in the database. Nodes
+// stored without one aren't checked.
+func (b *SqlBackend) SetValueChecksums(enabled bool) {
+	b.checksums = enabled
+}
+
+// withChecksum adds the node's checksum to the row of a bulk insert if
+// checksums are enabled. The checksum column is only written when they are,
+// so that a schema that isn't migrated yet keeps working without them.
+func (b *SqlBackend) withChecksum(row []interface{}, key, value string) []interface{} {
+	if !b.checksums {
+		return row
+	}
+	return append(row, checksum(key, value))
+}
+
+// checksum is the CRC-32C of the key and the value, separated by a zero byte
+// since keys can't contain one
+func checksum(key, value string) int64 {
+	h := crc32.New(castagnoli)
+	h.Write([]byte(key))
+	h.Write([]byte{0})
+	h.Write([]byte(value))
+	return int64(h.Sum32())
+}
+
+// FsckProblem is a problem found by Fsck with a key, and how it was repaired
+// if it was
+type FsckProblem struct {
+	Key     string
+	Problem string
+	Repair  string
+}
+
+func (p FsckProblem) String() string {
+	if p.Repair == "" {
+		return p.Key + ": " + p.Problem
+	}
+	return p.Key + ": " + p.Problem + ", " + p.Repair
+}
+
+// FsckRepair is how Fsck repairs the problems it finds
+type FsckRepair string
+
+const (
+	// FsckCheckOnly only reports the problems
+	FsckCheckOnly FsckRepair = ""
+	// FsckDeleteOrphans deletes the keys under a missing directory, as a
+	// recursive delete of the directory would have
+	FsckDeleteOrphans FsckRepair = "delete"
+	// FsckCreateParents creates the missing directories above keys instead,
+	// keeping the keys, like after a partial import
+	FsckCreateParents FsckRepair = "mkdir"
+)
+
+// Fsck checks the nodes for corruption, and returns the problems found. The
+// problems that can be repaired are unless repair is FsckCheckOnly, all in
+// one transaction.
+//
+// Three problems are checked:
+//
+// - Node versions whose value doesn't match their checksum. They can't be
+// repaired, since the right value isn't known.
+//
+// - Keys under a directory that doesn't exist or isn't a directory, like the
+// children a database failover can leave behind from a recursive delete. The
+// topmost of them are deleted recursively, or the missing directories are
+// created with FsckCreateParents. Keys under a key that isn't a directory are
+// always deleted.
+//
+// - Keys with a wrong path_depth, which listings would leave out. It is
+// corrected.
+func (b *SqlBackend) Fsck(repair FsckRepair) (problems []FsckProblem, err error) {
+	tx, err := b.conn().Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}()
+
+	if repair != FsckCheckOnly {
+		// lock the index so no other instance makes changes during the
+		// checks
+		_, err = tx.Exec(`UPDATE "index" SET "index" = "index"`)
+	} else {
+		err = b.dialect.Snapshot(tx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	problems, err = b.checkChecksums(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	structure, err := b.checkStructure(tx, repair)
+	if err != nil {
+		return nil, err
+	}
+	return append(problems, structure...), nil
+}
+
+// checkChecksums checks the values that have a checksum, if the schema is
+// migrated to have them
+func (b *SqlBackend) checkChecksums(tx *sql.Tx) ([]FsckProblem, error) {
+	if exists, err := b.columnExists("nodes", "checksum"); err != nil || !exists {
+		return nil, err
+	}
+	rows, err := tx.Query(`SELECT "key", "modified", "value", "checksum" FROM "nodes"
+		WHERE "checksum" IS NOT NULL ORDER BY "key", "modified"`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var problems []FsckProblem
+	for rows.Next() {
+		var key, value string
+		var modified, sum int64
+		if err := rows.Scan(&key, &modified, &value, &sum); err != nil {
+			return nil, err
+		}
+		if checksum(key, value) != sum {
+			problems = append(problems, FsckProblem{
+				Key:     key,
+				Problem: fmt.Sprintf("the value at index %d doesn't match its checksum", modified),
+			})
+		}
+	}
+	return problems, rows.Err()
+}
+
+// fsckNode is a current node, as far as the structure checks need it
+type fsckNode struct {
+	key       string
+	dir       bool
+	modified  int64
+	pathDepth sql.NullInt64
+}
+
+func (b *SqlBackend) checkStructure(tx *sql.Tx, repair FsckRepair) ([]FsckProblem, error) {
+	rows, err := tx.Query(`SELECT "key", "dir", "modified", "path_depth" FROM "nodes" WHERE "deleted" = 0`)
+	if err != nil {
+		return nil, err
+	}
+	var nodes []*fsckNode
+	byKey := make(map[string]*fsckNode)
+	for rows.Next() {
+		n := &fsckNode{}
+		if err := rows.Scan(&n.key, &n.dir, &n.modified, &n.pathDepth); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		nodes = append(nodes, n)
+		byKey[n.key] = n
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// keys sort before the keys under them
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].key < nodes[j].key })
+
+	var problems []FsckProblem
+	deleted := make(map[string]bool)
+	for _, n := range nodes {
+		if deleted[splitKey(n.key)] {
+			deleted[n.key] = true
+			continue
+		}
+
+		if parent, missing := badParent(byKey, n.key); parent != "" {
+			p := FsckProblem{Key: n.key, Problem: "parent " + parent + " isn't a directory"}
+			if missing {
+				p.Problem = "parent directory " + parent + " doesn't exist"
+			}
+			switch {
+			case repair == FsckCreateParents && missing:
+				if err := b.createParents(tx, byKey, n.key); err != nil {
+					return nil, err
+				}
+				p.Repair = "created it"
+			case repair != FsckCheckOnly:
+				if err := b.deleteOrphan(tx, n); err != nil {
+					return nil, err
+				}
+				deleted[n.key] = true
+				p.Repair = "deleted it"
+			}
+			problems = append(problems, p)
+			if !missing || repair != FsckCreateParents {
+				continue
+			}
+		}
+
+		depth := int64(pathDepth(n.key))
+		if !n.pathDepth.Valid || n.pathDepth.Int64 != depth {
+			p := FsckProblem{
+				Key:     n.key,
+				Problem: fmt.Sprintf("path depth is %s instead of %d", formatDepth(n.pathDepth), depth),
+			}
+			if repair != FsckCheckOnly {
+				_, err := b.Query().Extend(`UPDATE "nodes" SET "path_depth" = `, depth,
+					` WHERE "deleted" = 0 AND "key" = `, n.key).Exec(tx)
+				if err != nil {
+					return nil, err
+				}
+				p.Repair = "corrected it"
+			}
+			problems = append(problems, p)
+		}
+	}
+	return problems, nil
+}
+
+// badParent returns a key above the key that isn't a directory, or else the
+// closest directory above it that is missing, or "" if there is neither
+func badParent(nodes map[string]*fsckNode, key string) (parent string, missing bool) {
+	var closestMissing string
+	for parent = splitKey(key); parent != "/" && parent != ""; parent = splitKey(parent) {
+		n, ok := nodes[parent]
+		if !ok {
+			if closestMissing == "" {
+				closestMissing = parent
+			}
+		} else if !n.dir {
+			return parent, false
+		}
+	}
+	return closestMissing, closestMissing != ""
+}
+
+func formatDepth(depth sql.NullInt64) string {
+	if !depth.Valid {
+		return "null"
+	}
+	return fmt.Sprint(depth.Int64)
+}
+
+// createParents creates the missing directories above the key with a new
+// index, adding them to the nodes, and records the creation of the topmost
+// one for watchers
+func (b *SqlBackend) createParents(tx *sql.Tx, nodes map[string]*fsckNode, key string) error {
+	index, err := b.incrementIndex(tx)
+	if err != nil {
+		return err
+	}
+	if err := b.mkdirs(tx, splitKey(key), index); err != nil {
+		return err
+	}
+	var top string
+	for parent := splitKey(key); parent != "/" && parent != ""; parent = splitKey(parent) {
+		if _, ok := nodes[parent]; !ok {
+			nodes[parent] = &fsckNode{key: parent, dir: true, modified: index}
+			top = parent
+		}
+	}
+	return b.recordChange(tx, index, "create", top, nil)
+}
+
+// deleteOrphan deletes the node and the keys under it with a new index, and
+// records the delete for watchers
+func (b *SqlBackend) deleteOrphan(tx *sql.Tx, n *fsckNode) error {
+	index, err := b.incrementIndex(tx)
+	if err != nil {
+		return err
+	}
+	_, err = b.Query().Extend(`UPDATE "nodes" SET "deleted" = `, index,
+		` WHERE "deleted" = 0 AND ("key" = `, n.key, ` OR "key" LIKE `, likePrefix(n.key), `)`).Exec(tx)
+	if err != nil {
+		return err
+	}
+	return b.recordChange(tx, index, "delete", n.key, &models.Node{Key: n.key, ModifiedIndex: n.modified})
+}
@@ -0,0 +1,131 @@
+package backend
+
+import (
+	"fmt"
+	"testing"
+)
+
+func Test_Fsck_Consistent(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+	store.SetValueChecksums(true)
+
+	_, _, err := store.Set("/dir/foo", "bar", Always)
+	ok(t, err)
+	_, _, err = store.Set("/dir/foo", "baz", Always)
+	ok(t, err)
+	_, err = store.BulkSet(map[string]BulkValue{"/bulk/a": {Value: "1"}})
+	ok(t, err)
+	_, _, err = store.RmDir("/dir", true, Always)
+	ok(t, err)
+
+	problems, err := store.Fsck(FsckCheckOnly)
+	ok(t, err)
+	equals(t, 0, len(problems))
+}
+
+func Test_Fsck_Checksum(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+	store.SetValueChecksums(true)
+
+	node, _, err := store.Set("/foo", "bar", Always)
+	ok(t, err)
+
+	_, err = store.Query().Extend(`UPDATE "nodes" SET "value" = `, "bad", ` WHERE "key" = `, "/foo").Exec(store.conn())
+	ok(t, err)
+
+	problems, err := store.Fsck(FsckDeleteOrphans)
+	ok(t, err)
+	equals(t, []FsckProblem{{
+		Key:     "/foo",
+		Problem: fmt.Sprintf("the value at index %d doesn't match its checksum", node.ModifiedIndex),
+	}}, problems)
+}
+
+func Test_Fsck_Orphans(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	_, _, err := store.Set("/dir/sub/foo", "bar", Always)
+	ok(t, err)
+	_, _, err = store.Set("/other", "value", Always)
+	ok(t, err)
+
+	_, err = store.Query().Extend(`DELETE FROM "nodes" WHERE "key" = `, "/dir").Exec(store.conn())
+	ok(t, err)
+
+	problems, err := store.Fsck(FsckCheckOnly)
+	ok(t, err)
+	equals(t, []FsckProblem{
+		{Key: "/dir/sub", Problem: "parent directory /dir doesn't exist"},
+		{Key: "/dir/sub/foo", Problem: "parent directory /dir doesn't exist"},
+	}, problems)
+
+	index := currIndex(store)
+	problems, err = store.Fsck(FsckDeleteOrphans)
+	ok(t, err)
+	equals(t, []FsckProblem{{Key: "/dir/sub", Problem: "parent directory /dir doesn't exist", Repair: "deleted it"}}, problems)
+	equals(t, index+1, currIndex(store))
+
+	_, err = store.Get("/dir/sub/foo", false)
+	expectError(t, "Key not found", "/dir/sub/foo", err)
+
+	problems, err = store.Fsck(FsckCheckOnly)
+	ok(t, err)
+	equals(t, 0, len(problems))
+}
+
+func Test_Fsck_PathDepth(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	_, _, err := store.Set("/dir/foo", "bar", Always)
+	ok(t, err)
+
+	_, err = store.Query().Extend(`UPDATE "nodes" SET "path_depth" = 5 WHERE "key" = `, "/dir/foo").Exec(store.conn())
+	ok(t, err)
+
+	problems, err := store.Fsck(FsckDeleteOrphans)
+	ok(t, err)
+	equals(t, 1, len(problems))
+	equals(t, "/dir/foo: path depth is 5 instead of 2, corrected it", problems[0].String())
+
+	node, err := store.Get("/dir", true)
+	ok(t, err)
+	equals(t, 1, len(node.Nodes))
+}
+
+func Test_Fsck_CreateParents(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	_, _, err := store.Set("/dir/sub/foo", "bar", Always)
+	ok(t, err)
+	_, _, err = store.Set("/file", "value", Always)
+	ok(t, err)
+
+	_, err = store.Query().Extend(`DELETE FROM "nodes" WHERE "key" = `, "/dir").Exec(store.conn())
+	ok(t, err)
+	_, err = store.Query().Extend(`INSERT INTO "nodes" ("key", "value", "created", "modified", "path_depth") VALUES (`,
+		"/file/child", `, 'x', 1, 1, 2)`).Exec(store.conn())
+	ok(t, err)
+
+	problems, err := store.Fsck(FsckCreateParents)
+	ok(t, err)
+	equals(t, []FsckProblem{
+		{Key: "/dir/sub", Problem: "parent directory /dir doesn't exist", Repair: "created it"},
+		{Key: "/file/child", Problem: "parent /file isn't a directory", Repair: "deleted it"},
+	}, problems)
+
+	node, err := store.Get("/dir/sub/foo", false)
+	ok(t, err)
+	equals(t, "bar", node.Value)
+	node, err = store.Get("/dir", false)
+	ok(t, err)
+	equals(t, true, node.Dir)
+
+	problems, err = store.Fsck(FsckCheckOnly)
+	ok(t, err)
+	equals(t, 0, len(problems))
+}
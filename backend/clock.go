@@ -0,0 +1,107 @@
+package backend
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// ClockSkewPolicy is the handling of jumps in the database clock, which key
+// expirations are based on.
+type ClockSkewPolicy string
+
+const (
+	// ClockSkewOff doesn't check the database clock
+	ClockSkewOff ClockSkewPolicy = "off"
+	// ClockSkewLog logs jumps in the database clock
+	ClockSkewLog ClockSkewPolicy = "log"
+	// ClockSkewFreeze logs jumps in the database clock, and stops expiring
+	// keys for as long as the jump, so that a clock stepped forward doesn't
+	// expire keys early all at once.
+	ClockSkewFreeze ClockSkewPolicy = "freeze"
+)
+
+// clockWatch compares the time passed on the database clock with the time
+// passed locally, to detect the database clock being stepped, e.g. by NTP.
+type clockWatch struct {
+	mu          sync.Mutex
+	policy      ClockSkewPolicy
+	tolerance   time.Duration
+	lastDB      time.Time
+	lastLocal   time.Time
+	frozenUntil time.Time
+}
+
+// SetClockSkewPolicy sets the handling of jumps in the database clock larger
+// than the tolerance.
+func (b *SqlBackend) SetClockSkewPolicy(policy ClockSkewPolicy, tolerance time.Duration) {
+	b.clock.mu.Lock()
+	defer b.clock.mu.Unlock()
+	b.clock.policy = policy
+	b.clock.tolerance = tolerance
+}
+
+// expirationsFrozen checks the database clock, and returns true if keys
+// shouldn't be expired yet after a jump.
+func (b *SqlBackend) expirationsFrozen(db Querier) (bool, error) {
+	b.clock.mu.Lock()
+	policy := b.clock.policy
+	b.clock.mu.Unlock()
+
+	if policy == ClockSkewOff || policy == "" {
+		return false, nil
+	}
+
+	// mysql.NullTime is more portable and works with the Postgres driver
+	var now mysql.NullTime
+	err := db.QueryRow(`SELECT ` + b.dialect.Now()).Scan(&now)
+	if err != nil {
+		return false, err
+	}
+
+	return b.clock.observe(now.Time, time.Now()), nil
+}
+
+// frozen returns true if expirations are frozen after the last jump observed
+func (c *clockWatch) frozen() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Now().Before(c.frozenUntil)
+}
+
+// observe records a reading of the database clock, taken at the local time,
+// and returns true if expirations are frozen.
+func (c *clockWatch) observe(dbNow, localNow time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.lastDB.IsZero() {
+		skew := dbNow.Sub(c.lastDB) - localNow.Sub(c.lastLocal)
+		jump := skew
+		if jump < 0 {
+			jump = -jump
+		}
+
+		if jump > c.tolerance {
+			if skew < 0 {
+				log.Printf("WARNING: database clock went back by %v, keys will expire late by up to %v", jump, jump)
+			} else {
+				log.Printf("WARNING: database clock jumped forward by %v, keys may expire early by up to %v", jump, jump)
+			}
+
+			if c.policy == ClockSkewFreeze {
+				log.Printf("WARNING: not expiring keys for %v after the database clock jump", jump)
+				if until := localNow.Add(jump); until.After(c.frozenUntil) {
+					c.frozenUntil = until
+				}
+			}
+		}
+	}
+
+	c.lastDB = dbNow
+	c.lastLocal = localNow
+
+	return localNow.Before(c.frozenUntil)
+}
@@ -0,0 +1,48 @@
+package backend
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_Clock_NoJump(t *testing.T) {
+	c := clockWatch{policy: ClockSkewFreeze, tolerance: time.Second}
+	db, local := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC), time.Now()
+
+	equals(t, false, c.observe(db, local))
+	equals(t, false, c.observe(db.Add(time.Minute), local.Add(time.Minute+500*time.Millisecond)))
+}
+
+func Test_Clock_FreezesForJump(t *testing.T) {
+	c := clockWatch{policy: ClockSkewFreeze, tolerance: time.Second}
+	db, local := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC), time.Now()
+
+	c.observe(db, local)
+
+	// the database clock jumps forward an hour
+	db, local = db.Add(time.Hour+time.Second), local.Add(time.Second)
+	equals(t, true, c.observe(db, local))
+
+	db, local = db.Add(30*time.Minute), local.Add(30*time.Minute)
+	equals(t, true, c.observe(db, local))
+
+	db, local = db.Add(31*time.Minute), local.Add(31*time.Minute)
+	equals(t, false, c.observe(db, local))
+}
+
+func Test_Clock_FreezesForBackwardsJump(t *testing.T) {
+	c := clockWatch{policy: ClockSkewFreeze, tolerance: time.Second}
+	db, local := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC), time.Now()
+
+	c.observe(db, local)
+	equals(t, true, c.observe(db.Add(-time.Minute), local.Add(time.Second)))
+	equals(t, false, c.observe(db.Add(time.Minute), local.Add(2*time.Minute)))
+}
+
+func Test_Clock_LogOnly(t *testing.T) {
+	c := clockWatch{policy: ClockSkewLog, tolerance: time.Second}
+	db, local := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC), time.Now()
+
+	c.observe(db, local)
+	equals(t, false, c.observe(db.Add(time.Hour), local.Add(time.Second)))
+}
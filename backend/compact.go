@@ -0,0 +1,83 @@
+package backend
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rancher/etcdb/models"
+)
+
+// Compact removes the history up to and including the index: deleted or
+// replaced node versions, and change rows. Watches and reads at those indexes
+// are no longer possible afterwards. Deletes in the recycle bin are kept.
+func (b *SqlBackend) Compact(index int64) (res *models.Compaction, err error) {
+	tx, err := b.conn().Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}()
+
+	currIndex, err := b.currIndex(tx)
+	if err != nil {
+		return nil, err
+	}
+	if index > currIndex {
+		return nil, models.InvalidField(fmt.Sprintf("index %d is after the current index %d", index, currIndex))
+	}
+
+	res = &models.Compaction{Index: index}
+
+	nodes, err := b.Query().Extend(`DELETE FROM "nodes" WHERE "deleted" > 0 AND "deleted" <= `, index,
+		` AND "deleted" NOT IN (SELECT "index" FROM "recycle")`).Exec(tx)
+	if err != nil {
+		return nil, err
+	}
+	res.Nodes, err = nodes.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := b.Query().Extend(`DELETE FROM "changes" WHERE "index" <= `, index).Exec(tx)
+	if err != nil {
+		return nil, err
+	}
+	res.Changes, err = changes.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// IndexBefore returns the last index changed longer than the age ago, or 0 if
+// there is none in the history.
+func (b *SqlBackend) IndexBefore(age time.Duration) (int64, error) {
+	query := b.Query().Text(`SELECT COALESCE(MAX("index"), 0) FROM "changes" WHERE "time" < `)
+	b.dialect.Ago(query, int64(age/time.Second))
+
+	var index int64
+	err := query.QueryRow(b.conn()).Scan(&index)
+	return index, err
+}
+
+// oldestIndex returns the oldest index that the history is complete from.
+func (b *SqlBackend) oldestIndex(db Querier, currIndex int64) (int64, error) {
+	oldest := currIndex - MaxChanges + 1
+
+	// after a compaction, the history starts with the oldest remaining change
+	var oldestChange int64
+	err := db.QueryRow(`SELECT COALESCE(MIN("index"), 0) FROM "changes"`).Scan(&oldestChange)
+	if err != nil {
+		return 0, err
+	}
+	if oldestChange > oldest {
+		oldest = oldestChange
+	}
+	return oldest, nil
+}
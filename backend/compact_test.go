@@ -0,0 +1,55 @@
+package backend
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rancher/etcdb/models"
+)
+
+func Test_Compact(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	first, _, err := store.Set("/foo", "first", Always)
+	ok(t, err)
+	second, _, err := store.Set("/foo", "second", Always)
+	ok(t, err)
+	_, _, err = store.Set("/foo", "third", Always)
+	ok(t, err)
+
+	res, err := store.Compact(second.ModifiedIndex)
+	ok(t, err)
+	equals(t, &models.Compaction{Index: second.ModifiedIndex, Nodes: 1, Changes: 2}, res)
+
+	_, err = store.GetAt("/foo", false, first.ModifiedIndex)
+	equals(t, 401, err.(models.Error).ErrorCode)
+
+	node, err := store.Get("/foo", false)
+	ok(t, err)
+	equals(t, "third", node.Value)
+}
+
+func Test_Compact_FutureIndex(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	_, err := store.Compact(currIndex(store) + 1)
+	equals(t, 209, err.(models.Error).ErrorCode)
+}
+
+func Test_IndexBefore(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	index, err := store.IndexBefore(time.Hour)
+	ok(t, err)
+	equals(t, int64(0), index)
+
+	node, _, err := store.Set("/foo", "bar", Always)
+	ok(t, err)
+
+	index, err = store.IndexBefore(-time.Hour)
+	ok(t, err)
+	equals(t, node.ModifiedIndex, index)
+}
@@ -38,7 +38,7 @@ func (p always) SetActionName() string {
 }
 
 func (p always) DeleteActionName() string {
-	return "set"
+	return "delete"
 }
 
 // PrevValue matches on the previous node's value.
@@ -85,6 +85,65 @@ func (p PrevIndex) DeleteActionName() string {
 	return "compareAndDelete"
 }
 
+// PrevValueAndIndex matches on both the previous node's value and
+// modifiedIndex, as etcd does when both prevValue and prevIndex are given.
+type PrevValueAndIndex struct {
+	Value string
+	Index int64
+}
+
+// Check succeeds if both the previous value and modifiedIndex match. If both
+// fail, the error's cause lists both comparisons.
+func (p PrevValueAndIndex) Check(key string, index int64, node *models.Node) error {
+	if node == nil {
+		return models.NotFound(key, index)
+	}
+	valueErr := PrevValue(p.Value).Check(key, index, node)
+	indexErr := PrevIndex(p.Index).Check(key, index, node)
+	switch {
+	case valueErr != nil && indexErr != nil:
+		err := valueErr.(models.Error)
+		err.Cause += " " + indexErr.(models.Error).Cause
+		return err
+	case valueErr != nil:
+		return valueErr
+	}
+	return indexErr
+}
+
+func (p PrevValueAndIndex) SetActionName() string {
+	return "compareAndSwap"
+}
+
+func (p PrevValueAndIndex) DeleteActionName() string {
+	return "compareAndDelete"
+}
+
+// isCompare checks if the condition compares the previous node, like etcd's
+// compareAndSwap and compareAndDelete, which never apply to directories.
+func isCompare(c Condition) bool {
+	if t, ok := c.(wrapped); ok {
+		c = t.unwrap()
+	}
+	switch c.(type) {
+	case PrevValue, PrevIndex, PrevValueAndIndex:
+		return true
+	}
+	return false
+}
+
+// isUpdate checks if the condition requires an existing node that is updated,
+// like etcd's update and compareAndSwap actions, rather than replaced.
+func isUpdate(c Condition) bool {
+	if t, ok := c.(wrapped); ok {
+		c = t.unwrap()
+	}
+	if p, ok := c.(PrevExist); ok {
+		return bool(p)
+	}
+	return isCompare(c)
+}
+
 // PrevExist matches on whether there was a previous value.
 type PrevExist bool
 
@@ -107,3 +166,33 @@ func (p PrevExist) SetActionName() string {
 	}
 	return "create"
 }
+
+// SetConditionFor returns the condition for etcd's set parameters, any of
+// which may be nil.
+func SetConditionFor(prevValue *string, prevIndex *int64, prevExist *bool) SetCondition {
+	switch {
+	case prevExist != nil:
+		return PrevExist(*prevExist)
+	case prevValue != nil && prevIndex != nil:
+		return PrevValueAndIndex{Value: *prevValue, Index: *prevIndex}
+	case prevValue != nil:
+		return PrevValue(*prevValue)
+	case prevIndex != nil:
+		return PrevIndex(*prevIndex)
+	}
+	return Always
+}
+
+// DeleteConditionFor returns the condition for etcd's delete parameters,
+// either of which may be nil.
+func DeleteConditionFor(prevValue *string, prevIndex *int64) DeleteCondition {
+	switch {
+	case prevValue != nil && prevIndex != nil:
+		return PrevValueAndIndex{Value: *prevValue, Index: *prevIndex}
+	case prevValue != nil:
+		return PrevValue(*prevValue)
+	case prevIndex != nil:
+		return PrevIndex(*prevIndex)
+	}
+	return Always
+}
@@ -0,0 +1,12 @@
+package backend
+
+import "testing"
+
+// the DELETE responses and the recorded changes take their action from the
+// condition, like etcd's delete and compareAndDelete
+func Test_DeleteActionName(t *testing.T) {
+	equals(t, "delete", Always.DeleteActionName())
+	equals(t, "compareAndDelete", PrevValue("foo").DeleteActionName())
+	equals(t, "compareAndDelete", PrevIndex(1).DeleteActionName())
+	equals(t, "compareAndDelete", PrevValueAndIndex{Value: "foo", Index: 1}.DeleteActionName())
+}
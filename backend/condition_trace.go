@@ -0,0 +1,83 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/rancher/etcdb/models"
+)
+
+// traced adds the evaluated condition and a snapshot of the previous node to
+// the cause of the condition's errors.
+type traced struct {
+	Condition
+}
+
+// wrapped is a condition wrapping another, which determines how the operation
+// applies.
+type wrapped interface {
+	unwrap() Condition
+}
+
+func (t traced) unwrap() Condition {
+	return t.Condition
+}
+
+// TraceSet returns the condition with its failures traced, to debug why a
+// compare failed without a separate read racing the state.
+func TraceSet(c SetCondition) SetCondition {
+	return tracedSet{traced{c}, c}
+}
+
+// TraceDelete is TraceSet for delete conditions.
+func TraceDelete(c DeleteCondition) DeleteCondition {
+	return tracedDelete{traced{c}, c}
+}
+
+type tracedSet struct {
+	traced
+	set SetCondition
+}
+
+func (t tracedSet) SetActionName() string {
+	return t.set.SetActionName()
+}
+
+type tracedDelete struct {
+	traced
+	del DeleteCondition
+}
+
+func (t tracedDelete) DeleteActionName() string {
+	return t.del.DeleteActionName()
+}
+
+func (t traced) Check(key string, index int64, node *models.Node) error {
+	err := t.Condition.Check(key, index, node)
+	etcdErr, ok := err.(models.Error)
+	if !ok {
+		return err
+	}
+
+	snapshot, jsonErr := json.Marshal(node)
+	if jsonErr != nil {
+		return err
+	}
+	etcdErr.Cause = fmt.Sprintf("%s (condition %s, prevNode %s)", etcdErr.Cause, describeCondition(t.Condition), snapshot)
+	return etcdErr
+}
+
+// describeCondition names the condition with the etcd parameters for it
+func describeCondition(c Condition) string {
+	switch c := c.(type) {
+	case PrevValue:
+		return fmt.Sprintf("prevValue=%q", string(c))
+	case PrevIndex:
+		return fmt.Sprintf("prevIndex=%d", int64(c))
+	case PrevValueAndIndex:
+		return fmt.Sprintf("prevValue=%q prevIndex=%d", c.Value, c.Index)
+	case PrevExist:
+		return fmt.Sprintf("prevExist=%t", bool(c))
+	}
+	return "none"
+}
@@ -0,0 +1,49 @@
+package backend
+
+import (
+	"testing"
+
+	"github.com/rancher/etcdb/models"
+)
+
+func Test_TraceSet_CompareFailed(t *testing.T) {
+	c := TraceSet(PrevValue("wrong"))
+	node := &models.Node{Key: "/foo", Value: "baz", CreatedIndex: 3, ModifiedIndex: 5}
+
+	err := c.Check("/foo", 7, node)
+
+	equals(t, models.Error{
+		ErrorCode: 101,
+		Message:   "Compare failed",
+		Cause:     `[wrong != baz] (condition prevValue="wrong", prevNode {"key":"/foo","value":"baz","modifiedIndex":5,"createdIndex":3})`,
+		Index:     7,
+	}, err)
+	equals(t, "compareAndSwap", c.SetActionName())
+}
+
+func Test_TraceSet_Missing(t *testing.T) {
+	err := TraceSet(PrevExist(true)).Check("/foo", 7, nil)
+
+	expectError(t, "Key not found", "/foo (condition prevExist=true, prevNode null)", err)
+}
+
+func Test_TraceSet_Passes(t *testing.T) {
+	err := TraceSet(PrevIndex(5)).Check("/foo", 7, &models.Node{Key: "/foo", ModifiedIndex: 5})
+	ok(t, err)
+}
+
+func Test_TraceDelete(t *testing.T) {
+	c := TraceDelete(PrevValueAndIndex{Value: "a", Index: 1})
+
+	err := c.Check("/foo", 7, &models.Node{Key: "/foo", Value: "a", ModifiedIndex: 2})
+
+	expectError(t, "Compare failed", `[1 != 2] (condition prevValue="a" prevIndex=1, prevNode {"key":"/foo","value":"a","modifiedIndex":2})`, err)
+	equals(t, "compareAndDelete", c.DeleteActionName())
+}
+
+func Test_Traced_KeepsConditionType(t *testing.T) {
+	equals(t, true, isCompare(TraceSet(PrevValue("a"))))
+	equals(t, true, isUpdate(TraceSet(PrevExist(true))))
+	equals(t, false, isUpdate(TraceSet(Always)))
+	equals(t, true, isCompare(TraceDelete(PrevIndex(1))))
+}
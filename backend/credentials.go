@@ -0,0 +1,122 @@
+package backend
+
+import (
+	"log"
+	"time"
+)
+
+// Credentials are a database user and password with a limited lifetime, like
+// the dynamic credentials of a secrets manager.
+type Credentials struct {
+	User     string
+	Password string
+	// LeaseID identifies the credentials to the provider for renewals
+	LeaseID string
+	// Expires is when the credentials stop working, or zero if they don't
+	Expires   time.Time
+	Renewable bool
+}
+
+// A CredentialsProvider issues database credentials, and extends their
+// lifetime.
+type CredentialsProvider interface {
+	// Fetch issues new credentials
+	Fetch() (*Credentials, error)
+	// Renew extends the lifetime of renewable credentials. The returned
+	// credentials may expire sooner than asked for, when they reach their
+	// maximum lifetime.
+	Renew(*Credentials) (*Credentials, error)
+}
+
+// CredentialsRetry is how long to wait after failing to renew or replace the
+// credentials before trying again.
+var CredentialsRetry = 10 * time.Second
+
+// NewWithCredentials creates a SqlBackend for the DB described by the config,
+// with the user and password from the provider. The credentials are renewed
+// in the background, and when they can't be, the connections are replaced
+// with new ones using new credentials before they expire.
+func NewWithCredentials(driver string, config *ConnConfig, provider CredentialsProvider) (*SqlBackend, error) {
+	creds, err := provider.Fetch()
+	if err != nil {
+		return nil, err
+	}
+	dataSource, err := config.withCredentials(creds).DataSource(driver)
+	if err != nil {
+		return nil, err
+	}
+	b, err := New(driver, dataSource)
+	if err != nil {
+		return nil, err
+	}
+
+	go b.keepCredentials(config, provider, creds)
+	return b, nil
+}
+
+func (c ConnConfig) withCredentials(creds *Credentials) *ConnConfig {
+	c.User = creds.User
+	c.Password = creds.Password
+	return &c
+}
+
+// keepCredentials renews the credentials, or reconnects with new ones, when
+// two thirds of their lifetime is over, until the backend is closed.
+func (b *SqlBackend) keepCredentials(config *ConnConfig, provider CredentialsProvider, creds *Credentials) {
+	for !creds.Expires.IsZero() {
+		timer := time.NewTimer(renewIn(time.Now(), creds.Expires))
+		select {
+		case <-b.closing:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if creds.Renewable {
+			renewed, err := provider.Renew(creds)
+			if err != nil {
+				log.Println("error renewing database credentials:", err)
+			} else if renewIn(time.Now(), renewed.Expires) >= CredentialsRetry {
+				creds = renewed
+				continue
+			}
+			// otherwise the credentials are about to reach their maximum
+			// lifetime, so they are replaced
+		}
+
+		replaced, err := b.replaceCredentials(config, provider)
+		if err != nil {
+			log.Println("error replacing database credentials:", err)
+			creds = &Credentials{Expires: time.Now().Add(CredentialsRetry)}
+			continue
+		}
+		creds = replaced
+	}
+}
+
+func (b *SqlBackend) replaceCredentials(config *ConnConfig, provider CredentialsProvider) (*Credentials, error) {
+	creds, err := provider.Fetch()
+	if err != nil {
+		return nil, err
+	}
+	dataSource, err := config.withCredentials(creds).DataSource(b.driver)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.Reconnect(dataSource); err != nil {
+		return nil, err
+	}
+	log.Println("etcdb: reconnected to the database with new credentials")
+	return creds, nil
+}
+
+// renewIn is how long to wait before renewing credentials expiring at the
+// time: two thirds of their remaining lifetime, so that the old connections
+// can still finish their requests after a reconnect.
+func renewIn(now, expires time.Time) time.Duration {
+	remaining := expires.Sub(now)
+	if remaining <= 0 {
+		return 0
+	}
+	return remaining * 2 / 3
+}
@@ -0,0 +1,124 @@
+package backend
+
+import (
+	"testing"
+
+	"github.com/rancher/etcdb/models"
+)
+
+// deleteCompatCases are delete requests against a store with a file /file
+// (value "value") and a directory /dir containing /dir/child, with the error
+// code etcd 2.x returns for each, or 0 for success.
+var deleteCompatCases = []struct {
+	name      string
+	key       string
+	dir       bool
+	recursive bool
+	condition func(file, dir *models.Node) DeleteCondition
+	errorCode int
+}{
+	{"file", "/file", false, false, alwaysDelete, 0},
+	{"file dir", "/file", true, false, alwaysDelete, 0},
+	{"file recursive", "/file", false, true, alwaysDelete, 0},
+	{"dir", "/dir", false, false, alwaysDelete, 102},
+	{"dir dir", "/dir", true, false, alwaysDelete, 108},
+	{"dir recursive", "/dir", false, true, alwaysDelete, 0},
+	{"missing", "/missing", false, false, alwaysDelete, 100},
+	{"missing recursive", "/missing", false, true, alwaysDelete, 100},
+
+	{"file prevValue", "/file", false, false, prevValue("value"), 0},
+	{"file prevValue fails", "/file", false, false, prevValue("other"), 101},
+	{"file prevIndex", "/file", false, false, filePrevIndex, 0},
+	{"file prevIndex fails", "/file", false, false, prevIndex(1000), 101},
+	{"file prevIndex recursive", "/file", false, true, filePrevIndex, 0},
+	{"file prevValue and prevIndex", "/file", false, false, filePrevValueAndIndex("value"), 0},
+	{"file prevValue and prevIndex fails", "/file", false, false, filePrevValueAndIndex("other"), 101},
+
+	// compare and delete is never applied to a directory, even when the
+	// condition would match
+	{"dir prevIndex", "/dir", false, false, dirPrevIndex, 102},
+	{"dir prevIndex dir", "/dir", true, false, dirPrevIndex, 102},
+	{"dir prevIndex recursive", "/dir", false, true, dirPrevIndex, 102},
+	{"dir prevIndex fails recursive", "/dir", false, true, prevIndex(1000), 102},
+	{"dir prevValue recursive", "/dir", false, true, prevValue(""), 102},
+	{"missing prevIndex", "/missing", false, true, prevIndex(1), 100},
+}
+
+func alwaysDelete(file, dir *models.Node) DeleteCondition { return Always }
+
+func prevValue(value string) func(file, dir *models.Node) DeleteCondition {
+	return func(file, dir *models.Node) DeleteCondition { return PrevValue(value) }
+}
+
+func prevIndex(index int64) func(file, dir *models.Node) DeleteCondition {
+	return func(file, dir *models.Node) DeleteCondition { return PrevIndex(index) }
+}
+
+func filePrevIndex(file, dir *models.Node) DeleteCondition {
+	return PrevIndex(file.ModifiedIndex)
+}
+
+func dirPrevIndex(file, dir *models.Node) DeleteCondition {
+	return PrevIndex(dir.ModifiedIndex)
+}
+
+func filePrevValueAndIndex(value string) func(file, dir *models.Node) DeleteCondition {
+	return func(file, dir *models.Node) DeleteCondition {
+		return PrevValueAndIndex{Value: value, Index: file.ModifiedIndex}
+	}
+}
+
+func Test_Delete_EtcdCompatibility(t *testing.T) {
+	for _, c := range deleteCompatCases {
+		t.Run(c.name, func(t *testing.T) {
+			store := testConn(t)
+			defer store.Close()
+
+			file, _, err := store.Set("/file", "value", Always)
+			ok(t, err)
+			dir, _, err := store.MkDir("/dir", nil, Always)
+			ok(t, err)
+			_, _, err = store.Set("/dir/child", "value", Always)
+			ok(t, err)
+
+			index := currIndex(store)
+			condition := c.condition(file, dir)
+
+			if c.dir || c.recursive {
+				_, _, err = store.RmDir(c.key, c.recursive, condition)
+			} else {
+				_, _, err = store.Delete(c.key, condition)
+			}
+
+			if c.errorCode == 0 {
+				ok(t, err)
+				_, err = store.Get(c.key, false)
+				expectError(t, "Key not found", c.key, err)
+				return
+			}
+
+			etcdErr, isEtcdErr := err.(models.Error)
+			if !isEtcdErr {
+				fatalf(t, "expected error code %d, but got %#v", c.errorCode, err)
+			}
+			equals(t, c.errorCode, etcdErr.ErrorCode)
+			equals(t, index, etcdErr.Index)
+
+			// failed deletes leave the node in place
+			if c.errorCode != 100 {
+				_, err = store.Get(c.key, false)
+				ok(t, err)
+			}
+		})
+	}
+}
+
+func Test_PrevValueAndIndex_BothFail(t *testing.T) {
+	node := &models.Node{Key: "/foo", Value: "value", ModifiedIndex: 2}
+
+	err := PrevValueAndIndex{Value: "other", Index: 3}.Check("/foo", 5, node)
+	expectError(t, "Compare failed", "[other != value] [3 != 2]", err)
+
+	err = PrevValueAndIndex{Value: "value", Index: 3}.Check("/foo", 5, node)
+	expectError(t, "Compare failed", "[3 != 2]", err)
+}
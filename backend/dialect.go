@@ -1,29 +1,127 @@
 package backend
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/go-sql-driver/mysql"
 	"github.com/lib/pq"
 )
 
-type dbDialect interface {
+// A Dialect adapts the queries to a database. Dialects for other databases
+// can be added with RegisterDialect, usually by embedding MysqlDialect or
+// PostgresDialect and overriding the methods that differ.
+type Dialect interface {
+	// Open opens the database with the driver the dialect is registered as
 	Open(driver, dataSource string) (*sql.DB, error)
-	tableDefinitions() []string
-	nameParam([]interface{}) string
-	incrementIndex(Querier) (int64, error)
-	expiration(*Query, int64)
-	isDuplicateKeyError(error) bool
-	now() string
-	ttl() string
+	// DataSource formats the config as a data source string for Open
+	DataSource(*ConnConfig) string
+	// SchemaObjects are the tables, indexes and added columns, in the order
+	// to create them, and the obsolete ones to drop
+	SchemaObjects() []SchemaObject
+	// Migrations are the statements updating an existing schema to each
+	// version, by version
+	Migrations() map[int][]string
+	// SessionSetup are the statements setting a session up like the
+	// connections of Open, for scripts run with another client
+	SessionSetup() []string
+	// LockSchema waits for the lock that one instance at a time creates or
+	// migrates the schema with, held by the connection until unlock
+	LockSchema(conn *sql.Conn) (unlock func() error, err error)
+	IndexExists(db Querier, table, index string) (bool, error)
+	// CurrentSchema is an expression for the schema of information_schema
+	// tables to look for the tables in
+	CurrentSchema() string
+	// NameParam is the placeholder for the last of the params
+	NameParam([]interface{}) string
+	// IncrementIndex increments the index row, and returns the new index
+	IncrementIndex(Querier) (int64, error)
+	// Expiration adds an expression for the time ttl seconds from now
+	Expiration(*Query, int64)
+	// Ago adds an expression for the time seconds ago, in the time zone of
+	// the changes table's default
+	Ago(*Query, int64)
+	// Maintenance are statements to reclaim space and update statistics
+	Maintenance() []string
+	IsDuplicateKeyError(error) bool
+	// IsConnectionError reports whether the database error is about the
+	// connection rather than the statement, like the server shutting down
+	IsConnectionError(error) bool
+	// IgnoreDuplicates is a clause ending an INSERT that skips the rows whose
+	// key already exists instead of failing, without counting them as
+	// affected
+	IgnoreDuplicates() string
+	// Now is an expression for the current UTC time
+	Now() string
+	// TTL is an expression for the seconds until a node's expiration
+	TTL() string
+	// Lateness is an expression for the seconds since a node's expiration
+	Lateness() string
+	// TopLevelKey is an expression for the name of the top-level key that a
+	// node's key is under, like app for /app/config/port
+	TopLevelKey() string
+	// Snapshot makes all of the transaction's reads from the same snapshot
+	Snapshot(tx *sql.Tx) error
+	BulkInsert(tx *sql.Tx, table string, columns []string, rows [][]interface{}) error
 }
 
-type mysqlDialect struct{}
+var (
+	dialectsMu sync.RWMutex
+	dialects   = map[string]Dialect{
+		"mysql":    MysqlDialect{},
+		"postgres": PostgresDialect{},
+	}
+)
+
+// RegisterDialect makes a dialect available by name, to New and the other
+// constructors taking a driver. The name is passed to the dialect's Open as
+// the driver, so a dialect for a database that is compatible with another
+// one's protocol can open that one's driver. Like sql.Register, it panics if
+// the dialect is nil or the name is already registered.
+func RegisterDialect(name string, dialect Dialect) {
+	dialectsMu.Lock()
+	defer dialectsMu.Unlock()
+	if dialect == nil {
+		panic("backend: RegisterDialect dialect is nil")
+	}
+	if _, dup := dialects[name]; dup {
+		panic("backend: RegisterDialect called twice for dialect " + name)
+	}
+	dialects[name] = dialect
+}
+
+// Dialects returns the names of the registered dialects, sorted
+func Dialects() []string {
+	dialectsMu.RLock()
+	defer dialectsMu.RUnlock()
+	names := make([]string, 0, len(dialects))
+	for name := range dialects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
 
-func (d mysqlDialect) Open(driver, dataSource string) (*sql.DB, error) {
+func dialectFor(driver string) (Dialect, error) {
+	dialectsMu.RLock()
+	dialect, ok := dialects[driver]
+	dialectsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("Unrecognized database driver %s, should be one of %s", driver, strings.Join(Dialects(), ", "))
+	}
+	return dialect, nil
+}
+
+// MysqlDialect is the dialect of MySQL
+type MysqlDialect struct{}
+
+func (d MysqlDialect) Open(driver, dataSource string) (*sql.DB, error) {
 	sep := "?"
 	if strings.ContainsRune(dataSource, '?') {
 		sep = "&"
@@ -36,84 +134,250 @@ func (d mysqlDialect) Open(driver, dataSource string) (*sql.DB, error) {
 	return sql.Open(driver, dataSource)
 }
 
-func (d mysqlDialect) tableDefinitions() []string {
-	return []string{
-		`CREATE TABLE "nodes" (
+func (d MysqlDialect) DataSource(c *ConnConfig) string {
+	return mysqlDataSource(c)
+}
+
+func (d MysqlDialect) SchemaObjects() []SchemaObject {
+	return []SchemaObject{
+		{Table: "nodes", Definition: `CREATE TABLE "nodes" (
 			"key" varchar(255),
 			"created" bigint NOT NULL,
 			"modified" bigint NOT NULL,
 			"deleted" bigint NOT NULL DEFAULT 0,
 			"value" text NOT NULL DEFAULT '',
-			"expiration" timestamp NULL,
+			"expiration" datetime(6) NULL,
 			"dir" boolean NOT NULL DEFAULT 0,
 			"path_depth" integer,
+			"checksum" bigint NULL,
 			PRIMARY KEY ("deleted", "key")
-		) ENGINE=InnoDB DEFAULT CHARSET=utf8`,
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8`},
+		{Table: "nodes", Column: "checksum",
+			Definition: `ALTER TABLE "nodes" ADD COLUMN "checksum" bigint NULL`},
 
-		`CREATE INDEX "nodes_key_modified_idx" ON "nodes" ("key", "modified")`,
-		`CREATE INDEX "nodes_deleted_path_depth_idx" ON "nodes" ("deleted", "path_depth")`,
-		`CREATE INDEX "nodes_deleted_expiration_idx" ON "nodes" ("deleted", "expiration")`,
+		{Table: "nodes", Index: "nodes_key_modified_idx",
+			Definition: `CREATE INDEX "nodes_key_modified_idx" ON "nodes" ("key", "modified")`},
+		{Table: "nodes", Index: "nodes_deleted_path_depth_key_idx",
+			Definition: `CREATE INDEX "nodes_deleted_path_depth_key_idx" ON "nodes" ("deleted", "path_depth", "key")`},
+		{Table: "nodes", Index: "nodes_deleted_path_depth_idx", Obsolete: true,
+			Definition: `DROP INDEX "nodes_deleted_path_depth_idx" ON "nodes"`},
+		{Table: "nodes", Index: "nodes_deleted_expiration_idx",
+			Definition: `CREATE INDEX "nodes_deleted_expiration_idx" ON "nodes" ("deleted", "expiration")`},
 
-		`CREATE TABLE "index" (
+		{Table: "index", Definition: `CREATE TABLE "index" (
 			"index" bigint,
 			PRIMARY KEY ("index")
-		) ENGINE=InnoDB`,
+		) ENGINE=InnoDB`},
 
-		`CREATE TABLE "changes" (
+		{Table: "changes", Definition: `CREATE TABLE "changes" (
 			"index" bigint,
 			"key" varchar(255) NOT NULL,
 			"action" varchar(32) NOT NULL,
 			"prev_node_modified" bigint,
+			"time" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
 			PRIMARY KEY ("index", "key")
-		) ENGINE=InnoDB DEFAULT CHARSET=utf8`,
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8`},
+		{Table: "changes", Column: "time",
+			Definition: `ALTER TABLE "changes" ADD COLUMN "time" timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP`},
+
+		{Table: "subscriptions", Definition: `CREATE TABLE "subscriptions" (
+			"name" varchar(255),
+			"subscription" text NOT NULL,
+			"next_index" bigint NOT NULL,
+			PRIMARY KEY ("name")
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8`},
+
+		{Table: "members", Definition: `CREATE TABLE "members" (
+			"name" varchar(255),
+			"client_urls" text NOT NULL,
+			"heartbeat" timestamp NOT NULL,
+			PRIMARY KEY ("name")
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8`},
+
+		{Table: "recycle", Definition: `CREATE TABLE "recycle" (
+			"index" bigint,
+			"key" varchar(255) NOT NULL,
+			"expiration" timestamp NOT NULL,
+			PRIMARY KEY ("index")
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8`},
+
+		{Table: "sequences", Definition: `CREATE TABLE "sequences" (
+			"key" varchar(255),
+			"last" bigint NOT NULL,
+			PRIMARY KEY ("key")
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8`},
+
+		{Table: "schema", Definition: `CREATE TABLE "schema" (
+			"version" integer NOT NULL
+		) ENGINE=InnoDB`},
+	}
+}
+
+// Migrations store expirations with fractional seconds from version 2
+func (d MysqlDialect) Migrations() map[int][]string {
+	return map[int][]string{
+		2: {`ALTER TABLE "nodes" MODIFY "expiration" datetime(6) NULL`},
 	}
 }
 
-func (d mysqlDialect) nameParam(params []interface{}) string {
+// SessionSetup enables ANSI_QUOTES, which Open sets in the data source
+func (d MysqlDialect) SessionSetup() []string {
+	return []string{`SET SESSION sql_mode = 'ANSI_QUOTES'`}
+}
+
+// LockSchema takes a named lock with GET_LOCK, which is for the whole
+// server, so the name includes the database
+func (d MysqlDialect) LockSchema(conn *sql.Conn) (func() error, error) {
+	ctx := context.Background()
+	var locked sql.NullInt64
+	err := conn.QueryRowContext(ctx, `SELECT GET_LOCK(CONCAT('etcdb_schema.', DATABASE()), -1)`).Scan(&locked)
+	if err != nil {
+		return nil, err
+	}
+	if locked.Int64 != 1 {
+		return nil, errors.New("GET_LOCK failed")
+	}
+	return func() error {
+		_, err := conn.ExecContext(ctx, `DO RELEASE_LOCK(CONCAT('etcdb_schema.', DATABASE()))`)
+		return err
+	}, nil
+}
+
+func (d MysqlDialect) IndexExists(db Querier, table, index string) (bool, error) {
+	var count int
+	err := NewQuery(d).Extend(`
+		SELECT COUNT(*) FROM information_schema.statistics
+		WHERE table_schema = DATABASE() AND table_name = `, table, ` AND index_name = `, index).
+		QueryRow(db).Scan(&count)
+	return count > 0, err
+}
+
+func (d MysqlDialect) CurrentSchema() string {
+	return "DATABASE()"
+}
+
+func (d MysqlDialect) NameParam(params []interface{}) string {
 	return "?"
 }
 
-func (d mysqlDialect) incrementIndex(db Querier) (index int64, err error) {
-	_, err = db.Exec(`
-		UPDATE "index" SET "index" = "index" + 1
+// IncrementIndex returns the new index as the last insert ID of the update,
+// which LAST_INSERT_ID(expr) sets, since MySQL has no UPDATE ... RETURNING
+func (d MysqlDialect) IncrementIndex(db Querier) (index int64, err error) {
+	res, err := db.Exec(`
+		UPDATE "index" SET "index" = LAST_INSERT_ID("index" + 1)
 		`)
 	if err != nil {
 		return
 	}
-	err = db.QueryRow(`SELECT "index" FROM "index"`).Scan(&index)
-	return
+	return res.LastInsertId()
+}
+
+func (d MysqlDialect) Expiration(q *Query, ttl int64) {
+	q.Extend(`DATE_ADD(UTC_TIMESTAMP(6), INTERVAL `, ttl, ` SECOND)`)
+}
+
+func (d MysqlDialect) Maintenance() []string {
+	return []string{
+		`OPTIMIZE TABLE "nodes"`,
+		`OPTIMIZE TABLE "changes"`,
+	}
+}
+
+// ago is relative to CURRENT_TIMESTAMP, to match the default for the changes
+// table's time column
+func (d MysqlDialect) Ago(q *Query, seconds int64) {
+	q.Extend(`DATE_SUB(CURRENT_TIMESTAMP, INTERVAL `, seconds, ` SECOND)`)
+}
+
+func (d MysqlDialect) Now() string {
+	return "UTC_TIMESTAMP(6)"
+}
+
+// TTL rounds up like etcd, so that a TTL reads the same right after it is set
+func (d MysqlDialect) TTL() string {
+	return "CAST(CEIL(TIMESTAMPDIFF(MICROSECOND, UTC_TIMESTAMP(6), expiration) / 1000000) AS SIGNED)"
+}
+
+func (d MysqlDialect) Lateness() string {
+	return "TIMESTAMPDIFF(MICROSECOND, expiration, UTC_TIMESTAMP(6)) / 1000000"
+}
+
+func (d MysqlDialect) TopLevelKey() string {
+	return `SUBSTRING_INDEX(SUBSTRING("key", 2), '/', 1)`
 }
 
-func (d mysqlDialect) expiration(q *Query, ttl int64) {
-	q.Extend(`DATE_ADD(UTC_TIMESTAMP, INTERVAL `, ttl, ` SECOND)`)
+// snapshot does nothing, since MySQL transactions are REPEATABLE READ by
+// default, reading from a snapshot taken by the first read.
+func (d MysqlDialect) Snapshot(tx *sql.Tx) error {
+	return nil
 }
 
-func (d mysqlDialect) now() string {
-	return "UTC_TIMESTAMP"
+// bulkInsert inserts the rows with multi-row INSERTs of up to bulkBatch rows
+func (d MysqlDialect) BulkInsert(tx *sql.Tx, table string, columns []string, rows [][]interface{}) error {
+	row := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ") + ")"
+	for len(rows) > 0 {
+		batch := rows
+		if len(batch) > bulkBatch {
+			batch = batch[:bulkBatch]
+		}
+		rows = rows[len(batch):]
+
+		values := make([]string, len(batch))
+		var params []interface{}
+		for i, r := range batch {
+			values[i] = row
+			params = append(params, r...)
+		}
+		_, err := tx.Exec(`INSERT INTO "`+table+`" (`+columnList(columns)+`) VALUES `+strings.Join(values, ", "), params...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (d mysqlDialect) ttl() string {
-	return "TIMESTAMPDIFF(SECOND, UTC_TIMESTAMP, expiration)"
+// IsConnectionError is true for too many connections, and the server
+// shutting down
+func (d MysqlDialect) IsConnectionError(err error) bool {
+	if errors.Is(err, mysql.ErrInvalidConn) {
+		return true
+	}
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) {
+		return myErr.Number == 1040 || myErr.Number == 1053
+	}
+	return false
 }
 
-func (d mysqlDialect) isDuplicateKeyError(err error) bool {
+func (d MysqlDialect) IsDuplicateKeyError(err error) bool {
 	if err, ok := err.(*mysql.MySQLError); ok {
 		return err.Number == 1062
 	}
 	return false
 }
 
-// PostgreSQL
+// IgnoreDuplicates updates nothing on a duplicate key, which MySQL doesn't
+// count as an affected row
+func (d MysqlDialect) IgnoreDuplicates() string {
+	return `ON DUPLICATE KEY UPDATE "key" = "key"`
+}
 
-type postgresDialect struct{}
+// PostgresDialect is the dialect of PostgreSQL
+type PostgresDialect struct{}
 
-func (d postgresDialect) Open(driver, dataSource string) (*sql.DB, error) {
+func (d PostgresDialect) Open(driver, dataSource string) (*sql.DB, error) {
 	return sql.Open(driver, dataSource)
 }
 
-func (d postgresDialect) tableDefinitions() []string {
-	return []string{
-		`CREATE TABLE "nodes" (
+func (d PostgresDialect) DataSource(c *ConnConfig) string {
+	return postgresDataSource(c)
+}
+
+// schemaObjects names the indexes as Postgres does by default, since they
+// used to be created without names.
+func (d PostgresDialect) SchemaObjects() []SchemaObject {
+	return []SchemaObject{
+		{Table: "nodes", Definition: `CREATE TABLE "nodes" (
 			"key" varchar(2048),
 			"created" bigint NOT NULL,
 			"modified" bigint NOT NULL,
@@ -122,65 +386,214 @@ func (d postgresDialect) tableDefinitions() []string {
 			"expiration" timestamp,
 			"dir" boolean NOT NULL DEFAULT 'false',
 			"path_depth" integer,
+			"checksum" bigint NULL,
 			PRIMARY KEY ("deleted", "key")
-		)`,
+		)`},
+		{Table: "nodes", Column: "checksum",
+			Definition: `ALTER TABLE "nodes" ADD COLUMN "checksum" bigint NULL`},
 
 		// need varchar_pattern_ops index to optimize LIKE queries
 		// but not allowed in the primary key
-		`CREATE INDEX ON "nodes" ("deleted", "key" varchar_pattern_ops)`,
+		{Table: "nodes", Index: "nodes_deleted_key_idx",
+			Definition: `CREATE INDEX "nodes_deleted_key_idx" ON "nodes" ("deleted", "key" varchar_pattern_ops)`},
 
-		`CREATE INDEX ON "nodes" ("key", "modified")`,
-		`CREATE INDEX ON "nodes" ("deleted", "path_depth")`,
-		`CREATE INDEX ON "nodes" ("deleted", "expiration")`,
+		{Table: "nodes", Index: "nodes_key_modified_idx",
+			Definition: `CREATE INDEX "nodes_key_modified_idx" ON "nodes" ("key", "modified")`},
+		{Table: "nodes", Index: "nodes_deleted_path_depth_key_idx",
+			Definition: `CREATE INDEX "nodes_deleted_path_depth_key_idx" ON "nodes" ("deleted", "path_depth", "key" varchar_pattern_ops)`},
+		{Table: "nodes", Index: "nodes_deleted_path_depth_idx", Obsolete: true,
+			Definition: `DROP INDEX "nodes_deleted_path_depth_idx"`},
+		{Table: "nodes", Index: "nodes_deleted_expiration_idx",
+			Definition: `CREATE INDEX "nodes_deleted_expiration_idx" ON "nodes" ("deleted", "expiration")`},
 
-		`CREATE TABLE "index" (
+		{Table: "index", Definition: `CREATE TABLE "index" (
 			"index" bigint,
 			PRIMARY KEY ("index")
-		)`,
+		)`},
 
-		`CREATE TABLE "changes" (
+		{Table: "changes", Definition: `CREATE TABLE "changes" (
 			"index" bigint,
 			"key" varchar(2048) NOT NULL,
 			"action" varchar(32) NOT NULL,
 			"prev_node_modified" bigint,
+			"time" timestamp NOT NULL DEFAULT (CURRENT_TIMESTAMP AT TIME ZONE 'UTC'),
 			PRIMARY KEY ("index", "key")
-		)`,
+		)`},
+		{Table: "changes", Column: "time",
+			Definition: `ALTER TABLE "changes" ADD COLUMN "time" timestamp NOT NULL DEFAULT (CURRENT_TIMESTAMP AT TIME ZONE 'UTC')`},
 
 		// Postgres isn't using the primary key for the query to refresh
 		// the changes cache:
 		// WHERE "index" > ? ORDER BY "index"
 		// so need another index just on "index" column
-		`CREATE INDEX ON "changes" ("index")`,
+		{Table: "changes", Index: "changes_index_idx",
+			Definition: `CREATE INDEX "changes_index_idx" ON "changes" ("index")`},
+
+		{Table: "subscriptions", Definition: `CREATE TABLE "subscriptions" (
+			"name" varchar(255),
+			"subscription" text NOT NULL,
+			"next_index" bigint NOT NULL,
+			PRIMARY KEY ("name")
+		)`},
+
+		{Table: "members", Definition: `CREATE TABLE "members" (
+			"name" varchar(255),
+			"client_urls" text NOT NULL,
+			"heartbeat" timestamp NOT NULL,
+			PRIMARY KEY ("name")
+		)`},
+
+		{Table: "recycle", Definition: `CREATE TABLE "recycle" (
+			"index" bigint,
+			"key" varchar(2048) NOT NULL,
+			"expiration" timestamp NOT NULL,
+			PRIMARY KEY ("index")
+		)`},
+
+		{Table: "sequences", Definition: `CREATE TABLE "sequences" (
+			"key" varchar(2048),
+			"last" bigint NOT NULL,
+			PRIMARY KEY ("key")
+		)`},
+
+		{Table: "schema", Definition: `CREATE TABLE "schema" (
+			"version" integer NOT NULL
+		)`},
+	}
+}
+
+// Migrations are none, since Postgres timestamps always had fractional
+// seconds
+func (d PostgresDialect) Migrations() map[int][]string {
+	return nil
+}
+
+func (d PostgresDialect) SessionSetup() []string {
+	return nil
+}
+
+// schemaLockKey is the key of the advisory lock on the schema, which is
+// "etcd" in ASCII
+const schemaLockKey = 0x65746364
+
+// LockSchema takes an advisory lock of the session, which is for the
+// database
+func (d PostgresDialect) LockSchema(conn *sql.Conn) (func() error, error) {
+	ctx := context.Background()
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf(`SELECT pg_advisory_lock(%d)`, schemaLockKey)); err != nil {
+		return nil, err
 	}
+	return func() error {
+		_, err := conn.ExecContext(ctx, fmt.Sprintf(`SELECT pg_advisory_unlock(%d)`, schemaLockKey))
+		return err
+	}, nil
 }
 
-func (d postgresDialect) nameParam(params []interface{}) string {
+func (d PostgresDialect) IndexExists(db Querier, table, index string) (bool, error) {
+	var count int
+	err := NewQuery(d).Extend(`
+		SELECT COUNT(*) FROM pg_indexes
+		WHERE schemaname = current_schema() AND tablename = `, table, ` AND indexname = `, index).
+		QueryRow(db).Scan(&count)
+	return count > 0, err
+}
+
+func (d PostgresDialect) CurrentSchema() string {
+	return "current_schema()"
+}
+
+func (d PostgresDialect) NameParam(params []interface{}) string {
 	return fmt.Sprintf("$%d", len(params))
 }
 
-func (d postgresDialect) incrementIndex(db Querier) (index int64, err error) {
+func (d PostgresDialect) IncrementIndex(db Querier) (index int64, err error) {
 	err = db.QueryRow(`
 		UPDATE index SET index = index + 1 RETURNING index
 		`).Scan(&index)
 	return
 }
 
-func (d postgresDialect) expiration(q *Query, ttl int64) {
+func (d PostgresDialect) Expiration(q *Query, ttl int64) {
 	q.Extend(`CURRENT_TIMESTAMP AT TIME ZONE 'UTC' + `,
 		strconv.FormatInt(ttl, 10),
 		`::INTERVAL`,
 	)
 }
 
-func (d postgresDialect) now() string {
+func (d PostgresDialect) Maintenance() []string {
+	return []string{
+		`VACUUM ANALYZE "nodes"`,
+		`VACUUM ANALYZE "changes"`,
+	}
+}
+
+func (d PostgresDialect) Ago(q *Query, seconds int64) {
+	d.Expiration(q, -seconds)
+}
+
+func (d PostgresDialect) Now() string {
 	return `CURRENT_TIMESTAMP AT TIME ZONE 'UTC'`
 }
 
-func (d postgresDialect) ttl() string {
-	return "CAST(EXTRACT(EPOCH FROM expiration) - EXTRACT(EPOCH FROM CURRENT_TIMESTAMP) AS integer)"
+// TTL rounds up like etcd, so that a TTL reads the same right after it is set
+func (d PostgresDialect) TTL() string {
+	return "CAST(CEIL(EXTRACT(EPOCH FROM expiration) - EXTRACT(EPOCH FROM CURRENT_TIMESTAMP)) AS integer)"
+}
+
+func (d PostgresDialect) Lateness() string {
+	return "EXTRACT(EPOCH FROM (CURRENT_TIMESTAMP AT TIME ZONE 'UTC') - expiration)"
+}
+
+func (d PostgresDialect) TopLevelKey() string {
+	return `SPLIT_PART("key", '/', 2)`
+}
+
+// snapshot makes the transaction REPEATABLE READ, so that all its queries
+// read from the same snapshot instead of each seeing the latest commits.
+func (d PostgresDialect) Snapshot(tx *sql.Tx) error {
+	_, err := tx.Exec(`SET TRANSACTION ISOLATION LEVEL REPEATABLE READ`)
+	return err
+}
+
+// bulkInsert copies the rows into the table with COPY FROM STDIN
+func (d PostgresDialect) BulkInsert(tx *sql.Tx, table string, columns []string, rows [][]interface{}) error {
+	stmt, err := tx.Prepare(pq.CopyIn(table, columns...))
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if _, err := stmt.Exec(row...); err != nil {
+			stmt.Close()
+			return err
+		}
+	}
+	// flushes the buffered rows
+	if _, err := stmt.Exec(); err != nil {
+		stmt.Close()
+		return err
+	}
+	return stmt.Close()
+}
+
+func (d PostgresDialect) IgnoreDuplicates() string {
+	return `ON CONFLICT DO NOTHING`
+}
+
+// IsConnectionError is true for the connection exception class 08, and the
+// operator intervention errors of the server shutting down or starting
+func (d PostgresDialect) IsConnectionError(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case "57P01", "57P02", "57P03":
+			return true
+		}
+		return pqErr.Code.Class() == "08"
+	}
+	return false
 }
 
-func (d postgresDialect) isDuplicateKeyError(err error) bool {
+func (d PostgresDialect) IsDuplicateKeyError(err error) bool {
 	if err, ok := err.(*pq.Error); ok {
 		return err.Code == "23505"
 	}
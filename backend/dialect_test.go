@@ -0,0 +1,96 @@
+package backend
+
+import (
+	"database/sql"
+	"sort"
+	"testing"
+)
+
+// cockroachDialect is like Postgres, but opens the database with the
+// postgres driver under its own name
+type cockroachDialect struct {
+	PostgresDialect
+}
+
+func (d cockroachDialect) Open(driver, dataSource string) (*sql.DB, error) {
+	return d.PostgresDialect.Open("postgres", dataSource)
+}
+
+func (d cockroachDialect) Maintenance() []string {
+	return nil
+}
+
+func Test_RegisterDialect(t *testing.T) {
+	RegisterDialect("test-cockroach", cockroachDialect{})
+	defer func() {
+		dialectsMu.Lock()
+		delete(dialects, "test-cockroach")
+		dialectsMu.Unlock()
+	}()
+
+	dialect, err := dialectFor("test-cockroach")
+	ok(t, err)
+	equals(t, 0, len(dialect.Maintenance()))
+	equals(t, "$2", dialect.NameParam(make([]interface{}, 2)))
+	equals(t, []string{"mysql", "postgres", "test-cockroach"}, Dialects())
+
+	config := &ConnConfig{Host: "db", DBName: "etcdb"}
+	dataSource, err := config.DataSource("test-cockroach")
+	ok(t, err)
+	equals(t, "host=db dbname=etcdb", dataSource)
+}
+
+func Test_RegisterDialect_Twice(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected registering postgres again to panic")
+		}
+	}()
+	RegisterDialect("postgres", PostgresDialect{})
+}
+
+func Test_IncrementIndex_Concurrent(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	start := currIndex(store)
+
+	// each transaction gets its own index, even if the increments interleave
+	const writers = 10
+	indexes := make(chan int64, writers)
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		go func() {
+			tx, err := store.conn().Begin()
+			if err != nil {
+				errs <- err
+				return
+			}
+			index, err := store.dialect.IncrementIndex(tx)
+			if err != nil {
+				tx.Rollback()
+				errs <- err
+				return
+			}
+			indexes <- index
+			errs <- tx.Commit()
+		}()
+	}
+
+	var got []int64
+	for i := 0; i < writers; i++ {
+		ok(t, <-errs)
+	}
+	close(indexes)
+	for index := range indexes {
+		got = append(got, index)
+	}
+	sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+
+	var expected []int64
+	for i := int64(1); i <= writers; i++ {
+		expected = append(expected, start+i)
+	}
+	equals(t, expected, got)
+	equals(t, start+writers, currIndex(store))
+}
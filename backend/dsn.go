@@ -0,0 +1,127 @@
+package backend
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ConnConfig holds the database connection parameters in a driver-independent
+// form. It is translated to the driver's own data source syntax by DataSource.
+type ConnConfig struct {
+	Host     string
+	Port     int
+	User     string
+	Password string
+	DBName   string
+	// Options are passed through to the driver as extra connection
+	// parameters, e.g. sslmode for Postgres or timeout for MySQL.
+	Options map[string]string
+}
+
+// ParseOptions parses a comma separated list of name=value pairs into a map
+// suitable for ConnConfig.Options.
+func ParseOptions(s string) (map[string]string, error) {
+	options := make(map[string]string)
+	for _, opt := range strings.Split(s, ",") {
+		opt = strings.TrimSpace(opt)
+		if opt == "" {
+			continue
+		}
+		parts := strings.SplitN(opt, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("Invalid connection option %q, should be name=value", opt)
+		}
+		options[parts[0]] = parts[1]
+	}
+	return options, nil
+}
+
+// DataSource formats the config as a data source string for the driver.
+func (c *ConnConfig) DataSource(driver string) (string, error) {
+	dialect, err := dialectFor(driver)
+	if err != nil {
+		return "", err
+	}
+	return dialect.DataSource(c), nil
+}
+
+func (c *ConnConfig) sortedOptions() []string {
+	names := make([]string, 0, len(c.Options))
+	for name := range c.Options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// mysqlDataSource formats the config in the go-sql-driver/mysql DSN syntax:
+// user:password@tcp(host:port)/dbname?param=value
+func mysqlDataSource(c *ConnConfig) string {
+	var buf bytes.Buffer
+
+	if c.User != "" {
+		buf.WriteString(c.User)
+		if c.Password != "" {
+			buf.WriteString(":" + c.Password)
+		}
+		buf.WriteString("@")
+	}
+
+	if c.Host != "" {
+		addr := c.Host
+		if c.Port != 0 {
+			addr = net.JoinHostPort(strings.Trim(c.Host, "[]"), strconv.Itoa(c.Port))
+		}
+		buf.WriteString("tcp(" + addr + ")")
+	}
+
+	buf.WriteString("/" + c.DBName)
+
+	sep := "?"
+	for _, name := range c.sortedOptions() {
+		buf.WriteString(sep + name + "=" + url.QueryEscape(c.Options[name]))
+		sep = "&"
+	}
+
+	return buf.String()
+}
+
+// postgresDataSource formats the config in the lib/pq key/value syntax:
+// host=hostname port=5432 user=username dbname=dbname sslmode=disable
+func postgresDataSource(c *ConnConfig) string {
+	var params []string
+	add := func(name, value string) {
+		if value != "" {
+			params = append(params, name+"="+quotePostgresValue(value))
+		}
+	}
+
+	add("host", c.Host)
+	if c.Port != 0 {
+		add("port", strconv.Itoa(c.Port))
+	}
+	add("user", c.User)
+	add("password", c.Password)
+	add("dbname", c.DBName)
+	for _, name := range c.sortedOptions() {
+		add(name, c.Options[name])
+	}
+
+	return strings.Join(params, " ")
+}
+
+// quotePostgresValue single-quotes values containing whitespace, quotes or
+// backslashes, as required by the key/value connection string format.
+func quotePostgresValue(value string) string {
+	if !strings.ContainsAny(value, " \t\n'\\") {
+		return value
+	}
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, `'`, `\'`, -1)
+	return "'" + value + "'"
+}
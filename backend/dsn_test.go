@@ -0,0 +1,69 @@
+package backend
+
+import "testing"
+
+func Test_DataSource_Postgres(t *testing.T) {
+	config := &ConnConfig{
+		Host:     "db.example.com",
+		Port:     5432,
+		User:     "etcdb",
+		Password: "it's secret",
+		DBName:   "etcdb",
+		Options:  map[string]string{"sslmode": "disable", "connect_timeout": "5"},
+	}
+
+	dataSource, err := config.DataSource("postgres")
+	ok(t, err)
+	equals(t, `host=db.example.com port=5432 user=etcdb password='it\'s secret' dbname=etcdb connect_timeout=5 sslmode=disable`, dataSource)
+}
+
+func Test_DataSource_MySQL(t *testing.T) {
+	config := &ConnConfig{
+		Host:     "db.example.com",
+		Port:     3306,
+		User:     "etcdb",
+		Password: "secret",
+		DBName:   "etcdb",
+		Options:  map[string]string{"timeout": "5s", "tls": "skip-verify"},
+	}
+
+	dataSource, err := config.DataSource("mysql")
+	ok(t, err)
+	equals(t, "etcdb:secret@tcp(db.example.com:3306)/etcdb?timeout=5s&tls=skip-verify", dataSource)
+}
+
+func Test_DataSource_MySQL_IPv6(t *testing.T) {
+	config := &ConnConfig{Host: "::1", Port: 3306, User: "root", DBName: "etcd_test"}
+
+	dataSource, err := config.DataSource("mysql")
+	ok(t, err)
+	equals(t, "root@tcp([::1]:3306)/etcd_test", dataSource)
+}
+
+func Test_DataSource_MySQL_Defaults(t *testing.T) {
+	config := &ConnConfig{User: "root", DBName: "etcd_test"}
+
+	dataSource, err := config.DataSource("mysql")
+	ok(t, err)
+	equals(t, "root@/etcd_test", dataSource)
+}
+
+func Test_DataSource_UnknownDriver(t *testing.T) {
+	config := &ConnConfig{}
+
+	_, err := config.DataSource("oracle")
+	if err == nil {
+		fatalf(t, "expected an error for an unknown driver")
+	}
+}
+
+func Test_ParseOptions(t *testing.T) {
+	options, err := ParseOptions("sslmode=disable, connect_timeout=5")
+	ok(t, err)
+	equals(t, map[string]string{"sslmode": "disable", "connect_timeout": "5"}, options)
+
+	_, err = ParseOptions("sslmode")
+	if err == nil {
+		fatalf(t, "expected an error for an option without a value")
+	}
+}
@@ -0,0 +1,87 @@
+package backend
+
+import (
+	"errors"
+	"time"
+
+	"github.com/rancher/etcdb/models"
+)
+
+// ErrCampaignTimeout is returned by Elections.Campaign when the candidate
+// didn't become the leader before the timeout.
+var ErrCampaignTimeout = errors.New("campaign timeout")
+
+// Elections implements leader election in the style of etcd's old leader
+// module.
+//
+// The leader's name is the value of the election key, which has a TTL that
+// the leader must keep renewing. Candidates create the key with
+// prevExist=false, and watch it to try again when the leader resigns or its
+// key expires.
+type Elections struct {
+	store   *SqlBackend
+	watcher *ChangeWatcher
+}
+
+// NewElections creates an Elections using the watcher to wait for leader
+// changes
+func NewElections(store *SqlBackend, watcher *ChangeWatcher) *Elections {
+	return &Elections{store, watcher}
+}
+
+// Campaign waits until the name is the leader for the key, and returns the
+// election node. If the name is already the leader, its TTL is renewed. A
+// negative timeout waits forever.
+func (e *Elections) Campaign(key, name string, ttl int64, timeout time.Duration) (*models.Node, error) {
+	var deadline <-chan time.Time
+	if timeout >= 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	for {
+		node, _, err := e.store.SetTTL(key, name, ttl, PrevExist(false))
+		if err == nil {
+			return node, nil
+		}
+		existsErr, ok := err.(models.Error)
+		if !ok || existsErr.ErrorCode != 105 {
+			return nil, err
+		}
+
+		node, err = e.Renew(key, name, ttl)
+		if err == nil {
+			return node, nil
+		}
+		if etcdErr, ok := err.(models.Error); !ok || (etcdErr.ErrorCode != 100 && etcdErr.ErrorCode != 101) {
+			return nil, err
+		}
+
+		_, err = e.watcher.NextChangeUntil(key, false, existsErr.Index+1, deadline)
+		if err == ErrWatchTimeout {
+			return nil, ErrCampaignTimeout
+		}
+	}
+}
+
+// Renew resets the TTL of the election key, if the name is still the leader.
+func (e *Elections) Renew(key, name string, ttl int64) (*models.Node, error) {
+	node, _, err := e.store.SetTTL(key, name, ttl, PrevValue(name))
+	return node, err
+}
+
+// Resign removes the election key, if the name is the leader.
+func (e *Elections) Resign(key, name string) error {
+	_, _, err := e.store.Delete(key, PrevValue(name))
+	return err
+}
+
+// Leader returns the name of the current leader for the key.
+func (e *Elections) Leader(key string) (string, error) {
+	node, err := e.store.Get(key, false)
+	if err != nil {
+		return "", err
+	}
+	return node.Value, nil
+}
@@ -0,0 +1,85 @@
+package backend
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_Election_Campaign(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	cw := Watch(store, 100*time.Millisecond)
+	defer cw.Stop()
+
+	elections := NewElections(store, cw)
+
+	_, err := elections.Campaign("/leader", "first", 60, -1)
+	ok(t, err)
+
+	leader, err := elections.Leader("/leader")
+	ok(t, err)
+	equals(t, "first", leader)
+
+	// campaigning again as the leader just renews
+	_, err = elections.Campaign("/leader", "first", 60, 0)
+	ok(t, err)
+}
+
+func Test_Election_WaitsForResign(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	cw := Watch(store, 100*time.Millisecond)
+	defer cw.Stop()
+
+	elections := NewElections(store, cw)
+
+	_, err := elections.Campaign("/leader", "first", 60, -1)
+	ok(t, err)
+
+	elected := make(chan error)
+	go func() {
+		_, err := elections.Campaign("/leader", "second", 60, -1)
+		elected <- err
+	}()
+
+	select {
+	case <-elected:
+		fatalf(t, "second should not be elected while first is the leader")
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	// only the leader can resign
+	err = elections.Resign("/leader", "second")
+	expectError(t, "Compare failed", "[second != first]", err)
+
+	ok(t, elections.Resign("/leader", "first"))
+
+	select {
+	case err := <-elected:
+		ok(t, err)
+	case <-time.After(5 * time.Second):
+		fatalf(t, "second should be elected after first resigns")
+	}
+
+	leader, err := elections.Leader("/leader")
+	ok(t, err)
+	equals(t, "second", leader)
+}
+
+func Test_Election_Timeout(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	cw := Watch(store, 100*time.Millisecond)
+	defer cw.Stop()
+
+	elections := NewElections(store, cw)
+
+	_, err := elections.Campaign("/leader", "first", 60, -1)
+	ok(t, err)
+
+	_, err = elections.Campaign("/leader", "second", 60, 200*time.Millisecond)
+	equals(t, ErrCampaignTimeout, err)
+}
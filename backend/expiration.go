@@ -0,0 +1,81 @@
+package backend
+
+import (
+	"expvar"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ExpirationStats measures how late keys are expired after their expiration
+// time, published with expvar. Expired keys are only removed when a write or
+// watch poll purges them, so the lateness depends on how often those happen.
+//
+// "count" and "sum_seconds" count the expirations and their total lateness,
+// "max_seconds" is the latest one, and each "le_<seconds>" bucket counts the
+// expirations at most that late. "missed" counts the expirations later than
+// the objective set with SetExpirationObjective.
+var ExpirationStats = expvar.NewMap("expiration")
+
+// expirationBuckets are the upper bounds of the lateness histogram
+var expirationBuckets = []time.Duration{
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2 * time.Second,
+	5 * time.Second,
+	10 * time.Second,
+	30 * time.Second,
+}
+
+var expirationMax = new(expvar.Float)
+
+func init() {
+	ExpirationStats.Set("max_seconds", expirationMax)
+}
+
+type expirationObjective struct {
+	mu  sync.Mutex
+	max time.Duration
+}
+
+// SetExpirationObjective sets the lateness after which expirations are counted
+// as missed. Zero doesn't count any.
+func (b *SqlBackend) SetExpirationObjective(max time.Duration) {
+	b.expiry.mu.Lock()
+	defer b.expiry.mu.Unlock()
+	b.expiry.max = max
+}
+
+// recordExpirations adds the lateness of each expiration, in seconds, to the
+// stats.
+func (b *SqlBackend) recordExpirations(lateness []float64) {
+	b.expiry.mu.Lock()
+	objective := b.expiry.max
+	b.expiry.mu.Unlock()
+
+	for _, seconds := range lateness {
+		// the database clock may have a coarser precision than the expiration
+		if seconds < 0 {
+			seconds = 0
+		}
+		late := time.Duration(seconds * float64(time.Second))
+
+		ExpirationStats.Add("count", 1)
+		ExpirationStats.AddFloat("sum_seconds", seconds)
+		if seconds > expirationMax.Value() {
+			expirationMax.Set(seconds)
+		}
+
+		for _, le := range expirationBuckets {
+			if late <= le {
+				ExpirationStats.Add(fmt.Sprintf("le_%g", le.Seconds()), 1)
+			}
+		}
+
+		if objective > 0 && late > objective {
+			ExpirationStats.Add("missed", 1)
+		}
+	}
+}
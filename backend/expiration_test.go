@@ -0,0 +1,52 @@
+package backend
+
+import (
+	"testing"
+	"time"
+)
+
+func expirationStat(name string) string {
+	if v := ExpirationStats.Get(name); v != nil {
+		return v.String()
+	}
+	return ""
+}
+
+func Test_RecordExpirations(t *testing.T) {
+	store := &SqlBackend{}
+	store.SetExpirationObjective(time.Second)
+
+	ExpirationStats.Init()
+	expirationMax.Set(0)
+	ExpirationStats.Set("max_seconds", expirationMax)
+
+	store.recordExpirations([]float64{0.05, 0.3, 2.5, -0.2})
+
+	equals(t, "4", expirationStat("count"))
+	equals(t, "2", expirationStat("le_0.1"))
+	equals(t, "3", expirationStat("le_0.5"))
+	equals(t, "3", expirationStat("le_2"))
+	equals(t, "4", expirationStat("le_5"))
+	equals(t, "1", expirationStat("missed"))
+	equals(t, "2.5", expirationStat("max_seconds"))
+	equals(t, "2.85", expirationStat("sum_seconds"))
+}
+
+func Test_Expire_RecordsLateness(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	ttl := int64(-1)
+	_, _, err := store.SetTTL("/foo", "bar", ttl, Always)
+	ok(t, err)
+
+	before := expirationStat("count")
+
+	// purges the expired key
+	_, err = store.Get("/foo", false)
+	expectError(t, "Key not found", "/foo", err)
+
+	if expirationStat("count") == before {
+		fatalf(t, "expected the expiration to be counted")
+	}
+}
@@ -0,0 +1,172 @@
+package backend
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// FailoverStats counts the "probe_failures" of the database, the
+// "failovers" to another datasource, and the "candidate_errors" of the ones
+// that couldn't be switched to, published with expvar. "active" is the
+// position of the datasource in use, like ActiveDataSource.
+var FailoverStats = expvar.NewMap("failover")
+
+var failoverActive = new(expvar.Int)
+
+func init() {
+	FailoverStats.Set("active", failoverActive)
+}
+
+// AddFailover adds the datasource of a standby that can take over as the
+// primary, which a Failover switches to when the primary can't be reached.
+func (b *SqlBackend) AddFailover(dataSource string) {
+	b.dbMu.Lock()
+	defer b.dbMu.Unlock()
+	b.dataSources = append(b.dataSources, dataSource)
+}
+
+// ActiveDataSource returns the position of the datasource in use: 0 for the
+// one the backend was created or last reconnected with, and from 1 for the
+// failover datasources in the order they were added.
+func (b *SqlBackend) ActiveDataSource() int {
+	b.dbMu.RLock()
+	defer b.dbMu.RUnlock()
+	return b.activeSource
+}
+
+// ping checks that the database in use can be reached within the timeout
+func (b *SqlBackend) ping(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return b.conn().PingContext(ctx)
+}
+
+// failOver switches to the next of the other datasources, in order, that can
+// be connected to and written to. Like after Reconnect, the old connections
+// are closed after the ReconnectGrace.
+func (b *SqlBackend) failOver(timeout time.Duration) error {
+	b.dbMu.RLock()
+	dataSources := append([]string(nil), b.dataSources...)
+	active := b.activeSource
+	b.dbMu.RUnlock()
+
+	if len(dataSources) < 2 {
+		return errors.New("no failover datasources")
+	}
+
+	var err error
+	for n := 1; n < len(dataSources); n++ {
+		i := (active + n) % len(dataSources)
+		db, openErr := b.openWritable(dataSources[i], timeout)
+		if openErr != nil {
+			FailoverStats.Add("candidate_errors", 1)
+			err = fmt.Errorf("datasource %d: %v", i, openErr)
+			continue
+		}
+
+		b.dbMu.Lock()
+		old := b.db
+		b.db = db
+		b.activeSource = i
+		b.dbMu.Unlock()
+
+		atomic.AddUint64(&b.switches, 1)
+		failoverActive.Set(int64(i))
+		FailoverStats.Add("failovers", 1)
+		time.AfterFunc(ReconnectGrace, func() { old.Close() })
+		return nil
+	}
+	return err
+}
+
+// openWritable opens the datasource, and checks that it isn't a standby
+// that is still read-only, with an update of no rows that read-only
+// databases refuse anyway
+func (b *SqlBackend) openWritable(dataSource string, timeout time.Duration) (*sql.DB, error) {
+	db, err := b.dialect.Open(b.driver, dataSource)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if _, err := db.ExecContext(ctx, `UPDATE "index" SET "index" = "index" WHERE 1 = 0`); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Failover probes the database in the background, and switches to another
+// datasource added with AddFailover when the one in use can't be reached.
+type Failover struct {
+	store    *SqlBackend
+	interval time.Duration
+	failures int
+	// failed counts the probes that failed in a row
+	failed int
+	stop   chan struct{}
+}
+
+// StartFailover creates and starts a Failover for the store. The database
+// is pinged every interval, and after failures pings in a row failed or
+// timed out after the interval, the store switches to the next datasource
+// that can be written to.
+func StartFailover(store *SqlBackend, interval time.Duration, failures int) *Failover {
+	f := &Failover{
+		store:    store,
+		interval: interval,
+		failures: failures,
+		stop:     make(chan struct{}),
+	}
+	go f.Run()
+	return f
+}
+
+// Stop stops the probes
+func (f *Failover) Stop() {
+	close(f.stop)
+}
+
+// Run probes the database every interval until stopped
+func (f *Failover) Run() {
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-f.stop:
+			return
+		case <-ticker.C:
+			f.probe()
+		}
+	}
+}
+
+func (f *Failover) probe() {
+	err := f.store.ping(f.interval)
+	if err == nil {
+		f.failed = 0
+		return
+	}
+	FailoverStats.Add("probe_failures", 1)
+	f.failed++
+	log.Printf("database probe failed (%d in a row): %v", f.failed, err)
+	if f.failed < f.failures {
+		return
+	}
+
+	// until it succeeds, failing over is tried again on every probe
+	if err := f.store.failOver(f.interval); err != nil {
+		log.Println("error failing over:", err)
+		return
+	}
+	f.failed = 0
+	log.Println("failed over to database datasource", f.store.ActiveDataSource())
+}
@@ -0,0 +1,65 @@
+package backend
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_FailOver(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	err := store.failOver(time.Second)
+	equals(t, "no failover datasources", err.Error())
+
+	store.AddFailover(dbDataSource)
+	ok(t, store.failOver(time.Second))
+	equals(t, 1, store.ActiveDataSource())
+
+	_, _, err = store.Set("/foo", "bar", Always)
+	ok(t, err)
+
+	// fails back to the primary, after the standby
+	ok(t, store.failOver(time.Second))
+	equals(t, 0, store.ActiveDataSource())
+}
+
+func Test_FailOver_SkipsUnreachable(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	store.AddFailover("host=127.0.0.1 port=1")
+	store.AddFailover(dbDataSource)
+	ok(t, store.failOver(time.Second))
+	equals(t, 2, store.ActiveDataSource())
+}
+
+func Test_Reconnect_KeepsFailover(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	store.AddFailover(dbDataSource)
+	ok(t, store.failOver(time.Second))
+	ok(t, store.Reconnect(dbDataSource))
+	equals(t, 1, store.ActiveDataSource())
+	equals(t, uint64(2), store.switches)
+}
+
+func Test_Watch_RewindsAfterFailover(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	_, _, err := store.Set("/foo", "bar", Always)
+	ok(t, err)
+
+	cw := &ChangeWatcher{store: store, changes: newChangeList(10)}
+	cw.refresh()
+	equals(t, currIndex(store), cw.lastIndex)
+
+	// a standby that is behind the watched changes
+	cw.lastIndex += 10
+	store.AddFailover(dbDataSource)
+	ok(t, store.failOver(time.Second))
+	cw.refresh()
+	equals(t, currIndex(store), cw.lastIndex)
+}
@@ -0,0 +1,162 @@
+package backend
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rancher/etcdb/models"
+)
+
+// fuzzSeeds are awkward inputs for both keys and values: SQL quoting
+// characters, LIKE wildcards, NULs, non-ASCII text and long strings.
+var fuzzSeeds = []string{
+	"foo",
+	"it's",
+	`say "hello"`,
+	`back\slash`,
+	"100%",
+	"a_b",
+	"'; DROP TABLE nodes; --",
+	"nul\x00byte",
+	"emoji \U0001F600",
+	"日本語",
+	"a/b/c",
+	strings.Repeat("x", 1000),
+}
+
+// fuzzKey builds a key under /fuzz from arbitrary input. Empty path segments
+// are dropped, since keys are expected to be already cleaned.
+func fuzzKey(s string) string {
+	var parts []string
+	for _, part := range strings.Split(s, "/") {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	if len(parts) == 0 {
+		parts = []string{"empty"}
+	}
+	return "/fuzz/" + strings.Join(parts, "/")
+}
+
+func FuzzSetGetDelete(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed, seed)
+	}
+
+	f.Fuzz(func(t *testing.T, rawKey, value string) {
+		store := testConn(t)
+		defer store.Close()
+
+		key := fuzzKey(rawKey)
+
+		// the database may legitimately refuse some inputs (e.g. NULs or keys
+		// longer than the column), but it must never store something else
+		if _, _, err := store.Set(key, value, Always); err != nil {
+			if _, ok := err.(models.Error); ok {
+				t.Fatalf("unexpected etcd error setting %q: %s", key, err)
+			}
+			return
+		}
+
+		node, err := store.Get(key, false)
+		ok(t, err)
+		equals(t, key, node.Key)
+		equals(t, value, node.Value)
+
+		dir, err := store.Get("/fuzz", true)
+		ok(t, err)
+		equals(t, "/fuzz", dir.Key)
+
+		prevNode, _, err := store.Delete(key, Always)
+		ok(t, err)
+		equals(t, value, prevNode.Value)
+
+		_, err = store.Get(key, false)
+		expectError(t, "Key not found", key, err)
+	})
+}
+
+func FuzzMkDirRmDir(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, rawKey string) {
+		store := testConn(t)
+		defer store.Close()
+
+		key := fuzzKey(rawKey)
+
+		if _, _, err := store.MkDir(key, nil, Always); err != nil {
+			if _, ok := err.(models.Error); ok {
+				t.Fatalf("unexpected etcd error creating %q: %s", key, err)
+			}
+			return
+		}
+
+		// a sibling whose name matches the key as a LIKE pattern must not be
+		// treated as a child of the key
+		sibling := strings.NewReplacer("_", "x", "%", "x").Replace(key)
+		if sibling == key {
+			sibling = ""
+		} else if _, _, err := store.Set(sibling+"/child", "value", Always); err != nil {
+			sibling = ""
+		}
+
+		node, err := store.Get(key, true)
+		ok(t, err)
+		equals(t, true, node.Dir)
+		equals(t, 0, len(node.Nodes))
+
+		_, _, err = store.RmDir(key, false, Always)
+		ok(t, err)
+
+		if sibling != "" {
+			node, err = store.Get(sibling+"/child", false)
+			ok(t, err)
+			equals(t, "value", node.Value)
+		}
+	})
+}
+
+func FuzzKeyHelpers(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed, seed)
+	}
+
+	f.Fuzz(func(t *testing.T, a, b string) {
+		a, b = fuzzKey(a), fuzzKey(b)
+
+		if parent := splitKey(a); pathDepth(parent) != pathDepth(a)-1 {
+			t.Fatalf("parent %q of %q should be one level up", parent, a)
+		}
+
+		w := &watch{Key: a, Recursive: true}
+		c := &change{Key: b, Action: "delete"}
+		// must not panic for keys of any length
+		w.Match(c)
+
+		equals(t, strings.HasPrefix(b, a+"/"), isParent(a, b))
+	})
+}
+
+func FuzzCleanKey(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed)
+	}
+	f.Add("//a/./b/../c/")
+
+	f.Fuzz(func(t *testing.T, key string) {
+		cleaned := CleanKey(key)
+		if !strings.HasPrefix(cleaned, "/") || cleaned != "/" && strings.HasSuffix(cleaned, "/") {
+			t.Fatalf("%q cleaned to %q, without a leading slash or with a trailing one", key, cleaned)
+		}
+		for _, s := range strings.Split(cleaned, "/")[1:] {
+			if s == "" && cleaned != "/" || s == "." || s == ".." {
+				t.Fatalf("%q cleaned to %q, with a %q segment", key, cleaned, s)
+			}
+		}
+		equals(t, cleaned, CleanKey(cleaned))
+	})
+}
@@ -0,0 +1,178 @@
+package backend
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"expvar"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/rancher/etcdb/models"
+)
+
+// HealthStats tracks the connections to the database, published with
+// expvar. "healthy" is 1 while requests are served, and 0 while they fail
+// fast. It counts the "connection_errors", the requests that "failed_fast",
+// the "pool_resets" tried while the database is unavailable, and the
+// "recoveries" from it.
+var HealthStats = expvar.NewMap("dbHealth")
+
+var healthy = new(expvar.Int)
+
+func init() {
+	healthy.Set(1)
+	HealthStats.Set("healthy", healthy)
+}
+
+// connHealth tracks the connection errors in a row, to stop sending requests
+// to a database that can't be reached, and reset the connection pool until it
+// can.
+type connHealth struct {
+	// failures is how many connection errors in a row make the database
+	// unavailable, or 0 to never fail fast
+	failures      int
+	resetInterval time.Duration
+	// reset is resetPool, but can be replaced in tests
+	reset func() error
+
+	mu sync.Mutex
+	// errors counts the connection errors in a row
+	errors int
+	// down is when the database became unavailable, or zero if it is
+	// available
+	down time.Time
+}
+
+// SetHealthCheck makes requests fail fast with a DatabaseUnavailable error
+// after failures connection errors in a row, instead of waiting for the
+// database to time out. The connection pool is then reset every
+// resetInterval, until the database can be reached again. It is disabled
+// with 0 failures. It must be set before the backend is used.
+func (b *SqlBackend) SetHealthCheck(failures int, resetInterval time.Duration) {
+	b.health.failures = failures
+	b.health.resetInterval = resetInterval
+	b.health.reset = b.resetPool
+}
+
+// available returns a DatabaseUnavailable error while the database is
+// unavailable
+func (b *SqlBackend) available() error {
+	h := &b.health
+	if h.failures == 0 {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.down.IsZero() {
+		return nil
+	}
+	HealthStats.Add("failed_fast", 1)
+	return models.DatabaseUnavailable("the database can't be reached since " + h.down.UTC().Format(time.RFC3339))
+}
+
+// observe records the result of a statement, and after failures connection
+// errors in a row, makes the database unavailable and starts resetting the
+// connection pool
+func (b *SqlBackend) observe(err error) {
+	h := &b.health
+	if h.failures == 0 {
+		return
+	}
+	connErr := err != nil && b.isConnectionError(err)
+	if connErr {
+		HealthStats.Add("connection_errors", 1)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.down.IsZero() {
+		return
+	}
+	if !connErr {
+		h.errors = 0
+		return
+	}
+	h.errors++
+	if h.errors < h.failures {
+		return
+	}
+
+	h.down = time.Now()
+	healthy.Set(0)
+	log.Printf("database unavailable after %d connection errors in a row, failing requests until it can be reached: %v", h.errors, err)
+	go b.recoverPool(h.down)
+}
+
+// recoverPool resets the connection pool every resetInterval, until the new
+// one can reach the database or the backend is closed
+func (b *SqlBackend) recoverPool(down time.Time) {
+	ticker := time.NewTicker(b.health.resetInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.closing:
+			return
+		case <-ticker.C:
+		}
+
+		HealthStats.Add("pool_resets", 1)
+		if err := b.health.reset(); err != nil {
+			continue
+		}
+
+		h := &b.health
+		h.mu.Lock()
+		h.down = time.Time{}
+		h.errors = 0
+		h.mu.Unlock()
+
+		healthy.Set(1)
+		HealthStats.Add("recoveries", 1)
+		log.Printf("database connection recovered after %s", time.Since(down).Round(time.Millisecond))
+		return
+	}
+}
+
+// resetPool replaces the connections with new ones to the same data source,
+// if it can be reached. The old ones are closed after the ReconnectGrace.
+func (b *SqlBackend) resetPool() error {
+	b.dbMu.RLock()
+	dataSource := b.dataSources[b.activeSource]
+	b.dbMu.RUnlock()
+
+	db, err := b.dialect.Open(b.driver, dataSource)
+	if err != nil {
+		return err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return err
+	}
+
+	b.dbMu.Lock()
+	old := b.db
+	b.db = db
+	b.dbMu.Unlock()
+
+	time.AfterFunc(ReconnectGrace, func() { old.Close() })
+	return nil
+}
+
+// isConnectionError reports whether the error is from the connection to the
+// database, rather than from the statement
+func (b *SqlBackend) isConnectionError(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	return b.dialect.IsConnectionError(err)
+}
@@ -0,0 +1,79 @@
+package backend
+
+import (
+	"database/sql/driver"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/rancher/etcdb/models"
+)
+
+func Test_IsConnectionError(t *testing.T) {
+	store := &SqlBackend{dialect: PostgresDialect{}}
+
+	equals(t, true, store.isConnectionError(driver.ErrBadConn))
+	equals(t, true, store.isConnectionError(&pq.Error{Code: "57P01"}))
+	equals(t, true, store.isConnectionError(&pq.Error{Code: "08006"}))
+	equals(t, false, store.isConnectionError(&pq.Error{Code: "23505"}))
+	equals(t, false, store.isConnectionError(errors.New("syntax error")))
+}
+
+func Test_Health_FailsFast(t *testing.T) {
+	store := &SqlBackend{dialect: PostgresDialect{}}
+	store.SetHealthCheck(2, time.Millisecond)
+	resets := make(chan error)
+	store.health.reset = func() error { return <-resets }
+
+	// other errors don't count, and reset the connection errors in a row
+	store.observe(driver.ErrBadConn)
+	store.observe(errors.New("syntax error"))
+	store.observe(driver.ErrBadConn)
+	ok(t, store.available())
+
+	store.observe(driver.ErrBadConn)
+	err := store.available()
+	equals(t, 302, err.(models.Error).ErrorCode)
+
+	// still unavailable until a reset succeeds
+	resets <- errors.New("connection refused")
+	equals(t, 302, store.available().(models.Error).ErrorCode)
+	resets <- nil
+
+	for start := time.Now(); store.available() != nil; time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatal("not recovered after the pool reset")
+		}
+	}
+}
+
+func Test_Health_Disabled(t *testing.T) {
+	store := &SqlBackend{dialect: PostgresDialect{}}
+	store.SetHealthCheck(0, time.Millisecond)
+
+	for i := 0; i < 10; i++ {
+		store.observe(driver.ErrBadConn)
+	}
+	ok(t, store.available())
+}
+
+func Test_Health_StopsResettingOnClose(t *testing.T) {
+	store := &SqlBackend{dialect: PostgresDialect{}, closing: make(chan struct{})}
+	store.SetHealthCheck(1, time.Millisecond)
+	var resets int32
+	store.health.reset = func() error {
+		atomic.AddInt32(&resets, 1)
+		return errors.New("connection refused")
+	}
+
+	store.observe(driver.ErrBadConn)
+	time.Sleep(10 * time.Millisecond)
+	close(store.closing)
+	time.Sleep(10 * time.Millisecond)
+
+	stopped := atomic.LoadInt32(&resets)
+	time.Sleep(10 * time.Millisecond)
+	equals(t, stopped, atomic.LoadInt32(&resets))
+}
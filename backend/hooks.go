@@ -0,0 +1,143 @@
+package backend
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/rancher/etcdb/models"
+)
+
+// A Change is a change to a key, passed to the hooks
+type Change struct {
+	// Action is the action recorded in the history, like set, create,
+	// compareAndSwap, delete or expire
+	Action string
+	// Index is the index the change is made at
+	Index int64
+	Key   string
+	// Value, Dir and TTL are what a set sets the key to
+	Value string
+	Dir   bool
+	TTL   *int64
+	// Recursive is set for deletes of directories with their keys
+	Recursive bool
+	// DryRun is set for deletes that are checked but rolled back
+	DryRun bool
+	// Node is the node after a set, only for AfterSet
+	Node *models.Node
+	// PrevNode is the node before the change, or nil if the key didn't exist
+	PrevNode *models.Node
+}
+
+// A BeforeSetHook is called before a key is set, after its condition is
+// checked. Returning an error rolls back the transaction, and the error is
+// returned instead; a models.Error is reported to the client with its code.
+type BeforeSetHook interface {
+	BeforeSet(tx *sql.Tx, change *Change) error
+}
+
+// An AfterSetHook is called after a key is set, before the transaction is
+// committed. Returning an error rolls back the transaction.
+type AfterSetHook interface {
+	AfterSet(tx *sql.Tx, change *Change) error
+}
+
+// A BeforeDeleteHook is called before a key is deleted, after its condition
+// is checked, including for dry runs. Returning an error rolls back the
+// transaction, and the error is returned instead.
+type BeforeDeleteHook interface {
+	BeforeDelete(tx *sql.Tx, change *Change) error
+}
+
+// An AfterDeleteHook is called after a key is deleted, before the transaction
+// is committed, but not for dry runs. Returning an error rolls back the
+// transaction.
+type AfterDeleteHook interface {
+	AfterDelete(tx *sql.Tx, change *Change) error
+}
+
+// An ExpireHook is called for each expired key as it is purged. Expirations
+// can't be vetoed: returning an error rolls back the purge, which is retried
+// on the next one, delaying all of the expirations until the hook succeeds.
+type ExpireHook interface {
+	OnExpire(tx *sql.Tx, change *Change) error
+}
+
+// AddHook adds a hook implementing one or more of BeforeSetHook,
+// AfterSetHook, BeforeDeleteHook, AfterDeleteHook and ExpireHook. The hooks
+// are called in the order they are added, inside the transaction of the
+// change, including for the changes of transactions and bulk sets. Hooks must
+// be added before the backend is used, and it panics if the hook implements
+// none of them.
+func (b *SqlBackend) AddHook(hook interface{}) {
+	switch hook.(type) {
+	case BeforeSetHook, AfterSetHook, BeforeDeleteHook, AfterDeleteHook, ExpireHook:
+	default:
+		panic(fmt.Sprintf("backend: AddHook called with %T, which implements none of the hooks", hook))
+	}
+	b.hooks = append(b.hooks, hook)
+}
+
+func (b *SqlBackend) beforeSet(tx *sql.Tx, change *Change) error {
+	for _, hook := range b.hooks {
+		if h, ok := hook.(BeforeSetHook); ok {
+			if err := h.BeforeSet(tx, change); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (b *SqlBackend) afterSet(tx *sql.Tx, change *Change) error {
+	for _, hook := range b.hooks {
+		if h, ok := hook.(AfterSetHook); ok {
+			if err := h.AfterSet(tx, change); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (b *SqlBackend) beforeDelete(tx *sql.Tx, change *Change) error {
+	for _, hook := range b.hooks {
+		if h, ok := hook.(BeforeDeleteHook); ok {
+			if err := h.BeforeDelete(tx, change); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (b *SqlBackend) afterDelete(tx *sql.Tx, change *Change) error {
+	for _, hook := range b.hooks {
+		if h, ok := hook.(AfterDeleteHook); ok {
+			if err := h.AfterDelete(tx, change); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (b *SqlBackend) hasExpireHooks() bool {
+	for _, hook := range b.hooks {
+		if _, ok := hook.(ExpireHook); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *SqlBackend) onExpire(tx *sql.Tx, change *Change) error {
+	for _, hook := range b.hooks {
+		if h, ok := hook.(ExpireHook); ok {
+			if err := h.OnExpire(tx, change); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package backend
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/rancher/etcdb/models"
+)
+
+// recordingHook records the changes it is called with, and denies changes to
+// keys under /locked
+type recordingHook struct {
+	calls []string
+	nodes []*models.Node
+}
+
+func (h *recordingHook) deny(change *Change) error {
+	if strings.HasPrefix(change.Key, "/locked") {
+		return models.InvalidField("the key is locked")
+	}
+	return nil
+}
+
+func (h *recordingHook) BeforeSet(tx *sql.Tx, change *Change) error {
+	h.calls = append(h.calls, "beforeSet "+change.Action+" "+change.Key)
+	return h.deny(change)
+}
+
+func (h *recordingHook) AfterSet(tx *sql.Tx, change *Change) error {
+	h.calls = append(h.calls, "afterSet "+change.Action+" "+change.Key)
+	h.nodes = append(h.nodes, change.Node)
+	return nil
+}
+
+func (h *recordingHook) BeforeDelete(tx *sql.Tx, change *Change) error {
+	h.calls = append(h.calls, "beforeDelete "+change.Action+" "+change.Key)
+	return h.deny(change)
+}
+
+func (h *recordingHook) AfterDelete(tx *sql.Tx, change *Change) error {
+	h.calls = append(h.calls, "afterDelete "+change.Action+" "+change.Key)
+	return nil
+}
+
+func (h *recordingHook) OnExpire(tx *sql.Tx, change *Change) error {
+	h.calls = append(h.calls, "expire "+change.Key+" "+change.PrevNode.Value)
+	return nil
+}
+
+func Test_Hooks_SetAndDelete(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+	hook := &recordingHook{}
+	store.AddHook(hook)
+
+	_, _, err := store.Set("/foo", "bar", Always)
+	ok(t, err)
+	_, _, err = store.Delete("/foo", Always)
+	ok(t, err)
+
+	equals(t, []string{
+		"beforeSet set /foo",
+		"afterSet set /foo",
+		"beforeDelete delete /foo",
+		"afterDelete delete /foo",
+	}, hook.calls)
+	equals(t, "bar", hook.nodes[0].Value)
+}
+
+func Test_Hooks_Veto(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+	_, _, err := store.Set("/locked/foo", "bar", Always)
+	ok(t, err)
+
+	store.AddHook(&recordingHook{})
+	index := currIndex(store)
+
+	_, _, err = store.Set("/locked/foo", "baz", Always)
+	expectError(t, "Invalid field", "the key is locked", err)
+	_, _, err = store.Delete("/locked/foo", Always)
+	expectError(t, "Invalid field", "the key is locked", err)
+
+	node, err := store.Get("/locked/foo", false)
+	ok(t, err)
+	equals(t, "bar", node.Value)
+	equals(t, index, currIndex(store))
+}
+
+func Test_Hooks_DryRunDelete(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+	_, _, err := store.Set("/foo", "bar", Always)
+	ok(t, err)
+
+	hook := &recordingHook{}
+	store.AddHook(hook)
+
+	_, err = store.DryRunDelete("/foo", false, false, Always)
+	ok(t, err)
+	equals(t, []string{"beforeDelete delete /foo"}, hook.calls)
+}
+
+func Test_Hooks_BulkSet(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+	hook := &recordingHook{}
+	store.AddHook(hook)
+
+	_, err := store.BulkSet(map[string]BulkValue{"/a": {Value: "1"}, "/locked/b": {Value: "2"}})
+	expectError(t, "Invalid field", "the key is locked", err)
+
+	hook.calls = nil
+	_, err = store.BulkSet(map[string]BulkValue{"/a": {Value: "1"}, "/b": {Value: "2"}})
+	ok(t, err)
+	equals(t, []string{
+		"beforeSet set /a",
+		"beforeSet set /b",
+		"afterSet set /a",
+		"afterSet set /b",
+	}, hook.calls)
+}
+
+func Test_Hooks_Expire(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+	hook := &recordingHook{}
+	store.AddHook(hook)
+
+	_, _, err := store.SetTTL("/foo", "bar", -1, Always)
+	ok(t, err)
+
+	// purges the expired key
+	_, err = store.Get("/foo", false)
+	expectError(t, "Key not found", "/foo", err)
+
+	equals(t, "expire /foo bar", hook.calls[len(hook.calls)-1])
+}
+
+func Test_AddHook_NoHooks(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected adding a value without hooks to panic")
+		}
+	}()
+	(&SqlBackend{}).AddHook(struct{}{})
+}
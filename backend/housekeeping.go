@@ -0,0 +1,115 @@
+package backend
+
+import (
+	"expvar"
+	"log"
+	"time"
+)
+
+// HousekeepingStats counts the rows removed and maintenance runs by
+// Housekeeping, published with expvar.
+var HousekeepingStats = expvar.NewMap("housekeeping")
+
+// Housekeeping periodically trims the history of changes and deleted node
+// versions in the background, instead of on every change, and runs the
+// database's table maintenance.
+type Housekeeping struct {
+	store               *SqlBackend
+	trimInterval        time.Duration
+	maintenanceInterval time.Duration
+	stop                chan struct{}
+}
+
+// StartHousekeeping creates and starts a Housekeeping for the store. The
+// history is trimmed every trimInterval, and tables are vacuumed or optimized
+// every maintenanceInterval. Either is disabled with a zero interval, and with
+// trimming disabled the history is trimmed on every change as usual.
+func StartHousekeeping(store *SqlBackend, trimInterval, maintenanceInterval time.Duration) *Housekeeping {
+	h := &Housekeeping{
+		store:               store,
+		trimInterval:        trimInterval,
+		maintenanceInterval: maintenanceInterval,
+		stop:                make(chan struct{}),
+	}
+	if trimInterval > 0 {
+		store.deferTrim = true
+	}
+	go h.Run()
+	return h
+}
+
+// Stop stops the housekeeping loop
+func (h *Housekeeping) Stop() {
+	close(h.stop)
+}
+
+// Run trims the history and runs maintenance on schedule until stopped
+func (h *Housekeeping) Run() {
+	var trim, maintenance <-chan time.Time
+	if h.trimInterval > 0 {
+		ticker := time.NewTicker(h.trimInterval)
+		defer ticker.Stop()
+		trim = ticker.C
+	}
+	if h.maintenanceInterval > 0 {
+		ticker := time.NewTicker(h.maintenanceInterval)
+		defer ticker.Stop()
+		maintenance = ticker.C
+	}
+
+	for {
+		select {
+		case <-h.stop:
+			return
+		case <-trim:
+			h.trim()
+		case <-maintenance:
+			h.maintenance()
+		}
+	}
+}
+
+func (h *Housekeeping) trim() {
+	t, err := h.store.TrimHistory()
+	if err != nil {
+		log.Println("error trimming history:", err)
+		HousekeepingStats.Add("errors", 1)
+		return
+	}
+	HousekeepingStats.Add("trims", 1)
+	HousekeepingStats.Add("changes", t.Changes)
+	HousekeepingStats.Add("nodes", t.Nodes)
+	HousekeepingStats.Add("recycle", t.Recycle)
+}
+
+func (h *Housekeeping) maintenance() {
+	start := time.Now()
+	if err := h.store.Maintenance(); err != nil {
+		log.Println("error running table maintenance:", err)
+		HousekeepingStats.Add("errors", 1)
+		return
+	}
+	log.Printf("table maintenance took %v", time.Since(start))
+	HousekeepingStats.Add("maintenance", 1)
+}
+
+// TrimHistory removes the changes and node versions older than the last
+// MaxChanges, and expired deletes in the recycle bin.
+func (b *SqlBackend) TrimHistory() (t Trimmed, err error) {
+	index, err := b.currIndex(b.conn())
+	if err != nil {
+		return
+	}
+	return b.trimHistory(b.conn(), index)
+}
+
+// Maintenance vacuums and analyzes the tables for Postgres, or optimizes them
+// for MySQL, to reclaim the space of removed rows.
+func (b *SqlBackend) Maintenance() error {
+	for _, query := range b.dialect.Maintenance() {
+		if _, err := b.conn().Exec(query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
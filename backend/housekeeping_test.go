@@ -0,0 +1,37 @@
+package backend
+
+import "testing"
+
+func Test_TrimHistory_Deferred(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+	store.deferTrim = true
+
+	_, _, err := store.Set("/foo", "first", Always)
+	ok(t, err)
+	_, _, err = store.Set("/foo", "second", Always)
+	ok(t, err)
+
+	// move the old version and its changes out of the history
+	_, err = store.Query().Extend(`UPDATE "index" SET "index" = `, currIndex(store)+MaxChanges+1).Exec(store.conn())
+	ok(t, err)
+
+	// changes don't trim the history themselves
+	_, _, err = store.Set("/bar", "value", Always)
+	ok(t, err)
+
+	trimmed, err := store.TrimHistory()
+	ok(t, err)
+	equals(t, Trimmed{Changes: 2, Nodes: 1}, trimmed)
+
+	node, err := store.Get("/foo", false)
+	ok(t, err)
+	equals(t, "second", node.Value)
+}
+
+func Test_Maintenance(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	ok(t, store.Maintenance())
+}
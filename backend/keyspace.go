@@ -0,0 +1,41 @@
+package backend
+
+import "github.com/rancher/etcdb/models"
+
+// KeyspaceUsage counts the keys, directories and value bytes by top-level key,
+// and finds the depth of their deepest paths, in a single aggregation over
+// the nodes.
+func (b *SqlBackend) KeyspaceUsage() (*models.Keyspace, error) {
+	top := b.dialect.TopLevelKey()
+	rows, err := b.conn().Query(`SELECT ` + top + `,
+		SUM(CASE WHEN "dir" = false THEN 1 ELSE 0 END),
+		SUM(CASE WHEN "dir" = false THEN 0 ELSE 1 END),
+		COALESCE(SUM(OCTET_LENGTH("value")), 0),
+		COALESCE(MAX("path_depth"), 0)
+		FROM "nodes" WHERE "deleted" = 0 AND "key" <> '/'
+		GROUP BY ` + top + `
+		ORDER BY 4 DESC, 1`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keyspace := &models.Keyspace{Prefixes: []*models.KeyspaceUsage{}}
+	for rows.Next() {
+		var name string
+		u := &models.KeyspaceUsage{}
+		if err := rows.Scan(&name, &u.Keys, &u.Dirs, &u.Bytes, &u.MaxDepth); err != nil {
+			return nil, err
+		}
+		u.Prefix = "/" + name
+		keyspace.Prefixes = append(keyspace.Prefixes, u)
+
+		keyspace.Total.Keys += u.Keys
+		keyspace.Total.Dirs += u.Dirs
+		keyspace.Total.Bytes += u.Bytes
+		if u.MaxDepth > keyspace.Total.MaxDepth {
+			keyspace.Total.MaxDepth = u.MaxDepth
+		}
+	}
+	return keyspace, rows.Err()
+}
@@ -0,0 +1,36 @@
+package backend
+
+import (
+	"testing"
+
+	"github.com/rancher/etcdb/models"
+)
+
+func Test_KeyspaceUsage(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	_, _, err := store.Set("/app/config/port", "80", Always)
+	ok(t, err)
+	_, _, err = store.Set("/app/name", "web", Always)
+	ok(t, err)
+	_, _, err = store.Set("/registry/a", "0123456789", Always)
+	ok(t, err)
+	_, _, err = store.Set("/top", "x", Always)
+	ok(t, err)
+	_, _, err = store.Delete("/registry/a", Always)
+	ok(t, err)
+	_, _, err = store.Set("/registry/b", "01234567", Always)
+	ok(t, err)
+
+	keyspace, err := store.KeyspaceUsage()
+	ok(t, err)
+	equals(t, &models.Keyspace{
+		Total: models.KeyspaceUsage{Keys: 4, Dirs: 3, Bytes: 14, MaxDepth: 3},
+		Prefixes: []*models.KeyspaceUsage{
+			{Prefix: "/registry", Keys: 1, Dirs: 1, Bytes: 8, MaxDepth: 2},
+			{Prefix: "/app", Keys: 2, Dirs: 2, Bytes: 5, MaxDepth: 3},
+			{Prefix: "/top", Keys: 1, Bytes: 1, MaxDepth: 1},
+		},
+	}, keyspace)
+}
@@ -2,22 +2,61 @@ package backend
 
 import (
 	"errors"
+	"expvar"
 	"fmt"
 	"log"
+	"path"
+	"sort"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/rancher/etcdb/models"
 )
 
+// WatcherStats measures how far the change watcher's loop falls behind,
+// published with expvar. "lag_seconds" is how long after its tick the last
+// refresh started, and "max_lag_seconds" the longest. "late_refreshes" counts
+// the refreshes that started more than a refresh period late, which skips
+// ticks. "refreshes" and "watches" count the refreshes and the watches added,
+// and "coalesced" the watches added to a group of identical ones.
+var WatcherStats = expvar.NewMap("watcher")
+
+var watcherLag, watcherMaxLag = new(expvar.Float), new(expvar.Float)
+
+func init() {
+	WatcherStats.Set("lag_seconds", watcherLag)
+	WatcherStats.Set("max_lag_seconds", watcherMaxLag)
+}
+
+// watchBatch is the most watches the run loop adds at a time before checking
+// for a refresh again
+const watchBatch = 100
+
 // A ChangeWatcher monitors the store's changes table to serve watch results
 type ChangeWatcher struct {
 	store         *SqlBackend
 	changes       *changeList
 	watch         chan *watch
+	unwatch       chan *watch
 	watches       map[*watch]struct{}
+	byKey         *watchIndex
+	groups        map[watchGroupKey]*watchGroup
+	watchSeq      int64
+	subscribe     chan *subscription
+	unsubscribe   chan *subscription
+	subscriptions map[*subscription]struct{}
+	inspect       chan chan *models.WatcherState
 	refreshPeriod time.Duration
-	lastIndex     int64
-	stop          chan struct{}
+	// timeout ends the watches that haven't had a change, if positive
+	timeout   time.Duration
+	lastIndex int64
+	// switches is the store's count of failovers as of the last refresh
+	switches uint64
+	stop     chan struct{}
+	drain    chan struct{}
+	// draining ends the watches right away, once Drain was called
+	draining bool
 }
 
 // Watch creates and starts a new ChangeWatcher for the SqlBackend
@@ -25,9 +64,17 @@ func Watch(store *SqlBackend, refreshPeriod time.Duration) *ChangeWatcher {
 	cw := &ChangeWatcher{
 		store:         store,
 		watch:         make(chan *watch),
+		unwatch:       make(chan *watch),
 		refreshPeriod: refreshPeriod,
 		stop:          make(chan struct{}),
+		drain:         make(chan struct{}),
 		watches:       make(map[*watch]struct{}),
+		byKey:         newWatchIndex(),
+		groups:        make(map[watchGroupKey]*watchGroup),
+		subscribe:     make(chan *subscription),
+		unsubscribe:   make(chan *subscription),
+		subscriptions: make(map[*subscription]struct{}),
+		inspect:       make(chan chan *models.WatcherState),
 		changes:       newChangeList(MaxChanges),
 	}
 	go cw.Run()
@@ -39,75 +86,331 @@ func (cw *ChangeWatcher) Stop() {
 	close(cw.stop)
 }
 
+// Drain ends the waiting watches, and the ones started later, with
+// ErrWatchTimeout, so that their clients retry them with another instance,
+// like a new version of this one taking over the listening sockets.
+func (cw *ChangeWatcher) Drain() {
+	cw.drain <- struct{}{}
+}
+
+// SetTimeout sets how long NextChange waits for a change before returning
+// ErrWatchTimeout, so that the connections of clients that went away aren't
+// kept open. With 0, it waits forever. It must be set before the watcher is
+// used.
+func (cw *ChangeWatcher) SetTimeout(timeout time.Duration) {
+	cw.timeout = timeout
+}
+
 // NextChange waits for a matching change event, and returns an ActionUpdate
 // with the change data
 func (cw *ChangeWatcher) NextChange(key string, recursive bool, index int64) (*models.ActionUpdate, error) {
+	return cw.NextChangeFrom(key, recursive, index, "")
+}
+
+// NextChangeFrom is NextChange for a client at the remote address, which is
+// shown in the watcher's State.
+func (cw *ChangeWatcher) NextChangeFrom(key string, recursive bool, index int64, remoteAddr string) (*models.ActionUpdate, error) {
+	return cw.NextChangeGlob(key, recursive, index, remoteAddr, "")
+}
+
+// NextChangeGlob is NextChangeFrom for only the changes to keys matching the
+// glob, with the syntax of path.Match. For recursive watches, the glob can
+// also match a directory that the key is under, so that /services/* follows
+// the keys of every service.
+func (cw *ChangeWatcher) NextChangeGlob(key string, recursive bool, index int64, remoteAddr, glob string) (*models.ActionUpdate, error) {
+	if glob != "" {
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, models.InvalidField("invalid keyGlob: " + glob)
+		}
+	}
+	w := NewWatch(index, key, recursive)
+	w.RemoteAddr = remoteAddr
+	w.Glob = glob
+	cw.watch <- w
+	if cw.timeout <= 0 {
+		return w.Result()
+	}
+
+	timer := time.NewTimer(cw.timeout)
+	defer timer.Stop()
+	return cw.wait(w, timer.C)
+}
+
+// ErrWatchTimeout is returned by NextChange when there was no matching change
+// before the watch timeout or the watcher was drained, and by NextChangeUntil
+// before the deadline
+var ErrWatchTimeout = errors.New("watch timed out")
+
+// NextChangeUntil is NextChange giving up at the deadline. A nil deadline
+// waits forever.
+func (cw *ChangeWatcher) NextChangeUntil(key string, recursive bool, index int64, deadline <-chan time.Time) (*models.ActionUpdate, error) {
 	w := NewWatch(index, key, recursive)
 	cw.watch <- w
-	return w.Result()
+	return cw.wait(w, deadline)
+}
+
+// wait returns the watch's result, or ErrWatchTimeout if there is none by the
+// deadline
+func (cw *ChangeWatcher) wait(w *watch, deadline <-chan time.Time) (*models.ActionUpdate, error) {
+	select {
+	case res := <-w.result:
+		return res.Action, res.Err
+	case <-deadline:
+		// the run loop either sets the timeout as the result, or the
+		// result was already set just before the timeout
+		cw.unwatch <- w
+		return w.Result()
+	}
 }
 
-// Run starts the event loop to poll for changes, and receive new watch requests
+// Run starts the event loop to poll for changes, and receive new watch
+// requests. The change buffer is loaded before the first watch is added, so
+// that watches from an index in the recent history get its changes after a
+// restart.
 func (cw *ChangeWatcher) Run() {
+	cw.warmUp()
 	cw.refresh()
 
 	refresh := time.NewTicker(cw.refreshPeriod)
 
 	for {
+		// a due refresh goes first, so that many watches being added at once
+		// don't delay it
+		select {
+		case tick := <-refresh.C:
+			cw.refreshAt(tick)
+		default:
+		}
+
 		select {
 		case <-cw.stop:
 			refresh.Stop()
 			return
+		case <-cw.drain:
+			cw.draining = true
+			for w := range cw.watches {
+				cw.removeWatch(w)
+			}
+		case w := <-cw.watch:
+			cw.addWatches(w)
+		case w := <-cw.unwatch:
+			cw.removeWatch(w)
+		case s := <-cw.subscribe:
+			cw.addSubscription(s)
+		case s := <-cw.unsubscribe:
+			cw.removeSubscription(s)
+		case res := <-cw.inspect:
+			res <- cw.state(time.Now())
+		case tick := <-refresh.C:
+			cw.refreshAt(tick)
+		}
+	}
+}
+
+// refreshAt refreshes for the tick at the time, recording how late it is
+func (cw *ChangeWatcher) refreshAt(tick time.Time) {
+	lag := time.Since(tick)
+	watcherLag.Set(lag.Seconds())
+	if lag.Seconds() > watcherMaxLag.Value() {
+		watcherMaxLag.Set(lag.Seconds())
+	}
+	if lag > cw.refreshPeriod {
+		WatcherStats.Add("late_refreshes", 1)
+	}
+	WatcherStats.Add("refreshes", 1)
+	cw.refresh()
+}
+
+// addWatches adds the watch, and up to watchBatch-1 others that are already
+// being sent
+func (cw *ChangeWatcher) addWatches(w *watch) {
+	cw.addWatch(w)
+	for i := 1; i < watchBatch; i++ {
+		select {
 		case w := <-cw.watch:
 			cw.addWatch(w)
-		case <-refresh.C:
-			cw.refresh()
+		default:
+			return
 		}
 	}
 }
 
 func (cw *ChangeWatcher) addWatch(w *watch) {
+	cw.watchSeq++
+	w.seq = cw.watchSeq
 	cw.watches[w] = struct{}{}
+	cw.byKey.add(w)
+	WatcherStats.Add("watches", 1)
 
-	if w.Index <= 0 || cw.changes.Size == 0 {
+	if cw.draining {
+		cw.removeWatch(w)
 		return
 	}
 
-	if oldestIndex := cw.changes.First().Index; w.Index < oldestIndex {
-		w.SetResult(nil, models.EventIndexCleared(oldestIndex, w.Index, cw.lastIndex))
-		delete(cw.watches, w)
+	if w.Index > 0 && cw.changes.Size > 0 {
+		if oldestIndex := cw.changes.First().Index; w.Index < oldestIndex {
+			w.SetResult(nil, models.EventIndexCleared(oldestIndex, w.Index, cw.lastIndex))
+			cw.deleteWatch(w)
+			return
+		}
+
+		for i := 0; i < cw.changes.Size; i++ {
+			c := cw.changes.Item(i)
+			if cw.checkChange(c, w, cw.lastIndex) {
+				return
+			}
+		}
+	}
+
+	cw.coalesce(w)
+}
+
+// A watchGroup is the watches waiting for the same change, which get the
+// same result. Only the first one is in byKey.
+type watchGroup struct {
+	key     watchGroupKey
+	watches []*watch
+}
+
+// watchGroupKey is what the watches of a group have in common. Watches whose
+// index the changes were already checked up to wait for the next matching
+// change, so they are only told apart by whether the index is 0, which keeps
+// waiting when the change is cleared. The index of watches for later changes
+// is kept.
+type watchGroupKey struct {
+	key       string
+	recursive bool
+	glob      string
+	index     int64
+	fromZero  bool
+}
+
+// coalesce adds the waiting watch to the group of identical watches, or
+// starts one
+func (cw *ChangeWatcher) coalesce(w *watch) {
+	k := watchGroupKey{key: w.Key, recursive: w.Recursive, glob: w.Glob, fromZero: w.Index <= 0}
+	if w.Index > cw.lastIndex+1 {
+		k.index = w.Index
+	}
+
+	g, ok := cw.groups[k]
+	if !ok {
+		g = &watchGroup{key: k}
+		cw.groups[k] = g
+	} else {
+		cw.byKey.remove(w)
+		WatcherStats.Add("coalesced", 1)
+	}
+	g.watches = append(g.watches, w)
+	w.group = g
+}
+
+func (cw *ChangeWatcher) removeWatch(w *watch) {
+	if _, ok := cw.watches[w]; !ok {
 		return
 	}
+	cw.deleteWatch(w)
+	w.SetResult(nil, ErrWatchTimeout)
+}
 
-	for i := 0; i < cw.changes.Size; i++ {
-		c := cw.changes.Item(i)
-		if cw.checkChange(c, w) {
-			break
+func (cw *ChangeWatcher) deleteWatch(w *watch) {
+	delete(cw.watches, w)
+	g := w.group
+	if g == nil {
+		cw.byKey.remove(w)
+		return
+	}
+	w.group = nil
+
+	if g.watches[0] == w {
+		// the next watch of the group takes its place in byKey
+		cw.byKey.remove(w)
+		g.watches = g.watches[1:]
+		if len(g.watches) > 0 {
+			cw.byKey.add(g.watches[0])
 		}
+	} else {
+		for i, member := range g.watches {
+			if member == w {
+				g.watches = append(g.watches[:i], g.watches[i+1:]...)
+				break
+			}
+		}
+	}
+	if len(g.watches) == 0 {
+		delete(cw.groups, g.key)
+	}
+}
+
+// deleteGroup deletes the watches of the group that the watch is the first
+// of, or just the watch if it isn't in a group, and returns them
+func (cw *ChangeWatcher) deleteGroup(w *watch) []*watch {
+	g := w.group
+	if g == nil {
+		cw.deleteWatch(w)
+		return []*watch{w}
+	}
+
+	cw.byKey.remove(w)
+	delete(cw.groups, g.key)
+	for _, member := range g.watches {
+		delete(cw.watches, member)
+		member.group = nil
 	}
+	return g.watches
 }
 
-func (cw *ChangeWatcher) checkChange(c *change, w *watch) bool {
+// checkChange sets the change as the watch's result if it matches. Like etcd,
+// the result's EtcdIndex is the watcher's index for changes that already
+// happened when the watch was added, and the change's index for new ones.
+func (cw *ChangeWatcher) checkChange(c *change, w *watch, etcdIndex int64) bool {
 	if !w.Match(c) {
 		return false
 	}
 
 	action, err := c.Value(cw.store)
-	if err == ErrChangeIndexCleared {
+	if action != nil {
+		// the action is cached for all of the change's watches, and this
+		// copy is shared by the watch's group
+		update := *action
+		update.EtcdIndex = etcdIndex
+		action = &update
+	}
+	cleared := err == ErrChangeIndexCleared
+	if cleared && w.Index == 0 {
 		// if this change was already cleared, but watch didn't specify an index,
 		// just return to wait for the next matching change
-		if w.Index == 0 {
-			return false
+		return false
+	}
+	for _, member := range cw.deleteGroup(w) {
+		if cleared {
+			err = models.EventIndexCleared(c.Index+1, member.Index, cw.lastIndex)
 		}
-		err = models.EventIndexCleared(c.Index+1, w.Index, cw.lastIndex)
+		member.SetResult(action, err)
 	}
-	w.SetResult(action, err)
-	delete(cw.watches, w)
 
 	return true
 }
 
+// warmUp starts the first refresh from the oldest change the buffer can
+// hold, instead of reading the whole history when it wasn't trimmed yet
+func (cw *ChangeWatcher) warmUp() {
+	index, err := cw.store.CurrentIndex()
+	if err != nil {
+		log.Println("error reading the index:", err)
+		return
+	}
+	if index > int64(cw.changes.Capacity) {
+		cw.lastIndex = index - int64(cw.changes.Capacity)
+	}
+}
+
 func (cw *ChangeWatcher) refresh() {
+	if switches := atomic.LoadUint64(&cw.store.switches); switches != cw.switches {
+		cw.switches = switches
+		cw.rewind()
+	}
+
 	newCount, err := cw.fetchSince(cw.lastIndex)
 	if err != nil {
 		log.Println("error refreshing:", err)
@@ -118,18 +421,183 @@ func (cw *ChangeWatcher) refresh() {
 	}
 
 	cw.lastIndex = cw.changes.Last().Index
+	// so that replicas don't serve reads older than the changes of other
+	// instances that were already watched
+	cw.store.observeIndex(cw.lastIndex)
 
 	i := 0
 	if newCount < cw.changes.Size {
 		i = cw.changes.Size - newCount
 	}
+	if c := cw.store.readCache; c != nil {
+		c.applyChanges(cw.changes, i, newCount > cw.changes.Size, cw.lastIndex)
+	}
+	cw.dispatch(i)
+}
+
+// rewind starts over from the index of the database after a failover, if
+// the database is behind the changes already fetched, so that the changes
+// written to it at the same indexes aren't skipped
+func (cw *ChangeWatcher) rewind() {
+	index, err := cw.store.CurrentIndex()
+	if err != nil {
+		log.Println("error reading the index after failing over:", err)
+		return
+	}
+	if index >= cw.lastIndex {
+		return
+	}
 
+	log.Printf("database index %d is behind the watched index %d after failing over", index, cw.lastIndex)
+	cw.changes = newChangeList(cw.changes.Capacity)
+	cw.lastIndex = index
+	if c := cw.store.readCache; c != nil {
+		c.applyChanges(cw.changes, 0, true, index)
+	}
+}
+
+// dispatch sets the results of the watches and subscriptions matching the
+// changes from position i of the change buffer.
+//
+// Changes are fetched in index order, and since writers serialize on the
+// index row, a change can't be committed after a later one is visible. The
+// results are set in the same order: for each change, the watches get it in
+// the order they were added, with the identical watches coalesced with the
+// first of them, and a subscription's batch is set together with
+// its last event. So a client watching several keys, like nested prefixes,
+// never gets a result for a change before the results for earlier ones.
+func (cw *ChangeWatcher) dispatch(i int) {
+	if err := cw.resolveChanges(i); err != nil {
+		log.Println("error resolving changes:", err)
+		// the changes that weren't resolved are fetched one by one
+	}
+
+	var batches []pendingBatch
+	for s := range cw.subscriptions {
+		batch, err := cw.collectSubscription(s, i)
+		if err != nil {
+			s.SetResult(nil, err)
+			delete(cw.subscriptions, s)
+			continue
+		}
+		if batch != nil {
+			batches = append(batches, pendingBatch{s, batch})
+		}
+	}
+	sort.Sort(byLastEvent(batches))
+
+	for ; i < cw.changes.Size; i++ {
+		c := cw.changes.Item(i)
+		// the candidates are of the watches still waiting for a change
+		for _, w := range cw.byKey.candidates(c) {
+			cw.checkChange(c, w, c.Index)
+		}
+		for len(batches) > 0 && batches[0].lastIndex() <= c.Index {
+			batches[0].s.SetResult(batches[0].batch, nil)
+			delete(cw.subscriptions, batches[0].s)
+			batches = batches[1:]
+		}
+	}
+}
+
+// resolveBatch is the most changes whose nodes resolveChanges fetches in
+// one query
+const resolveBatch = 100
+
+// resolveChanges fetches the nodes of the changes from position i of the
+// change buffer that a watch or subscription matches, with a query per batch
+// of changes instead of one per change, and caches their values.
+func (cw *ChangeWatcher) resolveChanges(i int) error {
+	var pending []*change
 	for ; i < cw.changes.Size; i++ {
 		c := cw.changes.Item(i)
-		for w := range cw.watches {
-			cw.checkChange(c, w)
+		if c.value == nil && cw.matched(c) {
+			pending = append(pending, c)
+		}
+	}
+
+	for len(pending) > 0 {
+		batch := pending
+		if len(batch) > resolveBatch {
+			batch = batch[:resolveBatch]
+		}
+		pending = pending[len(batch):]
+		if err := resolveValues(cw.store, batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cw *ChangeWatcher) matched(c *change) bool {
+	for _, w := range cw.byKey.candidates(c) {
+		if w.Match(c) {
+			return true
+		}
+	}
+	for s := range cw.subscriptions {
+		if s.Match(c) {
+			return true
 		}
 	}
+	return false
+}
+
+// resolveValues fetches the nodes of the changes in one query, and caches
+// the values of the changes. Changes whose nodes are cleared are left for
+// Value to report.
+func resolveValues(store *SqlBackend, changes []*change) error {
+	q := store.queryNodeWithDeleted().Text(` WHERE `)
+	var resolvable []*change
+	for _, c := range changes {
+		versions, err := c.nodeVersions()
+		if err != nil {
+			continue
+		}
+		if len(resolvable) > 0 {
+			q.Text(` OR `)
+		}
+		q.Extend(`("key" = `, c.Key, ` AND "modified" IN (`)
+		for j, v := range versions {
+			if j > 0 {
+				q.Text(`, `)
+			}
+			q.Param(v)
+		}
+		q.Text(`))`)
+		resolvable = append(resolvable, c)
+	}
+	if len(resolvable) == 0 {
+		return nil
+	}
+
+	rows, err := q.Query(store.conn())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	nodes := make(map[string]map[int64]*models.Node)
+	for rows.Next() {
+		node, err := scanNode(rows)
+		if err != nil {
+			return err
+		}
+		if nodes[node.Key] == nil {
+			nodes[node.Key] = make(map[int64]*models.Node)
+		}
+		nodes[node.Key][node.ModifiedIndex] = node
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, c := range resolvable {
+		if action, err := c.build(nodes[c.Key]); err == nil {
+			c.value = action
+		}
+	}
+	return nil
 }
 
 func (cw *ChangeWatcher) fetchSince(lastIndex int64) (count int, err error) {
@@ -229,31 +697,25 @@ func (c *change) Clear() {
 }
 
 // Value fetches the node values for the changes, and returns an ActionUpdate
-// The result is memoized after the first call.
+// The result is memoized after the first call, or after the change was
+// resolved with others by resolveValues.
 func (c *change) Value(store *SqlBackend) (*models.ActionUpdate, error) {
 	if c.value == nil {
-		isDeleteAction := false
-		switch c.Action {
-		case "delete", "compareAndDelete", "expire":
-			isDeleteAction = true
-		}
-
-		if isDeleteAction && c.PrevNodeModified == nil {
-			return nil, fmt.Errorf("action type %s should have prev_node_modified set", c.Action)
+		versions, err := c.nodeVersions()
+		if err != nil {
+			return nil, err
 		}
 
 		q := store.queryNodeWithDeleted().Extend(` WHERE "key" = `, c.Key, ` AND "modified" IN (`)
-		if isDeleteAction {
-			q.Param(c.PrevNodeModified)
-		} else {
-			q.Param(c.Index)
-			if c.PrevNodeModified != nil {
-				q.Extend(`, `, c.PrevNodeModified)
+		for i, v := range versions {
+			if i > 0 {
+				q.Text(`, `)
 			}
+			q.Param(v)
 		}
 		q.Text(`)`)
 
-		rows, err := q.Query(store.db)
+		rows, err := q.Query(store.conn())
 		if err != nil {
 			return nil, err
 		}
@@ -269,32 +731,74 @@ func (c *change) Value(store *SqlBackend) (*models.ActionUpdate, error) {
 			nodes[node.ModifiedIndex] = node
 		}
 
-		action := models.ActionUpdate{Action: c.Action}
+		action, err := c.build(nodes)
+		if err != nil {
+			return nil, err
+		}
+		c.value = action
+	}
+
+	return c.value, nil
+}
 
-		if c.PrevNodeModified != nil {
-			prevNode, ok := nodes[*c.PrevNodeModified]
-			if !ok {
-				return nil, ErrChangeIndexCleared
-			}
-			action.PrevNode = prevNode
+func (c *change) isDelete() bool {
+	switch c.Action {
+	case "delete", "compareAndDelete", "expire":
+		return true
+	}
+	return false
+}
+
+// nodeVersions returns the modified indexes of the node versions that the
+// change's value is made of
+func (c *change) nodeVersions() ([]int64, error) {
+	if c.isDelete() {
+		if c.PrevNodeModified == nil {
+			return nil, fmt.Errorf("action type %s should have prev_node_modified set", c.Action)
 		}
+		return []int64{*c.PrevNodeModified}, nil
+	}
+	if c.PrevNodeModified != nil {
+		return []int64{c.Index, *c.PrevNodeModified}, nil
+	}
+	return []int64{c.Index}, nil
+}
 
-		if isDeleteAction {
-			action.Node.Key = c.Key
-			action.Node.CreatedIndex = action.PrevNode.CreatedIndex
-			action.Node.ModifiedIndex = c.Index
-		} else {
-			node, ok := nodes[c.Index]
-			if !ok {
-				return nil, ErrChangeIndexCleared
-			}
-			action.Node = *node
+// build makes the change's value from the versions of its node by modified
+// index
+func (c *change) build(nodes map[int64]*models.Node) (*models.ActionUpdate, error) {
+	action := models.ActionUpdate{Action: c.Action}
+
+	if c.PrevNodeModified != nil {
+		prevNode, ok := nodes[*c.PrevNodeModified]
+		if !ok {
+			return nil, ErrChangeIndexCleared
 		}
+		// copied, since resolveValues shares the nodes between changes
+		prev := *prevNode
+		action.PrevNode = &prev
+	}
 
-		c.value = &action
+	if c.Action == "expire" && action.PrevNode.TTL != nil && *action.PrevNode.TTL <= 0 {
+		// the TTL ran out, so etcd leaves it out and only has the
+		// expiration time
+		action.PrevNode.TTL = nil
 	}
 
-	return c.value, nil
+	if c.isDelete() {
+		action.Node.Key = c.Key
+		action.Node.CreatedIndex = action.PrevNode.CreatedIndex
+		action.Node.ModifiedIndex = c.Index
+		action.Node.Dir = action.PrevNode.Dir
+	} else {
+		node, ok := nodes[c.Index]
+		if !ok {
+			return nil, ErrChangeIndexCleared
+		}
+		action.Node = *node
+	}
+
+	return &action, nil
 }
 
 type watchResult struct {
@@ -303,14 +807,34 @@ type watchResult struct {
 }
 
 type watch struct {
-	Index     int64
-	Key       string
-	Recursive bool
-	result    chan watchResult
+	Index      int64
+	Key        string
+	Recursive  bool
+	RemoteAddr string
+	// Glob limits the changes to keys matching it, if set
+	Glob    string
+	started time.Time
+	// seq is the order the watch was added in
+	seq int64
+	// group is the group of identical watches it is waiting in
+	group  *watchGroup
+	result chan watchResult
 }
 
+type bySeq []*watch
+
+func (s bySeq) Len() int           { return len(s) }
+func (s bySeq) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s bySeq) Less(i, j int) bool { return s[i].seq < s[j].seq }
+
 func NewWatch(index int64, key string, recursive bool) *watch {
-	return &watch{index, key, recursive, make(chan watchResult, 1)}
+	return &watch{
+		Index:     index,
+		Key:       key,
+		Recursive: recursive,
+		started:   time.Now(),
+		result:    make(chan watchResult, 1),
+	}
 }
 
 func (w *watch) SetResult(action *models.ActionUpdate, err error) {
@@ -330,6 +854,9 @@ func (w *watch) Match(c *change) bool {
 	if c.Index < w.Index {
 		return false
 	}
+	if w.Glob != "" && !matchGlob(w.Glob, c.Key, w.Recursive) {
+		return false
+	}
 	if c.Key == w.Key {
 		return true
 	}
@@ -343,6 +870,22 @@ func (w *watch) Match(c *change) bool {
 	return false
 }
 
+// isParent checks if b is under the directory a, which can be the root
 func isParent(a, b string) bool {
-	return b[:len(a)+1] == a+"/"
+	prefix := strings.TrimSuffix(a, "/") + "/"
+	return len(b) > len(prefix) && strings.HasPrefix(b, prefix)
+}
+
+// matchGlob checks if the key matches the glob, or with prefix set, if any of
+// the directories it is under do
+func matchGlob(glob, key string, prefix bool) bool {
+	for {
+		if matched, _ := path.Match(glob, key); matched {
+			return true
+		}
+		if !prefix || key == "/" {
+			return false
+		}
+		key = path.Dir(key)
+	}
 }
@@ -1,6 +1,9 @@
 package backend
 
 import (
+	"fmt"
+	"sort"
+	"sync"
 	"testing"
 	"time"
 
@@ -26,6 +29,30 @@ func Test_Watch_Change(t *testing.T) {
 	equals(t, "bar", act.Node.Value)
 }
 
+func Test_Watch_ExpireHasPrevNode(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	cw := Watch(store, 1*time.Second)
+	defer cw.Stop()
+
+	node, _, err := store.SetTTL("/foo", "bar", 1, Always)
+	ok(t, err)
+
+	act, err := cw.NextChange("/foo", false, node.ModifiedIndex+1)
+	ok(t, err)
+
+	equals(t, "expire", act.Action)
+	equals(t, "/foo", act.Node.Key)
+	equals(t, "", act.Node.Value)
+	equals(t, node.CreatedIndex, act.Node.CreatedIndex)
+	equals(t, "bar", act.PrevNode.Value)
+	equals(t, node.ModifiedIndex, act.PrevNode.ModifiedIndex)
+	if act.PrevNode.Expiration == nil || act.PrevNode.TTL != nil {
+		fatalf(t, "expected the expiration without a TTL, got %v and %v", act.PrevNode.Expiration, act.PrevNode.TTL)
+	}
+}
+
 func Test_Watch_ReturnsFirstMatchingChange(t *testing.T) {
 	store := testConn(t)
 	defer store.Close()
@@ -66,6 +93,252 @@ func Test_Watch_IgnoresOldChangeWhenIndexNotSet(t *testing.T) {
 	equals(t, "second", act.Node.Value)
 }
 
+func Test_Watch_NestedPrefixesInIndexOrder(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	cw := Watch(store, 50*time.Millisecond)
+	defer cw.Stop()
+
+	start := currIndex(store)
+	var mu sync.Mutex
+	var all, nested []int64
+
+	var writers sync.WaitGroup
+	for _, key := range []string{"/a/x", "/a/b/y"} {
+		writers.Add(1)
+		go func(key string) {
+			defer writers.Done()
+			for i := 0; i < 20; i++ {
+				node, _, err := store.Set(key, fmt.Sprint(i), Always)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				all = append(all, node.ModifiedIndex)
+				if key == "/a/b/y" {
+					nested = append(nested, node.ModifiedIndex)
+				}
+				mu.Unlock()
+			}
+		}(key)
+	}
+
+	// each prefix is watched the way clients do, continuing from the index
+	// after the last event
+	watchLoop := func(key string, count int, indexes chan<- []int64) {
+		var seen []int64
+		index := start + 1
+		for len(seen) < count {
+			act, err := cw.NextChange(key, true, index)
+			if err != nil {
+				t.Error(err)
+				break
+			}
+			seen = append(seen, act.Node.ModifiedIndex)
+			index = act.Node.ModifiedIndex + 1
+		}
+		indexes <- seen
+	}
+	allSeen := make(chan []int64)
+	nestedSeen := make(chan []int64)
+	go watchLoop("/a", 40, allSeen)
+	go watchLoop("/a/b", 20, nestedSeen)
+
+	writers.Wait()
+	sort.Sort(int64s(all))
+	sort.Sort(int64s(nested))
+
+	equals(t, all, <-allSeen)
+	equals(t, nested, <-nestedSeen)
+}
+
+func Test_Watch_WarmUp(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	for i := 0; i < MaxChanges+5; i++ {
+		_, _, err := store.Set("/foo", fmt.Sprint(i), Always)
+		ok(t, err)
+	}
+
+	cw := Watch(store, 1*time.Second)
+	defer cw.Stop()
+
+	// a watch from the oldest change the buffer holds is served from it
+	index := currIndex(store)
+	act, err := cw.NextChange("/foo", false, index-MaxChanges+1)
+	ok(t, err)
+	equals(t, fmt.Sprint(5), act.Node.Value)
+
+	state := cw.State(0)
+	equals(t, MaxChanges, state.Changes.Size)
+	equals(t, index, state.Changes.LastIndex)
+}
+
+func Test_AddWatches_Batch(t *testing.T) {
+	cw := &ChangeWatcher{
+		watch:   make(chan *watch),
+		watches: make(map[*watch]struct{}),
+		byKey:   newWatchIndex(),
+		groups:  make(map[watchGroupKey]*watchGroup),
+		changes: newChangeList(10),
+	}
+	for i := 0; i < watchBatch+10; i++ {
+		go func(i int) {
+			cw.watch <- NewWatch(0, fmt.Sprintf("/key%d", i), false)
+		}(i)
+	}
+	// let the senders block on the channel
+	time.Sleep(100 * time.Millisecond)
+
+	cw.addWatches(<-cw.watch)
+	equals(t, watchBatch, len(cw.watches))
+
+	cw.addWatches(<-cw.watch)
+	equals(t, watchBatch+10, len(cw.watches))
+}
+
+func Test_Dispatch_WatchesInOrder(t *testing.T) {
+	cw := &ChangeWatcher{
+		watches:       make(map[*watch]struct{}),
+		byKey:         newWatchIndex(),
+		groups:        make(map[watchGroupKey]*watchGroup),
+		subscriptions: make(map[*subscription]struct{}),
+		changes:       newChangeList(10),
+	}
+	results := make(chan watchResult, 10)
+
+	for _, key := range []string{"/a/b", "/a", "/a/b/c"} {
+		w := NewWatch(0, key, true)
+		w.result = results
+		cw.addWatch(w)
+	}
+	for _, c := range []change{
+		{Index: 5, Key: "/a/x", Action: "set"},
+		{Index: 6, Key: "/a/b/c/y", Action: "set"},
+	} {
+		next := cw.changes.Next()
+		*next = c
+		next.value = &models.ActionUpdate{Action: c.Action, Node: models.Node{Key: c.Key, ModifiedIndex: c.Index}}
+	}
+
+	cw.dispatch(0)
+	close(results)
+
+	var keys []string
+	for res := range results {
+		keys = append(keys, res.Action.Node.Key)
+	}
+	// changes are dispatched in index order, and to the watches in the order
+	// they were added
+	equals(t, []string{"/a/x", "/a/b/c/y", "/a/b/c/y"}, keys)
+	equals(t, 0, len(cw.watches))
+}
+
+func Test_Dispatch_CoalescesWatches(t *testing.T) {
+	cw := &ChangeWatcher{
+		watches:       make(map[*watch]struct{}),
+		byKey:         newWatchIndex(),
+		groups:        make(map[watchGroupKey]*watchGroup),
+		subscriptions: make(map[*subscription]struct{}),
+		changes:       newChangeList(10),
+		lastIndex:     4,
+	}
+
+	var watches []*watch
+	for _, index := range []int64{0, 0, 3, 5, 7} {
+		w := NewWatch(index, "/foo", false)
+		cw.addWatch(w)
+		watches = append(watches, w)
+	}
+	other := NewWatch(0, "/foo", true)
+	cw.addWatch(other)
+
+	// the watches from 0, from an index already checked, and from index 7
+	// are in separate groups
+	equals(t, 4, len(cw.groups))
+	equals(t, 6, len(cw.watches))
+	equals(t, 4, len(cw.byKey.candidates(&change{Index: 5, Key: "/foo"})))
+
+	// the next of the group takes the place of a watch that timed out
+	cw.removeWatch(watches[0])
+	_, err := watches[0].Result()
+	equals(t, ErrWatchTimeout, err)
+	equals(t, 4, len(cw.byKey.candidates(&change{Index: 5, Key: "/foo"})))
+
+	next := cw.changes.Next()
+	*next = change{Index: 5, Key: "/foo", Action: "set"}
+	next.value = &models.ActionUpdate{Action: "set", Node: models.Node{Key: "/foo", ModifiedIndex: 5}}
+	cw.dispatch(0)
+
+	for _, w := range []*watch{watches[1], watches[2], watches[3], other} {
+		action, err := w.Result()
+		ok(t, err)
+		equals(t, int64(5), action.EtcdIndex)
+	}
+	equals(t, 1, len(cw.watches))
+	equals(t, 1, len(cw.groups))
+	_, waiting := cw.watches[watches[4]]
+	equals(t, true, waiting)
+}
+
+func Test_ResolveValues_LikeValue(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	_, _, err := store.Set("/foo", "a", Always)
+	ok(t, err)
+	_, _, err = store.Set("/foo", "b", Always)
+	ok(t, err)
+	_, _, err = store.Set("/bar", "c", Always)
+	ok(t, err)
+	_, _, err = store.Delete("/foo", Always)
+	ok(t, err)
+
+	cw := &ChangeWatcher{store: store, changes: newChangeList(10)}
+	count, err := cw.fetchSince(0)
+	ok(t, err)
+	equals(t, 4, count)
+
+	var batch []*change
+	for i := 0; i < count; i++ {
+		c := *cw.changes.Item(i)
+		batch = append(batch, &c)
+	}
+	ok(t, resolveValues(store, batch))
+
+	// the values resolved together are the ones resolved one by one
+	for i, c := range batch {
+		if c.value == nil {
+			fatalf(t, "change %d wasn't resolved", c.Index)
+		}
+		expected, err := cw.changes.Item(i).Value(store)
+		ok(t, err)
+		equals(t, expected, c.value)
+	}
+}
+
+type int64s []int64
+
+func (s int64s) Len() int           { return len(s) }
+func (s int64s) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s int64s) Less(i, j int) bool { return s[i] < s[j] }
+
+func Test_Change_Build_DeleteDir(t *testing.T) {
+	prevModified := int64(3)
+	c := &change{Index: 5, Key: "/dir", Action: "delete", PrevNodeModified: &prevModified}
+	action, err := c.build(map[int64]*models.Node{
+		3: {Key: "/dir", Dir: true, CreatedIndex: 2, ModifiedIndex: 3},
+	})
+	ok(t, err)
+
+	// like etcd, the deleted node is still marked as a directory
+	equals(t, models.Node{Key: "/dir", Dir: true, CreatedIndex: 2, ModifiedIndex: 5}, action.Node)
+	equals(t, &models.Node{Key: "/dir", Dir: true, CreatedIndex: 2, ModifiedIndex: 3}, action.PrevNode)
+}
+
 func Test_ChangeList_Empty(t *testing.T) {
 	cl := newChangeList(100)
 	equals(t, 0, cl.Size)
@@ -234,3 +507,107 @@ func Test_Match_ExpireParent(t *testing.T) {
 	c := &change{Key: "/foo", Index: 1, Action: "expire"}
 	equals(t, true, w.Match(c))
 }
+
+func Test_Match_RecursiveShorterChangeKey(t *testing.T) {
+	w := &watch{Key: "/foo/bar", Recursive: true}
+	c := &change{Key: "/foo", Index: 1, Action: "set"}
+	equals(t, false, w.Match(c))
+}
+
+func Test_Match_RootRecursive(t *testing.T) {
+	w := &watch{Key: "/", Recursive: true}
+	equals(t, true, w.Match(&change{Key: "/foo", Index: 1, Action: "set"}))
+	equals(t, true, w.Match(&change{Key: "/foo/bar", Index: 1, Action: "delete"}))
+
+	w = &watch{Key: "/"}
+	equals(t, false, w.Match(&change{Key: "/foo", Index: 1, Action: "set"}))
+}
+
+func Test_Match_TrailingSlashRecursive(t *testing.T) {
+	w := &watch{Key: "/foo/", Recursive: true}
+	equals(t, true, w.Match(&change{Key: "/foo/bar", Index: 1, Action: "set"}))
+	equals(t, false, w.Match(&change{Key: "/foobar", Index: 1, Action: "set"}))
+}
+
+func Test_Match_Glob(t *testing.T) {
+	w := &watch{Key: "/services", Recursive: true, Glob: "/services/*/health"}
+	equals(t, true, w.Match(&change{Key: "/services/web/health", Index: 1, Action: "set"}))
+	equals(t, false, w.Match(&change{Key: "/services/web/port", Index: 1, Action: "set"}))
+
+	// recursive watches match the keys under matching directories
+	w = &watch{Key: "/", Recursive: true, Glob: "/tenants/*/config"}
+	equals(t, true, w.Match(&change{Key: "/tenants/a/config/port", Index: 1, Action: "set"}))
+	equals(t, false, w.Match(&change{Key: "/tenants/a/data", Index: 1, Action: "set"}))
+
+	w = &watch{Key: "/tenants", Glob: "/tenants/*/config"}
+	equals(t, false, w.Match(&change{Key: "/tenants/a/config/port", Index: 1, Action: "set"}))
+}
+
+func Test_Watch_Timeout(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	cw := Watch(store, 100*time.Millisecond)
+	defer cw.Stop()
+	cw.SetTimeout(200 * time.Millisecond)
+
+	_, err := cw.NextChange("/foo", false, 0)
+	equals(t, ErrWatchTimeout, err)
+	equals(t, 0, cw.State(0).WatchCount)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		store.Set("/foo", "bar", Always)
+	}()
+	act, err := cw.NextChange("/foo", false, 0)
+	ok(t, err)
+	equals(t, "bar", act.Node.Value)
+}
+
+func Test_Watch_Drain(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	cw := Watch(store, 100*time.Millisecond)
+	defer cw.Stop()
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cw.Drain()
+	}()
+	_, err := cw.NextChange("/foo", false, 0)
+	equals(t, ErrWatchTimeout, err)
+
+	// later watches end right away
+	_, err = cw.NextChange("/foo", false, 0)
+	equals(t, ErrWatchTimeout, err)
+	equals(t, 0, cw.State(0).WatchCount)
+}
+
+func Test_Watch_EtcdIndex(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	cw := Watch(store, 100*time.Millisecond)
+	defer cw.Stop()
+
+	set, _, err := store.Set("/foo", "first", Always)
+	ok(t, err)
+	_, _, err = store.Set("/other", "bar", Always)
+	ok(t, err)
+	time.Sleep(500 * time.Millisecond)
+
+	// a change in the history has the watcher's index
+	act, err := cw.NextChange("/foo", false, set.ModifiedIndex)
+	ok(t, err)
+	equals(t, currIndex(store), act.EtcdIndex)
+
+	// a new change has its own index
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		store.Set("/foo", "second", Always)
+	}()
+	act, err = cw.NextChange("/foo", false, 0)
+	ok(t, err)
+	equals(t, act.Node.ModifiedIndex, act.EtcdIndex)
+}
@@ -0,0 +1,218 @@
+package backend
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/rancher/etcdb/models"
+)
+
+// ErrLockTimeout is returned by Locks.Acquire when the lock could not be
+// acquired before the timeout.
+var ErrLockTimeout = errors.New("lock timeout")
+
+// Locks implements distributed locks in the style of etcd's old lock module.
+//
+// Each lock is a directory, and each client waiting for the lock creates an
+// in-order child node with a TTL. The client owning the child with the lowest
+// index holds the lock, and the others watch the child just ahead of them to
+// be notified when it is released or expires.
+type Locks struct {
+	store   *SqlBackend
+	watcher *ChangeWatcher
+}
+
+// NewLocks creates a Locks using the watcher to wait for lock releases
+func NewLocks(store *SqlBackend, watcher *ChangeWatcher) *Locks {
+	return &Locks{store, watcher}
+}
+
+// Acquire waits until the lock on the key is acquired, and returns the lock's
+// index. The value is stored in the lock node to identify the holder. A
+// negative timeout waits forever. The TTL must be positive, so that the lock
+// is released when its holder goes away.
+func (l *Locks) Acquire(key, value string, ttl int64, timeout time.Duration) (int64, error) {
+	if ttl <= 0 {
+		return 0, models.InvalidField("ttl must be positive")
+	}
+	// lock nodes are named after the index even with per-directory
+	// sequences, since the index is the lock index
+	node, err := l.store.createInOrder(key, value, &ttl, Always, false)
+	if err != nil {
+		return 0, err
+	}
+	index := node.CreatedIndex
+
+	var deadline <-chan time.Time
+	if timeout >= 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	waitIndex := index + 1
+
+	for {
+		prev, err := l.predecessor(key, node.Key)
+		if err != nil {
+			l.store.Delete(node.Key, Always)
+			return 0, err
+		}
+		if prev == nil {
+			return index, nil
+		}
+
+		action, err := l.watcher.NextChangeUntil(prev.Key, false, waitIndex, deadline)
+		if err == ErrWatchTimeout {
+			l.store.Delete(node.Key, Always)
+			return 0, ErrLockTimeout
+		}
+		if action != nil {
+			waitIndex = action.Node.ModifiedIndex + 1
+		}
+	}
+}
+
+// Renew resets the TTL of the lock node for the index.
+func (l *Locks) Renew(key string, index int64, ttl int64) error {
+	node, err := l.find(key, func(n *models.Node) bool { return LockIndex(n) == index })
+	if err != nil {
+		return err
+	}
+	_, _, err = l.store.SetTTL(node.Key, node.Value, ttl, PrevIndex(node.ModifiedIndex))
+	return err
+}
+
+// RenewValue resets the TTL of the lock node with the value.
+func (l *Locks) RenewValue(key, value string, ttl int64) error {
+	node, err := l.find(key, func(n *models.Node) bool { return n.Value == value })
+	if err != nil {
+		return err
+	}
+	_, _, err = l.store.SetTTL(node.Key, node.Value, ttl, PrevIndex(node.ModifiedIndex))
+	return err
+}
+
+// Release removes the lock node for the index, giving the lock to the next
+// waiting client.
+func (l *Locks) Release(key string, index int64) error {
+	node, err := l.find(key, func(n *models.Node) bool { return LockIndex(n) == index })
+	if err != nil {
+		return err
+	}
+	_, _, err = l.store.Delete(node.Key, PrevIndex(node.ModifiedIndex))
+	return err
+}
+
+// ReleaseValue removes the lock node with the value.
+func (l *Locks) ReleaseValue(key, value string) error {
+	node, err := l.find(key, func(n *models.Node) bool { return n.Value == value })
+	if err != nil {
+		return err
+	}
+	_, _, err = l.store.Delete(node.Key, PrevIndex(node.ModifiedIndex))
+	return err
+}
+
+// Holder returns the lock node of the current lock holder.
+func (l *Locks) Holder(key string) (*models.Node, error) {
+	nodes, err := l.waiting(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) == 0 {
+		index, err := l.store.currIndex(l.store.conn())
+		if err != nil {
+			return nil, err
+		}
+		return nil, models.NotFound(key, index)
+	}
+	return nodes[0], nil
+}
+
+// LockIndex returns the lock index of a lock node, from its in-order key.
+func LockIndex(node *models.Node) int64 {
+	index, _ := strconv.ParseInt(node.Key[len(splitKey(node.Key))+1:], 10, 64)
+	return index
+}
+
+func (l *Locks) find(key string, match func(*models.Node) bool) (*models.Node, error) {
+	nodes, err := l.waiting(key)
+	if err != nil {
+		return nil, err
+	}
+	for _, node := range nodes {
+		if match(node) {
+			return node, nil
+		}
+	}
+	index, err := l.store.currIndex(l.store.conn())
+	if err != nil {
+		return nil, err
+	}
+	return nil, models.NotFound(key, index)
+}
+
+// predecessor returns the lock node just ahead of the client's node, or nil if
+// the client holds the lock.
+func (l *Locks) predecessor(key, own string) (*models.Node, error) {
+	nodes, err := l.waiting(key)
+	if err != nil {
+		return nil, err
+	}
+	for i, node := range nodes {
+		if node.Key == own {
+			if i == 0 {
+				return nil, nil
+			}
+			return nodes[i-1], nil
+		}
+	}
+	// our node expired before getting the lock
+	index, err := l.store.currIndex(l.store.conn())
+	if err != nil {
+		return nil, err
+	}
+	return nil, models.NotFound(own, index)
+}
+
+// waiting returns the lock nodes under the key ordered by lock index.
+func (l *Locks) waiting(key string) ([]*models.Node, error) {
+	tx, err := l.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := l.store.unexpired(l.store.queryNode().Extend(
+		` AND "key" LIKE `, likePrefix(key),
+		` AND path_depth = `, pathDepth(key)+1,
+	)).Query(tx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var nodes []*models.Node
+	for rows.Next() {
+		node, err := scanNode(rows)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Sort(byLockIndex(nodes))
+	return nodes, nil
+}
+
+type byLockIndex []*models.Node
+
+func (n byLockIndex) Len() int           { return len(n) }
+func (n byLockIndex) Swap(i, j int)      { n[i], n[j] = n[j], n[i] }
+func (n byLockIndex) Less(i, j int) bool { return LockIndex(n[i]) < LockIndex(n[j]) }
@@ -0,0 +1,123 @@
+package backend
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rancher/etcdb/models"
+)
+
+func Test_Lock_AcquireRelease(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	cw := Watch(store, 100*time.Millisecond)
+	defer cw.Stop()
+
+	locks := NewLocks(store, cw)
+
+	index, err := locks.Acquire("/lock", "first", 60, -1)
+	ok(t, err)
+
+	holder, err := locks.Holder("/lock")
+	ok(t, err)
+	equals(t, "first", holder.Value)
+	equals(t, index, LockIndex(holder))
+
+	ok(t, locks.Release("/lock", index))
+
+	_, err = locks.Holder("/lock")
+	expectError(t, "Key not found", "/lock", err)
+}
+
+func Test_Lock_WaitsForRelease(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	cw := Watch(store, 100*time.Millisecond)
+	defer cw.Stop()
+
+	locks := NewLocks(store, cw)
+
+	first, err := locks.Acquire("/lock", "first", 60, -1)
+	ok(t, err)
+
+	acquired := make(chan int64)
+	go func() {
+		index, err := locks.Acquire("/lock", "second", 60, -1)
+		ok(t, err)
+		acquired <- index
+	}()
+
+	select {
+	case <-acquired:
+		fatalf(t, "second lock should not be acquired while the first is held")
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	ok(t, locks.ReleaseValue("/lock", "first"))
+
+	select {
+	case second := <-acquired:
+		if second <= first {
+			fatalf(t, "second lock index %d should be after the first %d", second, first)
+		}
+	case <-time.After(5 * time.Second):
+		fatalf(t, "second lock should be acquired after the first is released")
+	}
+
+	holder, err := locks.Holder("/lock")
+	ok(t, err)
+	equals(t, "second", holder.Value)
+}
+
+func Test_Lock_Timeout(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	cw := Watch(store, 100*time.Millisecond)
+	defer cw.Stop()
+
+	locks := NewLocks(store, cw)
+
+	_, err := locks.Acquire("/lock", "first", 60, -1)
+	ok(t, err)
+
+	_, err = locks.Acquire("/lock", "second", 60, 200*time.Millisecond)
+	equals(t, ErrLockTimeout, err)
+
+	// the timed out client should not be left waiting in line
+	nodes, err := locks.waiting("/lock")
+	ok(t, err)
+	equals(t, 1, len(nodes))
+}
+
+func Test_Lock_InvalidTTL(t *testing.T) {
+	locks := NewLocks(nil, nil)
+
+	_, err := locks.Acquire("/lock", "first", 0, -1)
+	equals(t, models.InvalidField("ttl must be positive"), err)
+}
+
+func Test_Lock_Renew(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	cw := Watch(store, 100*time.Millisecond)
+	defer cw.Stop()
+
+	locks := NewLocks(store, cw)
+
+	index, err := locks.Acquire("/lock", "first", 1, -1)
+	ok(t, err)
+
+	ok(t, locks.Renew("/lock", index, 60))
+
+	// MySQL only stores to 1-second precision, so sleep long enough that
+	// the original TTL would have expired
+	time.Sleep(2 * time.Second)
+
+	holder, err := locks.Holder("/lock")
+	ok(t, err)
+	equals(t, index, LockIndex(holder))
+}
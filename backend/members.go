@@ -0,0 +1,154 @@
+package backend
+
+import (
+	"log"
+	"strings"
+	"time"
+)
+
+// Membership registers this instance in the members table, and periodically
+// refreshes its heartbeat so other instances can advertise its client URLs.
+// Members whose heartbeat is older than the grace period are removed.
+type Membership struct {
+	store      *SqlBackend
+	name       string
+	clientURLs []string
+	interval   time.Duration
+	grace      time.Duration
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+// Register creates and starts a Membership for the instance
+func Register(store *SqlBackend, name string, clientURLs []string, interval, grace time.Duration) *Membership {
+	m := &Membership{
+		store:      store,
+		name:       name,
+		clientURLs: clientURLs,
+		interval:   interval,
+		grace:      grace,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go m.Run()
+	return m
+}
+
+// Stop stops the heartbeat loop, and removes the instance from the members
+// table once the loop has ended, so that a last heartbeat can't add it back
+func (m *Membership) Stop() {
+	close(m.stop)
+	<-m.done
+	if err := m.store.removeMember(m.name); err != nil {
+		log.Println("error removing member:", err)
+	}
+}
+
+// Handoff stops the heartbeat loop, but leaves the instance in the members
+// table for a successor with the same name, like a new version of this one
+// taking over the listening sockets.
+func (m *Membership) Handoff() {
+	close(m.stop)
+	<-m.done
+}
+
+// Run sends heartbeats until stopped
+func (m *Membership) Run() {
+	defer close(m.done)
+	m.beat()
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			m.beat()
+		}
+	}
+}
+
+func (m *Membership) beat() {
+	if err := m.store.heartbeat(m.name, m.clientURLs); err != nil {
+		log.Println("error sending heartbeat:", err)
+	}
+	removed, err := m.store.removeStaleMembers(m.grace)
+	if err != nil {
+		log.Println("error removing stale members:", err)
+	} else if removed > 0 {
+		log.Printf("removed %d stale members", removed)
+	}
+}
+
+// ClientURLs returns the client URLs of all live members
+func (m *Membership) ClientURLs() ([]string, error) {
+	return m.store.memberClientURLs(m.grace)
+}
+
+func (b *SqlBackend) heartbeat(name string, clientURLs []string) (err error) {
+	tx, err := b.conn().Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}()
+
+	_, err = b.Query().Extend(`DELETE FROM "members" WHERE "name" = `, name).Exec(tx)
+	if err != nil {
+		return err
+	}
+
+	_, err = b.Query().Extend(`INSERT INTO "members" ("name", "client_urls", "heartbeat")
+		VALUES (`, name, `, `, strings.Join(clientURLs, ","), `, `+b.dialect.Now()+`)`).Exec(tx)
+	return err
+}
+
+func (b *SqlBackend) removeMember(name string) error {
+	_, err := b.Query().Extend(`DELETE FROM "members" WHERE "name" = `, name).Exec(b.conn())
+	return err
+}
+
+// graceSeconds returns the grace period in whole seconds, rounded up so that
+// members aren't removed before it ends
+func graceSeconds(grace time.Duration) int64 {
+	return int64((grace + time.Second - 1) / time.Second)
+}
+
+func (b *SqlBackend) removeStaleMembers(grace time.Duration) (int64, error) {
+	query := b.Query().Text(`DELETE FROM "members" WHERE "heartbeat" < `)
+	b.dialect.Expiration(query, -graceSeconds(grace))
+	res, err := query.Exec(b.conn())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (b *SqlBackend) memberClientURLs(grace time.Duration) ([]string, error) {
+	query := b.Query().Text(`SELECT "client_urls" FROM "members" WHERE "heartbeat" >= `)
+	b.dialect.Expiration(query, -graceSeconds(grace))
+	query.Text(` ORDER BY "name"`)
+
+	rows, err := query.Query(b.conn())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var urls []string
+	for rows.Next() {
+		var clientURLs string
+		if err := rows.Scan(&clientURLs); err != nil {
+			return nil, err
+		}
+		urls = append(urls, strings.Split(clientURLs, ",")...)
+	}
+	return urls, rows.Err()
+}
@@ -0,0 +1,68 @@
+package backend
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_Members_ClientURLs(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	first := Register(store, "first", []string{"http://10.0.0.1:2379", "http://10.0.0.1:4001"}, time.Minute, time.Minute)
+	second := Register(store, "second", []string{"http://10.0.0.2:2379"}, time.Minute, time.Minute)
+	defer second.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	urls, err := first.ClientURLs()
+	ok(t, err)
+	equals(t, []string{"http://10.0.0.1:2379", "http://10.0.0.1:4001", "http://10.0.0.2:2379"}, urls)
+
+	first.Stop()
+
+	urls, err = second.ClientURLs()
+	ok(t, err)
+	equals(t, []string{"http://10.0.0.2:2379"}, urls)
+}
+
+func Test_Members_RemovesStale(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	ok(t, store.heartbeat("stale", []string{"http://10.0.0.1:2379"}))
+
+	// MySQL only stores to 1-second precision, so sleep long enough to be
+	// past the grace period
+	time.Sleep(2 * time.Second)
+
+	m := Register(store, "live", []string{"http://10.0.0.2:2379"}, time.Minute, time.Second)
+	defer m.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	urls, err := m.ClientURLs()
+	ok(t, err)
+	equals(t, []string{"http://10.0.0.2:2379"}, urls)
+
+	var count int
+	err = store.conn().QueryRow(`SELECT COUNT(*) FROM "members"`).Scan(&count)
+	ok(t, err)
+	equals(t, 1, count)
+}
+
+func Test_Members_StopLeaves(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	m := Register(store, "leaving", []string{"http://10.0.0.1:2379"}, time.Minute, time.Minute)
+	m.Stop()
+
+	urls, err := m.ClientURLs()
+	ok(t, err)
+	equals(t, 0, len(urls))
+}
+
+func Test_Members_GraceSeconds(t *testing.T) {
+	equals(t, int64(1), graceSeconds(time.Second))
+	equals(t, int64(5), graceSeconds(4500*time.Millisecond))
+	equals(t, int64(60), graceSeconds(time.Minute))
+}
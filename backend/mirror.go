@@ -0,0 +1,270 @@
+package backend
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"path"
+	"time"
+
+	"github.com/rancher/etcdb/client"
+	"github.com/rancher/etcdb/models"
+)
+
+// MirrorStats counts the changes replayed by a Mirror, its errors and full
+// copies, and publishes the last replayed index and how many indexes it is
+// behind, with expvar.
+var MirrorStats = expvar.NewMap("mirror")
+
+var mirrorIndex, mirrorLag = new(expvar.Int), new(expvar.Int)
+
+func init() {
+	MirrorStats.Set("index", mirrorIndex)
+	MirrorStats.Set("lag", mirrorLag)
+}
+
+// mirrorPoll is how long the mirror waits for changes before saving its
+// position and checking if it was stopped
+const mirrorPoll = 10 * time.Second
+
+// mirrorRequestTimeout limits each request to the target
+const mirrorRequestTimeout = 30 * time.Second
+
+// A Mirror replays the changes to the keys under its prefixes to another etcd
+// v2 endpoint, like etcd or another etcdb, in index order. It starts with a
+// copy of the keys, and its position is saved in a named subscription so that
+// it continues from there after a restart. When the changes it needs were
+// already trimmed from the history, it copies the keys again.
+type Mirror struct {
+	store      *SqlBackend
+	watcher    *ChangeWatcher
+	target     *client.Client
+	name       string
+	prefixes   []string
+	retryDelay time.Duration
+	// sub is the subscription for the changes still to replay, or nil until
+	// the keys are copied
+	sub  *Subscription
+	stop chan struct{}
+}
+
+// StartMirror creates and starts a Mirror of the keys under the prefixes, or
+// all keys without any, to the target. The name is the name of its
+// subscription, which is created on the first start.
+func StartMirror(watcher *ChangeWatcher, target *client.Client, name string, prefixes []string) *Mirror {
+	var cleaned []string
+	for _, prefix := range prefixes {
+		cleaned = append(cleaned, path.Clean("/"+prefix))
+	}
+	if len(cleaned) == 0 {
+		cleaned = []string{"/"}
+	}
+	m := &Mirror{
+		store:      watcher.store,
+		watcher:    watcher,
+		target:     target,
+		name:       name,
+		prefixes:   cleaned,
+		retryDelay: time.Second,
+		stop:       make(chan struct{}),
+	}
+	go m.Run()
+	return m
+}
+
+// Stop stops the mirror's Run loop
+func (m *Mirror) Stop() {
+	close(m.stop)
+}
+
+// Run replays the changes until stopped, retrying after errors
+func (m *Mirror) Run() {
+	for {
+		select {
+		case <-m.stop:
+			return
+		default:
+		}
+
+		if err := m.step(); err != nil {
+			log.Println("error mirroring changes:", err)
+			MirrorStats.Add("errors", 1)
+			select {
+			case <-m.stop:
+				return
+			case <-time.After(m.retryDelay):
+			}
+		}
+	}
+}
+
+// step replays the next batch of changes, after loading the subscription or
+// copying the keys if needed
+func (m *Mirror) step() error {
+	if m.sub == nil {
+		sub, err := m.store.GetSubscription(m.name)
+		if etcdErr, ok := err.(models.Error); ok && etcdErr.ErrorCode == 100 {
+			return m.copyKeys()
+		} else if err != nil {
+			return err
+		}
+		m.sub = sub
+	}
+
+	batch, err := m.watcher.Subscribe(m.sub, mirrorPoll)
+	if etcdErr, ok := err.(models.Error); ok && etcdErr.ErrorCode == 401 {
+		log.Printf("mirror %s is behind the history, copying the keys again: %v", m.name, err)
+		return m.copyKeys()
+	} else if err != nil {
+		return err
+	}
+
+	lastIndex := batch.NextIndex - 1
+	for _, event := range batch.Events {
+		mirrorLag.Set(lastIndex - event.Node.ModifiedIndex)
+		if err := m.apply(event); err != nil {
+			return m.saveCursor(err)
+		}
+		m.sub.SinceIndex = event.Node.ModifiedIndex + 1
+		mirrorIndex.Set(event.Node.ModifiedIndex)
+		MirrorStats.Add("changes", 1)
+	}
+	m.sub.SinceIndex = batch.NextIndex
+	mirrorLag.Set(0)
+	return m.saveCursor(nil)
+}
+
+// saveCursor saves the subscription's position, and returns err, or the
+// error saving it
+func (m *Mirror) saveCursor(err error) error {
+	if saveErr := m.store.SetSubscriptionCursor(m.name, m.sub.SinceIndex); err == nil {
+		err = saveErr
+	}
+	return err
+}
+
+// apply replays the change on the target. The target may already have it
+// after a retry, so missing keys aren't errors for deletes, and existing
+// directories aren't errors for sets.
+func (m *Mirror) apply(event *models.ActionUpdate) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mirrorRequestTimeout)
+	defer cancel()
+
+	node := event.Node
+	switch event.Action {
+	case "delete", "compareAndDelete", "expire":
+		_, err := m.target.Delete(ctx, node.Key, &client.DeleteOptions{Recursive: true})
+		return ignoreCodes(err, 100)
+	default:
+		return m.set(ctx, &node)
+	}
+}
+
+// set sets the node's value, or creates it as a directory, on the target
+func (m *Mirror) set(ctx context.Context, node *models.Node) error {
+	ttl := node.TTL
+	if ttl != nil && *ttl < 1 {
+		// about to expire, which the target will do itself
+		one := int64(1)
+		ttl = &one
+	}
+	if node.Dir {
+		_, err := m.target.Set(ctx, node.Key, "", &client.SetOptions{Dir: true, TTL: ttl})
+		return ignoreCodes(err, 102, 105)
+	}
+	_, err := m.target.Set(ctx, node.Key, node.Value, &client.SetOptions{TTL: ttl})
+	return err
+}
+
+// copyKeys replaces the keys under the prefixes on the target with a copy of
+// the current ones, and saves the subscription for the changes after them.
+func (m *Mirror) copyKeys() error {
+	var keys []WatchKey
+	var sinceIndex int64
+	for _, prefix := range m.prefixes {
+		node, index, err := m.store.GetConsistent(prefix, true)
+		if etcdErr, ok := err.(models.Error); ok && etcdErr.ErrorCode == 100 {
+			node, index = nil, etcdErr.Index
+		} else if err != nil {
+			return err
+		}
+
+		if err := m.clear(prefix); err != nil {
+			return err
+		}
+		if node != nil {
+			if err := m.copyNode(node); err != nil {
+				return err
+			}
+		}
+
+		keys = append(keys, WatchKey{Key: prefix, Recursive: true})
+		// changes between the copies of the prefixes are replayed again,
+		// which leaves the same keys
+		if sinceIndex == 0 || index+1 < sinceIndex {
+			sinceIndex = index + 1
+		}
+	}
+
+	sub := &Subscription{Keys: keys, SinceIndex: sinceIndex}
+	if err := m.store.SaveSubscription(m.name, sub); err != nil {
+		return err
+	}
+	m.sub = sub
+	MirrorStats.Add("copies", 1)
+	return nil
+}
+
+// clear removes the keys under the prefix on the target
+func (m *Mirror) clear(prefix string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mirrorRequestTimeout)
+	defer cancel()
+
+	if prefix != "/" {
+		_, err := m.target.Delete(ctx, prefix, &client.DeleteOptions{Recursive: true})
+		return ignoreCodes(err, 100)
+	}
+
+	// the root can't be removed, only the keys in it
+	res, err := m.target.Get(ctx, "/", nil)
+	if err != nil {
+		return err
+	}
+	for _, node := range res.Node.Nodes {
+		_, err := m.target.Delete(ctx, node.Key, &client.DeleteOptions{Recursive: true})
+		if err := ignoreCodes(err, 100); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyNode sets the node and the nodes under it on the target
+func (m *Mirror) copyNode(node *models.Node) error {
+	if node.Key != "/" {
+		ctx, cancel := context.WithTimeout(context.Background(), mirrorRequestTimeout)
+		err := m.set(ctx, node)
+		cancel()
+		if err != nil {
+			return err
+		}
+	}
+	for _, child := range node.Nodes {
+		if err := m.copyNode(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ignoreCodes returns nil instead of etcd errors with any of the codes
+func ignoreCodes(err error, codes ...int) error {
+	if etcdErr, ok := err.(models.Error); ok {
+		for _, code := range codes {
+			if etcdErr.ErrorCode == code {
+				return nil
+			}
+		}
+	}
+	return err
+}
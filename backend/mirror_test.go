@@ -0,0 +1,107 @@
+package backend
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rancher/etcdb/client"
+)
+
+// recordingTarget records the requests to a mirror's target, and answers them
+// with an empty root
+type recordingTarget struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []string
+}
+
+func newRecordingTarget() *recordingTarget {
+	target := &recordingTarget{}
+	target.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		target.mu.Lock()
+		target.requests = append(target.requests, r.Method+" "+r.URL.Path+" "+r.Form.Encode())
+		target.mu.Unlock()
+		rw.Write([]byte(`{"action":"get","node":{"key":"/","dir":true}}`))
+	}))
+	return target
+}
+
+func (target *recordingTarget) take() []string {
+	target.mu.Lock()
+	defer target.mu.Unlock()
+	requests := target.requests
+	target.requests = nil
+	return requests
+}
+
+func testMirror(store *SqlBackend, cw *ChangeWatcher, target *recordingTarget, prefixes ...string) *Mirror {
+	return &Mirror{
+		store:    store,
+		watcher:  cw,
+		target:   client.New(target.URL),
+		name:     "mirror",
+		prefixes: prefixes,
+	}
+}
+
+func Test_Mirror_CopiesThenReplays(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+	cw := Watch(store, 10*time.Millisecond)
+	defer cw.Stop()
+	target := newRecordingTarget()
+	defer target.Close()
+
+	_, _, err := store.Set("/app/a", "1", Always)
+	ok(t, err)
+	_, _, err = store.Set("/other/a", "1", Always)
+	ok(t, err)
+
+	m := testMirror(store, cw, target, "/app")
+	ok(t, m.step())
+	equals(t, []string{
+		"DELETE /v2/keys/app recursive=true",
+		"PUT /v2/keys/app dir=true",
+		"PUT /v2/keys/app/a value=1",
+	}, target.take())
+
+	_, _, err = store.Set("/app/b", "2", Always)
+	ok(t, err)
+	_, _, err = store.Set("/other/b", "2", Always)
+	ok(t, err)
+	_, _, err = store.Delete("/app/a", Always)
+	ok(t, err)
+
+	ok(t, m.step())
+	equals(t, []string{
+		"PUT /v2/keys/app/b value=2",
+		"DELETE /v2/keys/app/a recursive=true",
+	}, target.take())
+
+	sub, err := store.GetSubscription("mirror")
+	ok(t, err)
+	equals(t, currIndex(store)+1, sub.SinceIndex)
+}
+
+func Test_Mirror_ResumesFromSubscription(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+	cw := Watch(store, 10*time.Millisecond)
+	defer cw.Stop()
+	target := newRecordingTarget()
+	defer target.Close()
+
+	ok(t, testMirror(store, cw, target, "/").step())
+	equals(t, []string{"GET /v2/keys/ "}, target.take())
+
+	_, _, err := store.Set("/foo", "bar", Always)
+	ok(t, err)
+
+	// a restarted mirror continues after the copy
+	ok(t, testMirror(store, cw, target, "/").step())
+	equals(t, []string{"PUT /v2/keys/foo value=bar"}, target.take())
+}
@@ -0,0 +1,113 @@
+package backend
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"github.com/rancher/etcdb/models"
+)
+
+// SaveSubscription registers a named subscription, replacing any existing
+// subscription with the name. The subscription's SinceIndex is the first index
+// that will be delivered; if it is 0, delivery starts after the current index.
+func (b *SqlBackend) SaveSubscription(name string, sub *Subscription) (err error) {
+	tx, err := b.conn().Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}()
+
+	nextIndex := sub.SinceIndex
+	if nextIndex == 0 {
+		index, err := b.currIndex(tx)
+		if err != nil {
+			return err
+		}
+		nextIndex = index + 1
+	}
+
+	js, err := json.Marshal(Subscription{
+		Keys:       sub.Keys,
+		Actions:    sub.Actions,
+		KeyGlob:    sub.KeyGlob,
+		ValueRegex: sub.ValueRegex,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = b.Query().Extend(`DELETE FROM "subscriptions" WHERE "name" = `, name).Exec(tx)
+	if err != nil {
+		return err
+	}
+
+	_, err = b.Query().Extend(`INSERT INTO "subscriptions" ("name", "subscription", "next_index")
+		VALUES (`, name, `, `, string(js), `, `, nextIndex, `)`).Exec(tx)
+	return err
+}
+
+// GetSubscription returns the named subscription, with SinceIndex set to the
+// index following the last delivered change.
+func (b *SqlBackend) GetSubscription(name string) (*Subscription, error) {
+	var js string
+	var nextIndex int64
+	err := b.Query().Extend(`SELECT "subscription", "next_index" FROM "subscriptions" WHERE "name" = `, name).
+		QueryRow(b.conn()).Scan(&js, &nextIndex)
+	if err == sql.ErrNoRows {
+		return nil, b.subscriptionNotFound(name)
+	} else if err != nil {
+		return nil, err
+	}
+
+	var sub Subscription
+	if err := json.Unmarshal([]byte(js), &sub); err != nil {
+		return nil, err
+	}
+	sub.SinceIndex = nextIndex
+	return &sub, nil
+}
+
+// SetSubscriptionCursor records the index the named subscription should
+// continue delivering from.
+func (b *SqlBackend) SetSubscriptionCursor(name string, nextIndex int64) error {
+	res, err := b.Query().Extend(`UPDATE "subscriptions" SET "next_index" = `, nextIndex,
+		` WHERE "name" = `, name).Exec(b.conn())
+	if err != nil {
+		return err
+	}
+	return b.checkSubscriptionFound(name, res)
+}
+
+// DeleteSubscription removes the named subscription.
+func (b *SqlBackend) DeleteSubscription(name string) error {
+	res, err := b.Query().Extend(`DELETE FROM "subscriptions" WHERE "name" = `, name).Exec(b.conn())
+	if err != nil {
+		return err
+	}
+	return b.checkSubscriptionFound(name, res)
+}
+
+func (b *SqlBackend) checkSubscriptionFound(name string, res sql.Result) error {
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return b.subscriptionNotFound(name)
+	}
+	return nil
+}
+
+func (b *SqlBackend) subscriptionNotFound(name string) error {
+	index, err := b.currIndex(b.conn())
+	if err != nil {
+		return err
+	}
+	return models.NotFound(name, index)
+}
@@ -0,0 +1,86 @@
+package backend
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_SaveSubscription_StartsAfterCurrentIndex(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	_, _, err := store.Set("/foo", "bar", Always)
+	ok(t, err)
+
+	err = store.SaveSubscription("consumer", &Subscription{Keys: []WatchKey{{Key: "/foo"}}})
+	ok(t, err)
+
+	sub, err := store.GetSubscription("consumer")
+	ok(t, err)
+	equals(t, []WatchKey{{Key: "/foo"}}, sub.Keys)
+	equals(t, currIndex(store)+1, sub.SinceIndex)
+}
+
+func Test_SaveSubscription_Replaces(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	err := store.SaveSubscription("consumer", &Subscription{Keys: []WatchKey{{Key: "/foo"}}, SinceIndex: 5})
+	ok(t, err)
+	err = store.SaveSubscription("consumer", &Subscription{Keys: []WatchKey{{Key: "/bar"}}, SinceIndex: 7})
+	ok(t, err)
+
+	sub, err := store.GetSubscription("consumer")
+	ok(t, err)
+	equals(t, []WatchKey{{Key: "/bar"}}, sub.Keys)
+	equals(t, int64(7), sub.SinceIndex)
+}
+
+func Test_SubscriptionCursor_Resumes(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	cw := Watch(store, 100*time.Millisecond)
+	defer cw.Stop()
+
+	err := store.SaveSubscription("consumer", &Subscription{Keys: []WatchKey{{Key: "/foo"}}})
+	ok(t, err)
+
+	store.Set("/foo", "first", Always)
+	time.Sleep(500 * time.Millisecond)
+
+	sub, err := store.GetSubscription("consumer")
+	ok(t, err)
+	batch, err := cw.Subscribe(sub, -1)
+	ok(t, err)
+	equals(t, 1, len(batch.Events))
+	equals(t, "first", batch.Events[0].Node.Value)
+	ok(t, store.SetSubscriptionCursor("consumer", batch.NextIndex))
+
+	store.Set("/foo", "second", Always)
+	time.Sleep(500 * time.Millisecond)
+
+	// a restarted consumer only gets the changes it hasn't seen
+	sub, err = store.GetSubscription("consumer")
+	ok(t, err)
+	batch, err = cw.Subscribe(sub, -1)
+	ok(t, err)
+	equals(t, 1, len(batch.Events))
+	equals(t, "second", batch.Events[0].Node.Value)
+}
+
+func Test_DeleteSubscription(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	err := store.SaveSubscription("consumer", &Subscription{Keys: []WatchKey{{Key: "/foo"}}})
+	ok(t, err)
+
+	ok(t, store.DeleteSubscription("consumer"))
+
+	_, err = store.GetSubscription("consumer")
+	expectError(t, "Key not found", "consumer", err)
+
+	err = store.DeleteSubscription("consumer")
+	expectError(t, "Key not found", "consumer", err)
+}
@@ -0,0 +1,341 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// An ObjectStore keeps the snapshots written by Backups. The names are
+// relative to the store's prefix.
+type ObjectStore interface {
+	Put(name string, data []byte) error
+	Get(name string) ([]byte, error)
+	// List returns the names of the objects, sorted
+	List() ([]string, error)
+	Delete(name string) error
+}
+
+// OpenObjectStore returns the object store for the URL:
+//
+//   - s3://bucket/prefix?region=us-east-1 for Amazon S3, with the credentials
+//     in AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN. An
+//     endpoint=https://host option sends the requests to another
+//     S3-compatible store instead.
+//   - gs://bucket/prefix for Google Cloud Storage, through its S3-compatible
+//     XML API, with HMAC keys in AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY
+//   - azure://account/container/prefix for Azure Blob Storage, with a SAS
+//     token for the container in AZURE_STORAGE_SAS_TOKEN
+//   - file:///path for a local directory
+func OpenObjectStore(rawurl string) (ObjectStore, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.Trim(u.Path, "/")
+	options := u.Query()
+
+	switch u.Scheme {
+	case "s3":
+		region := options.Get("region")
+		if region == "" {
+			region = os.Getenv("AWS_REGION")
+		}
+		if region == "" {
+			return nil, fmt.Errorf("the region of %s is required", rawurl)
+		}
+		return newS3Store(u.Host, prefix, region, options.Get("endpoint"))
+	case "gs":
+		return newS3Store(u.Host, prefix, "auto", "https://storage.googleapis.com")
+	case "azure":
+		parts := strings.SplitN(prefix, "/", 2)
+		if parts[0] == "" {
+			return nil, fmt.Errorf("the container of %s is required", rawurl)
+		}
+		container, prefix := parts[0], ""
+		if len(parts) == 2 {
+			prefix = parts[1]
+		}
+		endpoint := options.Get("endpoint")
+		if endpoint == "" {
+			endpoint = "https://" + u.Host + ".blob.core.windows.net"
+		}
+		sas, err := url.ParseQuery(strings.TrimPrefix(os.Getenv("AZURE_STORAGE_SAS_TOKEN"), "?"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid AZURE_STORAGE_SAS_TOKEN: %v", err)
+		}
+		return &azureStore{container: strings.TrimRight(endpoint, "/") + "/" + container, prefix: prefix, sas: sas}, nil
+	case "file":
+		return &fileStore{dir: fileURLPath(u.Path)}, nil
+	}
+	return nil, fmt.Errorf("unsupported object store: %s", rawurl)
+}
+
+// objectName joins the prefix and the name
+func objectName(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "/" + name
+}
+
+// objectRequest sends a request to an object store, and returns the body of
+// a successful response
+func objectRequest(method, u string, body []byte, header http.Header) ([]byte, error) {
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s %s: %s", method, req.URL.Path, resp.Status)
+	}
+	return data, nil
+}
+
+// s3Expires is how long the presigned requests to S3 are valid
+const s3Expires = 5 * time.Minute
+
+// s3Store keeps the objects in an S3 bucket, with requests presigned with the
+// AWS credentials of the environment
+type s3Store struct {
+	scheme string
+	// host and base are the host and path of the bucket, which is in the host
+	// for AWS, and in the path for other endpoints
+	host   string
+	base   string
+	prefix string
+	region string
+}
+
+func newS3Store(bucket, prefix, region, endpoint string) (*s3Store, error) {
+	if endpoint == "" {
+		return &s3Store{
+			scheme: "https",
+			host:   bucket + ".s3." + region + ".amazonaws.com",
+			base:   "/",
+			prefix: prefix,
+			region: region,
+		}, nil
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return &s3Store{
+		scheme: u.Scheme,
+		host:   u.Host,
+		base:   "/" + bucket + "/",
+		prefix: prefix,
+		region: region,
+	}, nil
+}
+
+func (s *s3Store) do(method, key string, query url.Values, body []byte) ([]byte, error) {
+	aws, err := AWSCredentialsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	u := presignV4(aws, method, "s3", s.region, s.host, s.base+key, query, s3Expires, "UNSIGNED-PAYLOAD", time.Now())
+	u = s.scheme + strings.TrimPrefix(u, "https")
+	return objectRequest(method, u, body, nil)
+}
+
+func (s *s3Store) Put(name string, data []byte) error {
+	_, err := s.do("PUT", objectName(s.prefix, name), nil, data)
+	return err
+}
+
+func (s *s3Store) Get(name string) ([]byte, error) {
+	return s.do("GET", objectName(s.prefix, name), nil, nil)
+}
+
+func (s *s3Store) Delete(name string) error {
+	_, err := s.do("DELETE", objectName(s.prefix, name), nil, nil)
+	return err
+}
+
+func (s *s3Store) List() ([]string, error) {
+	prefix := objectName(s.prefix, "")
+	var names []string
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		data, err := s.do("GET", "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var res struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		if err := xml.Unmarshal(data, &res); err != nil {
+			return nil, err
+		}
+		for _, object := range res.Contents {
+			names = append(names, strings.TrimPrefix(object.Key, prefix))
+		}
+		if !res.IsTruncated {
+			break
+		}
+		token = res.NextContinuationToken
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// azureStore keeps the objects as block blobs in an Azure Blob Storage
+// container, authorized with a SAS token
+type azureStore struct {
+	// container is the URL of the container
+	container string
+	prefix    string
+	sas       url.Values
+}
+
+func (s *azureStore) url(name string, query url.Values) string {
+	params := url.Values{}
+	for name, values := range s.sas {
+		params[name] = values
+	}
+	for name, values := range query {
+		params[name] = values
+	}
+	u := s.container
+	if name != "" {
+		u += "/" + (&url.URL{Path: name}).EscapedPath()
+	}
+	return u + "?" + params.Encode()
+}
+
+func (s *azureStore) Put(name string, data []byte) error {
+	header := http.Header{"X-Ms-Blob-Type": {"BlockBlob"}}
+	_, err := objectRequest("PUT", s.url(objectName(s.prefix, name), nil), data, header)
+	return err
+}
+
+func (s *azureStore) Get(name string) ([]byte, error) {
+	return objectRequest("GET", s.url(objectName(s.prefix, name), nil), nil, nil)
+}
+
+func (s *azureStore) Delete(name string) error {
+	_, err := objectRequest("DELETE", s.url(objectName(s.prefix, name), nil), nil, nil)
+	return err
+}
+
+func (s *azureStore) List() ([]string, error) {
+	prefix := objectName(s.prefix, "")
+	var names []string
+	marker := ""
+	for {
+		query := url.Values{"restype": {"container"}, "comp": {"list"}, "prefix": {prefix}}
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+		data, err := objectRequest("GET", s.url("", query), nil, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var res struct {
+			Blobs []struct {
+				Name string
+			} `xml:"Blobs>Blob"`
+			NextMarker string
+		}
+		if err := xml.Unmarshal(data, &res); err != nil {
+			return nil, err
+		}
+		for _, blob := range res.Blobs {
+			names = append(names, strings.TrimPrefix(blob.Name, prefix))
+		}
+		if res.NextMarker == "" {
+			break
+		}
+		marker = res.NextMarker
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// fileURLPath returns the local path of a file URL's path, which on Windows
+// is like /C:/backups
+func fileURLPath(p string) string {
+	if runtime.GOOS == "windows" && len(p) > 2 && p[0] == '/' && p[2] == ':' {
+		p = p[1:]
+	}
+	return filepath.FromSlash(p)
+}
+
+// fileStore keeps the objects as files in a local directory
+type fileStore struct {
+	dir string
+}
+
+func (s *fileStore) Put(name string, data []byte) error {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	// written to a temporary file first, so that a partial file is never
+	// listed
+	tmp := filepath.Join(s.dir, "."+name+".tmp")
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(s.dir, name))
+}
+
+func (s *fileStore) Get(name string) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(s.dir, name))
+}
+
+func (s *fileStore) Delete(name string) error {
+	return os.Remove(filepath.Join(s.dir, name))
+}
+
+func (s *fileStore) List() ([]string, error) {
+	files, err := ioutil.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, file := range files {
+		if name := file.Name(); !file.IsDir() && !strings.HasPrefix(name, ".") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
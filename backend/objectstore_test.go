@@ -0,0 +1,122 @@
+package backend
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_FileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "etcdb-objects")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+
+	// like file:///tmp/... or file:///C:/...
+	objects, err := OpenObjectStore("file:///" + strings.TrimPrefix(filepath.ToSlash(filepath.Join(dir, "backups")), "/"))
+	ok(t, err)
+
+	names, err := objects.List()
+	ok(t, err)
+	equals(t, 0, len(names))
+
+	ok(t, objects.Put("b", []byte("2")))
+	ok(t, objects.Put("a", []byte("1")))
+	names, err = objects.List()
+	ok(t, err)
+	equals(t, []string{"a", "b"}, names)
+
+	data, err := objects.Get("b")
+	ok(t, err)
+	equals(t, "2", string(data))
+
+	ok(t, objects.Delete("a"))
+	names, err = objects.List()
+	ok(t, err)
+	equals(t, []string{"b"}, names)
+}
+
+// objectServer answers object store requests with the body, and records
+// them
+func objectServer(requests *[]string, body string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		*requests = append(*requests, r.Method+" "+r.URL.Path)
+		rw.Write([]byte(body))
+	}))
+}
+
+func Test_S3Store(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", exampleAWS.AccessKeyID)
+	t.Setenv("AWS_SECRET_ACCESS_KEY", exampleAWS.SecretAccessKey)
+
+	var requests []string
+	server := objectServer(&requests, `<ListBucketResult>
+		<Contents><Key>backups/etcdb-b.json</Key></Contents>
+		<Contents><Key>backups/etcdb-a.json</Key></Contents>
+		<IsTruncated>false</IsTruncated>
+	</ListBucketResult>`)
+	defer server.Close()
+
+	objects, err := OpenObjectStore("s3://bucket/backups?region=us-east-1&endpoint=" + server.URL)
+	ok(t, err)
+
+	ok(t, objects.Put("etcdb-a.json", []byte("{}")))
+	names, err := objects.List()
+	ok(t, err)
+	equals(t, []string{"etcdb-a.json", "etcdb-b.json"}, names)
+	equals(t, []string{"PUT /bucket/backups/etcdb-a.json", "GET /bucket/"}, requests)
+}
+
+func Test_AzureStore(t *testing.T) {
+	t.Setenv("AZURE_STORAGE_SAS_TOKEN", "?sv=2020-08-04&sig=signature")
+
+	var requests []string
+	server := objectServer(&requests, `<EnumerationResults>
+		<Blobs><Blob><Name>backups/etcdb-a.json</Name></Blob></Blobs>
+		<NextMarker />
+	</EnumerationResults>`)
+	defer server.Close()
+
+	objects, err := OpenObjectStore("azure://account/container/backups?endpoint=" + server.URL)
+	ok(t, err)
+
+	ok(t, objects.Delete("etcdb-a.json"))
+	names, err := objects.List()
+	ok(t, err)
+	equals(t, []string{"etcdb-a.json"}, names)
+	equals(t, []string{"DELETE /container/backups/etcdb-a.json", "GET /container"}, requests)
+}
+
+func Test_PruneSnapshots(t *testing.T) {
+	dir, err := ioutil.TempDir("", "etcdb-objects")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+	objects := &fileStore{dir: dir}
+
+	now := time.Date(2016, 9, 1, 12, 0, 0, 0, time.UTC)
+	for i := 4; i >= 0; i-- {
+		ok(t, objects.Put(snapshotName(&Snapshot{Index: int64(10 - i), Time: now.Add(-time.Duration(i) * time.Hour)}), nil))
+	}
+	ok(t, objects.Put("other.json", nil))
+
+	removed, err := PruneSnapshots(objects, BackupRetention{Keep: 4}, now)
+	ok(t, err)
+	equals(t, []string{"etcdb-20160901T080000Z-6.json"}, removed)
+
+	removed, err = PruneSnapshots(objects, BackupRetention{MaxAge: 90 * time.Minute}, now)
+	ok(t, err)
+	equals(t, []string{"etcdb-20160901T090000Z-7.json", "etcdb-20160901T100000Z-8.json"}, removed)
+
+	// the newest is kept even when too old
+	removed, err = PruneSnapshots(objects, BackupRetention{Keep: 1, MaxAge: time.Minute}, now.Add(time.Hour))
+	ok(t, err)
+	equals(t, []string{"etcdb-20160901T110000Z-9.json"}, removed)
+
+	names, err := objects.List()
+	ok(t, err)
+	equals(t, []string{"etcdb-20160901T120000Z-10.json", "other.json"}, names)
+}
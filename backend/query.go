@@ -8,7 +8,13 @@ import (
 type Query struct {
 	buf     bytes.Buffer
 	Params  []interface{}
-	dialect dbDialect
+	dialect Dialect
+}
+
+// NewQuery creates an empty query with the parameter placeholders of the
+// dialect
+func NewQuery(dialect Dialect) *Query {
+	return &Query{dialect: dialect}
 }
 
 func (q *Query) Text(text string) *Query {
@@ -18,7 +24,7 @@ func (q *Query) Text(text string) *Query {
 
 func (q *Query) Param(p interface{}) *Query {
 	q.Params = append(q.Params, p)
-	q.buf.WriteString(q.dialect.nameParam(q.Params))
+	q.buf.WriteString(q.dialect.NameParam(q.Params))
 	return q
 }
 
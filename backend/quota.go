@@ -0,0 +1,421 @@
+package backend
+
+import (
+	"expvar"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rancher/etcdb/models"
+)
+
+// QuotaStats publishes the usage measured for the quota, and counts the
+// writes that were warned or refused, with expvar.
+var QuotaStats = expvar.NewMap("quota")
+
+var quotaKeys, quotaBytes = new(expvar.Int), new(expvar.Int)
+
+func init() {
+	QuotaStats.Set("keys", quotaKeys)
+	QuotaStats.Set("bytes", quotaBytes)
+}
+
+// A Quota limits the number of keys, and the total size of their values in
+// bytes. Directories aren't counted. A zero limit is unlimited.
+type Quota struct {
+	MaxKeys  int64
+	MaxBytes int64
+	// SoftRatio is the fraction of a limit after which writes are warned
+	// that the limit is close.
+	SoftRatio float64
+	// Prefixes limit the keys under them, like the keys of a tenant, besides
+	// the limits of the whole store
+	Prefixes []PrefixQuota
+	// RefreshInterval is how often the usage is measured again, to count
+	// the keys that expired or were written by other instances. It is
+	// DefaultQuotaRefresh if zero.
+	RefreshInterval time.Duration
+}
+
+// DefaultQuotaRefresh is the RefreshInterval of quotas that don't set one
+const DefaultQuotaRefresh = 10 * time.Second
+
+// A PrefixQuota limits the number of keys under the prefix, and the total size
+// of their values. A zero limit is unlimited.
+type PrefixQuota struct {
+	Prefix   string
+	MaxKeys  int64
+	MaxBytes int64
+}
+
+func (q Quota) enabled() bool {
+	return q.MaxKeys > 0 || q.MaxBytes > 0
+}
+
+// limited is true if there is any quota to check, of the store or a prefix
+func (q Quota) limited() bool {
+	return q.enabled() || len(q.Prefixes) > 0
+}
+
+func (q Quota) refreshInterval() time.Duration {
+	if q.RefreshInterval > 0 {
+		return q.RefreshInterval
+	}
+	return DefaultQuotaRefresh
+}
+
+// underPrefix is true if the key is the prefix or under it
+func underPrefix(key, prefix string) bool {
+	return key == prefix || prefix == "/" || strings.HasPrefix(key, prefix+"/")
+}
+
+// anyUnderPrefix is true if any of the keys is the prefix or under it
+func anyUnderPrefix(keys []string, prefix string) bool {
+	for _, key := range keys {
+		if underPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// overLimit is true if the usage is over the limit
+func overLimit(used, limit int64) bool {
+	return used > limit
+}
+
+// overLimits describes the non-zero limits the usage is over, by the check
+func overLimits(u Usage, maxKeys, maxBytes int64, over func(used, limit int64) bool) []string {
+	var limits []string
+	if maxKeys > 0 && over(u.Keys, maxKeys) {
+		limits = append(limits, fmt.Sprintf("keys %d/%d", u.Keys, maxKeys))
+	}
+	if maxBytes > 0 && over(u.Bytes, maxBytes) {
+		limits = append(limits, fmt.Sprintf("bytes %d/%d", u.Bytes, maxBytes))
+	}
+	return limits
+}
+
+// Usage is the number of keys and total size of their values
+type Usage struct {
+	Keys  int64
+	Bytes int64
+}
+
+func (u Usage) add(d Usage) Usage {
+	return Usage{Keys: u.Keys + d.Keys, Bytes: u.Bytes + d.Bytes}
+}
+
+// nodeUsage is the usage of the node, which is none for directories and
+// missing nodes
+func nodeUsage(node *models.Node) Usage {
+	if node == nil || node.Dir {
+		return Usage{}
+	}
+	return Usage{Keys: 1, Bytes: int64(len(node.Value))}
+}
+
+// usageDelta is the change in usage of replacing the previous node, if any,
+// with the value or directory
+func usageDelta(prevNode *models.Node, value string, dir bool) Usage {
+	prev := nodeUsage(prevNode)
+	next := nodeUsage(&models.Node{Value: value, Dir: dir})
+	return Usage{Keys: next.Keys - prev.Keys, Bytes: next.Bytes - prev.Bytes}
+}
+
+// addDeltas adds the usage deltas of the keys to the usage of the store and
+// of the prefix quotas that the keys are under
+func addDeltas(q Quota, u Usage, prefixUsage map[string]Usage, deltas map[string]Usage) (Usage, map[string]Usage) {
+	added := make(map[string]Usage, len(q.Prefixes))
+	for _, pq := range q.Prefixes {
+		added[pq.Prefix] = prefixUsage[pq.Prefix]
+	}
+	for key, d := range deltas {
+		u = u.add(d)
+		for _, pq := range q.Prefixes {
+			if underPrefix(key, pq.Prefix) {
+				added[pq.Prefix] = added[pq.Prefix].add(d)
+			}
+		}
+	}
+	return u, added
+}
+
+// quotaState has the usage of the store and of the prefix quotas, as
+// measured by the last refresh and counted by the writes committed since.
+// Writes check the counts, instead of measuring the usage in their
+// transaction, which would scan the nodes while every other writer waits for
+// the index.
+type quotaState struct {
+	mu          sync.Mutex
+	quota       Quota
+	usage       Usage
+	prefixUsage map[string]Usage
+	// measured is when the usage was last measured, or zero if it hasn't
+	// been since the quota was set
+	measured time.Time
+	// stale is set by changes that aren't counted, like recursive deletes,
+	// to measure the usage again after them
+	stale      bool
+	refreshing bool
+}
+
+// A quotaTx is the change in usage of the keys written by one transaction,
+// which is only added to the counts once the transaction is committed.
+type quotaTx struct {
+	deltas map[string]Usage
+	// measured is set when the transaction measured the usage itself, as
+	// the first write after the quota is set, and usage and prefixUsage are
+	// what it measured
+	measured    bool
+	usage       Usage
+	prefixUsage map[string]Usage
+	// stale is set by changes that aren't counted
+	stale bool
+}
+
+// add adds the usage deltas of the keys
+func (qt *quotaTx) add(deltas map[string]Usage) {
+	if qt.deltas == nil {
+		qt.deltas = make(map[string]Usage, len(deltas))
+	}
+	for key, d := range deltas {
+		qt.deltas[key] = qt.deltas[key].add(d)
+	}
+}
+
+// SetQuota sets the quota enforced on writes
+func (b *SqlBackend) SetQuota(q Quota) {
+	prefixes := make([]PrefixQuota, len(q.Prefixes))
+	for i, pq := range q.Prefixes {
+		pq.Prefix = path.Clean("/" + pq.Prefix)
+		prefixes[i] = pq
+	}
+	q.Prefixes = prefixes
+
+	b.quota.mu.Lock()
+	defer b.quota.mu.Unlock()
+	b.quota.quota = q
+	b.quota.usage = Usage{}
+	b.quota.prefixUsage = nil
+	b.quota.measured = time.Time{}
+}
+
+func (b *SqlBackend) usage(db Querier) (u Usage, err error) {
+	err = db.QueryRow(`SELECT COUNT(*), COALESCE(SUM(OCTET_LENGTH("value")), 0) FROM "nodes"
+		WHERE "deleted" = 0 AND "dir" = false`).Scan(&u.Keys, &u.Bytes)
+	return
+}
+
+func (b *SqlBackend) prefixUsage(db Querier, prefix string) (u Usage, err error) {
+	if prefix == "/" {
+		return b.usage(db)
+	}
+	err = b.Query().Extend(
+		`SELECT COUNT(*), COALESCE(SUM(OCTET_LENGTH("value")), 0) FROM "nodes"`,
+		` WHERE "deleted" = 0 AND "dir" = false AND ("key" = `, prefix, ` OR "key" LIKE `, likePrefix(prefix), `)`,
+	).QueryRow(db).Scan(&u.Keys, &u.Bytes)
+	return
+}
+
+// measureUsage measures the usage of the store, if it has a quota, and of
+// each of the prefix quotas
+func (b *SqlBackend) measureUsage(db Querier, q Quota) (u Usage, prefixUsage map[string]Usage, err error) {
+	if q.enabled() {
+		u, err = b.usage(db)
+		if err != nil {
+			return
+		}
+	}
+	prefixUsage = make(map[string]Usage, len(q.Prefixes))
+	for _, pq := range q.Prefixes {
+		prefixUsage[pq.Prefix], err = b.prefixUsage(db, pq.Prefix)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// refreshQuotaUsage measures the usage again, outside of any write. The
+// writes committed during the measurement may be counted twice or not at
+// all until the next refresh.
+func (b *SqlBackend) refreshQuotaUsage() {
+	b.quota.mu.Lock()
+	q := b.quota.quota
+	b.quota.mu.Unlock()
+
+	u, prefixUsage, err := b.measureUsage(b.conn(), q)
+
+	b.quota.mu.Lock()
+	defer b.quota.mu.Unlock()
+	b.quota.refreshing = false
+	if b.quota.measured.IsZero() {
+		// the quota was set again since
+		return
+	}
+	b.quota.measured = time.Now()
+	if err != nil {
+		log.Println("error measuring the usage for the quota:", err)
+		return
+	}
+	b.quota.usage = u
+	b.quota.prefixUsage = prefixUsage
+	b.setQuotaStats()
+}
+
+// setQuotaStats publishes the usage of the store's quota, with the lock held
+func (b *SqlBackend) setQuotaStats() {
+	if b.quota.quota.enabled() {
+		quotaKeys.Set(b.quota.usage.Keys)
+		quotaBytes.Set(b.quota.usage.Bytes)
+	}
+}
+
+// checkQuota returns an error if the usage deltas of the keys written, added
+// to the counts and to the earlier writes of the transaction, would be over
+// the quota of the store or of a prefix that the keys are under. Otherwise
+// the deltas are added to the transaction's, for commitQuota. The first write
+// after the quota is set measures the usage in its transaction instead,
+// including its changes. Concurrent writes of other instances can each pass
+// the check, so the quota may be exceeded slightly.
+func (b *SqlBackend) checkQuota(tx Querier, prevIndex int64, qt *quotaTx, deltas map[string]Usage) error {
+	b.quota.mu.Lock()
+	defer b.quota.mu.Unlock()
+
+	q := b.quota.quota
+	if !q.limited() {
+		return nil
+	}
+
+	var u Usage
+	var prefixUsage map[string]Usage
+	switch {
+	case qt.measured:
+		u, prefixUsage = addDeltas(q, qt.usage, qt.prefixUsage, qt.deltas)
+		u, prefixUsage = addDeltas(q, u, prefixUsage, deltas)
+	case b.quota.measured.IsZero():
+		var err error
+		u, prefixUsage, err = b.measureUsage(tx, q)
+		if err != nil {
+			return err
+		}
+	default:
+		u, prefixUsage = addDeltas(q, b.quota.usage, b.quota.prefixUsage, qt.deltas)
+		u, prefixUsage = addDeltas(q, u, prefixUsage, deltas)
+	}
+
+	if q.enabled() {
+		if over := overLimits(u, q.MaxKeys, q.MaxBytes, overLimit); len(over) > 0 {
+			QuotaStats.Add("refused", 1)
+			return models.QuotaExceeded(strings.Join(over, ", "), prevIndex)
+		}
+	}
+	keys := make([]string, 0, len(deltas))
+	for key := range deltas {
+		keys = append(keys, key)
+	}
+	for _, pq := range q.Prefixes {
+		if !anyUnderPrefix(keys, pq.Prefix) {
+			continue
+		}
+		if over := overLimits(prefixUsage[pq.Prefix], pq.MaxKeys, pq.MaxBytes, overLimit); len(over) > 0 {
+			QuotaStats.Add("refused", 1)
+			return models.QuotaExceeded(pq.Prefix+" "+strings.Join(over, ", "), prevIndex)
+		}
+	}
+
+	if !qt.measured && b.quota.measured.IsZero() {
+		// the measurement already includes the earlier writes
+		qt.measured = true
+		qt.usage = u
+		qt.prefixUsage = prefixUsage
+		qt.deltas = nil
+		return nil
+	}
+	qt.add(deltas)
+	return nil
+}
+
+// quotaDeleted counts the deletion of the node in the transaction's usage.
+// Deleted directories leave the counts to be measured again once the delete
+// is committed.
+func (b *SqlBackend) quotaDeleted(qt *quotaTx, node *models.Node) {
+	if node.Dir {
+		qt.stale = true
+		return
+	}
+	d := nodeUsage(node)
+	qt.add(map[string]Usage{node.Key: {Keys: -d.Keys, Bytes: -d.Bytes}})
+}
+
+// commitQuota adds the usage deltas of the committed transaction to the
+// counts, and starts measuring the usage again in the background every
+// RefreshInterval, or after changes that weren't counted.
+func (b *SqlBackend) commitQuota(qt *quotaTx) {
+	b.quota.mu.Lock()
+	defer b.quota.mu.Unlock()
+
+	q := b.quota.quota
+	if !q.limited() {
+		return
+	}
+
+	switch {
+	case qt.measured:
+		b.quota.usage, b.quota.prefixUsage = addDeltas(q, qt.usage, qt.prefixUsage, qt.deltas)
+		b.quota.measured = time.Now()
+	case b.quota.measured.IsZero():
+		// the next write measures the usage, including this one
+		return
+	default:
+		b.quota.usage, b.quota.prefixUsage = addDeltas(q, b.quota.usage, b.quota.prefixUsage, qt.deltas)
+	}
+	b.quota.stale = b.quota.stale || qt.stale
+
+	if (b.quota.stale || time.Since(b.quota.measured) > q.refreshInterval()) && !b.quota.refreshing {
+		b.quota.stale = false
+		b.quota.refreshing = true
+		go b.refreshQuotaUsage()
+	}
+	b.setQuotaStats()
+}
+
+// QuotaWarning describes the limits that the usage has passed the soft ratio
+// of, those of the store and of the prefix quotas that the written keys are
+// under, or returns "" if there are none.
+func (b *SqlBackend) QuotaWarning(keys ...string) string {
+	b.quota.mu.Lock()
+	defer b.quota.mu.Unlock()
+
+	q := b.quota.quota
+	if q.SoftRatio <= 0 {
+		return ""
+	}
+
+	soft := func(used, limit int64) bool {
+		return float64(used) >= q.SoftRatio*float64(limit)
+	}
+	var warnings []string
+	if q.enabled() {
+		warnings = overLimits(b.quota.usage, q.MaxKeys, q.MaxBytes, soft)
+	}
+	for _, pq := range q.Prefixes {
+		pu, ok := b.quota.prefixUsage[pq.Prefix]
+		if !ok || !anyUnderPrefix(keys, pq.Prefix) {
+			continue
+		}
+		if over := overLimits(pu, pq.MaxKeys, pq.MaxBytes, soft); len(over) > 0 {
+			warnings = append(warnings, pq.Prefix+" "+strings.Join(over, ", "))
+		}
+	}
+	if len(warnings) == 0 {
+		return ""
+	}
+
+	QuotaStats.Add("warned", 1)
+	return strings.Join(warnings, ", ")
+}
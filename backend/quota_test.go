@@ -0,0 +1,152 @@
+package backend
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rancher/etcdb/models"
+)
+
+func Test_Quota_Keys(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+	store.SetQuota(Quota{MaxKeys: 2, SoftRatio: 0.5})
+
+	_, _, err := store.Set("/dir/a", "1", Always)
+	ok(t, err)
+	equals(t, "keys 1/2", store.QuotaWarning("/dir/a"))
+
+	_, err = store.CreateInOrder("/queue", "2", nil, Always)
+	ok(t, err)
+
+	_, _, err = store.Set("/dir/c", "3", Always)
+	expectError(t, "Quota exceeded", "keys 3/2", err)
+
+	// replacing a key doesn't add to the count
+	_, _, err = store.Set("/dir/a", "4", Always)
+	ok(t, err)
+}
+
+func Test_Quota_Bytes(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+	store.SetQuota(Quota{MaxBytes: 10, SoftRatio: 0.8})
+
+	_, _, err := store.Set("/a", "12345", Always)
+	ok(t, err)
+	equals(t, "", store.QuotaWarning("/a"))
+
+	_, _, err = store.Set("/b", "678", Always)
+	ok(t, err)
+	equals(t, "bytes 8/10", store.QuotaWarning("/b"))
+
+	_, _, err = store.Set("/b", "67890X", Always)
+	expectError(t, "Quota exceeded", "bytes 11/10", err)
+}
+
+func Test_Quota_Disabled(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	_, _, err := store.Set("/a", "value", Always)
+	ok(t, err)
+	equals(t, "", store.QuotaWarning("/a"))
+}
+
+func Test_Quota_Prefixes(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+	store.SetQuota(Quota{SoftRatio: 0.5, Prefixes: []PrefixQuota{
+		{Prefix: "/tenants/a", MaxKeys: 2},
+		{Prefix: "/tenants/b/", MaxBytes: 4},
+	}})
+
+	_, _, err := store.Set("/tenants/a/1", "value", Always)
+	ok(t, err)
+	equals(t, "/tenants/a keys 1/2", store.QuotaWarning("/tenants/a/1"))
+	_, err = store.CreateInOrder("/tenants/a/queue", "value", nil, Always)
+	ok(t, err)
+	_, _, err = store.Set("/tenants/a/3", "value", Always)
+	expectError(t, "Quota exceeded", "/tenants/a keys 3/2", err)
+
+	// other prefixes aren't limited by it
+	_, _, err = store.Set("/tenants/ab", "value", Always)
+	ok(t, err)
+	equals(t, "", store.QuotaWarning("/tenants/ab"))
+
+	// nor warned about it
+	equals(t, "/tenants/a keys 2/2", store.QuotaWarning("/tenants/a/1"))
+
+	_, _, err = store.Set("/tenants/b/x", "12345", Always)
+	expectError(t, "Quota exceeded", "/tenants/b bytes 5/4", err)
+}
+
+func Test_Quota_Deletes(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+	store.SetQuota(Quota{MaxKeys: 2, MaxBytes: 10})
+
+	_, _, err := store.Set("/a", "12345", Always)
+	ok(t, err)
+	_, _, err = store.Set("/b", "12345", Always)
+	ok(t, err)
+	_, _, err = store.Set("/c", "1", Always)
+	expectError(t, "Quota exceeded", "keys 3/2, bytes 11/10", err)
+
+	// deleting a key makes room right away
+	_, _, err = store.Delete("/a", Always)
+	ok(t, err)
+	_, _, err = store.Set("/c", "1", Always)
+	ok(t, err)
+}
+
+func Test_Quota_Refresh(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+	store.SetQuota(Quota{MaxKeys: 2, Prefixes: []PrefixQuota{{Prefix: "/dir", MaxKeys: 1}}})
+
+	_, _, err := store.Set("/dir/a", "1", Always)
+	ok(t, err)
+
+	// keys removed without counting them, like expired keys, are counted by
+	// measuring the usage again
+	_, err = store.db.Exec(`UPDATE "nodes" SET "deleted" = 1 WHERE "key" = '/dir/a'`)
+	ok(t, err)
+	_, _, err = store.Set("/dir/b", "1", Always)
+	expectError(t, "Quota exceeded", "/dir keys 2/1", err)
+
+	store.refreshQuotaUsage()
+	_, _, err = store.Set("/dir/b", "1", Always)
+	ok(t, err)
+	equals(t, Usage{Keys: 1, Bytes: 1}, store.quota.usage)
+}
+
+func Test_Quota_CountsCommittedWrites(t *testing.T) {
+	store := &SqlBackend{}
+	store.SetQuota(Quota{MaxKeys: 3, Prefixes: []PrefixQuota{{Prefix: "/dir", MaxKeys: 1}}})
+	store.quota.usage = Usage{Keys: 1, Bytes: 1}
+	store.quota.prefixUsage = map[string]Usage{"/dir": {}}
+	store.quota.measured = time.Now()
+	one := Usage{Keys: 1, Bytes: 1}
+
+	// a write that is rolled back isn't counted
+	ok(t, store.checkQuota(nil, 0, &quotaTx{}, map[string]Usage{"/dir/a": one}))
+	equals(t, Usage{Keys: 1, Bytes: 1}, store.quota.usage)
+
+	qt := &quotaTx{}
+	ok(t, store.checkQuota(nil, 0, qt, map[string]Usage{"/dir/a": one}))
+	store.commitQuota(qt)
+	equals(t, Usage{Keys: 2, Bytes: 2}, store.quota.usage)
+	equals(t, map[string]Usage{"/dir": one}, store.quota.prefixUsage)
+
+	// the writes of a transaction add up
+	qt = &quotaTx{}
+	store.quotaDeleted(qt, &models.Node{Key: "/dir/a", Value: "1"})
+	ok(t, store.checkQuota(nil, 0, qt, map[string]Usage{"/b": one, "/c": one}))
+	err := store.checkQuota(nil, 0, qt, map[string]Usage{"/d": one})
+	expectError(t, "Quota exceeded", "keys 4/3", err)
+
+	// the keys under a prefix are counted for its quota
+	err = store.checkQuota(nil, 0, &quotaTx{}, map[string]Usage{"/dir/b": one})
+	expectError(t, "Quota exceeded", "/dir keys 2/1", err)
+}
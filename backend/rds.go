@@ -0,0 +1,159 @@
+package backend
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rdsTokenLifetime is how long RDS accepts an IAM auth token for new
+// connections. Connections opened with it stay open after it expires.
+const rdsTokenLifetime = 15 * time.Minute
+
+// RDSIAMCredentials generates IAM authentication tokens for RDS and Aurora
+// databases to use as the password. The AWS credentials are read from the
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment
+// variables.
+type RDSIAMCredentials struct {
+	Host   string
+	Port   int
+	User   string
+	Region string
+}
+
+// AWSCredentials are the keys used to sign requests to AWS
+type AWSCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// AWSCredentialsFromEnv reads the AWS credentials from the environment
+func AWSCredentialsFromEnv() (*AWSCredentials, error) {
+	creds := &AWSCredentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return nil, errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are required")
+	}
+	return creds, nil
+}
+
+// Fetch generates a new token. The AWS credentials are read again each time,
+// since temporary ones are rotated.
+func (r *RDSIAMCredentials) Fetch() (*Credentials, error) {
+	aws, err := AWSCredentialsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &Credentials{
+		User:     r.User,
+		Password: r.token(aws, now),
+		Expires:  now.Add(rdsTokenLifetime),
+	}, nil
+}
+
+// Renew can't extend tokens, so it returns a new one
+func (r *RDSIAMCredentials) Renew(*Credentials) (*Credentials, error) {
+	return r.Fetch()
+}
+
+// token is a presigned connect request for the user, without the scheme
+func (r *RDSIAMCredentials) token(aws *AWSCredentials, now time.Time) string {
+	host := r.Host + ":" + strconv.Itoa(r.Port)
+	query := url.Values{"Action": {"connect"}, "DBUser": {r.User}}
+	return strings.TrimPrefix(presignV4(aws, "GET", "rds-db", r.Region, host, "/", query, rdsTokenLifetime, emptyPayloadHash, now), "https://")
+}
+
+var emptyPayloadHash = sha256Hex("")
+
+// presignV4 signs a request for the service with AWS Signature Version 4 in
+// the query string, and returns its URL.
+func presignV4(aws *AWSCredentials, method, service, region, host, path string, query url.Values, expires time.Duration, payloadHash string, now time.Time) string {
+	now = now.UTC()
+	date := now.Format("20060102")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+
+	params := url.Values{}
+	for name, values := range query {
+		params[name] = values
+	}
+	params.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	params.Set("X-Amz-Credential", aws.AccessKeyID+"/"+scope)
+	params.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	params.Set("X-Amz-Expires", strconv.Itoa(int(expires.Seconds())))
+	params.Set("X-Amz-SignedHeaders", "host")
+	if aws.SessionToken != "" {
+		params.Set("X-Amz-Security-Token", aws.SessionToken)
+	}
+	canonicalQuery := canonicalQueryString(params)
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		path,
+		canonicalQuery,
+		"host:" + host + "\n",
+		"host",
+		payloadHash,
+	}, "\n")
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		now.Format("20060102T150405Z"),
+		scope,
+		sha256Hex(canonicalRequest),
+	}, "\n")
+
+	key := []byte("AWS4" + aws.SecretAccessKey)
+	for _, part := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	return fmt.Sprintf("https://%s%s?%s&X-Amz-Signature=%s", host, path, canonicalQuery, signature)
+}
+
+// canonicalQueryString sorts the parameters and escapes them as required by
+// AWS, with %20 for spaces and without escaping ~
+func canonicalQueryString(params url.Values) string {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var pairs []string
+	for _, name := range names {
+		for _, value := range params[name] {
+			pairs = append(pairs, awsEscape(name)+"="+awsEscape(value))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+func awsEscape(s string) string {
+	s = url.QueryEscape(s)
+	s = strings.Replace(s, "+", "%20", -1)
+	return strings.Replace(s, "%7E", "~", -1)
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,260 @@
+package backend
+
+import (
+	"container/list"
+	"database/sql"
+	"expvar"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rancher/etcdb/models"
+)
+
+// ReadCacheStats counts the reads served by the ReadCache, published with
+// expvar: "hits" and "misses", "invalidations" of entries by changes,
+// "evictions" of the least recently used ones when the cache is full, and
+// "clears" when the watcher may have missed changes.
+var ReadCacheStats = expvar.NewMap("readCache")
+
+// A ReadCache keeps the files read by single-key GETs in memory, so that the
+// reads of hot keys don't query the database. Entries are invalidated by the
+// changes the ChangeWatcher fetches, and right away by the writes of this
+// instance, so that a client reads its own writes. Entries are also dropped
+// after the TTL, in case a change was missed.
+//
+// With SetDirs, the listings of non-recursive GETs of directories are cached
+// too, and invalidated by any change under the directory. Files with a TTL
+// whose remaining time changes, and listings including one, aren't cached.
+type ReadCache struct {
+	size int
+	ttl  time.Duration
+	dirs bool
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	// applied is the index up to which the watcher's changes invalidated
+	// the entries
+	applied int64
+	// written are the keys written by this instance at an index that the
+	// changes weren't applied up to yet. They aren't cached from a read
+	// older than the write.
+	written map[string]int64
+	now     func() time.Time
+}
+
+type readCacheEntry struct {
+	key     string
+	node    *models.Node
+	index   int64
+	expires time.Time
+}
+
+// NewReadCache creates a ReadCache of up to size files, each cached for up
+// to ttl
+func NewReadCache(size int, ttl time.Duration) *ReadCache {
+	return &ReadCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+		written: make(map[string]int64),
+		now:     time.Now,
+	}
+}
+
+// SetDirs sets whether the listings of directories are cached
+func (c *ReadCache) SetDirs(dirs bool) {
+	c.dirs = dirs
+}
+
+// SetReadCache sets the cache of the reads of GetReplica, which the
+// ChangeWatcher of the backend keeps up to date. It must be set before the
+// backend is used.
+func (b *SqlBackend) SetReadCache(c *ReadCache) {
+	b.readCache = c
+	b.AddHook(c)
+}
+
+// get returns a copy of the cached node for the key, and the index it is
+// current as of
+func (c *ReadCache) get(key string) (*models.Node, int64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		ReadCacheStats.Add("misses", 1)
+		return nil, 0, false
+	}
+	entry := e.Value.(*readCacheEntry)
+	if c.now().After(entry.expires) {
+		c.remove(key)
+		ReadCacheStats.Add("misses", 1)
+		return nil, 0, false
+	}
+	c.lru.MoveToFront(e)
+	ReadCacheStats.Add("hits", 1)
+	node := copyNode(entry.node)
+	if entry.index > c.applied {
+		return node, entry.index, true
+	}
+	return node, c.applied, true
+}
+
+// add caches the node read as of the index, unless a change after the index
+// may already have been applied to it
+func (c *ReadCache) add(node *models.Node, index int64) {
+	if node.Dir && !c.dirs || hasTTL(node) {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if index < c.applied {
+		return
+	}
+	// including a recursive delete of a directory above it
+	for key := node.Key; key != "" && key != "/"; key = splitKey(key) {
+		if index < c.written[key] {
+			return
+		}
+	}
+	if node.Dir {
+		prefix := strings.TrimSuffix(node.Key, "/") + "/"
+		for key, written := range c.written {
+			if index < written && strings.HasPrefix(key, prefix) {
+				return
+			}
+		}
+	}
+
+	entry := &readCacheEntry{key: node.Key, node: copyNode(node), index: index, expires: c.now().Add(c.ttl)}
+	if e, ok := c.entries[node.Key]; ok {
+		e.Value = entry
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.entries[node.Key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back().Value.(*readCacheEntry).key)
+		ReadCacheStats.Add("evictions", 1)
+	}
+}
+
+// remove removes the entry of the key, with the lock held
+func (c *ReadCache) remove(key string) bool {
+	e, ok := c.entries[key]
+	if !ok {
+		return false
+	}
+	c.lru.Remove(e)
+	delete(c.entries, key)
+	return true
+}
+
+// invalidate removes the entry of the key, the listings of the directories
+// above it, and the entries of the keys under it if the change removes them
+// too, with the lock held
+func (c *ReadCache) invalidate(key string, below bool) {
+	if c.remove(key) {
+		ReadCacheStats.Add("invalidations", 1)
+	}
+	if c.dirs {
+		for dir := splitKey(key); dir != ""; dir = splitKey(dir) {
+			if c.remove(dir) {
+				ReadCacheStats.Add("invalidations", 1)
+			}
+			if dir == "/" {
+				break
+			}
+		}
+	}
+	if !below {
+		return
+	}
+	prefix := strings.TrimSuffix(key, "/") + "/"
+	for k := range c.entries {
+		if strings.HasPrefix(k, prefix) {
+			c.remove(k)
+			ReadCacheStats.Add("invalidations", 1)
+		}
+	}
+}
+
+// applyChanges invalidates the entries of the changes from position i of the
+// watcher's buffer, which are applied up to the index. If the watcher may
+// have missed changes, all of the entries are removed.
+func (c *ReadCache) applyChanges(changes *changeList, i int, missed bool, index int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if missed {
+		c.entries = make(map[string]*list.Element)
+		c.lru.Init()
+		ReadCacheStats.Add("clears", 1)
+	} else {
+		for ; i < changes.Size; i++ {
+			ch := changes.Item(i)
+			c.invalidate(ch.Key, ch.isDelete())
+		}
+	}
+
+	c.applied = index
+	for key, written := range c.written {
+		if written <= index {
+			delete(c.written, key)
+		}
+	}
+}
+
+// write invalidates the entries of a key written by this instance
+func (c *ReadCache) write(change *Change) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.invalidate(change.Key, change.PrevNode != nil && change.PrevNode.Dir)
+	if change.Index > c.written[change.Key] {
+		c.written[change.Key] = change.Index
+	}
+}
+
+// AfterSet invalidates the entry of the key set by this instance
+func (c *ReadCache) AfterSet(tx *sql.Tx, change *Change) error {
+	c.write(change)
+	return nil
+}
+
+// AfterDelete invalidates the entries of the keys deleted by this instance
+func (c *ReadCache) AfterDelete(tx *sql.Tx, change *Change) error {
+	c.write(change)
+	return nil
+}
+
+// hasTTL returns whether the node, or one of the nodes listed in it, expires
+func hasTTL(node *models.Node) bool {
+	if node.TTL != nil || node.Expiration != nil {
+		return true
+	}
+	for _, child := range node.Nodes {
+		if hasTTL(child) {
+			return true
+		}
+	}
+	return false
+}
+
+// copyNode copies the node and the nodes listed in it, which SortNodes
+// reorders
+func copyNode(node *models.Node) *models.Node {
+	copied := *node
+	if node.Nodes != nil {
+		copied.Nodes = make([]*models.Node, len(node.Nodes))
+		for i, child := range node.Nodes {
+			copied.Nodes[i] = copyNode(child)
+		}
+	}
+	return &copied
+}
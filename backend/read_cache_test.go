@@ -0,0 +1,250 @@
+package backend
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rancher/etcdb/models"
+)
+
+func cachedFile(key, value string, index int64) *models.Node {
+	return &models.Node{Key: key, Value: value, ModifiedIndex: index, CreatedIndex: index}
+}
+
+func addChanges(changes *changeList, keyActions ...string) {
+	for i := 0; i < len(keyActions); i += 2 {
+		c := changes.Next()
+		c.Index = int64(100 + i)
+		c.Key = keyActions[i]
+		c.Action = keyActions[i+1]
+	}
+}
+
+func Test_ReadCache_HitMiss(t *testing.T) {
+	c := NewReadCache(10, time.Minute)
+
+	_, _, hit := c.get("/foo")
+	equals(t, false, hit)
+
+	c.add(cachedFile("/foo", "bar", 3), 5)
+	node, index, hit := c.get("/foo")
+	equals(t, true, hit)
+	equals(t, "bar", node.Value)
+	equals(t, int64(5), index)
+
+	// a copy is returned
+	node.Value = "changed"
+	node, _, _ = c.get("/foo")
+	equals(t, "bar", node.Value)
+}
+
+func Test_ReadCache_SkipsDirsAndTTLs(t *testing.T) {
+	c := NewReadCache(10, time.Minute)
+
+	c.add(&models.Node{Key: "/dir", Dir: true}, 5)
+	ttl := int64(10)
+	c.add(&models.Node{Key: "/ttl", Value: "v", TTL: &ttl}, 5)
+
+	_, _, hit := c.get("/dir")
+	equals(t, false, hit)
+	_, _, hit = c.get("/ttl")
+	equals(t, false, hit)
+}
+
+func Test_ReadCache_ApplyChanges(t *testing.T) {
+	c := NewReadCache(10, time.Minute)
+	c.add(cachedFile("/foo", "bar", 1), 5)
+	c.add(cachedFile("/dir/a", "a", 1), 5)
+	c.add(cachedFile("/dir/b/c", "c", 1), 5)
+	c.add(cachedFile("/dirx", "x", 1), 5)
+	c.add(cachedFile("/other", "o", 1), 5)
+
+	changes := newChangeList(10)
+	addChanges(changes, "/foo", "set", "/dir", "delete")
+	c.applyChanges(changes, 0, false, 102)
+
+	for _, key := range []string{"/foo", "/dir/a", "/dir/b/c"} {
+		_, _, hit := c.get(key)
+		equals(t, false, hit)
+	}
+	for _, key := range []string{"/dirx", "/other"} {
+		_, index, hit := c.get(key)
+		equals(t, true, hit)
+		equals(t, int64(102), index)
+	}
+
+	// a read from before the applied changes isn't cached
+	c.add(cachedFile("/foo", "old", 1), 101)
+	_, _, hit := c.get("/foo")
+	equals(t, false, hit)
+	c.add(cachedFile("/foo", "new", 100), 102)
+	node, _, hit := c.get("/foo")
+	equals(t, true, hit)
+	equals(t, "new", node.Value)
+}
+
+func Test_ReadCache_ApplyChangesFromPosition(t *testing.T) {
+	c := NewReadCache(10, time.Minute)
+	c.add(cachedFile("/foo", "bar", 1), 5)
+
+	changes := newChangeList(10)
+	addChanges(changes, "/foo", "set", "/other", "set")
+	// the change of /foo was applied by an earlier refresh
+	c.applyChanges(changes, 1, false, 102)
+
+	_, _, hit := c.get("/foo")
+	equals(t, true, hit)
+}
+
+func Test_ReadCache_MissedChangesClear(t *testing.T) {
+	c := NewReadCache(10, time.Minute)
+	c.add(cachedFile("/foo", "bar", 1), 5)
+
+	c.applyChanges(newChangeList(10), 0, true, 200)
+
+	_, _, hit := c.get("/foo")
+	equals(t, false, hit)
+}
+
+func Test_ReadCache_Write(t *testing.T) {
+	c := NewReadCache(10, time.Minute)
+	c.add(cachedFile("/foo", "bar", 1), 5)
+	c.add(cachedFile("/dir/a", "a", 1), 5)
+
+	ok(t, c.AfterSet(nil, &Change{Action: "set", Index: 7, Key: "/foo"}))
+	_, _, hit := c.get("/foo")
+	equals(t, false, hit)
+
+	// a read that started before the write, but ended after it
+	c.add(cachedFile("/foo", "bar", 1), 6)
+	_, _, hit = c.get("/foo")
+	equals(t, false, hit)
+
+	ok(t, c.AfterDelete(nil, &Change{Action: "delete", Index: 8, Key: "/dir", Dir: true, Recursive: true, PrevNode: &models.Node{Key: "/dir", Dir: true}}))
+	_, _, hit = c.get("/dir/a")
+	equals(t, false, hit)
+	c.add(cachedFile("/dir/a", "a", 1), 7)
+	_, _, hit = c.get("/dir/a")
+	equals(t, false, hit)
+
+	// once the watcher applied the writes, they're forgotten
+	c.applyChanges(newChangeList(10), 0, false, 8)
+	equals(t, 0, len(c.written))
+	c.add(cachedFile("/foo", "baz", 7), 8)
+	_, _, hit = c.get("/foo")
+	equals(t, true, hit)
+}
+
+func Test_ReadCache_Evicts(t *testing.T) {
+	c := NewReadCache(2, time.Minute)
+	c.add(cachedFile("/a", "a", 1), 1)
+	c.add(cachedFile("/b", "b", 1), 1)
+	// /a is now the most recently used
+	c.get("/a")
+	c.add(cachedFile("/c", "c", 1), 1)
+
+	_, _, hit := c.get("/b")
+	equals(t, false, hit)
+	_, _, hit = c.get("/a")
+	equals(t, true, hit)
+	_, _, hit = c.get("/c")
+	equals(t, true, hit)
+	equals(t, 2, c.lru.Len())
+}
+
+func Test_ReadCache_TTL(t *testing.T) {
+	now := time.Unix(1000, 0)
+	c := NewReadCache(10, time.Minute)
+	c.now = func() time.Time { return now }
+
+	c.add(cachedFile("/foo", "bar", 1), 1)
+	now = now.Add(time.Minute)
+	_, _, hit := c.get("/foo")
+	equals(t, true, hit)
+
+	now = now.Add(time.Second)
+	_, _, hit = c.get("/foo")
+	equals(t, false, hit)
+	equals(t, 0, c.lru.Len())
+}
+
+func Test_GetReplica_ReadCache(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+	store.SetReadCache(NewReadCache(10, time.Minute))
+
+	_, _, err := store.Set("/foo", "bar", Always)
+	ok(t, err)
+
+	node, _, err := store.GetReplica("/foo", false)
+	ok(t, err)
+	equals(t, "bar", node.Value)
+	_, _, hit := store.readCache.get("/foo")
+	equals(t, true, hit)
+
+	// the write of this instance is read right away
+	_, _, err = store.Set("/foo", "baz", Always)
+	ok(t, err)
+	node, _, err = store.GetReplica("/foo", false)
+	ok(t, err)
+	equals(t, "baz", node.Value)
+}
+
+func listing(key string, index int64, children ...*models.Node) *models.Node {
+	return &models.Node{Key: key, Dir: true, ModifiedIndex: index, CreatedIndex: index, Nodes: children}
+}
+
+func Test_ReadCache_Dirs(t *testing.T) {
+	c := NewReadCache(10, time.Minute)
+	c.add(listing("/dir", 1, cachedFile("/dir/a", "a", 1)), 5)
+	_, _, hit := c.get("/dir")
+	equals(t, false, hit)
+
+	c.SetDirs(true)
+	c.add(listing("/dir", 1, cachedFile("/dir/b", "b", 1), cachedFile("/dir/a", "a", 1)), 5)
+	c.add(listing("/", 1, listing("/dir", 1)), 5)
+	c.add(listing("/other", 1), 5)
+	node, _, hit := c.get("/dir")
+	equals(t, true, hit)
+	equals(t, 2, len(node.Nodes))
+
+	// sorting the copy doesn't reorder the cached listing
+	models.SortNodes(node)
+	node, _, _ = c.get("/dir")
+	equals(t, "/dir/b", node.Nodes[0].Key)
+
+	// a change in a subdirectory invalidates the listings above it
+	changes := newChangeList(10)
+	addChanges(changes, "/dir/sub/c", "set")
+	c.applyChanges(changes, 0, false, 100)
+	_, _, hit = c.get("/dir")
+	equals(t, false, hit)
+	_, _, hit = c.get("/")
+	equals(t, false, hit)
+	_, _, hit = c.get("/other")
+	equals(t, true, hit)
+
+	// listings with a file that expires aren't cached
+	ttl := int64(10)
+	c.add(listing("/dir", 1, &models.Node{Key: "/dir/a", Value: "a", TTL: &ttl}), 100)
+	_, _, hit = c.get("/dir")
+	equals(t, false, hit)
+}
+
+func Test_ReadCache_DirWrite(t *testing.T) {
+	c := NewReadCache(10, time.Minute)
+	c.SetDirs(true)
+	c.add(listing("/dir", 1), 5)
+
+	ok(t, c.AfterSet(nil, &Change{Action: "set", Index: 7, Key: "/dir/sub/a"}))
+	_, _, hit := c.get("/dir")
+	equals(t, false, hit)
+
+	// a listing read before the write under it isn't cached
+	c.add(listing("/dir", 1), 6)
+	_, _, hit = c.get("/dir")
+	equals(t, false, hit)
+	c.add(listing("/dir", 1, listing("/dir/sub", 7)), 7)
+	_, _, hit = c.get("/dir")
+	equals(t, true, hit)
+}
@@ -0,0 +1,127 @@
+package backend
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Recover checks for partially applied changes, which some MySQL
+// configurations can leave behind after a crash in the middle of a
+// transaction, and repairs them. It returns a description of each repair.
+//
+// Two problems are repaired:
+//
+// - An index lower than the indexes already used by nodes or changes, which
+// would make new changes reuse them. The index is moved past them.
+//
+// - Changes without the node versions they refer to, which watchers can't
+// return. The changes are removed.
+func (b *SqlBackend) Recover() (repairs []string, err error) {
+	tx, err := b.conn().Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}()
+
+	// lock the index so no other instance makes changes during the checks
+	_, err = tx.Exec(`UPDATE "index" SET "index" = "index"`)
+	if err != nil {
+		return nil, err
+	}
+
+	repair, err := b.recoverIndex(tx)
+	if err != nil {
+		return nil, err
+	}
+	if repair != "" {
+		repairs = append(repairs, repair)
+	}
+
+	orphans, err := b.recoverChanges(tx)
+	if err != nil {
+		return nil, err
+	}
+	repairs = append(repairs, orphans...)
+
+	return repairs, nil
+}
+
+func (b *SqlBackend) recoverIndex(tx *sql.Tx) (string, error) {
+	index, err := b.currIndex(tx)
+	if err != nil {
+		return "", err
+	}
+
+	maxIndex := index
+	for _, query := range []string{
+		`SELECT COALESCE(MAX("modified"), 0) FROM "nodes"`,
+		`SELECT COALESCE(MAX("deleted"), 0) FROM "nodes"`,
+		`SELECT COALESCE(MAX("index"), 0) FROM "changes"`,
+	} {
+		var used int64
+		if err := tx.QueryRow(query).Scan(&used); err != nil {
+			return "", err
+		}
+		if used > maxIndex {
+			maxIndex = used
+		}
+	}
+
+	if maxIndex == index {
+		return "", nil
+	}
+
+	_, err = b.Query().Extend(`UPDATE "index" SET "index" = `, maxIndex).Exec(tx)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("index %d was behind the highest used index, moved to %d", index, maxIndex), nil
+}
+
+func (b *SqlBackend) recoverChanges(tx *sql.Tx) ([]string, error) {
+	// delete actions refer to the deleted node version, the others to the new
+	// version and to any replaced version. Older versions recorded plain
+	// deletes with the set action, which refer to a version deleted at their
+	// index instead.
+	rows, err := tx.Query(`SELECT c."index", c."key" FROM "changes" c
+		WHERE (NOT EXISTS (SELECT 1 FROM "nodes" n WHERE n."key" = c."key" AND n."modified" =
+			CASE WHEN c."action" IN ('delete', 'compareAndDelete', 'expire')
+			THEN c."prev_node_modified" ELSE c."index" END)
+		AND NOT EXISTS (SELECT 1 FROM "nodes" n WHERE n."key" = c."key" AND c."action" = 'set'
+			AND n."modified" = c."prev_node_modified" AND n."deleted" = c."index"))
+		OR (c."prev_node_modified" IS NOT NULL AND NOT EXISTS (
+			SELECT 1 FROM "nodes" n WHERE n."key" = c."key" AND n."modified" = c."prev_node_modified"))
+		ORDER BY c."index"`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orphans []change
+	for rows.Next() {
+		var c change
+		if err := rows.Scan(&c.Index, &c.Key); err != nil {
+			return nil, err
+		}
+		orphans = append(orphans, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var repairs []string
+	for _, c := range orphans {
+		_, err := b.Query().Extend(`DELETE FROM "changes" WHERE "index" = `, c.Index, ` AND "key" = `, c.Key).Exec(tx)
+		if err != nil {
+			return nil, err
+		}
+		repairs = append(repairs, fmt.Sprintf("removed change %d for %s without a matching node", c.Index, c.Key))
+	}
+	return repairs, nil
+}
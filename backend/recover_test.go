@@ -0,0 +1,82 @@
+package backend
+
+import (
+	"fmt"
+	"testing"
+)
+
+func Test_Recover_Consistent(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	_, _, err := store.Set("/foo", "bar", Always)
+	ok(t, err)
+	_, _, err = store.Delete("/foo", Always)
+	ok(t, err)
+
+	repairs, err := store.Recover()
+	ok(t, err)
+	equals(t, 0, len(repairs))
+}
+
+func Test_Recover_LegacyDeleteAction(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	_, _, err := store.Set("/foo", "bar", Always)
+	ok(t, err)
+	_, index, err := store.Delete("/foo", Always)
+	ok(t, err)
+
+	// recorded like a plain delete before it had the delete action
+	_, err = store.Query().Extend(`UPDATE "changes" SET "action" = 'set' WHERE "index" = `, index).Exec(store.db)
+	ok(t, err)
+
+	repairs, err := store.Recover()
+	ok(t, err)
+	equals(t, 0, len(repairs))
+}
+
+func Test_Recover_IndexBehind(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	node, _, err := store.Set("/foo", "bar", Always)
+	ok(t, err)
+
+	_, err = store.conn().Exec(`UPDATE "index" SET "index" = 0`)
+	ok(t, err)
+
+	repairs, err := store.Recover()
+	ok(t, err)
+	equals(t, 1, len(repairs))
+	equals(t, node.ModifiedIndex, currIndex(store))
+
+	// new changes continue after the repaired index
+	node2, _, err := store.Set("/foo", "baz", Always)
+	ok(t, err)
+	equals(t, node.ModifiedIndex+1, node2.ModifiedIndex)
+}
+
+func Test_Recover_ChangeWithoutNode(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	node, _, err := store.Set("/foo", "bar", Always)
+	ok(t, err)
+	_, _, err = store.Set("/other", "value", Always)
+	ok(t, err)
+
+	_, err = store.Query().Extend(`DELETE FROM "nodes" WHERE "key" = `, "/foo").Exec(store.conn())
+	ok(t, err)
+
+	repairs, err := store.Recover()
+	ok(t, err)
+	equals(t, []string{fmt.Sprintf("removed change %d for /foo without a matching node", node.ModifiedIndex)}, repairs)
+
+	var count int
+	err = store.Query().Extend(`SELECT COUNT(*) FROM "changes" WHERE "index" = `, node.ModifiedIndex).
+		QueryRow(store.conn()).Scan(&count)
+	ok(t, err)
+	equals(t, 0, count)
+}
@@ -0,0 +1,132 @@
+package backend
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/rancher/etcdb/models"
+)
+
+// SetRecycleGrace enables the recycle bin for recursive deletes. The nodes
+// removed by a recursive RmDir are kept for the grace period, and can be put
+// back with Restore until then. A zero grace period disables the recycle bin.
+func (b *SqlBackend) SetRecycleGrace(grace time.Duration) {
+	b.recycleGrace = grace
+}
+
+// recycle records the recursive delete of the key at the index in the recycle
+// bin, keeping its deleted nodes until the grace period ends.
+func (b *SqlBackend) recycle(tx *sql.Tx, index int64, key string) error {
+	query := b.Query().Extend(`INSERT INTO "recycle" ("index", "key", "expiration") VALUES (`, index, `, `, key, `, `)
+	b.dialect.Expiration(query, int64(b.recycleGrace/time.Second))
+	_, err := query.Text(`)`).Exec(tx)
+	return err
+}
+
+// Recycled lists the recursive deletes in the recycle bin that can still be
+// restored, oldest first.
+func (b *SqlBackend) Recycled() ([]*models.RecycledDelete, error) {
+	rows, err := b.Query().Text(`SELECT "index", "key", "expiration" FROM "recycle"
+		WHERE "expiration" >= ` + b.dialect.Now() + ` ORDER BY "index"`).Query(b.conn())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deletes := []*models.RecycledDelete{}
+	for rows.Next() {
+		var d models.RecycledDelete
+		var expiration mysql.NullTime
+		if err := rows.Scan(&d.Index, &d.Key, &expiration); err != nil {
+			return nil, err
+		}
+		d.Expiration = expiration.Time
+		deletes = append(deletes, &d)
+	}
+	return deletes, rows.Err()
+}
+
+// Restore puts back the nodes removed by the recursive delete at the index,
+// and returns the restored node. The nodes keep their created index and TTL,
+// and are modified at a new index. The key must not have been set again since
+// the delete.
+func (b *SqlBackend) Restore(deleted int64) (node *models.Node, err error) {
+	tx, err := b.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}()
+
+	prevIndex, err := b.currIndex(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	var key string
+	err = b.Query().Extend(`SELECT "key" FROM "recycle" WHERE "index" = `, deleted,
+		` AND "expiration" >= `+b.dialect.Now()).QueryRow(tx).Scan(&key)
+	if err == sql.ErrNoRows {
+		return nil, models.NotFound(fmt.Sprint(deleted), prevIndex)
+	} else if err != nil {
+		return nil, err
+	}
+
+	existing, err := b.getOne(tx, key)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, models.KeyExists(key, prevIndex)
+	}
+
+	index, err := b.incrementIndex(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = b.mkdirs(tx, splitKey(key), index)
+	if err != nil {
+		return nil, err
+	}
+
+	// checksums are of the key and value, so they are copied as they are
+	checksumColumn := ""
+	if b.checksums {
+		checksumColumn = `, "checksum"`
+	}
+	_, err = b.Query().Extend(`
+		INSERT INTO nodes ("key", "value", "dir", "created", "modified", "path_depth", "expiration"`+checksumColumn+`)
+		SELECT "key", "value", "dir", "created", `, index, `, "path_depth", "expiration"`+checksumColumn+`
+		FROM "nodes" WHERE "deleted" = `, deleted,
+		` AND ("key" = `, key, ` OR "key" LIKE `, likePrefix(key), `)`).Exec(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = b.Query().Extend(`DELETE FROM "recycle" WHERE "index" = `, deleted).Exec(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	node, err = b.getOne(tx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	// the restored subtree is reported to watchers as the creation of its
+	// top node
+	err = b.recordChange(tx, index, "create", key, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return node, nil
+}
@@ -0,0 +1,77 @@
+package backend
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func Test_Restore_RecursiveDelete(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+	store.SetRecycleGrace(time.Hour)
+
+	created, _, err := store.Set("/foo/bar/baz", "value", Always)
+	ok(t, err)
+
+	_, deleted, err := store.RmDir("/foo", true, Always)
+	ok(t, err)
+
+	recycled, err := store.Recycled()
+	ok(t, err)
+	equals(t, 1, len(recycled))
+	equals(t, deleted, recycled[0].Index)
+	equals(t, "/foo", recycled[0].Key)
+
+	node, err := store.Restore(deleted)
+	ok(t, err)
+	equals(t, "/foo", node.Key)
+	equals(t, true, node.Dir)
+	equals(t, deleted+1, node.ModifiedIndex)
+
+	restored, err := store.Get("/foo/bar/baz", false)
+	ok(t, err)
+	equals(t, "value", restored.Value)
+	equals(t, created.CreatedIndex, restored.CreatedIndex)
+
+	// each delete can only be restored once
+	recycled, err = store.Recycled()
+	ok(t, err)
+	equals(t, 0, len(recycled))
+
+	_, err = store.Restore(deleted)
+	expectError(t, "Key not found", fmt.Sprint(deleted), err)
+}
+
+func Test_Restore_KeySetAgain(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+	store.SetRecycleGrace(time.Hour)
+
+	_, _, err := store.Set("/foo/bar", "value", Always)
+	ok(t, err)
+	_, deleted, err := store.RmDir("/foo", true, Always)
+	ok(t, err)
+	_, _, err = store.Set("/foo", "new", Always)
+	ok(t, err)
+
+	_, err = store.Restore(deleted)
+	expectError(t, "Key already exists", "/foo", err)
+}
+
+func Test_Recycled_Disabled(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	_, _, err := store.Set("/foo/bar", "value", Always)
+	ok(t, err)
+	_, deleted, err := store.RmDir("/foo", true, Always)
+	ok(t, err)
+
+	recycled, err := store.Recycled()
+	ok(t, err)
+	equals(t, 0, len(recycled))
+
+	_, err = store.Restore(deleted)
+	expectError(t, "Key not found", fmt.Sprint(deleted), err)
+}
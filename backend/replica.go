@@ -0,0 +1,128 @@
+package backend
+
+import (
+	"database/sql"
+	"errors"
+	"expvar"
+	"log"
+	"sync/atomic"
+
+	"github.com/rancher/etcdb/models"
+)
+
+// ReplicaStats counts the reads served by replicas, and the ones that fell
+// back to the primary because the replica was behind or failed, published
+// with expvar.
+var ReplicaStats = expvar.NewMap("replicas")
+
+// AddReplica adds a read-only replica of the DB that plain reads can be
+// served from with GetReplica.
+func (b *SqlBackend) AddReplica(dataSource string) error {
+	db, err := b.dialect.Open(b.driver, dataSource)
+	if err != nil {
+		return err
+	}
+	b.dbMu.Lock()
+	defer b.dbMu.Unlock()
+	b.replicas = append(b.replicas, db)
+	return nil
+}
+
+// SetMaxReplicaLag sets how many indexes a replica can be behind the latest
+// index this instance has seen, before reads fall back to the primary. With
+// 0, a client always reads its own writes to this instance.
+func (b *SqlBackend) SetMaxReplicaLag(lag int64) {
+	atomic.StoreInt64(&b.maxReplicaLag, lag)
+}
+
+// observeIndex records an index read from or written to the primary
+func (b *SqlBackend) observeIndex(index int64) {
+	for {
+		seen := atomic.LoadInt64(&b.seenIndex)
+		if index <= seen || atomic.CompareAndSwapInt64(&b.seenIndex, seen, index) {
+			return
+		}
+	}
+}
+
+func (b *SqlBackend) nextReplica() *sql.DB {
+	b.dbMu.RLock()
+	defer b.dbMu.RUnlock()
+	if len(b.replicas) == 0 {
+		return nil
+	}
+	n := atomic.AddUint64(&b.replicaNext, 1)
+	return b.replicas[n%uint64(len(b.replicas))]
+}
+
+// GetReplica returns a node for the key like Get, but reads it from one of the
+// replicas if there are any, and returns the index it was read at. Expired
+// keys are left out, since the replica can't purge them. If the replica is
+// behind by more than the maximum lag, or fails, or there are no replicas,
+// the node is read from the primary instead, with the index read before it.
+// Files are read from the ReadCache first, if one is set.
+func (b *SqlBackend) GetReplica(key string, recursive bool) (*models.Node, int64, error) {
+	if b.readCache == nil || recursive {
+		return b.getReplicaOrPrimary(key, recursive)
+	}
+	if node, index, ok := b.readCache.get(key); ok {
+		return node, index, nil
+	}
+	node, index, err := b.getReplicaOrPrimary(key, recursive)
+	if err == nil {
+		b.readCache.add(node, index)
+	}
+	return node, index, err
+}
+
+func (b *SqlBackend) getReplicaOrPrimary(key string, recursive bool) (*models.Node, int64, error) {
+	db := b.nextReplica()
+	if db == nil {
+		return b.getIndexed(key, recursive)
+	}
+
+	node, index, err := b.getReplica(db, key, recursive)
+	switch {
+	case err == errReplicaBehind:
+		ReplicaStats.Add("behind", 1)
+	case err == nil:
+		ReplicaStats.Add("reads", 1)
+		return node, index, nil
+	case isModelsError(err):
+		// like NotFound, as of the replica's index
+		ReplicaStats.Add("reads", 1)
+		return nil, index, err
+	default:
+		ReplicaStats.Add("errors", 1)
+		log.Println("error reading from replica:", err)
+	}
+
+	return b.getIndexed(key, recursive)
+}
+
+var errReplicaBehind = errors.New("replica is behind")
+
+func isModelsError(err error) bool {
+	_, ok := err.(models.Error)
+	return ok
+}
+
+func (b *SqlBackend) getReplica(db *sql.DB, key string, recursive bool) (node *models.Node, index int64, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+
+	// read the replica's index directly, since currIndex records it as seen
+	err = tx.QueryRow(`SELECT "index" FROM "index"`).Scan(&index)
+	if err != nil {
+		return nil, 0, err
+	}
+	if index < atomic.LoadInt64(&b.seenIndex)-atomic.LoadInt64(&b.maxReplicaLag) {
+		return nil, index, errReplicaBehind
+	}
+
+	node, err = b.readNode(tx, key, recursive, 0)
+	return node, index, err
+}
@@ -0,0 +1,107 @@
+package backend
+
+import (
+	"expvar"
+	"testing"
+	"time"
+)
+
+func replicaStat(name string) int64 {
+	if v, ok := ReplicaStats.Get(name).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func testReplica(t *testing.T) *SqlBackend {
+	store := testConn(t)
+	// the test database stands in for its own replica
+	ok(t, store.AddReplica(dbDataSource))
+	return store
+}
+
+func Test_GetReplica(t *testing.T) {
+	store := testReplica(t)
+	defer store.Close()
+
+	node, _, err := store.Set("/foo", "bar", Always)
+	ok(t, err)
+
+	replicaNode, index, err := store.GetReplica("/foo", false)
+	ok(t, err)
+
+	equals(t, "bar", replicaNode.Value)
+	equals(t, node.ModifiedIndex, index)
+}
+
+func Test_GetReplica_NotFound(t *testing.T) {
+	store := testReplica(t)
+	defer store.Close()
+
+	_, index, err := store.GetReplica("/foo", false)
+
+	expectError(t, "Key not found", "/foo", err)
+	equals(t, currIndex(store), index)
+}
+
+func Test_GetReplica_BehindReadsPrimary(t *testing.T) {
+	store := testReplica(t)
+	defer store.Close()
+
+	_, _, err := store.Set("/foo", "bar", Always)
+	ok(t, err)
+	// as if another instance wrote more changes than the replica has
+	store.observeIndex(currIndex(store) + 5)
+
+	behind := replicaStat("behind")
+	node, index, err := store.GetReplica("/foo", false)
+	ok(t, err)
+	equals(t, "bar", node.Value)
+	// read from the primary, with its index
+	equals(t, currIndex(store), index)
+	equals(t, behind+1, replicaStat("behind"))
+
+	store.SetMaxReplicaLag(5)
+	_, index, err = store.GetReplica("/foo", false)
+	ok(t, err)
+	equals(t, currIndex(store), index)
+	equals(t, behind+1, replicaStat("behind"))
+}
+
+func Test_GetReplica_LeavesOutExpired(t *testing.T) {
+	store := testReplica(t)
+	defer store.Close()
+
+	_, _, err := store.SetTTL("/foo", "bar", 1, Always)
+	ok(t, err)
+	time.Sleep(2 * time.Second)
+
+	_, _, err = store.GetReplica("/foo", false)
+	expectError(t, "Key not found", "/foo", err)
+}
+
+func Test_ObserveIndex(t *testing.T) {
+	store := &SqlBackend{}
+
+	store.observeIndex(5)
+	store.observeIndex(3)
+	equals(t, int64(5), store.seenIndex)
+
+	store.observeIndex(8)
+	equals(t, int64(8), store.seenIndex)
+}
+
+func Test_GetReplica_NoReplicasReturnsIndex(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	_, _, err := store.Set("/foo", "bar", Always)
+	ok(t, err)
+	_, _, err = store.Set("/other", "baz", Always)
+	ok(t, err)
+
+	node, index, err := store.GetReplica("/foo", false)
+	ok(t, err)
+	equals(t, "bar", node.Value)
+	equals(t, currIndex(store), index)
+}
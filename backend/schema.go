@@ -0,0 +1,286 @@
+package backend
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// SchemaVersion is the version of the schema created by CreateSchema. It is
+// stored in the "schema" table, which schemas created before it was versioned
+// don't have.
+//
+// Version 2 stores expirations with fractional seconds. Version 3 adds the
+// checksum column of nodes. Version 4 replaces the index of nodes on deleted
+// and path_depth with one that includes the key, for listing directories.
+const SchemaVersion = 4
+
+// A SchemaObject is a table, or an index or a column of the table, and the
+// statement creating it. Columns added to existing tables are also in the
+// table's definition, so that they are only added to tables created without
+// them. Obsolete objects are dropped by the statement instead, if they exist.
+type SchemaObject struct {
+	Table      string
+	Index      string
+	Column     string
+	Obsolete   bool
+	Definition string
+}
+
+func (o SchemaObject) String() string {
+	var s string
+	switch {
+	case o.Index != "":
+		s = "index " + o.Index + " on " + o.Table
+	case o.Column != "":
+		s = "column " + o.Column + " of " + o.Table
+	default:
+		s = "table " + o.Table
+	}
+	if o.Obsolete {
+		return "obsolete " + s
+	}
+	return s
+}
+
+// SchemaStatus describes how the DB schema differs from the current one
+type SchemaStatus struct {
+	// Version is the version of the schema, or 0 if it isn't versioned
+	Version int
+	// Missing lists the tables, indexes and columns that don't exist
+	Missing []string
+	// Obsolete lists the tables and indexes that are no longer used, but
+	// still exist
+	Obsolete []string
+
+	objects int
+}
+
+// Empty reports whether none of the tables, indexes and columns exist
+func (s *SchemaStatus) Empty() bool {
+	return len(s.Missing) == s.objects
+}
+
+// Current reports whether the schema is complete and of the current version
+func (s *SchemaStatus) Current() bool {
+	return len(s.Missing) == 0 && len(s.Obsolete) == 0 && s.Version == SchemaVersion
+}
+
+// CreateSchema creates the DB schema. It only creates the tables, indexes and
+// columns that don't exist yet, so that it can be run again after failing
+// part way, or on a schema that is already complete, and migrates an existing
+// schema of an older version. Instances starting at once take turns with a
+// lock in the database, so the ones after the first find the schema current.
+func (b *SqlBackend) CreateSchema() error {
+	conn, err := b.conn().Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	unlock, err := b.dialect.LockSchema(conn)
+	if err != nil {
+		return fmt.Errorf("locking the schema: %v", err)
+	}
+	defer func() {
+		if err := unlock(); err != nil {
+			log.Println("error unlocking the schema:", err)
+		}
+	}()
+
+	return b.createSchema()
+}
+
+func (b *SqlBackend) createSchema() error {
+	changes, err := b.SchemaChanges()
+	if err != nil {
+		return err
+	}
+	for _, c := range changes {
+		if err := b.runQueries(c.Statement); err != nil {
+			return fmt.Errorf("%s: %v", c.Description, err)
+		}
+	}
+	return nil
+}
+
+// A SchemaChange is a statement that CreateSchema runs, and what it is for
+type SchemaChange struct {
+	// Description is like "creating table nodes" or "migrating to version 4"
+	Description string
+	Statement   string
+}
+
+// SchemaChanges returns the statements that CreateSchema would run on the
+// schema as it is now, in order, without running them.
+func (b *SqlBackend) SchemaChanges() ([]SchemaChange, error) {
+	status, err := b.CheckSchema()
+	if err != nil {
+		return nil, err
+	}
+	if status.Version > SchemaVersion {
+		return nil, fmt.Errorf("the schema version %d is newer than this version of etcdb's %d", status.Version, SchemaVersion)
+	}
+
+	changes, err := planObjects(b.dialect.SchemaObjects(), b.schemaObjectExists)
+	if err != nil {
+		return nil, err
+	}
+	changes = append(sessionChanges(b.dialect), changes...)
+
+	// tables created above already have the current definition, so migrating
+	// them again does nothing
+	if !status.Empty() {
+		migrations := b.dialect.Migrations()
+		for version := status.Version + 1; version <= SchemaVersion; version++ {
+			for _, statement := range migrations[version] {
+				changes = append(changes, SchemaChange{fmt.Sprintf("migrating to version %d", version), statement})
+			}
+		}
+	}
+
+	indexRow, err := b.tableExists("index")
+	if err != nil {
+		return nil, err
+	}
+	if indexRow {
+		var count int
+		if err := b.conn().QueryRow(`SELECT COUNT(*) FROM "index"`).Scan(&count); err != nil {
+			return nil, err
+		}
+		indexRow = count > 0
+	}
+	return append(changes, seedChanges(indexRow)...), nil
+}
+
+// SchemaFor returns the statements creating the whole schema of the dialect
+// in an empty database, with the rows it starts with, like CreateSchema
+// would run them. They can be run by a user with more rights than etcdb's.
+func SchemaFor(dialect string) ([]SchemaChange, error) {
+	d, err := dialectFor(dialect)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := planObjects(d.SchemaObjects(), func(SchemaObject) (bool, error) { return false, nil })
+	if err != nil {
+		return nil, err
+	}
+	changes = append(sessionChanges(d), changes...)
+	return append(changes, seedChanges(false)...), nil
+}
+
+// sessionChanges returns the statements setting the session up, which are
+// the same as etcdb's own connections use when it runs the others
+func sessionChanges(d Dialect) []SchemaChange {
+	var changes []SchemaChange
+	for _, statement := range d.SessionSetup() {
+		changes = append(changes, SchemaChange{"setting the session up like etcdb's", statement})
+	}
+	return changes
+}
+
+// planObjects returns the statements creating the objects that don't exist,
+// and dropping the obsolete ones that do
+func planObjects(objects []SchemaObject, objectExists func(SchemaObject) (bool, error)) ([]SchemaChange, error) {
+	var changes []SchemaChange
+	// the tables created have their columns already
+	created := make(map[string]bool)
+	for _, o := range objects {
+		if o.Column != "" && created[o.Table] {
+			continue
+		}
+		exists, err := objectExists(o)
+		if err != nil {
+			return nil, err
+		}
+		if o.Obsolete && exists {
+			changes = append(changes, SchemaChange{"dropping " + o.String(), o.Definition})
+		}
+		if !o.Obsolete && !exists {
+			changes = append(changes, SchemaChange{"creating " + o.String(), o.Definition})
+			if o.Index == "" && o.Column == "" {
+				created[o.Table] = true
+			}
+		}
+	}
+	return changes, nil
+}
+
+// seedChanges returns the statements adding the index row, unless there is
+// one, and recording the schema version
+func seedChanges(indexRow bool) []SchemaChange {
+	var changes []SchemaChange
+	if !indexRow {
+		changes = append(changes, SchemaChange{"adding the index row", `INSERT INTO "index" ("index") VALUES (0)`})
+	}
+	version := fmt.Sprintf("recording version %d", SchemaVersion)
+	return append(changes,
+		SchemaChange{version, `DELETE FROM "schema"`},
+		SchemaChange{version, fmt.Sprintf(`INSERT INTO "schema" ("version") VALUES (%d)`, SchemaVersion)},
+	)
+}
+
+// CheckSchema compares the DB schema with the current one
+func (b *SqlBackend) CheckSchema() (*SchemaStatus, error) {
+	status := &SchemaStatus{}
+	for _, o := range b.dialect.SchemaObjects() {
+		exists, err := b.schemaObjectExists(o)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case o.Obsolete:
+			if exists {
+				status.Obsolete = append(status.Obsolete, o.String())
+			}
+		case !exists:
+			status.Missing = append(status.Missing, o.String())
+			status.objects++
+		default:
+			status.objects++
+		}
+	}
+
+	if exists, err := b.tableExists("schema"); err != nil || !exists {
+		return status, err
+	}
+	err := b.conn().QueryRow(`SELECT "version" FROM "schema"`).Scan(&status.Version)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	return status, nil
+}
+
+func (b *SqlBackend) schemaObjectExists(o SchemaObject) (bool, error) {
+	switch {
+	case o.Index != "":
+		return b.dialect.IndexExists(b.conn(), o.Table, o.Index)
+	case o.Column != "":
+		return b.columnExists(o.Table, o.Column)
+	}
+	return b.tableExists(o.Table)
+}
+
+func (b *SqlBackend) tableExists(table string) (bool, error) {
+	var count int
+	err := b.Query().Extend(`
+		SELECT COUNT(*) FROM information_schema.tables
+		WHERE table_schema = `+b.dialect.CurrentSchema()+` AND table_name = `, table).
+		QueryRow(b.conn()).Scan(&count)
+	return count > 0, err
+}
+
+func (b *SqlBackend) columnExists(table, column string) (bool, error) {
+	var count int
+	err := b.Query().Extend(`
+		SELECT COUNT(*) FROM information_schema.columns
+		WHERE table_schema = `+b.dialect.CurrentSchema()+` AND table_name = `, table, ` AND column_name = `, column).
+		QueryRow(b.conn()).Scan(&count)
+	return count > 0, err
+}
+
+// DropSchema drops all of the tables, and the keys with them
+func (b *SqlBackend) DropSchema() error {
+	return b.dropSchema()
+}
@@ -0,0 +1,261 @@
+package backend
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_CreateSchema_Idempotent(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	_, _, err := store.Set("/foo", "bar", Always)
+	ok(t, err)
+	index := currIndex(store)
+
+	ok(t, store.CreateSchema())
+
+	node, err := store.Get("/foo", false)
+	ok(t, err)
+	equals(t, "bar", node.Value)
+	equals(t, index, currIndex(store))
+}
+
+func Test_CreateSchema_Concurrent(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+	ok(t, store.DropSchema())
+
+	errs := make(chan error)
+	for i := 0; i < 4; i++ {
+		go func() {
+			other, err := New(dbDriver, dbDataSource)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer other.Close()
+			errs <- other.CreateSchema()
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		ok(t, <-errs)
+	}
+
+	status, err := store.CheckSchema()
+	ok(t, err)
+	equals(t, true, status.Current())
+	equals(t, int64(0), currIndex(store))
+}
+
+func Test_SchemaChanges_CreatedTableColumns(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	ok(t, store.runQueries(`DROP TABLE "nodes"`))
+
+	changes, err := store.SchemaChanges()
+	ok(t, err)
+	var descriptions []string
+	for _, c := range changes {
+		descriptions = append(descriptions, c.Description)
+	}
+	equals(t, "creating table nodes", descriptions[0])
+	for _, d := range descriptions {
+		equals(t, false, strings.HasPrefix(d, "creating column"))
+	}
+
+	ok(t, store.CreateSchema())
+}
+
+func Test_SchemaFor(t *testing.T) {
+	changes, err := SchemaFor("mysql")
+	ok(t, err)
+	equals(t, `SET SESSION sql_mode = 'ANSI_QUOTES'`, changes[0].Statement)
+	equals(t, "creating table nodes", changes[1].Description)
+
+	changes, err = SchemaFor("postgres")
+	ok(t, err)
+	equals(t, "creating table nodes", changes[0].Description)
+	for _, c := range changes {
+		equals(t, false, strings.HasPrefix(c.Description, "creating column"))
+		equals(t, false, strings.HasPrefix(c.Description, "dropping"))
+	}
+	last := changes[len(changes)-3:]
+	equals(t, `INSERT INTO "index" ("index") VALUES (0)`, last[0].Statement)
+	equals(t, `DELETE FROM "schema"`, last[1].Statement)
+
+	_, err = SchemaFor("oracle")
+	equals(t, true, err != nil)
+}
+
+func Test_CreateSchema_CompletesPartialSchema(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	ok(t, store.runQueries(`DROP TABLE "members"`, `DROP TABLE "schema"`))
+
+	status, err := store.CheckSchema()
+	ok(t, err)
+	equals(t, false, status.Current())
+	equals(t, false, status.Empty())
+	equals(t, 0, status.Version)
+	equals(t, []string{"table members", "table schema"}, status.Missing)
+
+	ok(t, store.CreateSchema())
+
+	status, err = store.CheckSchema()
+	ok(t, err)
+	equals(t, true, status.Current())
+	equals(t, SchemaVersion, status.Version)
+}
+
+func Test_CreateSchema_Migrates(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	ok(t, store.runQueries(`UPDATE "schema" SET "version" = 1`, `ALTER TABLE "nodes" DROP COLUMN "checksum"`))
+	status, err := store.CheckSchema()
+	ok(t, err)
+	equals(t, false, status.Current())
+	equals(t, []string{"column checksum of nodes"}, status.Missing)
+
+	ok(t, store.CreateSchema())
+
+	status, err = store.CheckSchema()
+	ok(t, err)
+	equals(t, true, status.Current())
+
+	// expirations keep their fractional seconds
+	_, _, err = store.SetTTL("/foo", "bar", 10, Always)
+	ok(t, err)
+	node, err := store.Get("/foo", false)
+	ok(t, err)
+	equals(t, int64(10), *node.TTL)
+	if node.Expiration.Nanosecond() == 0 {
+		fatalf(t, "expected fractional seconds in %v", node.Expiration)
+	}
+}
+
+func Test_CreateSchema_AddsChangeTimes(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	_, _, err := store.Set("/foo", "bar", Always)
+	ok(t, err)
+
+	// the changes table of schemas from before the change times
+	ok(t, store.runQueries(`DELETE FROM "schema"`, `ALTER TABLE "changes" DROP COLUMN "time"`))
+	status, err := store.CheckSchema()
+	ok(t, err)
+	equals(t, false, status.Current())
+	equals(t, []string{"column time of changes"}, status.Missing)
+
+	ok(t, store.CreateSchema())
+
+	status, err = store.CheckSchema()
+	ok(t, err)
+	equals(t, true, status.Current())
+
+	// the existing changes are as old as the upgrade
+	index, err := store.IndexBefore(time.Hour)
+	ok(t, err)
+	equals(t, int64(0), index)
+}
+
+func Test_CheckSchema_Empty(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	ok(t, store.dropSchema())
+
+	status, err := store.CheckSchema()
+	ok(t, err)
+	equals(t, true, status.Empty())
+}
+
+func Test_CreateSchema_NewerVersion(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	ok(t, store.runQueries(`UPDATE "schema" SET "version" = 1000`))
+
+	err := store.CreateSchema()
+	if err == nil {
+		t.Fatal("expected an error for a newer schema")
+	}
+}
+
+func Test_CreateSchema_DropsObsoleteIndex(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	ok(t, store.runQueries(`CREATE INDEX "nodes_deleted_path_depth_idx" ON "nodes" ("deleted", "path_depth")`))
+	status, err := store.CheckSchema()
+	ok(t, err)
+	equals(t, false, status.Current())
+	equals(t, []string{"obsolete index nodes_deleted_path_depth_idx on nodes"}, status.Obsolete)
+
+	ok(t, store.CreateSchema())
+
+	status, err = store.CheckSchema()
+	ok(t, err)
+	equals(t, true, status.Current())
+}
+
+// the listing of a directory, and the trimming of deleted nodes, which would
+// scan the whole nodes table without an index
+func Test_Schema_IndexesQueries(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	for query, index := range map[string]string{
+		`SELECT "key" FROM "nodes" WHERE "deleted" = 0 AND "path_depth" = 2 AND "key" LIKE '/dir/%'`: "nodes_deleted_path_depth_key_idx",
+		`SELECT "key" FROM "nodes" WHERE "deleted" > 0 AND "deleted" < 100`:                          "PRIMARY",
+	} {
+		explainUsesIndex(t, store, query, index)
+	}
+}
+
+// explainUsesIndex fails unless the query can use an index: in MySQL the
+// given one, which EXPLAIN lists in possible_keys, and in Postgres any, since
+// the planner picks one by the table's statistics
+func explainUsesIndex(t *testing.T, store *SqlBackend, query, index string) {
+	tx, err := store.conn().Begin()
+	ok(t, err)
+	defer tx.Rollback()
+
+	if dbDriver == "postgres" {
+		_, err = tx.Exec(`SET LOCAL enable_seqscan = off`)
+		ok(t, err)
+	}
+	rows, err := tx.Query(`EXPLAIN ` + query)
+	ok(t, err)
+	defer rows.Close()
+	columns, err := rows.Columns()
+	ok(t, err)
+
+	var plan []string
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		ok(t, rows.Scan(dest...))
+		for i, column := range columns {
+			if dbDriver == "postgres" || column == "possible_keys" {
+				plan = append(plan, values[i].String)
+			}
+		}
+	}
+	ok(t, rows.Err())
+
+	text := strings.Join(plan, "\n")
+	if dbDriver == "postgres" && strings.Contains(text, "Seq Scan") ||
+		dbDriver != "postgres" && !strings.Contains(text, index) {
+		fatalf(t, "expected %s to use an index, got the plan:\n%s", query, text)
+	}
+}
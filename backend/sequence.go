@@ -0,0 +1,56 @@
+package backend
+
+import "database/sql"
+
+// SetInOrderSequence sets whether in-order keys are named with a counter per
+// directory, 1, 2, 3 and so on, instead of the global index. The counter isn't
+// reset when the directory is deleted, so names are never reused, and names
+// already taken by keys set directly are skipped.
+func (b *SqlBackend) SetInOrderSequence(enabled bool) {
+	b.inOrderSequence = enabled
+}
+
+// nextSequence increments and returns the in-order key counter for the
+// directory, starting at 1.
+func (b *SqlBackend) nextSequence(tx *sql.Tx, key string) (int64, error) {
+	updated, err := b.incrementSequence(tx, key)
+	if err != nil {
+		return 0, err
+	}
+	if updated {
+		return b.lastSequence(tx, key)
+	}
+
+	_, err = tx.Exec("SAVEPOINT sequence")
+	if err != nil {
+		return 0, err
+	}
+	_, err = b.Query().Extend(`INSERT INTO "sequences" ("key", "last") VALUES (`, key, `, 1)`).Exec(tx)
+	if err == nil {
+		return 1, nil
+	}
+	tx.Exec("ROLLBACK TO SAVEPOINT sequence")
+	if !b.dialect.IsDuplicateKeyError(err) {
+		return 0, err
+	}
+
+	// created concurrently by another in-order create
+	if _, err := b.incrementSequence(tx, key); err != nil {
+		return 0, err
+	}
+	return b.lastSequence(tx, key)
+}
+
+func (b *SqlBackend) incrementSequence(tx *sql.Tx, key string) (bool, error) {
+	res, err := b.Query().Extend(`UPDATE "sequences" SET "last" = "last" + 1 WHERE "key" = `, key).Exec(tx)
+	if err != nil {
+		return false, err
+	}
+	rows, err := res.RowsAffected()
+	return rows > 0, err
+}
+
+func (b *SqlBackend) lastSequence(tx *sql.Tx, key string) (last int64, err error) {
+	err = b.Query().Extend(`SELECT "last" FROM "sequences" WHERE "key" = `, key).QueryRow(tx).Scan(&last)
+	return
+}
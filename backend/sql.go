@@ -4,7 +4,11 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"path"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-sql-driver/mysql"
 	"github.com/rancher/etcdb/models"
@@ -16,37 +20,144 @@ const MaxChanges = 1000
 
 // SqlBackend SQL implementation
 type SqlBackend struct {
-	db      *sql.DB
-	dialect dbDialect
+	// seenIndex is the latest index read from or written to the primary. The
+	// atomically accessed fields are first for their 64-bit alignment.
+	seenIndex     int64
+	maxReplicaLag int64
+	replicaNext   uint64
+	// switches counts the failovers and reconnects to another database,
+	// which can be behind the one the changes were watched from
+	switches uint64
+
+	// db is replaced by Reconnect and failOver, so it is read with conn
+	dbMu     sync.RWMutex
+	db       *sql.DB
+	replicas []*sql.DB
+	// dataSources are the one of db when created or reconnected, and the
+	// ones added with AddFailover. activeSource is the one db is open to.
+	dataSources  []string
+	activeSource int
+	driver       string
+	dialect      Dialect
+	recycleGrace time.Duration
+	clock        clockWatch
+	quota        quotaState
+	expiry       expirationObjective
+	// inOrderSequence names in-order keys with a counter per directory
+	inOrderSequence bool
+	// checksums stores the checksum of each node's key and value
+	checksums bool
+	// deferTrim leaves trimming the history to Housekeeping, instead of
+	// trimming it on every change
+	deferTrim bool
+	// closing is closed by Close, to stop renewing the credentials from a
+	// CredentialsProvider and resetting the pool
+	closing chan struct{}
+	// hooks are added with AddHook
+	hooks []interface{}
+	// readCache is set with SetReadCache
+	readCache *ReadCache
+	// health is set with SetHealthCheck
+	health connHealth
 }
 
 // New creates a SqlBackend for the DB
 func New(driver, dataSource string) (*SqlBackend, error) {
-	var dialect dbDialect
-	switch driver {
-	case "mysql":
-		dialect = mysqlDialect{}
-	case "postgres":
-		dialect = postgresDialect{}
-	default:
-		return nil, fmt.Errorf("Unrecognized database driver %s, should be 'mysql' or 'postgres'", driver)
+	dialect, err := dialectFor(driver)
+	if err != nil {
+		return nil, err
 	}
 
 	db, err := dialect.Open(driver, dataSource)
 	if err != nil {
 		return nil, err
 	}
-	backend := &SqlBackend{db, dialect}
+	backend := &SqlBackend{
+		db:          db,
+		driver:      driver,
+		dialect:     dialect,
+		dataSources: []string{dataSource},
+		closing:     make(chan struct{}),
+	}
 	return backend, nil
 }
 
+// NewFromConfig creates a SqlBackend for the DB described by the config
+func NewFromConfig(driver string, config *ConnConfig) (*SqlBackend, error) {
+	dataSource, err := config.DataSource(driver)
+	if err != nil {
+		return nil, err
+	}
+	return New(driver, dataSource)
+}
+
 func (b *SqlBackend) Close() error {
-	return b.db.Close()
+	if b.closing != nil {
+		close(b.closing)
+	}
+	b.dbMu.RLock()
+	for _, replica := range b.replicas {
+		replica.Close()
+	}
+	b.dbMu.RUnlock()
+	return b.conn().Close()
+}
+
+// ReconnectGrace is how long the old connections are kept open after
+// Reconnect, for the requests still using them.
+var ReconnectGrace = 1 * time.Minute
+
+// Reconnect replaces the connections to the DB with new ones, like after
+// rotating the password. The data source replaces the primary one, and the
+// new connections are to the datasource in use, so that after failing over
+// they stay on the standby. The old connections are closed after the
+// ReconnectGrace. If the datasource can't be connected to, the old
+// connections are kept.
+func (b *SqlBackend) Reconnect(dataSource string) error {
+	b.dbMu.RLock()
+	active := b.activeSource
+	activeSource := dataSource
+	if active != 0 {
+		activeSource = b.dataSources[active]
+	}
+	b.dbMu.RUnlock()
+
+	db, err := b.dialect.Open(b.driver, activeSource)
+	if err != nil {
+		return err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return err
+	}
+
+	b.dbMu.Lock()
+	b.dataSources[0] = dataSource
+	if b.activeSource != active {
+		// failed over meanwhile, to connections that are newer anyway
+		b.dbMu.Unlock()
+		db.Close()
+		return nil
+	}
+	old := b.db
+	b.db = db
+	b.dbMu.Unlock()
+
+	// the new datasource can be another database, behind the watched changes
+	atomic.AddUint64(&b.switches, 1)
+	time.AfterFunc(ReconnectGrace, func() { old.Close() })
+	return nil
+}
+
+func (b *SqlBackend) conn() *sql.DB {
+	b.dbMu.RLock()
+	defer b.dbMu.RUnlock()
+	return b.db
 }
 
 func (b *SqlBackend) runQueries(queries ...string) error {
 	for _, q := range queries {
-		_, err := b.db.Exec(q)
+		_, err := b.conn().Exec(q)
 		if err != nil {
 			log.Printf("err: %s -- %T %s", err, err, q)
 			return err
@@ -61,38 +172,77 @@ func (b *SqlBackend) dropSchema() error {
 		`DROP TABLE IF EXISTS "nodes"`,
 		`DROP TABLE IF EXISTS "index"`,
 		`DROP TABLE IF EXISTS "changes"`,
+		`DROP TABLE IF EXISTS "subscriptions"`,
+		`DROP TABLE IF EXISTS "members"`,
+		`DROP TABLE IF EXISTS "recycle"`,
+		`DROP TABLE IF EXISTS "sequences"`,
+		`DROP TABLE IF EXISTS "schema"`,
 	)
 }
 
-// CreateSchema creates the DB schema
-func (b *SqlBackend) CreateSchema() error {
-	queries := b.dialect.tableDefinitions()
-	queries = append(queries, `INSERT INTO "index" ("index") VALUES (0)`)
-	return b.runQueries(queries...)
-}
-
 func (b *SqlBackend) Query() *Query {
 	return &Query{dialect: b.dialect}
 }
 
 func (b *SqlBackend) Begin() (tx *sql.Tx, err error) {
+	if err = b.available(); err != nil {
+		return
+	}
+
 	err = b.purgeExpired()
 	if err != nil {
+		b.observe(err)
 		log.Println("error expiring:", err)
 		return
 	}
 
-	return b.db.Begin()
+	tx, err = b.conn().Begin()
+	b.observe(err)
+	return
+}
+
+// beginRead begins a transaction for reads, like Begin, but continues when
+// expired keys can't be purged, since reads leave them out anyway.
+func (b *SqlBackend) beginRead() (*sql.Tx, error) {
+	if err := b.available(); err != nil {
+		return nil, err
+	}
+
+	if err := b.purgeExpired(); err != nil {
+		b.observe(err)
+		log.Println("error expiring:", err)
+	}
+	tx, err := b.conn().Begin()
+	b.observe(err)
+	return tx, err
+}
+
+// unexpired leaves the nodes that have expired, but haven't been purged yet,
+// out of the query, unless expirations are frozen after a clock jump.
+func (b *SqlBackend) unexpired(query *Query) *Query {
+	if b.clock.frozen() {
+		return query
+	}
+	return query.Text(` AND ("expiration" IS NULL OR "expiration" >= ` + b.dialect.Now() + `)`)
 }
 
 func (b *SqlBackend) purgeExpired() (err error) {
-	tx, err := b.db.Begin()
+	frozen, err := b.expirationsFrozen(b.conn())
+	if err != nil || frozen {
+		return err
+	}
+
+	tx, err := b.conn().Begin()
 	if err != nil {
 		return err
 	}
+	var lateness []float64
 	defer func() {
 		if err == nil {
 			err = tx.Commit()
+			if err == nil {
+				b.recordExpirations(lateness)
+			}
 		} else {
 			tx.Rollback()
 		}
@@ -106,8 +256,8 @@ func (b *SqlBackend) purgeExpired() (err error) {
 		return
 	}
 
-	rows, err := tx.Query(`SELECT "key", "modified" FROM "nodes"
-		WHERE "deleted" = 0 AND "expiration" < ` + b.dialect.now() + `
+	rows, err := tx.Query(`SELECT "key", "modified", ` + b.dialect.Lateness() + ` FROM "nodes"
+		WHERE "deleted" = 0 AND "expiration" < ` + b.dialect.Now() + `
 		ORDER BY "expiration"`)
 	if err != nil {
 		return
@@ -118,11 +268,13 @@ func (b *SqlBackend) purgeExpired() (err error) {
 
 	for rows.Next() {
 		var node models.Node
-		err = rows.Scan(&node.Key, &node.ModifiedIndex)
+		var seconds float64
+		err = rows.Scan(&node.Key, &node.ModifiedIndex, &seconds)
 		if err != nil {
 			return err
 		}
 		nodes = append(nodes, &node)
+		lateness = append(lateness, seconds)
 	}
 
 	if len(nodes) == 0 {
@@ -130,15 +282,28 @@ func (b *SqlBackend) purgeExpired() (err error) {
 	}
 
 	expirationIndex := index
+	expireHooks := b.hasExpireHooks()
 
 	for _, node := range nodes {
+		if expireHooks {
+			// the hooks get the whole node, not just its key and index
+			prevNode, err := b.getOne(tx, node.Key)
+			if err != nil {
+				return err
+			}
+			change := &Change{Action: "expire", Index: expirationIndex, Key: node.Key, PrevNode: prevNode}
+			if err := b.onExpire(tx, change); err != nil {
+				return err
+			}
+		}
+
 		err = b.recordChange(tx, expirationIndex, "expire", node.Key, node)
 		if err != nil {
 			return err
 		}
 
 		query := b.Query().Extend(`UPDATE nodes SET deleted = `, expirationIndex,
-			` WHERE deleted = 0 AND ("key" = `, node.Key, ` OR "key" LIKE `, node.Key+"/%", `)`)
+			` WHERE deleted = 0 AND ("key" = `, node.Key, ` OR "key" LIKE `, likePrefix(node.Key), `)`)
 		_, err = query.Exec(tx)
 		if err != nil {
 			return err
@@ -157,7 +322,96 @@ func (b *SqlBackend) purgeExpired() (err error) {
 
 // Get returns a node for the key
 func (b *SqlBackend) Get(key string, recursive bool) (node *models.Node, err error) {
-	tx, err := b.Begin()
+	return b.get(key, recursive, 0)
+}
+
+// Exists checks if the key exists, without reading the node's value or TTL, so
+// that the database can answer from the primary key index alone. It returns a
+// NotFound error if the key doesn't exist.
+func (b *SqlBackend) Exists(key string) error {
+	if key == "/" {
+		return nil
+	}
+
+	if err := b.purgeExpired(); err != nil {
+		log.Println("error expiring:", err)
+	}
+
+	var one int
+	query := b.Query().Extend(`SELECT 1 FROM "nodes" WHERE "deleted" = 0 AND "key" = `, key)
+	err := b.unexpired(query).QueryRow(b.conn()).Scan(&one)
+	if err == sql.ErrNoRows {
+		index, err := b.currIndex(b.conn())
+		if err != nil {
+			return err
+		}
+		return models.NotFound(key, index)
+	}
+	return err
+}
+
+// GetConsistent returns a node for the key like Get, and the current index, in
+// the same transaction on the primary, so that the node is exactly as of the
+// index.
+func (b *SqlBackend) GetConsistent(key string, recursive bool) (node *models.Node, index int64, err error) {
+	tx, err := b.beginRead()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}()
+
+	if err = b.dialect.Snapshot(tx); err != nil {
+		return nil, 0, err
+	}
+	index, err = b.currIndex(tx)
+	if err != nil {
+		return nil, 0, err
+	}
+	node, err = b.readNode(tx, key, recursive, 0)
+	return node, index, err
+}
+
+// getIndexed returns a node for the key like Get, and the current index read
+// before it, so that a watch from the next index misses no change to the
+// node. Changes committed between the two reads can be in the node, and seen
+// again by the watch.
+func (b *SqlBackend) getIndexed(key string, recursive bool) (node *models.Node, index int64, err error) {
+	tx, err := b.beginRead()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}()
+
+	index, err = b.currIndex(tx)
+	if err != nil {
+		return nil, 0, err
+	}
+	node, err = b.readNode(tx, key, recursive, 0)
+	return node, index, err
+}
+
+// GetAt returns a node for the key as it was at the index. Only indexes within
+// the last MaxChanges can be read, since older node versions are removed.
+func (b *SqlBackend) GetAt(key string, recursive bool, index int64) (node *models.Node, err error) {
+	return b.get(key, recursive, index)
+}
+
+// get returns a node for the key as of the index, or the current node if the
+// index is 0.
+func (b *SqlBackend) get(key string, recursive bool, atIndex int64) (node *models.Node, err error) {
+	tx, err := b.beginRead()
 	if err != nil {
 		return nil, err
 	}
@@ -169,18 +423,51 @@ func (b *SqlBackend) Get(key string, recursive bool) (node *models.Node, err err
 		}
 	}()
 
-	query := b.queryNode()
+	return b.readNode(tx, key, recursive, atIndex)
+}
+
+// readNode reads the node for the key in the transaction, as get does. The
+// current nodes are read without those that expired but haven't been purged
+// yet, so that TTLs are honored even if purging is late or fails.
+func (b *SqlBackend) readNode(tx *sql.Tx, key string, recursive bool, atIndex int64) (*models.Node, error) {
+	var query *Query
+	if atIndex == 0 {
+		query = b.queryNode()
+	} else {
+		currIndex, err := b.currIndex(tx)
+		if err != nil {
+			return nil, err
+		}
+		if atIndex > currIndex {
+			return nil, models.InvalidField(fmt.Sprintf("atIndex %d is after the current index %d", atIndex, currIndex))
+		}
+		oldest, err := b.oldestIndex(tx, currIndex)
+		if err != nil {
+			return nil, err
+		}
+		if atIndex < oldest {
+			return nil, models.EventIndexCleared(oldest, atIndex, currIndex)
+		}
+
+		// the version that was modified by the index, and not yet deleted
+		query = b.queryNodeWithDeleted().Extend(` WHERE "modified" <= `, atIndex,
+			` AND ("deleted" = 0 OR "deleted" > `, atIndex, `)`)
+	}
+
 	if key == "/" {
 		if !recursive {
 			query.Text(` AND path_depth = 1`)
 		}
 	} else {
-		query.Extend(` AND ("key" = `, key, ` OR ("key" LIKE `, key+"/%")
+		query.Extend(` AND ("key" = `, key, ` OR ("key" LIKE `, likePrefix(key))
 		if !recursive {
 			query.Extend(" AND path_depth = ", pathDepth(key)+1)
 		}
 		query.Text("))")
 	}
+	if atIndex == 0 {
+		query = b.unexpired(query)
+	}
 	rows, err := query.Query(tx)
 	if err != nil {
 		return nil, err
@@ -214,7 +501,11 @@ func (b *SqlBackend) Get(key string, recursive bool) (node *models.Node, err err
 			// don't need to compute parent of the requested key, or root key
 			continue
 		}
-		parent := nodes[splitKey(node.Key)]
+		parent, ok := nodes[splitKey(node.Key)]
+		if !ok {
+			// under a directory that expired but wasn't purged yet
+			continue
+		}
 		parent.Nodes = append(parent.Nodes, node)
 	}
 
@@ -235,7 +526,9 @@ func scanNode(scanner scannable) (*models.Node, error) {
 		return nil, err
 	}
 	if expiration.Valid {
-		node.Expiration = &expiration.Time
+		// in UTC, to format like etcd with a Z
+		utc := expiration.Time.UTC()
+		node.Expiration = &utc
 	}
 	return &node, nil
 }
@@ -243,7 +536,7 @@ func scanNode(scanner scannable) (*models.Node, error) {
 func (b *SqlBackend) queryNodeWithDeleted() *Query {
 	return b.Query().Text(`
 		SELECT "key", "created", "modified", "value", "dir", "expiration",
-		`).Text(b.dialect.ttl()).Text(`
+		`).Text(b.dialect.TTL()).Text(`
 		FROM "nodes"`)
 }
 
@@ -259,6 +552,20 @@ func (b *SqlBackend) getOne(tx *sql.Tx, key string) (*models.Node, error) {
 	return node, err
 }
 
+// getOneForUpdate is getOne for a node that the transaction checks a
+// condition on before changing it. The lock on the index row already orders
+// the writers, and locking the node's row too keeps the condition true until
+// the commit even for writes that don't take it, such as a transaction's
+// compares. In MySQL it also reads the latest version of the node, instead of
+// the one in the transaction's snapshot.
+func (b *SqlBackend) getOneForUpdate(tx *sql.Tx, key string) (*models.Node, error) {
+	node, err := scanNode(b.queryNode().Extend(` AND "key" = `, key, ` FOR UPDATE`).QueryRow(tx))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return node, err
+}
+
 // Set sets the value for a key
 func (b *SqlBackend) Set(key, value string, condition SetCondition) (*models.Node, *models.Node, error) {
 	return b.set(key, value, false, nil, condition)
@@ -273,7 +580,7 @@ func (b *SqlBackend) MkDir(key string, ttl *int64, condition SetCondition) (*mod
 }
 
 func (b *SqlBackend) readOnlyError() error {
-	index, err := b.currIndex(b.db)
+	index, err := b.currIndex(b.conn())
 	if err != nil {
 		return err
 	}
@@ -289,9 +596,13 @@ func (b *SqlBackend) set(key, value string, dir bool, ttl *int64, condition SetC
 	if err != nil {
 		return nil, nil, err
 	}
+	qt := &quotaTx{}
 	defer func() {
 		if err == nil {
 			err = tx.Commit()
+			if err == nil {
+				b.commitQuota(qt)
+			}
 		} else {
 			tx.Rollback()
 		}
@@ -302,19 +613,58 @@ func (b *SqlBackend) set(key, value string, dir bool, ttl *int64, condition SetC
 		return nil, nil, err
 	}
 
-	prevNode, err = b.getOne(tx, key)
+	return b.setTx(tx, qt, index, key, value, dir, ttl, condition)
+}
+
+// setTx sets the node for the key at the index, replacing or updating any
+// existing node depending on the condition. The change in usage is counted
+// in the transaction's quota usage.
+func (b *SqlBackend) setTx(tx *sql.Tx, qt *quotaTx, index int64, key, value string, dir bool, ttl *int64, condition SetCondition) (node *models.Node, prevNode *models.Node, err error) {
+	prevNode, err = b.getOneForUpdate(tx, key)
 	if err != nil {
 		return nil, nil, err
 	}
 
 	prevIndex := index - 1
 
+	// like etcd, compare-and-swap is never allowed on a directory, even
+	// before comparing
+	if isCompare(condition) && prevNode != nil && prevNode.Dir {
+		return nil, nil, models.NotAFile(key, prevIndex)
+	}
+
 	if err := condition.Check(key, prevIndex, prevNode); err != nil {
 		return nil, nil, err
 	}
 
+	update := isUpdate(condition) && prevNode != nil
+
 	if prevNode != nil && prevNode.Dir {
-		return nil, nil, models.NotAFile(key, prevIndex)
+		// an existing directory can only have its TTL updated
+		if !update || value != "" {
+			return nil, nil, models.NotAFile(key, prevIndex)
+		}
+	}
+
+	// updates modify the existing node, keeping its type and created index,
+	// instead of replacing it with a new node
+	created := index
+	if update {
+		dir = prevNode.Dir
+		created = prevNode.CreatedIndex
+	}
+
+	change := &Change{
+		Action:   condition.SetActionName(),
+		Index:    index,
+		Key:      key,
+		Value:    value,
+		Dir:      dir,
+		TTL:      ttl,
+		PrevNode: prevNode,
+	}
+	if err := b.beforeSet(tx, change); err != nil {
+		return nil, nil, err
 	}
 
 	err = b.mkdirs(tx, splitKey(key), index)
@@ -332,7 +682,12 @@ func (b *SqlBackend) set(key, value string, dir bool, ttl *int64, condition SetC
 		}
 	}
 
-	_, err = b.insertQuery(key, value, dir, index, ttl).Exec(tx)
+	_, err = b.insertQuery(key, value, dir, created, index, ttl).Exec(tx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = b.checkQuota(tx, prevIndex, qt, map[string]Usage{key: usageDelta(prevNode, value, dir)})
 	if err != nil {
 		return nil, nil, err
 	}
@@ -347,6 +702,11 @@ func (b *SqlBackend) set(key, value string, dir bool, ttl *int64, condition SetC
 		return nil, nil, err
 	}
 
+	change.Node = node
+	if err := b.afterSet(tx, change); err != nil {
+		return nil, nil, err
+	}
+
 	return node, prevNode, nil
 }
 
@@ -364,75 +724,193 @@ func (b *SqlBackend) recordChange(db Querier, index int64, action, key string, p
 		return
 	}
 
-	_, err = b.Query().Extend(`DELETE FROM changes WHERE "index" < `, index-MaxChanges).Exec(db)
+	if b.deferTrim {
+		return nil
+	}
+	_, err = b.trimHistory(db, index)
+	return
+}
+
+// Trimmed counts the rows removed by trimming the history
+type Trimmed struct {
+	Changes int64
+	Nodes   int64
+	Recycle int64
+}
+
+// trimHistory removes the changes and node versions older than the last
+// MaxChanges before the index, and expired deletes in the recycle bin.
+func (b *SqlBackend) trimHistory(db Querier, index int64) (t Trimmed, err error) {
+	res, err := b.Query().Extend(`DELETE FROM changes WHERE "index" < `, index-MaxChanges).Exec(db)
+	if err != nil {
+		return
+	}
+	if t.Changes, err = res.RowsAffected(); err != nil {
+		return
+	}
+
+	res, err = b.Query().Text(`DELETE FROM "recycle" WHERE "expiration" < ` + b.dialect.Now()).Exec(db)
 	if err != nil {
 		return
 	}
+	if t.Recycle, err = res.RowsAffected(); err != nil {
+		return
+	}
 
-	_, err = b.Query().Extend(`DELETE FROM "nodes" WHERE "deleted" > 0 AND "deleted" < `, index-MaxChanges).Exec(db)
+	// deleted nodes in the recycle bin are kept until it expires
+	res, err = b.Query().Extend(`DELETE FROM "nodes" WHERE "deleted" > 0 AND "deleted" < `, index-MaxChanges,
+		` AND "deleted" NOT IN (SELECT "index" FROM "recycle")`).Exec(db)
+	if err != nil {
+		return
+	}
+	t.Nodes, err = res.RowsAffected()
 	return
 }
 
-func (b *SqlBackend) insertQuery(key, value string, dir bool, index int64, ttl *int64) *Query {
+func (b *SqlBackend) insertQuery(key, value string, dir bool, created, index int64, ttl *int64) *Query {
 	pathDepth := pathDepth(key)
 	query := b.Query()
 	query.Text(`INSERT INTO nodes ("key", "value", "dir", "created", "modified", "path_depth"`)
+	if b.checksums {
+		query.Text(`, "checksum"`)
+	}
 	if ttl != nil {
 		query.Text(`, expiration`)
 	}
 	query.Extend(`) VALUES (`,
-		key, `, `, value, `, `, dir, `, `, index, `, `, index, `, `, pathDepth,
+		key, `, `, value, `, `, dir, `, `, created, `, `, index, `, `, pathDepth,
 	)
+	if b.checksums {
+		query.Extend(`, `, checksum(key, value))
+	}
 	if ttl != nil {
 		query.Text(`, `)
-		b.dialect.expiration(query, *ttl)
+		b.dialect.Expiration(query, *ttl)
 	}
 	query.Text(")")
 	return query
 }
 
+// mkdirs creates the directories of the path that don't exist yet with the
+// index. It reads the existing ones in one query and inserts the missing ones
+// in another, so deep keys don't cost a round trip per directory.
 func (b *SqlBackend) mkdirs(tx *sql.Tx, path string, index int64) error {
-	pathDepth := pathDepth(path)
+	var paths []string
 	for ; path != "/" && path != ""; path = splitKey(path) {
-		_, err := tx.Exec("SAVEPOINT mkdirs")
-		if err != nil {
-			return err
-		}
-		_, err = b.Query().Extend(`
-			INSERT INTO nodes ("key", "dir", "created", "modified", "path_depth")
-			VALUES (`, path, `, true, `, index, `, `, index, `, `, pathDepth, `)
-			`).Exec(tx)
-		if err != nil {
-			tx.Exec("ROLLBACK TO SAVEPOINT mkdirs")
-		}
-		if b.dialect.isDuplicateKeyError(err) {
-			var existingIsDir bool
-			err := b.Query().Extend(`SELECT dir FROM nodes WHERE "deleted" = 0 AND "key" = `, path).QueryRow(tx).Scan(&existingIsDir)
-			if err != nil {
-				return err
-			}
-			if !existingIsDir {
+		paths = append(paths, path)
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+
+	dirs, err := b.dirFlags(tx, paths)
+	if err != nil {
+		return err
+	}
+	// the paths are deepest first, and the ones above an existing directory
+	// exist too
+	for i, path := range paths {
+		if isDir, ok := dirs[path]; ok {
+			if !isDir {
 				// FIXME should this be previous index before the update?
 				return models.NotADirectory(path, index)
 			}
-			return nil
+			paths = paths[:i]
+			break
 		}
-		if err != nil {
-			return err
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+
+	query := b.Query().Text(`INSERT INTO nodes ("key", "dir", "created", "modified", "path_depth"`)
+	if b.checksums {
+		query.Text(`, "checksum"`)
+	}
+	query.Text(`) VALUES `)
+	for i, path := range paths {
+		if i > 0 {
+			query.Text(`, `)
+		}
+		query.Extend(`(`, path, `, true, `, index, `, `, index, `, `, pathDepth(path))
+		if b.checksums {
+			query.Extend(`, `, checksum(path, ""))
+		}
+		query.Text(`)`)
+	}
+	query.Text(` ` + b.dialect.IgnoreDuplicates())
+	res, err := query.Exec(tx)
+	if err != nil {
+		return err
+	}
+	inserted, err := res.RowsAffected()
+	if err != nil || int(inserted) == len(paths) {
+		return err
+	}
+
+	// some were created concurrently, which may not have been directories
+	dirs, err = b.dirFlags(tx, paths)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if !dirs[path] {
+			return models.NotADirectory(path, index)
 		}
-		pathDepth--
 	}
 	return nil
 }
 
-func (b *SqlBackend) CreateInOrder(key, value string, ttl *int64) (node *models.Node, err error) {
+// dirFlags returns whether each of the paths that exist is a directory
+func (b *SqlBackend) dirFlags(tx *sql.Tx, paths []string) (map[string]bool, error) {
+	query := b.Query().Text(`SELECT "key", "dir" FROM nodes WHERE "deleted" = 0 AND "key" IN (`)
+	for i, path := range paths {
+		if i > 0 {
+			query.Text(`, `)
+		}
+		query.Param(path)
+	}
+	rows, err := query.Text(`)`).Query(tx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	dirs := make(map[string]bool, len(paths))
+	for rows.Next() {
+		var key string
+		var dir bool
+		if err := rows.Scan(&key, &dir); err != nil {
+			return nil, err
+		}
+		dirs[key] = dir
+	}
+	return dirs, rows.Err()
+}
+
+// CreateInOrder creates a node with a unique key under the directory key,
+// creating the directory if needed. The condition is checked against the
+// directory, so that for example PrevExist(true) requires the directory to
+// already exist.
+//
+// The key is named after the index, or with the directory's sequence if
+// enabled with SetInOrderSequence.
+func (b *SqlBackend) CreateInOrder(key, value string, ttl *int64, condition SetCondition) (node *models.Node, err error) {
+	return b.createInOrder(key, value, ttl, condition, b.inOrderSequence)
+}
+
+func (b *SqlBackend) createInOrder(key, value string, ttl *int64, condition SetCondition, sequence bool) (node *models.Node, err error) {
 	tx, err := b.Begin()
 	if err != nil {
 		return nil, err
 	}
+	qt := &quotaTx{}
 	defer func() {
 		if err == nil {
 			err = tx.Commit()
+			if err == nil {
+				b.commitQuota(qt)
+			}
 		} else {
 			tx.Rollback()
 		}
@@ -443,9 +921,63 @@ func (b *SqlBackend) CreateInOrder(key, value string, ttl *int64) (node *models.
 		return nil, err
 	}
 
-	key = fmt.Sprintf("%s/%d", key, index)
+	dirNode, err := b.getOne(tx, key)
+	if err != nil {
+		return nil, err
+	}
+	if dirNode != nil && !dirNode.Dir {
+		return nil, models.NotADirectory(key, index-1)
+	}
+
+	if err := condition.Check(key, index-1, dirNode); err != nil {
+		return nil, err
+	}
+
+	err = b.mkdirs(tx, key, index)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := key
+	name := index
+	if sequence {
+		name, err = b.nextSequence(tx, dir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for {
+		key = fmt.Sprintf("%s/%d", strings.TrimSuffix(dir, "/"), name)
+		taken, err := b.getOne(tx, key)
+		if err != nil {
+			return nil, err
+		}
+		if taken == nil {
+			break
+		}
+		// keys can also be set by name, so the sequence skips the taken
+		// names
+		if !sequence {
+			return nil, models.KeyExists(key, index-1)
+		}
+		name, err = b.nextSequence(tx, dir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	change := &Change{Action: "create", Index: index, Key: key, Value: value, TTL: ttl}
+	if err := b.beforeSet(tx, change); err != nil {
+		return nil, err
+	}
+
+	_, err = b.insertQuery(key, value, false, index, index, ttl).Exec(tx)
+	if err != nil {
+		return nil, err
+	}
 
-	_, err = b.insertQuery(key, value, false, index, ttl).Exec(tx)
+	err = b.checkQuota(tx, index-1, qt, map[string]Usage{key: usageDelta(nil, value, false)})
 	if err != nil {
 		return nil, err
 	}
@@ -460,11 +992,25 @@ func (b *SqlBackend) CreateInOrder(key, value string, ttl *int64) (node *models.
 		return nil, err
 	}
 
+	change.Node = node
+	if err := b.afterSet(tx, change); err != nil {
+		return nil, err
+	}
+
 	return node, nil
 }
 
 // Delete removes the key
 func (b *SqlBackend) Delete(key string, condition DeleteCondition) (node *models.Node, index int64, err error) {
+	return b.delete(key, false, false, condition)
+}
+
+// RmDir removes the key for directories
+func (b *SqlBackend) RmDir(key string, recursive bool, condition DeleteCondition) (node *models.Node, index int64, err error) {
+	return b.delete(key, true, recursive, condition)
+}
+
+func (b *SqlBackend) delete(key string, dir, recursive bool, condition DeleteCondition) (node *models.Node, index int64, err error) {
 	if key == "/" {
 		return nil, 0, b.readOnlyError()
 	}
@@ -473,9 +1019,13 @@ func (b *SqlBackend) Delete(key string, condition DeleteCondition) (node *models
 	if err != nil {
 		return nil, 0, err
 	}
+	qt := &quotaTx{}
 	defer func() {
 		if err == nil {
 			err = tx.Commit()
+			if err == nil {
+				b.commitQuota(qt)
+			}
 		} else {
 			tx.Rollback()
 		}
@@ -486,102 +1036,150 @@ func (b *SqlBackend) Delete(key string, condition DeleteCondition) (node *models
 		return nil, 0, err
 	}
 
-	node, err = b.getOne(tx, key)
+	node, err = b.deleteTx(tx, qt, index, key, dir, recursive, condition, false)
 	if err != nil {
 		return nil, 0, err
 	}
 
-	prevIndex := index - 1
-
-	if node == nil {
-		return nil, 0, models.NotFound(key, prevIndex)
-	}
-	if node.Dir {
-		return nil, 0, models.NotAFile(key, prevIndex)
-	}
+	return node, index, nil
+}
 
-	if err := condition.Check(key, prevIndex, node); err != nil {
-		return nil, 0, err
+// DryRunDelete checks a delete like Delete or RmDir, and reports the keys that
+// would be removed, without removing them.
+func (b *SqlBackend) DryRunDelete(key string, dir, recursive bool, condition DeleteCondition) (*models.DryRun, error) {
+	if key == "/" {
+		return nil, b.readOnlyError()
 	}
 
-	_, err = b.Query().Extend(`
-		UPDATE "nodes" SET "deleted" = `, index,
-		` WHERE "key" = `, key, ` AND "deleted" = 0`).Exec(tx)
+	tx, err := b.Begin()
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
+	// never commit the delete
+	defer tx.Rollback()
 
-	err = b.recordChange(tx, index, condition.DeleteActionName(), key, node)
+	index, err := b.incrementIndex(tx)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
 
-	return node, index, nil
-}
-
-// RmDir removes the key for directories
-func (b *SqlBackend) RmDir(key string, recursive bool, condition DeleteCondition) (node *models.Node, index int64, err error) {
-	if key == "/" {
-		return nil, 0, b.readOnlyError()
+	node, err := b.deleteTx(tx, nil, index, key, dir, recursive, condition, true)
+	if err != nil {
+		return nil, err
 	}
 
-	tx, err := b.Begin()
+	rows, err := b.Query().Extend(`SELECT "key" FROM "nodes" WHERE "deleted" = `, index, ` ORDER BY "key"`).Query(tx)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
-	defer func() {
-		if err == nil {
-			err = tx.Commit()
-		} else {
-			tx.Rollback()
-		}
-	}()
+	defer rows.Close()
 
-	index, err = b.incrementIndex(tx)
-	if err != nil {
-		return nil, 0, err
+	keys := []string{}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &models.DryRun{
+		Action: condition.DeleteActionName(),
+		DryRun: true,
+		Node:   *node,
+		Count:  len(keys),
+		Keys:   keys,
+	}, nil
+}
 
+// deleteTx marks the node for the key, and any children, as deleted at the
+// index. Directories are only deleted with dir set, and only if empty unless
+// recursive is set. Unless it is a dryRun, recursive deletes are kept in the
+// recycle bin, if it is enabled, and the deleted keys are counted in the
+// transaction's quota usage. The hooks are told whether it is a dryRun.
+func (b *SqlBackend) deleteTx(tx *sql.Tx, qt *quotaTx, index int64, key string, dir, recursive bool, condition DeleteCondition, dryRun bool) (*models.Node, error) {
 	// use the previous index in any errors
 	prevIndex := index - 1
 
-	node, err = b.getOne(tx, key)
+	node, err := b.getOneForUpdate(tx, key)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
 
 	if node == nil {
-		return nil, 0, models.NotFound(key, prevIndex)
+		return nil, models.NotFound(key, prevIndex)
+	}
+
+	// like etcd, a conditional delete only applies to files, even when
+	// deleting recursively
+	if node.Dir && (!dir || isCompare(condition)) {
+		return nil, models.NotAFile(key, prevIndex)
 	}
 
 	if err := condition.Check(key, prevIndex, node); err != nil {
-		return nil, 0, err
+		return nil, err
+	}
+
+	change := &Change{
+		Action:    condition.DeleteActionName(),
+		Index:     index,
+		Key:       key,
+		Dir:       dir,
+		Recursive: recursive,
+		DryRun:    dryRun,
+		PrevNode:  node,
+	}
+	if err := b.beforeDelete(tx, change); err != nil {
+		return nil, err
 	}
 
 	query := b.Query().Extend(`
 		UPDATE nodes SET deleted = `, index,
-		` WHERE deleted = 0 AND ("key" = `, key, ` OR "key" LIKE `, key+"/%", `)`)
+		` WHERE deleted = 0 AND ("key" = `, key, ` OR "key" LIKE `, likePrefix(key), `)`)
 	res, err := query.Exec(tx)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
 
 	if !recursive {
 		rowsDeleted, err := res.RowsAffected()
 		if err != nil {
-			return nil, 0, err
+			return nil, err
 		}
 		if rowsDeleted > 1 {
-			return nil, 0, models.DirectoryNotEmpty(key, prevIndex)
+			return nil, models.DirectoryNotEmpty(key, prevIndex)
 		}
 	}
 
 	err = b.recordChange(tx, index, condition.DeleteActionName(), key, node)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
 
-	return node, index, nil
+	if !dryRun {
+		if recursive && b.recycleGrace > 0 {
+			if err := b.recycle(tx, index, key); err != nil {
+				return nil, err
+			}
+		}
+		b.quotaDeleted(qt, node)
+		if err := b.afterDelete(tx, change); err != nil {
+			return nil, err
+		}
+	}
+
+	return node, nil
+}
+
+// CleanKey canonicalizes a key like etcd does: it has a leading slash and no
+// trailing one, and duplicate slashes and . and .. elements are removed, so
+// that /foo and /foo/ are the same node. The keys of requests are cleaned with
+// it before they reach the store, which expects them cleaned.
+func CleanKey(key string) string {
+	return path.Clean("/" + key)
 }
 
 func splitKey(key string) string {
@@ -598,13 +1196,34 @@ func splitKey(key string) string {
 	return key[:i]
 }
 
+// likePrefix returns a LIKE pattern matching all keys under the key. Any
+// wildcard characters in the key itself are escaped so they match literally.
+func likePrefix(key string) string {
+	key = strings.Replace(key, `\`, `\\`, -1)
+	key = strings.Replace(key, `%`, `\%`, -1)
+	key = strings.Replace(key, `_`, `\_`, -1)
+	return key + "/%"
+}
+
+// CurrentIndex returns the index of the last change
+func (b *SqlBackend) CurrentIndex() (int64, error) {
+	return b.currIndex(b.conn())
+}
+
 func (b *SqlBackend) currIndex(db Querier) (index int64, err error) {
 	err = db.QueryRow(`SELECT "index" FROM "index"`).Scan(&index)
+	if err == nil {
+		b.observeIndex(index)
+	}
 	return
 }
 
 func (b *SqlBackend) incrementIndex(db Querier) (index int64, err error) {
-	return b.dialect.incrementIndex(db)
+	index, err = b.dialect.IncrementIndex(db)
+	if err == nil {
+		b.observeIndex(index)
+	}
+	return
 }
 
 func pathDepth(key string) int {
@@ -7,6 +7,8 @@ import (
 	"path/filepath"
 	"reflect"
 	"runtime"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 
@@ -41,10 +43,27 @@ func testConn(t *testing.T) *SqlBackend {
 }
 
 func currIndex(store *SqlBackend) int64 {
-	index, _ := store.currIndex(store.db)
+	index, _ := store.currIndex(store.conn())
 	return index
 }
 
+func Test_CleanKey(t *testing.T) {
+	for key, expected := range map[string]string{
+		"":           "/",
+		"/":          "/",
+		"foo":        "/foo",
+		"/foo/":      "/foo",
+		"//foo//bar": "/foo/bar",
+		"/foo/./bar": "/foo/bar",
+		"/foo/../..": "/",
+		"/a b/c?d":   "/a b/c?d",
+		"/100%":      "/100%",
+		"/日本語/":      "/日本語",
+	} {
+		equals(t, expected, CleanKey(key))
+	}
+}
+
 func TestGetMissingReturnsNotFound(t *testing.T) {
 	store := testConn(t)
 	defer store.Close()
@@ -219,7 +238,7 @@ func TestSet_ErrorIndex(t *testing.T) {
 	store := testConn(t)
 	defer store.Close()
 
-	origIndex, err := store.incrementIndex(store.db)
+	origIndex, err := store.incrementIndex(store.conn())
 	ok(t, err)
 
 	// ensure the index update is persisted
@@ -322,6 +341,71 @@ func TestSet_PrevValue_Fail_ValueMismatch(t *testing.T) {
 	expectError(t, "Compare failed", "[different value != original]", err)
 }
 
+func TestSet_PrevValue_Concurrent(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	_, _, err := store.Set("/foo", "original", Always)
+	ok(t, err)
+
+	// only one of the swaps from the same value succeeds
+	const writers = 10
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		go func(i int) {
+			_, _, err := store.Set("/foo", strconv.Itoa(i), PrevValue("original"))
+			errs <- err
+		}(i)
+	}
+	swapped := 0
+	for i := 0; i < writers; i++ {
+		if err := <-errs; err == nil {
+			swapped++
+		} else if e, ok := err.(models.Error); !ok || e.ErrorCode != 101 {
+			fatalf(t, "unexpected error: %v", err)
+		}
+	}
+	equals(t, 1, swapped)
+}
+
+func TestSet_PrevIndex_ConcurrentIncrements(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	_, _, err := store.Set("/counter", "0", Always)
+	ok(t, err)
+
+	// retrying compare-and-swaps lose no increments
+	const writers, increments = 5, 10
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for done := 0; done < increments; {
+				node, err := store.Get("/counter", false)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				n, _ := strconv.Atoi(node.Value)
+				_, _, err = store.Set("/counter", strconv.Itoa(n+1), PrevIndex(node.ModifiedIndex))
+				if err == nil {
+					done++
+				} else if e, ok := err.(models.Error); !ok || e.ErrorCode != 101 {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	node, err := store.Get("/counter", false)
+	ok(t, err)
+	equals(t, strconv.Itoa(writers*increments), node.Value)
+}
+
 func TestSet_PrevIndex_Success(t *testing.T) {
 	store := testConn(t)
 	defer store.Close()
@@ -362,7 +446,7 @@ func TestDelete_ErrorIndex(t *testing.T) {
 	store := testConn(t)
 	defer store.Close()
 
-	origIndex, err := store.incrementIndex(store.db)
+	origIndex, err := store.incrementIndex(store.conn())
 	ok(t, err)
 
 	// ensure the index update is persisted
@@ -444,6 +528,23 @@ func TestDelete_PrevIndex_Fail_IndexMismatch(t *testing.T) {
 	expectError(t, "Compare failed", "[100 != 1]", err)
 }
 
+func TestDelete_RecordsDeleteAction(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	_, _, err := store.Set("/foo", "original", Always)
+	ok(t, err)
+
+	_, index, err := store.Delete("/foo", Always)
+	ok(t, err)
+
+	var action string
+	err = store.Query().Extend(`SELECT "action" FROM "changes" WHERE "index" = `, index).
+		QueryRow(store.db).Scan(&action)
+	ok(t, err)
+	equals(t, "delete", action)
+}
+
 func Test_CreateDirectory_Simple(t *testing.T) {
 	store := testConn(t)
 	defer store.Close()
@@ -495,6 +596,119 @@ func Test_CreateDirectory_IfNotExist(t *testing.T) {
 	expectError(t, "Key already exists", "/foo", err)
 }
 
+func Test_MkDir_UpdateTTL(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	_, _, err := store.Set("/foo/bar", "value", Always)
+	ok(t, err)
+
+	dir, err := store.Get("/foo", false)
+	ok(t, err)
+
+	ttl := int64(100)
+	node, prevNode, err := store.MkDir("/foo", &ttl, PrevExist(true))
+	ok(t, err)
+
+	equals(t, true, node.Dir)
+	equals(t, ttl, *node.TTL)
+	equals(t, dir.CreatedIndex, node.CreatedIndex)
+	equals(t, true, prevNode.Dir)
+
+	// updating the directory keeps its children
+	node, err = store.Get("/foo", false)
+	ok(t, err)
+	equals(t, 1, len(node.Nodes))
+	equals(t, "value", node.Nodes[0].Value)
+}
+
+func Test_MkDir_RemoveTTL(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	ttl := int64(100)
+	_, _, err := store.MkDir("/foo", &ttl, Always)
+	ok(t, err)
+
+	node, _, err := store.MkDir("/foo", nil, PrevExist(true))
+	ok(t, err)
+
+	if node.TTL != nil {
+		fatalf(t, "expected TTL to be removed, but got %d", *node.TTL)
+	}
+}
+
+func Test_MkDir_PrevExist_Missing(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	_, _, err := store.MkDir("/foo", nil, PrevExist(true))
+	expectError(t, "Key not found", "/foo", err)
+}
+
+func Test_MkDir_PrevIndex_Dir(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	dir, _, err := store.MkDir("/foo", nil, Always)
+	ok(t, err)
+
+	// etcd doesn't allow compare and swap on directories, even if the
+	// comparison would succeed
+	_, _, err = store.MkDir("/foo", nil, PrevIndex(dir.ModifiedIndex))
+	expectError(t, "Not a file", "/foo", err)
+
+	_, _, err = store.MkDir("/foo", nil, PrevIndex(100))
+	expectError(t, "Not a file", "/foo", err)
+}
+
+func Test_MkDir_PrevIndex_Missing(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	_, _, err := store.MkDir("/foo", nil, PrevIndex(1))
+	expectError(t, "Key not found", "/foo", err)
+}
+
+func Test_Set_PrevValue_Dir(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	_, _, err := store.MkDir("/foo", nil, Always)
+	ok(t, err)
+
+	_, _, err = store.Set("/foo", "value", PrevValue(""))
+	expectError(t, "Not a file", "/foo", err)
+}
+
+func Test_Set_PrevExist_DirWithValue(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	_, _, err := store.MkDir("/foo", nil, Always)
+	ok(t, err)
+
+	_, _, err = store.Set("/foo", "value", PrevExist(true))
+	expectError(t, "Not a file", "/foo", err)
+}
+
+func Test_Set_Update_KeepsCreatedIndex(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	original, _, err := store.Set("/foo", "original", Always)
+	ok(t, err)
+
+	node, _, err := store.Set("/foo", "updated", PrevValue("original"))
+	ok(t, err)
+	equals(t, original.CreatedIndex, node.CreatedIndex)
+
+	// replacing the node with a plain set creates a new node
+	node, _, err = store.Set("/foo", "replaced", Always)
+	ok(t, err)
+	equals(t, node.ModifiedIndex, node.CreatedIndex)
+}
+
 func Test_Get_ListDirectory(t *testing.T) {
 	store := testConn(t)
 	defer store.Close()
@@ -639,6 +853,48 @@ func Test_Set_DoesNotOverwriteParentFile(t *testing.T) {
 	expectError(t, "Not a directory", "/foo", err)
 }
 
+func Test_Set_CreatesMissingParentsUnderExisting(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	_, _, err := store.Set("/registry/pods/a", "value", Always)
+	ok(t, err)
+	node, _, err := store.Set("/registry/pods/ns/name/c", "value", Always)
+	ok(t, err)
+
+	ns, err := store.Get("/registry/pods/ns", false)
+	ok(t, err)
+	equals(t, true, ns.Dir)
+	equals(t, node.ModifiedIndex, ns.CreatedIndex)
+	equals(t, 1, len(ns.Nodes))
+	equals(t, "/registry/pods/ns/name", ns.Nodes[0].Key)
+
+	pods, err := store.Get("/registry/pods", false)
+	ok(t, err)
+	equals(t, 2, len(pods.Nodes))
+
+	_, _, err = store.Set("/registry/pods/a/b/c", "value", Always)
+	expectError(t, "Not a directory", "/registry/pods/a", err)
+}
+
+func Test_MkDir_ReplacesFile(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	file, _, err := store.Set("/foo", "bar", Always)
+	ok(t, err)
+
+	// like etcd, the file is replaced and returned as the previous node
+	node, prevNode, err := store.MkDir("/foo", nil, Always)
+	ok(t, err)
+	equals(t, true, node.Dir)
+	equals(t, file, prevNode)
+
+	// but a directory isn't
+	_, _, err = store.MkDir("/foo", nil, Always)
+	expectError(t, "Not a file", "/foo", err)
+}
+
 func Test_MkDir_DoesNotOverwriteParentFile(t *testing.T) {
 	store := testConn(t)
 	defer store.Close()
@@ -735,7 +991,7 @@ func Test_RmDir_ErrorIndex(t *testing.T) {
 	store := testConn(t)
 	defer store.Close()
 
-	origIndex, err := store.incrementIndex(store.db)
+	origIndex, err := store.incrementIndex(store.conn())
 	ok(t, err)
 
 	// ensure the index update is persisted
@@ -751,6 +1007,124 @@ func Test_RmDir_ErrorIndex(t *testing.T) {
 	equals(t, origIndex, err.(models.Error).Index)
 }
 
+func Test_DryRunDelete_Recursive(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	_, _, err := store.Set("/foo/bar", "value", Always)
+	ok(t, err)
+	_, _, err = store.Set("/foo/baz/qux", "value", Always)
+	ok(t, err)
+
+	origIndex := currIndex(store)
+
+	res, err := store.DryRunDelete("/foo", true, true, Always)
+	ok(t, err)
+	equals(t, "delete", res.Action)
+	equals(t, true, res.DryRun)
+	equals(t, "/foo", res.Node.Key)
+	equals(t, 4, res.Count)
+	equals(t, []string{"/foo", "/foo/bar", "/foo/baz", "/foo/baz/qux"}, res.Keys)
+
+	// nothing is removed, and the index is unchanged
+	equals(t, origIndex, currIndex(store))
+
+	node, err := store.Get("/foo/baz/qux", false)
+	ok(t, err)
+	equals(t, "value", node.Value)
+}
+
+func Test_DryRunDelete_Errors(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	_, _, err := store.Set("/foo/bar", "value", Always)
+	ok(t, err)
+
+	_, err = store.DryRunDelete("/foo", true, false, Always)
+	expectError(t, "Directory not empty", "/foo", err)
+
+	_, err = store.DryRunDelete("/foo", false, false, Always)
+	expectError(t, "Not a file", "/foo", err)
+
+	_, err = store.DryRunDelete("/missing", false, false, Always)
+	expectError(t, "Key not found", "/missing", err)
+
+	_, err = store.DryRunDelete("/foo/bar", false, false, PrevValue("wrong"))
+	expectError(t, "Compare failed", "[wrong != value]", err)
+
+	res, err := store.DryRunDelete("/foo/bar", false, false, PrevValue("value"))
+	ok(t, err)
+	equals(t, "compareAndDelete", res.Action)
+	equals(t, []string{"/foo/bar"}, res.Keys)
+}
+
+func Test_GetAt_PreviousValues(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	first, _, err := store.Set("/foo", "first", Always)
+	ok(t, err)
+	second, _, err := store.Set("/foo", "second", Always)
+	ok(t, err)
+	_, deleted, err := store.Delete("/foo", Always)
+	ok(t, err)
+
+	node, err := store.GetAt("/foo", false, first.ModifiedIndex)
+	ok(t, err)
+	equals(t, "first", node.Value)
+
+	node, err = store.GetAt("/foo", false, second.ModifiedIndex)
+	ok(t, err)
+	equals(t, "second", node.Value)
+
+	_, err = store.GetAt("/foo", false, deleted)
+	expectError(t, "Key not found", "/foo", err)
+
+	_, err = store.GetAt("/foo", false, first.ModifiedIndex-1)
+	expectError(t, "Key not found", "/foo", err)
+}
+
+func Test_GetAt_Recursive(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	first, _, err := store.Set("/dir/a", "1", Always)
+	ok(t, err)
+	_, _, err = store.Set("/dir/b", "2", Always)
+	ok(t, err)
+	_, _, err = store.RmDir("/dir", true, Always)
+	ok(t, err)
+
+	node, err := store.GetAt("/dir", true, first.ModifiedIndex)
+	ok(t, err)
+	equals(t, 1, len(node.Nodes))
+	equals(t, "/dir/a", node.Nodes[0].Key)
+}
+
+func Test_GetAt_OutsideRetention(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	node, _, err := store.Set("/foo", "bar", Always)
+	ok(t, err)
+
+	_, err = store.GetAt("/foo", false, node.ModifiedIndex+1)
+	expectError(t, "Invalid field", fmt.Sprintf("atIndex %d is after the current index %d", node.ModifiedIndex+1, node.ModifiedIndex), err)
+
+	_, err = store.Query().Extend(`UPDATE "index" SET "index" = `, node.ModifiedIndex+MaxChanges).Exec(store.conn())
+	ok(t, err)
+
+	_, err = store.GetAt("/foo", false, node.ModifiedIndex)
+	ok(t, err)
+
+	_, err = store.Query().Extend(`UPDATE "index" SET "index" = `, node.ModifiedIndex+MaxChanges+1).Exec(store.conn())
+	ok(t, err)
+
+	_, err = store.GetAt("/foo", false, node.ModifiedIndex)
+	equals(t, 401, err.(models.Error).ErrorCode)
+}
+
 func Test_TTL_SetsExpiration(t *testing.T) {
 	store := testConn(t)
 	defer store.Close()
@@ -826,6 +1200,10 @@ func Test_TTL_SetThenClear(t *testing.T) {
 	}
 }
 
+// ttlSecond is long enough for a second of a TTL to pass. Expirations are
+// stored with fractional seconds, so a little over a second is enough.
+const ttlSecond = 1100 * time.Millisecond
+
 func Test_TTL_CountsDown(t *testing.T) {
 	store := testConn(t)
 	defer store.Close()
@@ -837,9 +1215,7 @@ func Test_TTL_CountsDown(t *testing.T) {
 	ok(t, err)
 	equals(t, int64(100), *node.TTL)
 
-	// MySQL only stores to 1-second precision, so sleep long enough
-	// to make sure there's no chance of truncation error
-	time.Sleep(2 * time.Second)
+	time.Sleep(ttlSecond)
 
 	node, err = store.Get("/foo", false)
 	ok(t, err)
@@ -860,9 +1236,7 @@ func Test_TTL_NodeExpires(t *testing.T) {
 	ok(t, err)
 	equals(t, int64(1), *node.TTL)
 
-	// MySQL only stores to 1-second precision, so sleep long enough
-	// to make sure there's no chance of truncation error
-	time.Sleep(2 * time.Second)
+	time.Sleep(ttlSecond)
 
 	_, err = store.Get("/foo", false)
 	expectError(t, "Key not found", "/foo", err)
@@ -882,9 +1256,7 @@ func Test_TTL_DirExpiresEmpty(t *testing.T) {
 	equals(t, int64(1), *node.TTL)
 	equals(t, true, node.Dir)
 
-	// MySQL only stores to 1-second precision, so sleep long enough
-	// to make sure there's no chance of truncation error
-	time.Sleep(2 * time.Second)
+	time.Sleep(ttlSecond)
 
 	_, err = store.Get("/foo", false)
 	expectError(t, "Key not found", "/foo", err)
@@ -906,26 +1278,39 @@ func Test_TTL_DirExpiresChildren(t *testing.T) {
 	ok(t, err)
 	equals(t, "bar", node.Value)
 
-	// MySQL only stores to 1-second precision, so sleep long enough
-	// to make sure there's no chance of truncation error
-	time.Sleep(2 * time.Second)
+	time.Sleep(ttlSecond)
 
 	_, err = store.Get("/foo/bar", false)
 	expectError(t, "Key not found", "/foo/bar", err)
 }
 
+func Test_Get_RecursiveWildcardCharacters(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	_, _, err := store.Set("/a_c/x", "1", Always)
+	ok(t, err)
+	_, _, err = store.Set("/abc/y", "2", Always)
+	ok(t, err)
+
+	node, err := store.Get("/a_c", true)
+	ok(t, err)
+	equals(t, 1, len(node.Nodes))
+	equals(t, "/a_c/x", node.Nodes[0].Key)
+}
+
 func Test_CreateInOrder(t *testing.T) {
 	store := testConn(t)
 	defer store.Close()
 
-	node1, err := store.CreateInOrder("/foo", "value", nil)
+	node1, err := store.CreateInOrder("/foo", "value", nil, Always)
 	ok(t, err)
 
 	equals(t, int64(1), node1.CreatedIndex)
 	equals(t, "/foo/1", node1.Key)
 	equals(t, "value", node1.Value)
 
-	node2, err := store.CreateInOrder("/foo", "value", nil)
+	node2, err := store.CreateInOrder("/foo", "value", nil, Always)
 	ok(t, err)
 
 	equals(t, int64(2), node2.CreatedIndex)
@@ -938,7 +1323,7 @@ func Test_CreateInOrder_TTL(t *testing.T) {
 	defer store.Close()
 
 	ttl := int64(100)
-	node, err := store.CreateInOrder("/foo", "value", &ttl)
+	node, err := store.CreateInOrder("/foo", "value", &ttl, Always)
 	ok(t, err)
 
 	equals(t, "/foo/1", node.Key)
@@ -948,6 +1333,162 @@ func Test_CreateInOrder_TTL(t *testing.T) {
 	}
 }
 
+func Test_CreateInOrder_CreatesDir(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	_, err := store.CreateInOrder("/foo/bar", "value", nil, Always)
+	ok(t, err)
+
+	node, err := store.Get("/foo/bar", false)
+	ok(t, err)
+	equals(t, true, node.Dir)
+	equals(t, int64(1), node.CreatedIndex)
+
+	node, err = store.Get("/foo", false)
+	ok(t, err)
+	equals(t, true, node.Dir)
+}
+
+func Test_CreateInOrder_UnderFile(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	_, _, err := store.Set("/foo", "file", Always)
+	ok(t, err)
+
+	_, err = store.CreateInOrder("/foo", "value", nil, Always)
+	equals(t, models.NotADirectory("/foo", 1), err)
+
+	_, err = store.CreateInOrder("/foo/bar", "value", nil, Always)
+	equals(t, models.NotADirectory("/foo", 2), err)
+}
+
+func Test_CreateInOrder_Condition(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	_, err := store.CreateInOrder("/foo", "value", nil, PrevExist(true))
+	equals(t, models.NotFound("/foo", 0), err)
+
+	_, _, err = store.MkDir("/foo", nil, Always)
+	ok(t, err)
+
+	node, err := store.CreateInOrder("/foo", "value", nil, PrevExist(true))
+	ok(t, err)
+	equals(t, "/foo/2", node.Key)
+
+	_, err = store.CreateInOrder("/foo", "value", nil, PrevIndex(1))
+	ok(t, err)
+
+	_, err = store.CreateInOrder("/foo", "value", nil, PrevIndex(2))
+	equals(t, models.CompareFailed(int64(2), int64(1), 3), err)
+}
+
+func Test_CreateInOrder_Sequence(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+	store.SetInOrderSequence(true)
+
+	node, err := store.CreateInOrder("/a", "1", nil, Always)
+	ok(t, err)
+	equals(t, "/a/1", node.Key)
+	equals(t, int64(1), node.CreatedIndex)
+
+	node, err = store.CreateInOrder("/b", "1", nil, Always)
+	ok(t, err)
+	equals(t, "/b/1", node.Key)
+
+	node, err = store.CreateInOrder("/a", "2", nil, Always)
+	ok(t, err)
+	equals(t, "/a/2", node.Key)
+	equals(t, int64(3), node.CreatedIndex)
+
+	// names aren't reused after the directory is deleted
+	_, _, err = store.RmDir("/a", true, Always)
+	ok(t, err)
+	node, err = store.CreateInOrder("/a", "3", nil, Always)
+	ok(t, err)
+	equals(t, "/a/3", node.Key)
+}
+
+func Test_CreateInOrder_SkipsTakenNames(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+	store.SetInOrderSequence(true)
+
+	_, _, err := store.Set("/a/1", "taken", Always)
+	ok(t, err)
+
+	node, err := store.CreateInOrder("/a", "value", nil, Always)
+	ok(t, err)
+	equals(t, "/a/2", node.Key)
+}
+
+func Test_CreateInOrder_NameTaken(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	_, _, err := store.Set("/a/2", "taken", Always)
+	ok(t, err)
+
+	_, err = store.CreateInOrder("/a", "value", nil, Always)
+	equals(t, models.KeyExists("/a/2", 1), err)
+}
+
+func Test_Exists(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	_, _, err := store.Set("/dir/foo", "bar", Always)
+	ok(t, err)
+
+	ok(t, store.Exists("/"))
+	ok(t, store.Exists("/dir"))
+	ok(t, store.Exists("/dir/foo"))
+	equals(t, models.NotFound("/missing", 1), store.Exists("/missing"))
+
+	_, _, err = store.Delete("/dir/foo", Always)
+	ok(t, err)
+	equals(t, models.NotFound("/dir/foo", 2), store.Exists("/dir/foo"))
+}
+
+func Test_Exists_Expired(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	_, _, err := store.SetTTL("/foo", "bar", -1, Always)
+	ok(t, err)
+
+	expectError(t, "Key not found", "/foo", store.Exists("/foo"))
+}
+
+func Test_ReadNode_LeavesOutUnpurged(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	_, _, err := store.Set("/other", "baz", Always)
+	ok(t, err)
+	_, _, err = store.SetTTL("/foo", "bar", -1, Always)
+	ok(t, err)
+
+	// read without purging first
+	tx, err := store.conn().Begin()
+	ok(t, err)
+	defer tx.Rollback()
+
+	_, err = store.readNode(tx, "/foo", false, 0)
+	expectError(t, "Key not found", "/foo", err)
+	root, err := store.readNode(tx, "/", true, 0)
+	ok(t, err)
+	equals(t, 1, len(root.Nodes))
+
+	// unless expirations are frozen after a clock jump
+	store.clock.frozenUntil = time.Now().Add(time.Minute)
+	_, err = store.readNode(tx, "/foo", false, 0)
+	ok(t, err)
+}
+
 func fatalf(tb testing.TB, format string, args ...interface{}) {
 	fatalfLvl(1, tb, format, args...)
 }
@@ -985,3 +1526,60 @@ func equals(tb testing.TB, exp, act interface{}) {
 		fatalfLvl(1, tb, "\n\n\texp: %#v\n\n\tgot: %#v", exp, act)
 	}
 }
+
+func Test_GetConsistent(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	_, _, err := store.Set("/foo", "bar", Always)
+	ok(t, err)
+	set, _, err := store.Set("/foo", "baz", Always)
+	ok(t, err)
+
+	node, index, err := store.GetConsistent("/foo", false)
+	ok(t, err)
+	equals(t, "baz", node.Value)
+	equals(t, set.ModifiedIndex, index)
+}
+
+func Test_GetConsistent_NotFound(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	_, _, err := store.GetConsistent("/foo", false)
+	expectError(t, "Key not found", "/foo", err)
+}
+
+func Test_Reconnect(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	_, _, err := store.Set("/foo", "bar", Always)
+	ok(t, err)
+	old := store.conn()
+
+	ok(t, store.Reconnect(dbDataSource))
+	if store.conn() == old {
+		t.Fatal("expected new connections")
+	}
+
+	node, err := store.Get("/foo", false)
+	ok(t, err)
+	equals(t, "bar", node.Value)
+}
+
+func Test_Reconnect_FailureKeepsConnections(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+	old := store.conn()
+
+	badDataSource := dbDataSource + " user=missing"
+	if dbDriver == "mysql" {
+		badDataSource = "missing@/etcd_test"
+	}
+	err := store.Reconnect(badDataSource)
+	if err == nil {
+		t.Fatal("expected a connection error")
+	}
+	equals(t, old, store.conn())
+}
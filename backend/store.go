@@ -0,0 +1,51 @@
+package backend
+
+import (
+	"time"
+
+	"github.com/rancher/etcdb/models"
+)
+
+// A Store is what the REST operations read and write the keys with.
+// SqlBackend is the Store of the server, but the operations can be reused
+// with another implementation, like a fake in tests or a wrapper adding
+// checks. The conditions and results are the ones of SqlBackend's methods.
+type Store interface {
+	// CurrentIndex returns the index of the last change
+	CurrentIndex() (int64, error)
+	// IndexBefore returns the index of the last change older than the age
+	IndexBefore(age time.Duration) (int64, error)
+
+	Exists(key string) error
+	// GetReplica may read from a replica, and returns the index read at
+	GetReplica(key string, recursive bool) (*models.Node, int64, error)
+	// GetConsistent reads from the primary, and returns the current index
+	GetConsistent(key string, recursive bool) (*models.Node, int64, error)
+	// GetAt reads the node as of a past index
+	GetAt(key string, recursive bool, index int64) (*models.Node, error)
+
+	Set(key, value string, condition SetCondition) (*models.Node, *models.Node, error)
+	SetTTL(key, value string, ttl int64, condition SetCondition) (*models.Node, *models.Node, error)
+	MkDir(key string, ttl *int64, condition SetCondition) (*models.Node, *models.Node, error)
+	CreateInOrder(key, value string, ttl *int64, condition SetCondition) (*models.Node, error)
+	BulkSet(values map[string]BulkValue) (*models.BulkResult, error)
+	Delete(key string, condition DeleteCondition) (*models.Node, int64, error)
+	RmDir(key string, recursive bool, condition DeleteCondition) (*models.Node, int64, error)
+	DryRunDelete(key string, dir, recursive bool, condition DeleteCondition) (*models.DryRun, error)
+	Txn(ops []TxnOp) ([]*models.ActionUpdate, error)
+	// QuotaWarning describes the usage if it is close to the quota of the
+	// store, or of a prefix the written keys are under
+	QuotaWarning(keys ...string) string
+
+	Recycled() ([]*models.RecycledDelete, error)
+	Restore(deleted int64) (*models.Node, error)
+	Compact(index int64) (*models.Compaction, error)
+	KeyspaceUsage() (*models.Keyspace, error)
+
+	GetSubscription(name string) (*Subscription, error)
+	SaveSubscription(name string, sub *Subscription) error
+	SetSubscriptionCursor(name string, nextIndex int64) error
+	DeleteSubscription(name string) error
+}
+
+var _ Store = (*SqlBackend)(nil)
@@ -0,0 +1,271 @@
+package backend
+
+import (
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/rancher/etcdb/models"
+)
+
+// WatchKey is a key watched by a Subscription
+type WatchKey struct {
+	Key       string `json:"key"`
+	Recursive bool   `json:"recursive"`
+}
+
+// A Subscription requests every change to any of its keys from SinceIndex
+// onwards. Unlike a key watch, all of the matching changes already available
+// are returned together.
+type Subscription struct {
+	Keys []WatchKey `json:"keys"`
+	// SinceIndex is the first index to return changes for. If it is 0, only
+	// changes after the subscription starts are returned.
+	SinceIndex int64 `json:"sinceIndex"`
+	// Actions limits the changes to the listed action names, if set.
+	Actions []string `json:"actions,omitempty"`
+	// KeyGlob limits the changes to keys matching the pattern, if set, with
+	// the syntax of path.Match. A * doesn't match across a /.
+	KeyGlob string `json:"keyGlob,omitempty"`
+	// ValueRegex limits the changes to values matching the regular
+	// expression, if set. For deletes and expirations, the previous value is
+	// matched.
+	ValueRegex string `json:"valueRegex,omitempty"`
+
+	valueRegexp *regexp.Regexp
+}
+
+// Validate checks the subscription's keys and filters, cleans the keys, and
+// compiles the value filter. It must be called before watching with the
+// subscription.
+func (s *Subscription) Validate() error {
+	if len(s.Keys) == 0 {
+		return models.InvalidField("keys required")
+	}
+	for i, k := range s.Keys {
+		if !strings.HasPrefix(k.Key, "/") {
+			return models.InvalidField("keys must start with /: " + k.Key)
+		}
+		s.Keys[i].Key = CleanKey(k.Key)
+	}
+	if s.KeyGlob != "" {
+		if _, err := path.Match(s.KeyGlob, ""); err != nil {
+			return models.InvalidField("invalid keyGlob: " + s.KeyGlob)
+		}
+	}
+	s.valueRegexp = nil
+	if s.ValueRegex != "" {
+		re, err := regexp.Compile(s.ValueRegex)
+		if err != nil {
+			return models.InvalidField("invalid valueRegex: " + err.Error())
+		}
+		s.valueRegexp = re
+	}
+	return nil
+}
+
+// Match checks if the change is one requested by the subscription, before
+// its value is known
+func (s *Subscription) Match(c *change) bool {
+	if c.Index < s.SinceIndex {
+		return false
+	}
+	if len(s.Actions) > 0 {
+		found := false
+		for _, action := range s.Actions {
+			if action == c.Action {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if s.KeyGlob != "" {
+		if matched, _ := path.Match(s.KeyGlob, c.Key); !matched {
+			return false
+		}
+	}
+	for _, k := range s.Keys {
+		w := watch{Key: k.Key, Recursive: k.Recursive}
+		if w.Match(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchValue checks if the change's value is one requested by the
+// subscription
+func (s *Subscription) MatchValue(action *models.ActionUpdate) bool {
+	if s.valueRegexp == nil {
+		return true
+	}
+	value := action.Node.Value
+	switch action.Action {
+	case "delete", "compareAndDelete", "expire":
+		if action.PrevNode != nil {
+			value = action.PrevNode.Value
+		}
+	}
+	return s.valueRegexp.MatchString(value)
+}
+
+type subscriptionResult struct {
+	Batch *models.WatchBatch
+	Err   error
+}
+
+type subscription struct {
+	*Subscription
+	result chan subscriptionResult
+}
+
+func (s *subscription) SetResult(batch *models.WatchBatch, err error) {
+	select {
+	case s.result <- subscriptionResult{batch, err}:
+	default:
+		// drop duplicate results
+	}
+}
+
+// Subscribe waits for changes matching the subscription, and returns a batch
+// with all of them. After the timeout, an empty batch is returned. A negative
+// timeout waits forever.
+func (cw *ChangeWatcher) Subscribe(sub *Subscription, timeout time.Duration) (*models.WatchBatch, error) {
+	s := &subscription{sub, make(chan subscriptionResult, 1)}
+	cw.subscribe <- s
+
+	var deadline <-chan time.Time
+	if timeout >= 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	select {
+	case res := <-s.result:
+		return res.Batch, res.Err
+	case <-deadline:
+		// the run loop either sets an empty result, or the result was
+		// already set just before the timeout
+		cw.unsubscribe <- s
+		res := <-s.result
+		return res.Batch, res.Err
+	}
+}
+
+func (cw *ChangeWatcher) addSubscription(s *subscription) {
+	if err := s.Validate(); err != nil {
+		s.SetResult(nil, err)
+		return
+	}
+
+	if s.SinceIndex > 0 && cw.changes.Size > 0 {
+		if oldestIndex := cw.changes.First().Index; s.SinceIndex < oldestIndex {
+			s.SetResult(nil, models.EventIndexCleared(oldestIndex, s.SinceIndex, cw.lastIndex))
+			return
+		}
+	}
+
+	cw.subscriptions[s] = struct{}{}
+
+	if s.SinceIndex > 0 {
+		cw.checkSubscription(s, 0)
+	}
+}
+
+func (cw *ChangeWatcher) removeSubscription(s *subscription) {
+	if _, ok := cw.subscriptions[s]; !ok {
+		return
+	}
+	delete(cw.subscriptions, s)
+	s.SetResult(&models.WatchBatch{Events: []*models.ActionUpdate{}, NextIndex: cw.nextIndex(s)}, nil)
+}
+
+// checkSubscription collects the matching changes starting from position i of
+// the change buffer, and sets the subscription's result if there are any.
+func (cw *ChangeWatcher) checkSubscription(s *subscription, i int) {
+	batch, err := cw.collectSubscription(s, i)
+	if err != nil {
+		s.SetResult(nil, err)
+		delete(cw.subscriptions, s)
+		return
+	}
+	if batch != nil {
+		s.SetResult(batch, nil)
+		delete(cw.subscriptions, s)
+	}
+}
+
+// collectSubscription returns a batch of the changes matching the
+// subscription starting from position i of the change buffer, or nil if
+// there are none. Changes of the subscribed keys whose values were all
+// filtered out return an empty batch, so that the next request continues
+// after them instead of checking them again.
+func (cw *ChangeWatcher) collectSubscription(s *subscription, i int) (*models.WatchBatch, error) {
+	events := []*models.ActionUpdate{}
+	filtered := false
+
+	for ; i < cw.changes.Size; i++ {
+		c := cw.changes.Item(i)
+		if !s.Match(c) {
+			continue
+		}
+
+		action, err := c.Value(cw.store)
+		if err == ErrChangeIndexCleared {
+			// as for watches, skip cleared changes that weren't explicitly
+			// requested by index
+			if s.SinceIndex == 0 {
+				continue
+			}
+			err = models.EventIndexCleared(c.Index+1, s.SinceIndex, cw.lastIndex)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !s.MatchValue(action) {
+			filtered = true
+			continue
+		}
+		events = append(events, action)
+	}
+
+	if len(events) == 0 && !filtered {
+		return nil, nil
+	}
+	return &models.WatchBatch{Events: events, NextIndex: cw.nextIndex(s)}, nil
+}
+
+// A pendingBatch is a subscription's result waiting to be set in index order
+type pendingBatch struct {
+	s     *subscription
+	batch *models.WatchBatch
+}
+
+// lastIndex is the index of the batch's last event, or of the last change
+// checked if the events were all filtered out
+func (p pendingBatch) lastIndex() int64 {
+	if len(p.batch.Events) == 0 {
+		return p.batch.NextIndex - 1
+	}
+	return p.batch.Events[len(p.batch.Events)-1].Node.ModifiedIndex
+}
+
+type byLastEvent []pendingBatch
+
+func (b byLastEvent) Len() int           { return len(b) }
+func (b byLastEvent) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byLastEvent) Less(i, j int) bool { return b[i].lastIndex() < b[j].lastIndex() }
+
+// nextIndex is the SinceIndex for the subscription's next request to continue
+// after the changes already checked.
+func (cw *ChangeWatcher) nextIndex(s *subscription) int64 {
+	if s.SinceIndex > cw.lastIndex {
+		return s.SinceIndex
+	}
+	return cw.lastIndex + 1
+}
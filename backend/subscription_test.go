@@ -0,0 +1,202 @@
+package backend
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rancher/etcdb/models"
+)
+
+func Test_Subscribe_MultipleKeys(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	cw := Watch(store, 100*time.Millisecond)
+	defer cw.Stop()
+
+	store.Set("/foo", "1", Always)
+	store.Set("/other", "2", Always)
+	store.Set("/bar/baz", "3", Always)
+	time.Sleep(500 * time.Millisecond)
+
+	batch, err := cw.Subscribe(&Subscription{
+		Keys: []WatchKey{
+			{Key: "/foo"},
+			{Key: "/bar", Recursive: true},
+		},
+		SinceIndex: 1,
+	}, -1)
+	ok(t, err)
+
+	equals(t, 2, len(batch.Events))
+	equals(t, "/foo", batch.Events[0].Node.Key)
+	equals(t, "/bar/baz", batch.Events[1].Node.Key)
+	equals(t, currIndex(store)+1, batch.NextIndex)
+}
+
+func Test_Subscribe_ActionFilter(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	cw := Watch(store, 100*time.Millisecond)
+	defer cw.Stop()
+
+	store.Set("/foo", "1", Always)
+	store.Delete("/foo", Always)
+	time.Sleep(500 * time.Millisecond)
+
+	batch, err := cw.Subscribe(&Subscription{
+		Keys:       []WatchKey{{Key: "/foo"}},
+		SinceIndex: 1,
+		Actions:    []string{"delete"},
+	}, -1)
+	ok(t, err)
+
+	equals(t, 1, len(batch.Events))
+	equals(t, "delete", batch.Events[0].Action)
+}
+
+func Test_Subscribe_WaitsForChange(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	cw := Watch(store, 100*time.Millisecond)
+	defer cw.Stop()
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		store.Set("/foo", "bar", Always)
+	}()
+
+	batch, err := cw.Subscribe(&Subscription{Keys: []WatchKey{{Key: "/foo"}}}, -1)
+	ok(t, err)
+
+	equals(t, 1, len(batch.Events))
+	equals(t, "bar", batch.Events[0].Node.Value)
+}
+
+func Test_Subscribe_Timeout(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	cw := Watch(store, 100*time.Millisecond)
+	defer cw.Stop()
+
+	store.Set("/other", "bar", Always)
+	time.Sleep(500 * time.Millisecond)
+
+	batch, err := cw.Subscribe(&Subscription{Keys: []WatchKey{{Key: "/foo"}}}, 200*time.Millisecond)
+	ok(t, err)
+
+	equals(t, 0, len(batch.Events))
+	equals(t, currIndex(store)+1, batch.NextIndex)
+}
+
+func Test_Subscription_Match(t *testing.T) {
+	s := &Subscription{
+		Keys:       []WatchKey{{Key: "/foo"}, {Key: "/bar", Recursive: true}},
+		SinceIndex: 2,
+	}
+
+	equals(t, true, s.Match(&change{Key: "/foo", Index: 2, Action: "set"}))
+	equals(t, true, s.Match(&change{Key: "/bar/baz", Index: 3, Action: "set"}))
+	equals(t, false, s.Match(&change{Key: "/foo", Index: 1, Action: "set"}))
+	equals(t, false, s.Match(&change{Key: "/foo/baz", Index: 3, Action: "set"}))
+}
+
+func Test_Subscription_MatchActions(t *testing.T) {
+	s := &Subscription{
+		Keys:    []WatchKey{{Key: "/foo"}},
+		Actions: []string{"delete", "expire"},
+	}
+
+	equals(t, true, s.Match(&change{Key: "/foo", Index: 1, Action: "expire"}))
+	equals(t, false, s.Match(&change{Key: "/foo", Index: 1, Action: "set"}))
+}
+
+func Test_Subscription_MatchKeyGlob(t *testing.T) {
+	s := &Subscription{
+		Keys:    []WatchKey{{Key: "/services", Recursive: true}},
+		KeyGlob: "/services/*/health",
+	}
+	ok(t, s.Validate())
+
+	equals(t, true, s.Match(&change{Key: "/services/web/health", Index: 1, Action: "set"}))
+	equals(t, false, s.Match(&change{Key: "/services/web/config", Index: 1, Action: "set"}))
+	equals(t, false, s.Match(&change{Key: "/services/web/a/health", Index: 1, Action: "set"}))
+}
+
+func Test_Subscription_MatchValue(t *testing.T) {
+	s := &Subscription{
+		Keys:       []WatchKey{{Key: "/foo"}},
+		ValueRegex: "^(down|failed)$",
+	}
+	ok(t, s.Validate())
+
+	equals(t, true, s.MatchValue(&models.ActionUpdate{Action: "set", Node: models.Node{Value: "down"}}))
+	equals(t, false, s.MatchValue(&models.ActionUpdate{Action: "set", Node: models.Node{Value: "up"}}))
+	equals(t, true, s.MatchValue(&models.ActionUpdate{
+		Action:   "delete",
+		PrevNode: &models.Node{Value: "failed"},
+	}))
+}
+
+func Test_Subscription_Validate(t *testing.T) {
+	expectError(t, "Invalid field", "keys required", (&Subscription{}).Validate())
+	expectError(t, "Invalid field", "keys must start with /: foo",
+		(&Subscription{Keys: []WatchKey{{Key: "foo"}}}).Validate())
+	expectError(t, "Invalid field", "invalid keyGlob: /foo/[",
+		(&Subscription{Keys: []WatchKey{{Key: "/foo"}}, KeyGlob: "/foo/["}).Validate())
+
+	err := (&Subscription{Keys: []WatchKey{{Key: "/foo"}}, ValueRegex: "("}).Validate()
+	equals(t, 209, err.(models.Error).ErrorCode)
+
+	s := &Subscription{Keys: []WatchKey{{Key: "/foo/"}, {Key: "//foo//bar"}}}
+	ok(t, s.Validate())
+	equals(t, []WatchKey{{Key: "/foo"}, {Key: "/foo/bar"}}, s.Keys)
+}
+
+func Test_Subscribe_ValueFilter(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	cw := Watch(store, 100*time.Millisecond)
+	defer cw.Stop()
+
+	store.Set("/status", "up", Always)
+	store.Set("/status", "down", Always)
+	time.Sleep(500 * time.Millisecond)
+
+	batch, err := cw.Subscribe(&Subscription{
+		Keys:       []WatchKey{{Key: "/status"}},
+		SinceIndex: 1,
+		ValueRegex: "down",
+	}, -1)
+	ok(t, err)
+
+	equals(t, 1, len(batch.Events))
+	equals(t, "down", batch.Events[0].Node.Value)
+}
+
+func Test_Subscribe_ValueFilterAdvances(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	cw := Watch(store, 100*time.Millisecond)
+	defer cw.Stop()
+
+	store.Set("/status", "up", Always)
+	node, _, _ := store.Set("/status", "up", Always)
+	time.Sleep(500 * time.Millisecond)
+
+	// without a timeout, the filtered changes still end the request
+	batch, err := cw.Subscribe(&Subscription{
+		Keys:       []WatchKey{{Key: "/status"}},
+		SinceIndex: 1,
+		ValueRegex: "down",
+	}, -1)
+	ok(t, err)
+
+	equals(t, 0, len(batch.Events))
+	equals(t, node.ModifiedIndex+1, batch.NextIndex)
+}
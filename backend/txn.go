@@ -0,0 +1,133 @@
+package backend
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/rancher/etcdb/models"
+)
+
+// A TxnOp is one operation of a transaction. Action is "compare" to only
+// check the conditions, "set", or "delete". The other fields are the same as
+// etcd's parameters for the action.
+type TxnOp struct {
+	Action    string  `json:"action"`
+	Key       string  `json:"key"`
+	Value     string  `json:"value,omitempty"`
+	TTL       *int64  `json:"ttl,omitempty"`
+	Dir       bool    `json:"dir,omitempty"`
+	Recursive bool    `json:"recursive,omitempty"`
+	PrevValue *string `json:"prevValue,omitempty"`
+	PrevIndex *int64  `json:"prevIndex,omitempty"`
+	PrevExist *bool   `json:"prevExist,omitempty"`
+}
+
+// Txn applies the operations in order in a single transaction, each set or
+// delete at its own index. If any operation fails, none are applied, and the
+// error's cause is prefixed with the failed operation's position.
+func (b *SqlBackend) Txn(ops []TxnOp) (results []*models.ActionUpdate, err error) {
+	tx, err := b.Begin()
+	if err != nil {
+		return nil, err
+	}
+	qt := &quotaTx{}
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+			if err == nil {
+				b.commitQuota(qt)
+			}
+		} else {
+			tx.Rollback()
+		}
+	}()
+
+	startIndex, err := b.currIndex(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, op := range ops {
+		res, err := b.txnOp(tx, qt, op, startIndex)
+		if etcdErr, ok := err.(models.Error); ok {
+			// the indexes used by earlier operations are rolled back
+			etcdErr.Cause = fmt.Sprintf("[op %d] %s", i, etcdErr.Cause)
+			etcdErr.Index = startIndex
+			return nil, etcdErr
+		} else if err != nil {
+			return nil, err
+		}
+		results = append(results, res)
+	}
+
+	return results, nil
+}
+
+func (b *SqlBackend) txnOp(tx *sql.Tx, qt *quotaTx, op TxnOp, startIndex int64) (*models.ActionUpdate, error) {
+	if op.Key == "/" && op.Action != "compare" {
+		return nil, b.readOnlyError()
+	}
+
+	switch op.Action {
+	case "compare":
+		condition := SetConditionFor(op.PrevValue, op.PrevIndex, op.PrevExist)
+		index, err := b.currIndex(tx)
+		if err != nil {
+			return nil, err
+		}
+		node, err := b.getOneForUpdate(tx, op.Key)
+		if err != nil {
+			return nil, err
+		}
+		if err := condition.Check(op.Key, index, node); err != nil {
+			return nil, err
+		}
+		res := &models.ActionUpdate{Action: "compare"}
+		if node != nil {
+			res.Node = *node
+		} else {
+			res.Node.Key = op.Key
+		}
+		return res, nil
+
+	case "set":
+		index, err := b.incrementIndex(tx)
+		if err != nil {
+			return nil, err
+		}
+		condition := SetConditionFor(op.PrevValue, op.PrevIndex, op.PrevExist)
+		node, prevNode, err := b.setTx(tx, qt, index, op.Key, op.Value, op.Dir, op.TTL, condition)
+		if err != nil {
+			return nil, err
+		}
+		return &models.ActionUpdate{
+			Action:   condition.SetActionName(),
+			Node:     *node,
+			PrevNode: prevNode,
+		}, nil
+
+	case "delete":
+		index, err := b.incrementIndex(tx)
+		if err != nil {
+			return nil, err
+		}
+		condition := DeleteConditionFor(op.PrevValue, op.PrevIndex)
+		dir := op.Dir || op.Recursive
+		node, err := b.deleteTx(tx, qt, index, op.Key, dir, op.Recursive, condition, false)
+		if err != nil {
+			return nil, err
+		}
+		return &models.ActionUpdate{
+			Action: condition.DeleteActionName(),
+			Node: models.Node{
+				Key:           op.Key,
+				CreatedIndex:  node.CreatedIndex,
+				ModifiedIndex: index,
+				Dir:           node.Dir,
+			},
+			PrevNode: node,
+		}, nil
+	}
+
+	return nil, models.InvalidField("unknown action: " + op.Action)
+}
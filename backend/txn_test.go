@@ -0,0 +1,70 @@
+package backend
+
+import (
+	"testing"
+
+	"github.com/rancher/etcdb/models"
+)
+
+func Test_Txn_AppliesAll(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	old, _, err := store.Set("/old", "value", Always)
+	ok(t, err)
+
+	value := "value"
+	results, err := store.Txn([]TxnOp{
+		{Action: "compare", Key: "/old", PrevValue: &value},
+		{Action: "set", Key: "/a", Value: "1"},
+		{Action: "set", Key: "/b", Value: "2"},
+		{Action: "delete", Key: "/old"},
+	})
+	ok(t, err)
+	equals(t, 4, len(results))
+
+	equals(t, "compare", results[0].Action)
+	equals(t, old.ModifiedIndex, results[0].Node.ModifiedIndex)
+	equals(t, "set", results[1].Action)
+	equals(t, old.ModifiedIndex+1, results[1].Node.ModifiedIndex)
+	equals(t, old.ModifiedIndex+2, results[2].Node.ModifiedIndex)
+	equals(t, "delete", results[3].Action)
+	equals(t, old.ModifiedIndex+3, results[3].Node.ModifiedIndex)
+	equals(t, old.ModifiedIndex+3, currIndex(store))
+
+	node, err := store.Get("/b", false)
+	ok(t, err)
+	equals(t, "2", node.Value)
+
+	_, err = store.Get("/old", false)
+	expectError(t, "Key not found", "/old", err)
+}
+
+func Test_Txn_FailureAppliesNone(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	_, _, err := store.Set("/a", "1", Always)
+	ok(t, err)
+	origIndex := currIndex(store)
+
+	wrong := "wrong"
+	_, err = store.Txn([]TxnOp{
+		{Action: "set", Key: "/b", Value: "2"},
+		{Action: "set", Key: "/a", Value: "3", PrevValue: &wrong},
+	})
+	expectError(t, "Compare failed", "[op 1] [wrong != 1]", err)
+	equals(t, origIndex, err.(models.Error).Index)
+	equals(t, origIndex, currIndex(store))
+
+	_, err = store.Get("/b", false)
+	expectError(t, "Key not found", "/b", err)
+}
+
+func Test_Txn_UnknownAction(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	_, err := store.Txn([]TxnOp{{Action: "get", Key: "/a"}})
+	expectError(t, "Invalid field", "[op 0] unknown action: get", err)
+}
@@ -0,0 +1,118 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// VaultCredentials fetches dynamic database credentials from a HashiCorp
+// Vault database secrets engine, like database/creds/etcdb, and renews their
+// leases. The Vault token itself is not renewed.
+type VaultCredentials struct {
+	// Addr is the Vault server's URL, like https://vault:8200
+	Addr  string
+	Token string
+	// Path is the credentials endpoint, like database/creds/etcdb
+	Path string
+	// Client is used for the requests to Vault, or http.DefaultClient
+	Client *http.Client
+}
+
+// vaultSecret is the part of Vault's secret responses used for credentials
+type vaultSecret struct {
+	LeaseID       string `json:"lease_id"`
+	LeaseDuration int64  `json:"lease_duration"`
+	Renewable     bool   `json:"renewable"`
+	Data          struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	} `json:"data"`
+}
+
+type vaultErrors struct {
+	Errors []string `json:"errors"`
+}
+
+// Fetch reads new credentials from the Path
+func (v *VaultCredentials) Fetch() (*Credentials, error) {
+	var secret vaultSecret
+	if err := v.request("GET", v.Path, nil, &secret); err != nil {
+		return nil, err
+	}
+	if secret.Data.Username == "" {
+		return nil, fmt.Errorf("vault: no username in the secret at %s", v.Path)
+	}
+
+	return &Credentials{
+		User:      secret.Data.Username,
+		Password:  secret.Data.Password,
+		LeaseID:   secret.LeaseID,
+		Expires:   leaseExpiry(time.Now(), secret.LeaseDuration),
+		Renewable: secret.Renewable,
+	}, nil
+}
+
+// Renew extends the credentials' lease by its original duration
+func (v *VaultCredentials) Renew(creds *Credentials) (*Credentials, error) {
+	var secret vaultSecret
+	body := map[string]interface{}{"lease_id": creds.LeaseID}
+	if err := v.request("PUT", "sys/leases/renew", body, &secret); err != nil {
+		return nil, err
+	}
+
+	renewed := *creds
+	renewed.Expires = leaseExpiry(time.Now(), secret.LeaseDuration)
+	renewed.Renewable = secret.Renewable
+	return &renewed, nil
+}
+
+func (v *VaultCredentials) request(method, path string, body, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, strings.TrimSuffix(v.Addr, "/")+"/v1/"+strings.TrimPrefix(path, "/"), reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", v.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client := v.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var errs vaultErrors
+		json.NewDecoder(resp.Body).Decode(&errs)
+		return fmt.Errorf("vault: %s %s: %s %s", method, path, resp.Status, strings.Join(errs.Errors, ", "))
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// leaseExpiry is when a lease of the duration in seconds expires, or zero for
+// leases without a duration
+func leaseExpiry(now time.Time, seconds int64) time.Time {
+	if seconds <= 0 {
+		return time.Time{}
+	}
+	return now.Add(time.Duration(seconds) * time.Second)
+}
@@ -0,0 +1,84 @@
+package backend
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func fakeVault(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "secret" {
+			rw.WriteHeader(http.StatusForbidden)
+			rw.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		switch r.Method + " " + r.URL.Path {
+		case "GET /v1/database/creds/etcdb":
+			rw.Write([]byte(`{"lease_id":"database/creds/etcdb/abc","lease_duration":3600,"renewable":true,
+				"data":{"username":"v-etcdb-1","password":"p4ss"}}`))
+		case "PUT /v1/sys/leases/renew":
+			var body map[string]string
+			ok(t, json.NewDecoder(r.Body).Decode(&body))
+			equals(t, "database/creds/etcdb/abc", body["lease_id"])
+			rw.Write([]byte(`{"lease_id":"database/creds/etcdb/abc","lease_duration":60,"renewable":false}`))
+		default:
+			rw.WriteHeader(http.StatusNotFound)
+			rw.Write([]byte(`{"errors":[]}`))
+		}
+	}))
+}
+
+func Test_VaultCredentials_Fetch(t *testing.T) {
+	server := fakeVault(t)
+	defer server.Close()
+
+	vault := &VaultCredentials{Addr: server.URL, Token: "secret", Path: "database/creds/etcdb"}
+	creds, err := vault.Fetch()
+	ok(t, err)
+
+	equals(t, "v-etcdb-1", creds.User)
+	equals(t, "p4ss", creds.Password)
+	equals(t, "database/creds/etcdb/abc", creds.LeaseID)
+	equals(t, true, creds.Renewable)
+	if d := creds.Expires.Sub(time.Now()); d < 59*time.Minute || d > time.Hour {
+		t.Fatalf("expected to expire in an hour, got %s", d)
+	}
+}
+
+func Test_VaultCredentials_Renew(t *testing.T) {
+	server := fakeVault(t)
+	defer server.Close()
+
+	vault := &VaultCredentials{Addr: server.URL, Token: "secret", Path: "database/creds/etcdb"}
+	creds, err := vault.Fetch()
+	ok(t, err)
+
+	renewed, err := vault.Renew(creds)
+	ok(t, err)
+
+	equals(t, "v-etcdb-1", renewed.User)
+	equals(t, false, renewed.Renewable)
+	if d := renewed.Expires.Sub(time.Now()); d > time.Minute {
+		t.Fatalf("expected to expire in a minute, got %s", d)
+	}
+}
+
+func Test_VaultCredentials_Error(t *testing.T) {
+	server := fakeVault(t)
+	defer server.Close()
+
+	vault := &VaultCredentials{Addr: server.URL, Token: "wrong", Path: "database/creds/etcdb"}
+	_, err := vault.Fetch()
+
+	equals(t, "vault: GET database/creds/etcdb: 403 Forbidden permission denied", err.Error())
+}
+
+func Test_RenewIn(t *testing.T) {
+	now := time.Now()
+
+	equals(t, 40*time.Minute, renewIn(now, now.Add(time.Hour)))
+	equals(t, time.Duration(0), renewIn(now, now.Add(-time.Second)))
+}
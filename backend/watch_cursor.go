@@ -0,0 +1,53 @@
+package backend
+
+import "github.com/rancher/etcdb/models"
+
+// A WatchCursor returns the changes to a key, or the keys under it, one after
+// another in index order. It keeps the index to continue from, so that
+// sequential calls to Next don't miss or repeat a change, even when several
+// are fetched by the same refresh of the watcher.
+type WatchCursor struct {
+	watcher    *ChangeWatcher
+	key        string
+	recursive  bool
+	remoteAddr string
+	// next is the index of the first change that Next can return
+	next int64
+}
+
+// Cursor creates a cursor for the changes from the index, or for those after
+// the store's current index if the index is 0.
+func (cw *ChangeWatcher) Cursor(key string, recursive bool, index int64) (*WatchCursor, error) {
+	if index <= 0 {
+		current, err := cw.store.CurrentIndex()
+		if err != nil {
+			return nil, err
+		}
+		index = current + 1
+	}
+	return &WatchCursor{watcher: cw, key: key, recursive: recursive, next: index}, nil
+}
+
+// SetRemoteAddr sets the address of the client, shown in the watcher's State
+func (c *WatchCursor) SetRemoteAddr(remoteAddr string) {
+	c.remoteAddr = remoteAddr
+}
+
+// Index returns the index of the first change that Next can return
+func (c *WatchCursor) Index() int64 {
+	return c.next
+}
+
+// Next waits for the next matching change, like NextChange, and moves the
+// cursor past it. After ErrWatchTimeout it can be called again, and on an
+// EventIndexCleared error the changes since Index are gone.
+func (c *WatchCursor) Next() (*models.ActionUpdate, error) {
+	action, err := c.watcher.NextChangeFrom(c.key, c.recursive, c.next, c.remoteAddr)
+	if err != nil {
+		return nil, err
+	}
+	// the node of every action has the index of its change, including
+	// deletes and expirations
+	c.next = action.Node.ModifiedIndex + 1
+	return action, nil
+}
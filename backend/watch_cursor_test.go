@@ -0,0 +1,80 @@
+package backend
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_WatchCursor_FromIndex(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	cw := Watch(store, 10*time.Millisecond)
+	defer cw.Stop()
+
+	first, _, err := store.Set("/foo", "a", Always)
+	ok(t, err)
+	_, _, err = store.Set("/foo", "b", Always)
+	ok(t, err)
+	_, _, err = store.Set("/other", "x", Always)
+	ok(t, err)
+	_, _, err = store.Set("/foo", "c", Always)
+	ok(t, err)
+
+	cursor, err := cw.Cursor("/foo", false, first.ModifiedIndex)
+	ok(t, err)
+	for _, value := range []string{"a", "b", "c"} {
+		act, err := cursor.Next()
+		ok(t, err)
+		equals(t, value, act.Node.Value)
+	}
+	equals(t, currIndex(store)+1, cursor.Index())
+}
+
+func Test_WatchCursor_ConcurrentWritesInOrder(t *testing.T) {
+	store := testConn(t)
+	defer store.Close()
+
+	cw := Watch(store, 10*time.Millisecond)
+	defer cw.Stop()
+
+	cursor, err := cw.Cursor("/dir", true, 0)
+	ok(t, err)
+
+	const writers, writes = 4, 25
+	errs := make(chan error, writers)
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			for j := 0; j < writes; j++ {
+				if _, _, err := store.Set(key, fmt.Sprint(j), Always); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(fmt.Sprintf("/dir/%d", i))
+	}
+
+	// each change is seen once, in index order, and each key's values in the
+	// order they were written
+	prevIndex := cursor.Index() - 1
+	next := make(map[string]int)
+	for n := 0; n < writers*writes; n++ {
+		act, err := cursor.Next()
+		ok(t, err)
+		equals(t, prevIndex+1, act.Node.ModifiedIndex)
+		equals(t, fmt.Sprint(next[act.Node.Key]), act.Node.Value)
+		prevIndex = act.Node.ModifiedIndex
+		next[act.Node.Key]++
+	}
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		ok(t, err)
+	}
+}
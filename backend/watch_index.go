@@ -0,0 +1,113 @@
+package backend
+
+import (
+	"sort"
+	"strings"
+)
+
+// watchIndex finds the watches that a change can match by their keys, in a
+// tree of the key segments, so that a change is only matched against the
+// watches on its key and the directories above it, and for deletes the keys
+// under it, instead of against every watch.
+type watchIndex struct {
+	root *watchNode
+}
+
+type watchNode struct {
+	children map[string]*watchNode
+	watches  map[*watch]struct{}
+}
+
+func newWatchIndex() *watchIndex {
+	return &watchIndex{root: &watchNode{}}
+}
+
+// keySegments splits the key into the names of its directories and itself,
+// so that /foo and /foo/ are the same node like for watch.Match
+func keySegments(key string) []string {
+	var segments []string
+	for _, s := range strings.Split(key, "/") {
+		if s != "" {
+			segments = append(segments, s)
+		}
+	}
+	return segments
+}
+
+func (x *watchIndex) add(w *watch) {
+	n := x.root
+	for _, s := range keySegments(w.Key) {
+		child, ok := n.children[s]
+		if !ok {
+			if n.children == nil {
+				n.children = make(map[string]*watchNode)
+			}
+			child = &watchNode{}
+			n.children[s] = child
+		}
+		n = child
+	}
+	if n.watches == nil {
+		n.watches = make(map[*watch]struct{})
+	}
+	n.watches[w] = struct{}{}
+}
+
+// remove removes the watch, and the nodes left without watches or children
+func (x *watchIndex) remove(w *watch) {
+	segments := keySegments(w.Key)
+	path := []*watchNode{x.root}
+	for _, s := range segments {
+		child, ok := path[len(path)-1].children[s]
+		if !ok {
+			return
+		}
+		path = append(path, child)
+	}
+	delete(path[len(path)-1].watches, w)
+
+	for i := len(segments); i > 0; i-- {
+		n := path[i]
+		if len(n.watches) > 0 || len(n.children) > 0 {
+			return
+		}
+		delete(path[i-1].children, segments[i-1])
+	}
+}
+
+// candidates returns the watches that the change can match, in the order
+// they were added. watch.Match decides which of them it does.
+func (x *watchIndex) candidates(c *change) []*watch {
+	var found []*watch
+	n := x.root
+	for _, s := range keySegments(c.Key) {
+		for w := range n.watches {
+			found = append(found, w)
+		}
+		if n = n.children[s]; n == nil {
+			break
+		}
+	}
+	if n != nil {
+		for w := range n.watches {
+			found = append(found, w)
+		}
+		switch c.Action {
+		case "delete", "expire":
+			found = n.appendBelow(found)
+		}
+	}
+	sort.Sort(bySeq(found))
+	return found
+}
+
+// appendBelow appends the watches of the nodes under the node
+func (n *watchNode) appendBelow(found []*watch) []*watch {
+	for _, child := range n.children {
+		for w := range child.watches {
+			found = append(found, w)
+		}
+		found = child.appendBelow(found)
+	}
+	return found
+}
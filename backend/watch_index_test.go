@@ -0,0 +1,64 @@
+package backend
+
+import (
+	"fmt"
+	"testing"
+)
+
+func candidateKeys(x *watchIndex, c *change) []string {
+	var keys []string
+	for _, w := range x.candidates(c) {
+		keys = append(keys, w.Key)
+	}
+	return keys
+}
+
+func Test_WatchIndex_Candidates(t *testing.T) {
+	x := newWatchIndex()
+	for i, key := range []string{"/a/b/c", "/", "/a", "/a/b/", "/other", "/a/bc"} {
+		w := NewWatch(0, key, true)
+		w.seq = int64(i)
+		x.add(w)
+	}
+
+	// the key and the directories above it, in the order added
+	equals(t, []string{"/", "/a", "/a/b/"}, candidateKeys(x, &change{Key: "/a/b", Action: "set"}))
+	equals(t, []string{"/a/b/c", "/", "/a", "/a/b/"}, candidateKeys(x, &change{Key: "/a/b/c/d", Action: "set"}))
+	equals(t, []string{"/"}, candidateKeys(x, &change{Key: "/missing/key", Action: "set"}))
+
+	// and the keys under a deleted one
+	equals(t, []string{"/a/b/c", "/", "/a", "/a/b/"}, candidateKeys(x, &change{Key: "/a/b", Action: "delete"}))
+	equals(t, []string{"/a/b/c", "/", "/a", "/a/b/", "/a/bc"}, candidateKeys(x, &change{Key: "/a", Action: "expire"}))
+}
+
+func Test_WatchIndex_Remove(t *testing.T) {
+	x := newWatchIndex()
+	w1 := NewWatch(0, "/a/b", false)
+	w2 := NewWatch(0, "/a/b", false)
+	x.add(w1)
+	x.add(w2)
+
+	x.remove(w1)
+	equals(t, 1, len(x.candidates(&change{Key: "/a/b", Action: "set"})))
+
+	x.remove(w2)
+	equals(t, 0, len(x.candidates(&change{Key: "/a/b", Action: "set"})))
+	// the nodes without watches are removed too
+	equals(t, 0, len(x.root.children))
+
+	// removing a watch that isn't there does nothing
+	x.remove(w2)
+}
+
+func Benchmark_WatchIndex_Candidates(b *testing.B) {
+	x := newWatchIndex()
+	for i := 0; i < 20000; i++ {
+		x.add(NewWatch(0, fmt.Sprintf("/registry/pods/ns%d/pod%d", i%100, i), false))
+	}
+	c := &change{Key: "/registry/pods/ns26/pod126", Action: "set"}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		x.candidates(c)
+	}
+}
@@ -0,0 +1,61 @@
+package backend
+
+import (
+	"sort"
+	"time"
+
+	"github.com/rancher/etcdb/models"
+)
+
+// State returns the watches waiting for changes, oldest first, and the state
+// of the buffer of changes they are served from. At most limit watches are
+// listed if limit is positive, but all are counted.
+func (cw *ChangeWatcher) State(limit int) *models.WatcherState {
+	res := make(chan *models.WatcherState, 1)
+	cw.inspect <- res
+	state := <-res
+	if limit > 0 && len(state.Watches) > limit {
+		state.Watches = state.Watches[:limit]
+	}
+	return state
+}
+
+// state describes the watcher at the time. It is only called from Run.
+func (cw *ChangeWatcher) state(now time.Time) *models.WatcherState {
+	state := &models.WatcherState{
+		Watches:       make([]*models.WatchState, 0, len(cw.watches)),
+		WatchCount:    len(cw.watches),
+		KeyCounts:     make(map[string]int),
+		Subscriptions: len(cw.subscriptions),
+		Changes: models.ChangeBuffer{
+			Size:     cw.changes.Size,
+			Capacity: cw.changes.Capacity,
+		},
+	}
+
+	if cw.changes.Size > 0 {
+		state.Changes.FirstIndex = cw.changes.First().Index
+		state.Changes.LastIndex = cw.changes.Last().Index
+	}
+
+	for w := range cw.watches {
+		state.Watches = append(state.Watches, &models.WatchState{
+			Key:        w.Key,
+			Recursive:  w.Recursive,
+			WaitIndex:  w.Index,
+			Started:    w.started,
+			Age:        now.Sub(w.started).String(),
+			RemoteAddr: w.RemoteAddr,
+		})
+		state.KeyCounts[w.Key]++
+	}
+	sort.Sort(byStarted(state.Watches))
+
+	return state
+}
+
+type byStarted []*models.WatchState
+
+func (s byStarted) Len() int           { return len(s) }
+func (s byStarted) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byStarted) Less(i, j int) bool { return s[i].Started.Before(s[j].Started) }
@@ -0,0 +1,54 @@
+package backend
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rancher/etcdb/models"
+)
+
+func Test_WatcherState(t *testing.T) {
+	now := time.Now()
+	cw := &ChangeWatcher{
+		watches:       make(map[*watch]struct{}),
+		byKey:         newWatchIndex(),
+		subscriptions: make(map[*subscription]struct{}),
+		changes:       newChangeList(10),
+	}
+
+	for i, key := range []string{"/b", "/a", "/b"} {
+		w := NewWatch(int64(i), key, false)
+		w.started = now.Add(time.Duration(i-3) * time.Minute)
+		w.RemoteAddr = "10.0.0.1:1234"
+		cw.watches[w] = struct{}{}
+	}
+	cw.changes.Next().Index = 5
+	cw.changes.Next().Index = 6
+
+	state := cw.state(now)
+
+	equals(t, 3, state.WatchCount)
+	equals(t, map[string]int{"/a": 1, "/b": 2}, state.KeyCounts)
+	equals(t, models.ChangeBuffer{Size: 2, Capacity: 10, FirstIndex: 5, LastIndex: 6}, state.Changes)
+
+	equals(t, "/b", state.Watches[0].Key)
+	equals(t, "3m0s", state.Watches[0].Age)
+	equals(t, "/a", state.Watches[1].Key)
+	equals(t, int64(1), state.Watches[1].WaitIndex)
+	equals(t, "10.0.0.1:1234", state.Watches[1].RemoteAddr)
+}
+
+func Test_WatcherState_Empty(t *testing.T) {
+	cw := &ChangeWatcher{
+		watches:       make(map[*watch]struct{}),
+		byKey:         newWatchIndex(),
+		subscriptions: make(map[*subscription]struct{}),
+		changes:       newChangeList(10),
+	}
+
+	state := cw.state(time.Now())
+
+	equals(t, 0, state.WatchCount)
+	equals(t, []*models.WatchState{}, state.Watches)
+	equals(t, models.ChangeBuffer{Capacity: 10}, state.Changes)
+}
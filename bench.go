@@ -0,0 +1,222 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rancher/etcdb/backend"
+	"github.com/rancher/etcdb/models"
+)
+
+// benchOp runs a single operation of a workload for the worker
+type benchOp func(worker int, rnd *rand.Rand) error
+
+type benchResult struct {
+	Name      string
+	Elapsed   time.Duration
+	Errors    int
+	Latencies []time.Duration
+}
+
+// percentile returns the latency that p percent of the operations completed
+// within. The latencies must be sorted.
+func (r *benchResult) percentile(p float64) time.Duration {
+	if len(r.Latencies) == 0 {
+		return 0
+	}
+	i := int(float64(len(r.Latencies))*p/100+0.5) - 1
+	if i < 0 {
+		i = 0
+	} else if i >= len(r.Latencies) {
+		i = len(r.Latencies) - 1
+	}
+	return r.Latencies[i]
+}
+
+func (r *benchResult) String() string {
+	ops := len(r.Latencies)
+	return fmt.Sprintf("%-6s %8d ops %10.1f ops/s %6d errors   p50 %-10v p90 %-10v p99 %-10v max %v",
+		r.Name, ops, float64(ops)/r.Elapsed.Seconds(), r.Errors,
+		r.percentile(50), r.percentile(90), r.percentile(99), r.percentile(100))
+}
+
+// runWorkload calls op from concurrent workers until the duration has passed,
+// and records the latency of each successful call.
+func runWorkload(name string, concurrency int, duration time.Duration, op benchOp) *benchResult {
+	res := &benchResult{Name: name}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	start := time.Now()
+	deadline := start.Add(duration)
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+
+			rnd := rand.New(rand.NewSource(start.UnixNano() + int64(worker)))
+			var latencies []time.Duration
+			errors := 0
+
+			for time.Now().Before(deadline) {
+				opStart := time.Now()
+				if err := op(worker, rnd); err != nil {
+					if errors == 0 {
+						log.Printf("%s: %v", name, err)
+					}
+					errors++
+					continue
+				}
+				latencies = append(latencies, time.Since(opStart))
+			}
+
+			mu.Lock()
+			res.Latencies = append(res.Latencies, latencies...)
+			res.Errors += errors
+			mu.Unlock()
+		}(i)
+	}
+
+	wg.Wait()
+	res.Elapsed = time.Since(start)
+
+	sort.Sort(byDuration(res.Latencies))
+	return res
+}
+
+type byDuration []time.Duration
+
+func (d byDuration) Len() int           { return len(d) }
+func (d byDuration) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+func (d byDuration) Less(i, j int) bool { return d[i] < d[j] }
+
+// bench runs the bench command with its command line arguments
+func bench(args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+
+	workloads := fs.String("workloads", "write,read,watch", "Comma separated workloads to run: write, read and watch.")
+	concurrency := fs.Int("concurrency", 10, "Number of concurrent clients in each workload.")
+	keys := fs.Int("keys", 1000, "Number of keys written and read.")
+	valueSize := fs.Int("value-size", 256, "Size of the values written, in bytes.")
+	duration := fs.Duration("duration", 10*time.Second, "How long to run each workload.")
+	prefix := fs.String("prefix", "/etcdb-bench", "Key prefix used for the benchmark keys. It is deleted recursively before and after running.")
+	poll := fs.Duration("watch-poll", 1*time.Second, "Poll rate for watches.")
+
+	// accept the same database options as the server
+	dbFlags(fs)
+
+	fs.Usage = func() {
+		cmd := filepath.Base(os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage of %s bench:\n\n", cmd)
+		fmt.Fprintf(os.Stderr, "  %s bench [options] <postgres|mysql> [datasource]\n\n", cmd)
+		fs.PrintDefaults()
+
+		fmt.Fprintln(os.Stderr, "\n  Workloads:")
+		fmt.Fprintln(os.Stderr, "    write: set random keys")
+		fmt.Fprintln(os.Stderr, "    read:  get random keys, after setting each key once")
+		fmt.Fprintln(os.Stderr, "    watch: set a key per client, and wait for the change from a watch")
+	}
+
+	fs.Parse(args)
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *concurrency < 1 || *keys < 1 {
+		fmt.Fprintln(os.Stderr, "-concurrency and -keys must be at least 1")
+		os.Exit(2)
+	}
+
+	store, err := connect(fs.Args())
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer store.Close()
+
+	cleanup := func() {
+		_, _, err := store.RmDir(*prefix, true, backend.Always)
+		if etcdErr, ok := err.(models.Error); err != nil && !(ok && etcdErr.ErrorCode == 100) {
+			log.Fatalln("error removing benchmark keys:", err)
+		}
+	}
+	cleanup()
+	defer cleanup()
+
+	value := strings.Repeat("x", *valueSize)
+	key := func(i int) string {
+		return fmt.Sprintf("%s/keys/%d", *prefix, i)
+	}
+
+	for _, name := range strings.Split(*workloads, ",") {
+		var op benchOp
+
+		switch strings.TrimSpace(name) {
+		case "write":
+			op = func(worker int, rnd *rand.Rand) error {
+				_, _, err := store.Set(key(rnd.Intn(*keys)), value, backend.Always)
+				return err
+			}
+
+		case "read":
+			for i := 0; i < *keys; i++ {
+				if _, _, err := store.Set(key(i), value, backend.Always); err != nil {
+					log.Fatalln("error writing keys to read:", err)
+				}
+			}
+			op = func(worker int, rnd *rand.Rand) error {
+				_, err := store.Get(key(rnd.Intn(*keys)), false)
+				return err
+			}
+
+		case "watch":
+			cw := backend.Watch(store, *poll)
+			defer cw.Stop()
+
+			// the index each worker's watch key was last changed at
+			indexes := make([]int64, *concurrency)
+			watchKey := func(worker int) string {
+				return fmt.Sprintf("%s/watch/%d", *prefix, worker)
+			}
+			for i := range indexes {
+				node, _, err := store.Set(watchKey(i), value, backend.Always)
+				if err != nil {
+					log.Fatalln("error writing keys to watch:", err)
+				}
+				indexes[i] = node.ModifiedIndex
+			}
+
+			op = func(worker int, rnd *rand.Rand) error {
+				k := watchKey(worker)
+				changed := make(chan error, 1)
+				go func(waitIndex int64) {
+					_, err := cw.NextChange(k, false, waitIndex)
+					changed <- err
+				}(indexes[worker] + 1)
+
+				node, _, err := store.Set(k, value, backend.Always)
+				if err != nil {
+					// the watch is left to be resolved by the worker's next set
+					return err
+				}
+				indexes[worker] = node.ModifiedIndex
+				return <-changed
+			}
+
+		default:
+			fmt.Fprintf(os.Stderr, "unknown workload: %s\n", name)
+			os.Exit(2)
+		}
+
+		fmt.Println(runWorkload(strings.TrimSpace(name), *concurrency, *duration, op))
+	}
+}
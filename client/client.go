@@ -0,0 +1,268 @@
+// Package client is a Go client for etcdb's v2 keys API, and its extensions
+// like transactions and reading past values.
+//
+// Responses are models.ActionUpdate values, with the X-Etcd-Index header in
+// their EtcdIndex, and etcd errors are returned as models.Error values.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/rancher/etcdb/models"
+)
+
+// A Client sends requests to an etcdb, or etcd, server
+type Client struct {
+	// Endpoint is the server's client URL, like http://localhost:2379
+	Endpoint string
+	// HTTPClient is used for the requests, or http.DefaultClient
+	HTTPClient *http.Client
+}
+
+// New creates a client for the server's client URL
+func New(endpoint string) *Client {
+	return &Client{Endpoint: endpoint}
+}
+
+// GetOptions are the options of Get
+type GetOptions struct {
+	Recursive bool
+	Sorted    bool
+	// Quorum reads from the primary database, with the node exactly as of the
+	// index
+	Quorum bool
+	// AtIndex reads the node as it was at the index, if positive. It is an
+	// etcdb extension.
+	AtIndex int64
+}
+
+// SetOptions are the options of Set
+type SetOptions struct {
+	// TTL is the time to live in seconds, if set
+	TTL *int64
+	Dir bool
+	// PrevValue, PrevIndex and PrevExist are the conditions on the previous
+	// node, if set
+	PrevValue *string
+	PrevIndex int64
+	PrevExist *bool
+	// NoValueOnSuccess leaves the nodes out of the response if the set
+	// succeeds, so that only the action and EtcdIndex are returned
+	NoValueOnSuccess bool
+}
+
+// DeleteOptions are the options of Delete
+type DeleteOptions struct {
+	Dir       bool
+	Recursive bool
+	// PrevValue and PrevIndex are the conditions on the node, if set
+	PrevValue *string
+	PrevIndex int64
+}
+
+// A TxnOp is one operation of a transaction: "compare" to only check the
+// conditions, "set", or "delete"
+type TxnOp struct {
+	Action    string  `json:"action"`
+	Key       string  `json:"key"`
+	Value     string  `json:"value,omitempty"`
+	TTL       *int64  `json:"ttl,omitempty"`
+	Dir       bool    `json:"dir,omitempty"`
+	Recursive bool    `json:"recursive,omitempty"`
+	PrevValue *string `json:"prevValue,omitempty"`
+	PrevIndex *int64  `json:"prevIndex,omitempty"`
+	PrevExist *bool   `json:"prevExist,omitempty"`
+}
+
+// errEmptyResponse is returned for the empty responses to watches that timed
+// out on the server
+var errEmptyResponse = errors.New("empty response")
+
+// Get returns the node for the key
+func (c *Client) Get(ctx context.Context, key string, opts *GetOptions) (*models.ActionUpdate, error) {
+	if opts == nil {
+		opts = &GetOptions{}
+	}
+	params := url.Values{}
+	setBool(params, "recursive", opts.Recursive)
+	setBool(params, "sorted", opts.Sorted)
+	setBool(params, "quorum", opts.Quorum)
+	if opts.AtIndex > 0 {
+		params.Set("atIndex", strconv.FormatInt(opts.AtIndex, 10))
+	}
+	return c.keys(ctx, "GET", key, params)
+}
+
+// Set sets the value of the key, or makes it a directory with Dir
+func (c *Client) Set(ctx context.Context, key, value string, opts *SetOptions) (*models.ActionUpdate, error) {
+	if opts == nil {
+		opts = &SetOptions{}
+	}
+	params := url.Values{}
+	if opts.Dir {
+		params.Set("dir", "true")
+	} else {
+		params.Set("value", value)
+	}
+	if opts.TTL != nil {
+		params.Set("ttl", strconv.FormatInt(*opts.TTL, 10))
+	}
+	if opts.PrevValue != nil {
+		params.Set("prevValue", *opts.PrevValue)
+	}
+	if opts.PrevIndex > 0 {
+		params.Set("prevIndex", strconv.FormatInt(opts.PrevIndex, 10))
+	}
+	if opts.PrevExist != nil {
+		params.Set("prevExist", strconv.FormatBool(*opts.PrevExist))
+	}
+	setBool(params, "noValueOnSuccess", opts.NoValueOnSuccess)
+	return c.keys(ctx, "PUT", key, params)
+}
+
+// Create sets the value of the key only if it doesn't exist
+func (c *Client) Create(ctx context.Context, key, value string) (*models.ActionUpdate, error) {
+	exist := false
+	return c.Set(ctx, key, value, &SetOptions{PrevExist: &exist})
+}
+
+// Update sets the value of the key only if it exists
+func (c *Client) Update(ctx context.Context, key, value string) (*models.ActionUpdate, error) {
+	exist := true
+	return c.Set(ctx, key, value, &SetOptions{PrevExist: &exist})
+}
+
+// CreateInOrder creates a key in the directory, named after the index
+func (c *Client) CreateInOrder(ctx context.Context, dir, value string, ttl *int64) (*models.ActionUpdate, error) {
+	params := url.Values{"value": {value}}
+	if ttl != nil {
+		params.Set("ttl", strconv.FormatInt(*ttl, 10))
+	}
+	return c.keys(ctx, "POST", dir, params)
+}
+
+// Delete removes the key
+func (c *Client) Delete(ctx context.Context, key string, opts *DeleteOptions) (*models.ActionUpdate, error) {
+	if opts == nil {
+		opts = &DeleteOptions{}
+	}
+	params := url.Values{}
+	setBool(params, "dir", opts.Dir)
+	setBool(params, "recursive", opts.Recursive)
+	if opts.PrevValue != nil {
+		params.Set("prevValue", *opts.PrevValue)
+	}
+	if opts.PrevIndex > 0 {
+		params.Set("prevIndex", strconv.FormatInt(opts.PrevIndex, 10))
+	}
+	return c.keys(ctx, "DELETE", key, params)
+}
+
+// Txn applies the operations atomically, and returns their results. It is an
+// etcdb extension.
+func (c *Client) Txn(ctx context.Context, ops []TxnOp) ([]*models.ActionUpdate, error) {
+	body, err := json.Marshal(map[string]interface{}{"ops": ops})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", c.url("/v2/txn", nil), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	var res models.TxnResult
+	if _, err := c.do(ctx, req, &res); err != nil {
+		return nil, err
+	}
+	return res.Results, nil
+}
+
+func setBool(params url.Values, name string, value bool) {
+	if value {
+		params.Set(name, "true")
+	}
+}
+
+func (c *Client) url(path string, params url.Values) string {
+	u := strings.TrimRight(c.Endpoint, "/") + (&url.URL{Path: path}).EscapedPath()
+	if len(params) > 0 {
+		u += "?" + params.Encode()
+	}
+	return u
+}
+
+// keys sends a request for the key, with the params in the query for GET and
+// DELETE, and in a form otherwise
+func (c *Client) keys(ctx context.Context, method, key string, params url.Values) (*models.ActionUpdate, error) {
+	path := "/v2/keys/" + strings.TrimPrefix(key, "/")
+
+	var req *http.Request
+	var err error
+	if method == "GET" || method == "DELETE" {
+		req, err = http.NewRequest(method, c.url(path, params), nil)
+	} else {
+		req, err = http.NewRequest(method, c.url(path, nil), strings.NewReader(params.Encode()))
+		if req != nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var res models.ActionUpdate
+	index, err := c.do(ctx, req, &res)
+	if err != nil {
+		return nil, err
+	}
+	res.EtcdIndex = index
+	return &res, nil
+}
+
+// do sends the request, decodes the response into res, and returns the
+// X-Etcd-Index. Error responses are returned as a models.Error.
+func (c *Client) do(ctx context.Context, req *http.Request, res interface{}) (int64, error) {
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	index, _ := strconv.ParseInt(resp.Header.Get("X-Etcd-Index"), 10, 64)
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return index, err
+	}
+
+	if resp.StatusCode >= 400 {
+		var etcdErr models.Error
+		if json.Unmarshal(body, &etcdErr) == nil && etcdErr.ErrorCode != 0 {
+			return index, etcdErr
+		}
+		return index, fmt.Errorf("etcdb: %s %s: %s", req.Method, req.URL.Path, resp.Status)
+	}
+
+	if len(bytes.TrimSpace(body)) == 0 {
+		return index, errEmptyResponse
+	}
+	if err := json.Unmarshal(body, res); err != nil && err != io.EOF {
+		return index, err
+	}
+	return index, nil
+}
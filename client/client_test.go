@@ -0,0 +1,158 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/rancher/etcdb/models"
+)
+
+// fakeServer answers the requests with the handlers, in order, and records
+// the requests
+type fakeServer struct {
+	*httptest.Server
+	requests []string
+	handlers []http.HandlerFunc
+}
+
+func newFakeServer(handlers ...http.HandlerFunc) *fakeServer {
+	s := &fakeServer{handlers: handlers}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		s.requests = append(s.requests, r.Method+" "+r.URL.Path+" "+r.Form.Encode())
+		h := s.handlers[0]
+		s.handlers = s.handlers[1:]
+		h(rw, r)
+	}))
+	return s
+}
+
+func reply(status int, index int64, body interface{}) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("X-Etcd-Index", fmt.Sprint(index))
+		rw.WriteHeader(status)
+		if body != nil {
+			json.NewEncoder(rw).Encode(body)
+		}
+	}
+}
+
+func TestClient_Set(t *testing.T) {
+	s := newFakeServer(reply(201, 5, models.ActionUpdate{
+		Action: "create",
+		Node:   models.Node{Key: "/foo", Value: "bar", ModifiedIndex: 5, CreatedIndex: 5},
+	}))
+	defer s.Close()
+
+	res, err := New(s.URL).Create(context.Background(), "/foo", "bar")
+	ok(t, err)
+	equals(t, []string{"PUT /v2/keys/foo prevExist=false&value=bar"}, s.requests)
+	equals(t, "create", res.Action)
+	equals(t, "bar", res.Node.Value)
+	equals(t, int64(5), res.EtcdIndex)
+}
+
+func TestClient_Set_NoValueOnSuccess(t *testing.T) {
+	s := newFakeServer(reply(200, 6, models.ActionOnly{Action: "set"}))
+	defer s.Close()
+
+	res, err := New(s.URL).Set(context.Background(), "/foo", "bar", &SetOptions{NoValueOnSuccess: true})
+	ok(t, err)
+	equals(t, []string{"PUT /v2/keys/foo noValueOnSuccess=true&value=bar"}, s.requests)
+	equals(t, "set", res.Action)
+	equals(t, "", res.Node.Key)
+	equals(t, int64(6), res.EtcdIndex)
+}
+
+func TestClient_Error(t *testing.T) {
+	s := newFakeServer(reply(404, 7, models.NotFound("/foo", 7)))
+	defer s.Close()
+
+	_, err := New(s.URL).Get(context.Background(), "/foo", &GetOptions{AtIndex: 3})
+	equals(t, models.NotFound("/foo", 7), err)
+	equals(t, []string{"GET /v2/keys/foo atIndex=3"}, s.requests)
+}
+
+func TestClient_Txn(t *testing.T) {
+	var body map[string][]TxnOp
+	s := newFakeServer(func(rw http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(data, &body)
+		reply(200, 3, models.TxnResult{Results: []*models.ActionUpdate{
+			{Action: "compareAndSwap", Node: models.Node{Key: "/a", Value: "2"}},
+		}})(rw, r)
+	})
+	defer s.Close()
+
+	prev := "1"
+	ops := []TxnOp{{Action: "set", Key: "/a", Value: "2", PrevValue: &prev}}
+	results, err := New(s.URL).Txn(context.Background(), ops)
+	ok(t, err)
+	equals(t, ops, body["ops"])
+	equals(t, "compareAndSwap", results[0].Action)
+}
+
+func TestWatcher_Reconnects(t *testing.T) {
+	s := newFakeServer(
+		reply(404, 10, models.NotFound("/foo", 10)),
+		// the watch timed out
+		reply(200, 10, nil),
+		reply(502, 10, nil),
+		reply(200, 12, models.ActionUpdate{Action: "set", Node: models.Node{Key: "/foo", ModifiedIndex: 12}}),
+		reply(200, 13, models.ActionUpdate{Action: "delete", Node: models.Node{Key: "/foo", ModifiedIndex: 13}}),
+	)
+	defer s.Close()
+
+	w := New(s.URL).Watch("/foo", &WatchOptions{RetryDelay: time.Millisecond})
+	res, err := w.Next(context.Background())
+	ok(t, err)
+	equals(t, int64(12), res.Node.ModifiedIndex)
+	res, err = w.Next(context.Background())
+	ok(t, err)
+	equals(t, "delete", res.Action)
+
+	equals(t, []string{
+		"GET /v2/keys/foo ",
+		"GET /v2/keys/foo wait=true&waitIndex=11",
+		"GET /v2/keys/foo wait=true&waitIndex=11",
+		"GET /v2/keys/foo wait=true&waitIndex=11",
+		"GET /v2/keys/foo wait=true&waitIndex=13",
+	}, s.requests)
+}
+
+func TestWatcher_IndexCleared(t *testing.T) {
+	s := newFakeServer(reply(400, 2000, models.EventIndexCleared(1001, 2, 2000)))
+	defer s.Close()
+
+	w := New(s.URL).Watch("/foo", &WatchOptions{Recursive: true, AfterIndex: 1})
+	_, err := w.Next(context.Background())
+	equals(t, models.EventIndexCleared(1001, 2, 2000), err)
+	equals(t, []string{"GET /v2/keys/foo recursive=true&wait=true&waitIndex=2"}, s.requests)
+}
+
+// ok fails the test if an err is not nil.
+func ok(tb testing.TB, err error) {
+	if err != nil {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d: unexpected error: %s\033[39m\n\n", filepath.Base(file), line, err.Error())
+		tb.FailNow()
+	}
+}
+
+// equals fails the test if exp is not equal to act.
+func equals(tb testing.TB, exp, act interface{}) {
+	if !reflect.DeepEqual(exp, act) {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d:\n\n\texp: %#v\n\n\tgot: %#v\033[39m\n\n", filepath.Base(file), line, exp, act)
+		tb.FailNow()
+	}
+}
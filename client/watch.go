@@ -0,0 +1,101 @@
+package client
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/rancher/etcdb/models"
+)
+
+// WatchOptions are the options of Watch
+type WatchOptions struct {
+	Recursive bool
+	// AfterIndex starts the watch after the index, if positive. Otherwise the
+	// watch starts after the current index, read with a Get of the key.
+	AfterIndex int64
+	// RetryDelay is the delay before reconnecting after an error, or 1 second
+	// if 0
+	RetryDelay time.Duration
+}
+
+// A Watcher returns the changes to a key, or the keys under it, one after
+// the other, so that none are missed across reconnects
+type Watcher struct {
+	client *Client
+	key    string
+	opts   WatchOptions
+	// next is the index of the next change to wait for, or 0 until it is read
+	next int64
+}
+
+// Watch creates a watcher for the key. The requests are sent by Next.
+func (c *Client) Watch(key string, opts *WatchOptions) *Watcher {
+	w := &Watcher{client: c, key: key}
+	if opts != nil {
+		w.opts = *opts
+	}
+	if w.opts.AfterIndex > 0 {
+		w.next = w.opts.AfterIndex + 1
+	}
+	if w.opts.RetryDelay == 0 {
+		w.opts.RetryDelay = time.Second
+	}
+	return w
+}
+
+// Next waits for the next change. The watch is sent again after the server
+// times it out, and after network errors and other errors without an etcd
+// error code, from the index after the last change. Etcd errors are returned,
+// like EventIndexCleared when the next change isn't in the server's history
+// anymore, as is the context's error.
+func (w *Watcher) Next(ctx context.Context) (*models.ActionUpdate, error) {
+	for {
+		res, err := w.next1(ctx)
+		if err == nil {
+			w.next = res.Node.ModifiedIndex + 1
+			return res, nil
+		}
+		if _, ok := err.(models.Error); ok {
+			return nil, err
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err == errEmptyResponse {
+			continue
+		}
+
+		select {
+		case <-time.After(w.opts.RetryDelay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// next1 sends one watch, after reading the current index if needed
+func (w *Watcher) next1(ctx context.Context) (*models.ActionUpdate, error) {
+	if w.next == 0 {
+		res, err := w.client.Get(ctx, w.key, nil)
+		switch err := err.(type) {
+		case nil:
+			w.next = res.EtcdIndex + 1
+		case models.Error:
+			if err.ErrorCode != 100 {
+				return nil, err
+			}
+			w.next = err.Index + 1
+		default:
+			return nil, err
+		}
+	}
+
+	params := url.Values{
+		"wait":      {"true"},
+		"waitIndex": {strconv.FormatInt(w.next, 10)},
+	}
+	setBool(params, "recursive", w.opts.Recursive)
+	return w.client.keys(ctx, "GET", w.key, params)
+}
@@ -0,0 +1,326 @@
+// Command etcdb-compat runs the same sequence of v2 API requests against a
+// real etcd 2.x server and an etcdb server, and reports any differences in
+// the responses.
+//
+// Index values differ between the servers, so they are replaced with
+// placeholders numbered in the order they first appear in each server's
+// responses. TTLs and expiration times are also replaced since they depend on
+// timing.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var etcdURL = flag.String("etcd", "http://localhost:2379", "Client URL of the etcd server.")
+var etcdbURL = flag.String("etcdb", "http://localhost:2380", "Client URL of the etcdb server.")
+var prefix = flag.String("prefix", "/etcdb-compat", "Key prefix used for the test keys. It is deleted recursively before running.")
+var verbose = flag.Bool("v", false, "Print the responses for steps that match too.")
+
+// A step is a single request, sent to both servers. Params are sent in the
+// query string for GET and DELETE, and as a form body otherwise.
+type step struct {
+	Name   string
+	Method string
+	Key    string
+	Params url.Values
+}
+
+func params(pairs ...string) url.Values {
+	v := url.Values{}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		v.Add(pairs[i], pairs[i+1])
+	}
+	return v
+}
+
+var steps = []step{
+	{"get missing key", "GET", "/foo", nil},
+	{"set new key", "PUT", "/foo", params("value", "bar")},
+	{"get key", "GET", "/foo", nil},
+	{"replace key", "PUT", "/foo", params("value", "baz")},
+	{"create existing key", "PUT", "/foo", params("value", "x", "prevExist", "false")},
+	{"update existing key", "PUT", "/foo", params("value", "updated", "prevExist", "true")},
+	{"update missing key", "PUT", "/missing", params("value", "x", "prevExist", "true")},
+	{"compare and swap", "PUT", "/foo", params("value", "swapped", "prevValue", "updated")},
+	{"compare and swap fails", "PUT", "/foo", params("value", "x", "prevValue", "wrong")},
+	{"compare and swap bad index", "PUT", "/foo", params("value", "x", "prevIndex", "1")},
+	{"set with ttl", "PUT", "/ttl", params("value", "bar", "ttl", "100")},
+	{"get with ttl", "GET", "/ttl", nil},
+	{"set without value in response", "PUT", "/novalue", params("value", "x", "noValueOnSuccess", "true")},
+	{"failed set without value in response", "PUT", "/novalue", params("value", "y", "prevValue", "wrong", "noValueOnSuccess", "true")},
+	{"make directory", "PUT", "/dir", params("dir", "true")},
+	{"make existing directory", "PUT", "/dir", params("dir", "true")},
+	{"update directory ttl", "PUT", "/dir", params("dir", "true", "ttl", "100", "prevExist", "true")},
+	{"set in directory", "PUT", "/dir/b", params("value", "2")},
+	{"set in directory again", "PUT", "/dir/a", params("value", "1")},
+	{"set nested key", "PUT", "/dir/sub/c", params("value", "3")},
+	{"set under a file", "PUT", "/foo/bar", params("value", "x")},
+	{"set file to replace", "PUT", "/replaced", params("value", "file")},
+	{"make directory over file", "PUT", "/replaced", params("dir", "true")},
+	{"delete empty directory", "DELETE", "/replaced", params("dir", "true")},
+	{"list directory", "GET", "/dir", nil},
+	{"list directory sorted", "GET", "/dir", params("sorted", "true")},
+	{"list directory recursive sorted", "GET", "/dir", params("recursive", "true", "sorted", "true")},
+	{"delete directory without dir", "DELETE", "/dir", nil},
+	{"delete non-empty directory", "DELETE", "/dir", params("dir", "true")},
+	{"compare and delete directory", "DELETE", "/dir", params("recursive", "true", "prevIndex", "1")},
+	{"compare and delete fails", "DELETE", "/foo", params("prevValue", "wrong")},
+	{"compare and delete", "DELETE", "/foo", params("prevValue", "swapped")},
+	{"delete directory recursive", "DELETE", "/dir", params("recursive", "true")},
+	{"delete missing key", "DELETE", "/missing", nil},
+	{"create in order", "POST", "/queue", params("value", "first")},
+	{"create in order again", "POST", "/queue", params("value", "second")},
+	{"list in order sorted", "GET", "/queue", params("sorted", "true")},
+	{"replace prefix directory", "PUT", "", params("value", "x")},
+	{"list prefix", "GET", "", params("recursive", "true", "sorted", "true")},
+}
+
+// rootSteps are requests for the root, which aren't under the prefix. Both
+// servers must have no other top-level keys than the prefix for them to match.
+var rootSteps = []step{
+	{"get root", "GET", "/", params("sorted", "true")},
+	{"get root without slash", "GET", "", params("sorted", "true")},
+	{"get root recursive sorted", "GET", "/", params("recursive", "true", "sorted", "true")},
+	{"set root", "PUT", "/", params("value", "x")},
+	{"delete root", "DELETE", "/", params("recursive", "true")},
+}
+
+// ignoredHeaders vary between any two responses
+var ignoredHeaders = map[string]bool{
+	"Date":           true,
+	"Content-Length": true,
+}
+
+type response struct {
+	Status  int
+	Headers http.Header
+	Body    interface{}
+}
+
+type normalizer struct {
+	indexes map[string]string
+}
+
+func newNormalizer() *normalizer {
+	return &normalizer{make(map[string]string)}
+}
+
+func (n *normalizer) index(value string) string {
+	if placeholder, ok := n.indexes[value]; ok {
+		return placeholder
+	}
+	placeholder := fmt.Sprintf("<index %d>", len(n.indexes)+1)
+	n.indexes[value] = placeholder
+	return placeholder
+}
+
+var inOrderKey = regexp.MustCompile(`^(.*/)(\d+)$`)
+
+func (n *normalizer) value(name string, v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		// assign placeholders in a stable order
+		names := make([]string, 0, len(v))
+		for k := range v {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		for _, k := range names {
+			v[k] = n.value(k, v[k])
+		}
+		return v
+	case []interface{}:
+		for i, child := range v {
+			v[i] = n.value(name, child)
+		}
+		return v
+	case float64:
+		switch name {
+		case "createdIndex", "modifiedIndex", "index":
+			return n.index(strconv.FormatFloat(v, 'f', -1, 64))
+		case "ttl":
+			return "<ttl>"
+		}
+	case string:
+		switch name {
+		case "expiration":
+			return "<expiration>"
+		case "key":
+			if m := inOrderKey.FindStringSubmatch(v); m != nil {
+				return m[1] + n.index(m[2])
+			}
+		}
+	}
+	return v
+}
+
+// run sends the step's request for the key under the prefix
+func run(client *http.Client, base, prefix string, s step, n *normalizer) (*response, error) {
+	u := strings.TrimRight(base, "/") + "/v2/keys" + prefix + s.Key
+
+	var body *strings.Reader
+	if s.Method == "GET" || s.Method == "DELETE" {
+		if len(s.Params) > 0 {
+			u += "?" + s.Params.Encode()
+		}
+		body = strings.NewReader("")
+	} else {
+		body = strings.NewReader(s.Params.Encode())
+	}
+
+	req, err := http.NewRequest(s.Method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if s.Method != "GET" && s.Method != "DELETE" {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &response{Status: resp.StatusCode, Headers: http.Header{}}
+	for name, values := range resp.Header {
+		if ignoredHeaders[name] {
+			continue
+		}
+		if name == "X-Etcd-Index" {
+			values = []string{n.index(values[0])}
+		}
+		res.Headers[name] = values
+	}
+
+	var js interface{}
+	if err := json.Unmarshal(data, &js); err != nil {
+		res.Body = string(bytes.TrimSpace(data))
+	} else {
+		res.Body = n.value("", js)
+	}
+
+	return res, nil
+}
+
+func compare(etcd, etcdb *response) []string {
+	var diffs []string
+
+	if etcd.Status != etcdb.Status {
+		diffs = append(diffs, fmt.Sprintf("status: etcd %d, etcdb %d", etcd.Status, etcdb.Status))
+	}
+
+	names := make(map[string]bool)
+	for name := range etcd.Headers {
+		names[name] = true
+	}
+	for name := range etcdb.Headers {
+		names[name] = true
+	}
+	var sorted []string
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	for _, name := range sorted {
+		a, b := strings.Join(etcd.Headers[name], ", "), strings.Join(etcdb.Headers[name], ", ")
+		if a != b {
+			diffs = append(diffs, fmt.Sprintf("header %s: etcd %q, etcdb %q", name, a, b))
+		}
+	}
+
+	a, _ := json.Marshal(etcd.Body)
+	b, _ := json.Marshal(etcdb.Body)
+	if !bytes.Equal(a, b) {
+		diffs = append(diffs, fmt.Sprintf("body:\n        etcd  %s\n        etcdb %s", a, b))
+	}
+
+	return diffs
+}
+
+func cleanup(client *http.Client, base string) error {
+	req, err := http.NewRequest("DELETE", strings.TrimRight(base, "/")+"/v2/keys"+*prefix+"?recursive=true", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func main() {
+	flag.Parse()
+
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	for _, base := range []string{*etcdURL, *etcdbURL} {
+		if err := cleanup(client, base); err != nil {
+			fmt.Fprintln(os.Stderr, "error cleaning up:", err)
+			os.Exit(2)
+		}
+	}
+
+	etcdNormalizer, etcdbNormalizer := newNormalizer(), newNormalizer()
+	failed := 0
+
+	all := append(steps, rootSteps...)
+	for i, s := range all {
+		keyPrefix := *prefix
+		if i >= len(steps) {
+			keyPrefix = ""
+		}
+		etcd, err := run(client, *etcdURL, keyPrefix, s, etcdNormalizer)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error requesting etcd:", err)
+			os.Exit(2)
+		}
+		etcdb, err := run(client, *etcdbURL, keyPrefix, s, etcdbNormalizer)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error requesting etcdb:", err)
+			os.Exit(2)
+		}
+
+		diffs := compare(etcd, etcdb)
+		if len(diffs) == 0 {
+			fmt.Printf("PASS  %s %s %s\n", s.Name, s.Method, s.Key)
+			if *verbose {
+				js, _ := json.Marshal(etcd.Body)
+				fmt.Printf("        %d %s\n", etcd.Status, js)
+			}
+			continue
+		}
+
+		failed++
+		fmt.Printf("DIFF  %s %s %s\n", s.Name, s.Method, s.Key)
+		for _, diff := range diffs {
+			fmt.Printf("      %s\n", diff)
+		}
+	}
+
+	fmt.Printf("\n%d of %d steps matched\n", len(all)-failed, len(all))
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
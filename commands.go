@@ -0,0 +1,387 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rancher/etcdb/backend"
+)
+
+// A command is a subcommand of etcdb, run with the arguments after its name
+type command struct {
+	summary string
+	run     func(args []string)
+}
+
+// commands are the subcommands. Without one, the arguments are for serve, as
+// they were before there were subcommands.
+var commands map[string]command
+
+// commands is set in init, since the usage of serve lists them
+func init() {
+	commands = map[string]command{
+		"serve":   {"run the server (the default)", serve},
+		"init":    {"create the missing tables and indexes of the schema", initCommand},
+		"check":   {"check the schema, exiting nonzero if init or migrate is needed", checkCommand},
+		"migrate": {"update an existing schema to the current version", migrateCommand},
+		"schema":  {"print the statements creating the schema, without connecting", schemaCommand},
+		"fsck":    {"check the keys and directories for corruption, and repair it with -repair", fsckCommand},
+		"drop":    {"drop all of the tables", dropCommand},
+		"export":  {"write all keys as JSON for import", exportCommand},
+		"import":  {"set the keys from the JSON of export", importCommand},
+		"restore": {"set the keys from a snapshot in an object store", restoreCommand},
+		"bench":   {"benchmark the database", bench},
+		"get":     {"print the value of a key", getCommand},
+		"set":     {"set the value of a key", setCommand},
+		"rm":      {"remove a key", rmCommand},
+		"ls":      {"list the keys in a directory", lsCommand},
+		"watch":   {"print the changes to a key as they happen", watchCommand},
+	}
+	for name, cmd := range platformCommands {
+		commands[name] = cmd
+	}
+}
+
+// commandList describes the commands for usage messages
+func commandList() string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var lines []string
+	for _, name := range names {
+		lines = append(lines, fmt.Sprintf("    %-8s %s", name, commands[name].summary))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// dbFlags adds the server's database options to the flag set, so that every
+// command connects the same way.
+func dbFlags(fs *flag.FlagSet) {
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		switch {
+		case strings.HasPrefix(f.Name, "db-"), strings.HasPrefix(f.Name, "vault-"), f.Name == "aws-region":
+			fs.Var(f.Value, f.Name, f.Usage)
+		}
+	})
+}
+
+// connectCommand parses the arguments of a command with the flag set and the
+// database options, and connects to the database from the remaining
+// <postgres|mysql> [datasource] arguments.
+func connectCommand(name, description string, fs *flag.FlagSet, args []string) *backend.SqlBackend {
+	dbFlags(fs)
+	fs.Usage = func() {
+		cmd := filepath.Base(os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage of %s %s:\n\n", cmd, name)
+		fmt.Fprintf(os.Stderr, "  %s %s [options] <postgres|mysql> [datasource]\n\n", cmd, name)
+		fmt.Fprintf(os.Stderr, "  %s\n\n", description)
+		fs.PrintDefaults()
+	}
+
+	fs.Parse(args)
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	store, err := connect(fs.Args())
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return store
+}
+
+func initCommand(args []string) {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "Print the statements that would be run, without running them.")
+	store := connectCommand("init", "Creates the tables and indexes that don't exist yet, so it can be run again.", fs, args)
+	defer store.Close()
+
+	initSchema(store, false, *dryRun)
+}
+
+func checkCommand(args []string) {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	store := connectCommand("check", "Checks that the schema is complete and current, and exits with 1 if not.", fs, args)
+	defer store.Close()
+
+	initSchema(store, true, false)
+}
+
+func migrateCommand(args []string) {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "Print the statements that would be run, without running them.")
+	store := connectCommand("migrate", "Updates an existing schema to the current version.", fs, args)
+	defer store.Close()
+
+	status, err := store.CheckSchema()
+	if err != nil {
+		log.Fatalln("error checking db schema:", err)
+	}
+	if status.Empty() {
+		log.Fatalln("there is no schema to migrate, create it with init")
+	}
+	initSchema(store, false, *dryRun)
+}
+
+// initSchema checks the schema, and creates what is missing unless checkOnly
+// is set, in which case it exits with 1 if anything is missing. With dryRun,
+// the statements are printed as a script instead of run.
+func initSchema(store *backend.SqlBackend, checkOnly, dryRun bool) {
+	status, err := store.CheckSchema()
+	if err != nil {
+		log.Fatalln("error checking db schema:", err)
+	}
+	if status.Current() {
+		if dryRun {
+			fmt.Print("-- ")
+		}
+		fmt.Println("db schema is current, version", status.Version)
+		return
+	}
+	if dryRun {
+		printSchemaChanges(store, status)
+		return
+	}
+	if checkOnly || !status.Empty() {
+		fmt.Printf("db schema version is %d, current is %d\n", status.Version, backend.SchemaVersion)
+		for _, missing := range status.Missing {
+			fmt.Println("missing", missing)
+		}
+		for _, obsolete := range status.Obsolete {
+			fmt.Println(obsolete)
+		}
+	}
+	if checkOnly {
+		os.Exit(1)
+	}
+
+	fmt.Println("initializing db schema...")
+	if err := store.CreateSchema(); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// checkSchema exits unless the schema is current, describing how it isn't
+func checkSchema(store *backend.SqlBackend) {
+	status, err := store.CheckSchema()
+	if err != nil {
+		log.Fatalln("error checking db schema:", err)
+	}
+	if status.Current() {
+		return
+	}
+	problems := []string{fmt.Sprintf("version %d, current is %d", status.Version, backend.SchemaVersion)}
+	for _, missing := range status.Missing {
+		problems = append(problems, "missing "+missing)
+	}
+	problems = append(problems, status.Obsolete...)
+	log.Fatalln("db schema isn't current:", strings.Join(problems, ", "))
+}
+
+// printSchemaChanges prints the statements that would create or migrate the
+// schema, with what each is for as a comment, so they can be reviewed and run
+// separately
+func printSchemaChanges(store *backend.SqlBackend, status *backend.SchemaStatus) {
+	changes, err := store.SchemaChanges()
+	if err != nil {
+		log.Fatalln("error planning the db schema changes:", err)
+	}
+	fmt.Printf("-- db schema version is %d, current is %d\n", status.Version, backend.SchemaVersion)
+	writeSchemaChanges(changes)
+}
+
+// writeSchemaChanges prints the statements as a script, with what each group
+// is for as a comment
+func writeSchemaChanges(changes []backend.SchemaChange) {
+	description := ""
+	for _, c := range changes {
+		if c.Description != description {
+			description = c.Description
+			fmt.Printf("\n-- %s\n", description)
+		}
+		fmt.Printf("%s;\n", strings.TrimSpace(c.Statement))
+	}
+}
+
+func schemaCommand(args []string) {
+	fs := flag.NewFlagSet("schema", flag.ExitOnError)
+	dialect := fs.String("dialect", "postgres", "Database type to print the statements for: "+strings.Join(backend.Dialects(), ", ")+".")
+	fs.Usage = func() {
+		cmd := filepath.Base(os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage of %s schema:\n\n", cmd)
+		fmt.Fprintf(os.Stderr, "  %s schema [options]\n\n", cmd)
+		fmt.Fprintf(os.Stderr, "  Prints the statements creating the tables, indexes and first rows of the schema, for a database user with the rights etcdb's doesn't have. Run the server with -strict-schema to check it.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	changes, err := backend.SchemaFor(*dialect)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	fmt.Printf("-- etcdb db schema version %d for %s\n", backend.SchemaVersion, *dialect)
+	writeSchemaChanges(changes)
+}
+
+func fsckCommand(args []string) {
+	fs := flag.NewFlagSet("fsck", flag.ExitOnError)
+	repair := fs.Bool("repair", false, "Repair the problems that can be, in one transaction.")
+	orphans := fs.String("orphans", "delete", "Repair of keys under a missing directory: delete them, or mkdir to create the directory.")
+	store := connectCommand("fsck", "Checks the values against their checksums, and that every key is under existing directories with the right path depth. It exits with 1 if there are problems left.", fs, args)
+	defer store.Close()
+
+	mode := backend.FsckCheckOnly
+	if *repair {
+		mode = backend.FsckRepair(*orphans)
+		if mode != backend.FsckDeleteOrphans && mode != backend.FsckCreateParents {
+			fmt.Fprintf(os.Stderr, "invalid value for -orphans: %s\n", *orphans)
+			os.Exit(2)
+		}
+	}
+	problems, err := store.Fsck(mode)
+	if err != nil {
+		log.Fatalln("error checking keys:", err)
+	}
+	unrepaired := 0
+	for _, problem := range problems {
+		fmt.Println(problem)
+		if problem.Repair == "" {
+			unrepaired++
+		}
+	}
+	if unrepaired > 0 {
+		fmt.Printf("%d problems found\n", unrepaired)
+		os.Exit(1)
+	}
+	fmt.Println("no problems found")
+}
+
+func dropCommand(args []string) {
+	fs := flag.NewFlagSet("drop", flag.ExitOnError)
+	force := fs.Bool("force", false, "Confirm dropping the tables, and all of the keys with them.")
+	store := connectCommand("drop", "Drops all of the tables. It requires -force.", fs, args)
+	defer store.Close()
+
+	if !*force {
+		fmt.Fprintln(os.Stderr, "drop removes all of the keys, confirm with -force")
+		os.Exit(2)
+	}
+	if err := store.DropSchema(); err != nil {
+		log.Fatalln(err)
+	}
+	fmt.Println("dropped db schema")
+}
+
+func exportCommand(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	output := fs.String("o", "-", "File to write to, or - for standard output.")
+	store := connectCommand("export", "Writes all of the keys and their TTLs as a JSON object, in the format of import and /v2/bulk. Empty directories aren't included.", fs, args)
+	defer store.Close()
+
+	values, err := store.Export()
+	if err != nil {
+		log.Fatalln("error exporting:", err)
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer f.Close()
+		w = f
+	}
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(values); err != nil {
+		log.Fatalln("error writing export:", err)
+	}
+}
+
+func importCommand(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	input := fs.String("i", "-", "File to read from, or - for standard input.")
+	store := connectCommand("import", "Sets the keys of a JSON object from export in one transaction, like /v2/bulk.", fs, args)
+	defer store.Close()
+
+	var r io.Reader = os.Stdin
+	if *input != "-" {
+		f, err := os.Open(*input)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var values map[string]backend.BulkValue
+	if err := json.NewDecoder(r).Decode(&values); err != nil {
+		log.Fatalln("error reading import:", err)
+	}
+	if len(values) == 0 {
+		fmt.Println("nothing to import")
+		return
+	}
+
+	res, err := store.BulkSet(values)
+	if err != nil {
+		log.Fatalln("error importing:", err)
+	}
+	fmt.Printf("imported %d keys, index %d\n", res.Count, res.Index)
+}
+
+func restoreCommand(args []string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	from := fs.String("from", envDefault("ETCDB_BACKUP_URL", ""), "URL of the object store with the snapshots, like for -backup-url ($ETCDB_BACKUP_URL).")
+	name := fs.String("snapshot", "", "Name of the snapshot to restore. Defaults to the newest.")
+	list := fs.Bool("list", false, "List the snapshots instead of restoring one.")
+	store := connectCommand("restore", "Sets the keys of a snapshot written by -backup-url in one transaction, like import. Restore into an empty database, since other keys are left as they are.", fs, args)
+	defer store.Close()
+
+	objects, err := backend.OpenObjectStore(*from)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	if *list {
+		names, err := backend.ListSnapshots(objects)
+		if err != nil {
+			log.Fatalln("error listing snapshots:", err)
+		}
+		for _, name := range names {
+			fmt.Println(name)
+		}
+		return
+	}
+
+	snapshot, err := backend.ReadSnapshot(objects, *name)
+	if err != nil {
+		log.Fatalln("error reading snapshot:", err)
+	}
+	if len(snapshot.Keys) == 0 {
+		fmt.Println("nothing to restore")
+		return
+	}
+
+	res, err := store.BulkSet(snapshot.Keys)
+	if err != nil {
+		log.Fatalln("error restoring:", err)
+	}
+	fmt.Printf("restored %d keys from index %d at %s, index %d\n", res.Count, snapshot.Index, snapshot.Time.Format(time.RFC3339), res.Index)
+}
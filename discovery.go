@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// srvServices are the SRV service names of etcd's client URLs, by scheme
+var srvServices = map[string]string{"http": "etcd-client", "https": "etcd-client-ssl"}
+
+// srvClientUrls resolves the client URLs of the instances from the SRV records
+// of the domain, like etcd's -discovery-srv: _etcd-client._tcp for http and
+// _etcd-client-ssl._tcp for https. With a name, the service names get a
+// -<name> suffix.
+func srvClientUrls(domain, name string) (UrlsValue, error) {
+	var urls UrlsValue
+	var lookupErr error
+	for _, scheme := range []string{"http", "https"} {
+		service := srvServices[scheme]
+		if name != "" {
+			service += "-" + name
+		}
+		_, addrs, err := net.LookupSRV(service, "tcp", domain)
+		if err != nil {
+			lookupErr = err
+			continue
+		}
+		for _, addr := range addrs {
+			host := strings.TrimSuffix(addr.Target, ".")
+			urls = append(urls, url.URL{Scheme: scheme, Host: net.JoinHostPort(host, strconv.Itoa(int(addr.Port)))})
+		}
+	}
+	if len(urls) == 0 && lookupErr != nil {
+		return nil, fmt.Errorf("error looking up the SRV records of %s: %v", domain, lookupErr)
+	}
+	return urls, nil
+}
+
+// srvAdvertiseUrls returns the client URLs in the SRV records of the domain
+// that point to this host, on the scheme and port of a listen URL, to
+// advertise them. The host's addresses are those of its interfaces and
+// $ETCDB_ADVERTISE_HOST.
+func srvAdvertiseUrls(domain, name string, listen UrlsValue) (UrlsValue, error) {
+	srv, err := srvClientUrls(domain, name)
+	if err != nil {
+		return nil, err
+	}
+
+	local, err := localAddrs()
+	if err != nil {
+		return nil, err
+	}
+	ports := make(map[string]bool)
+	for _, u := range listen {
+		_, port, _ := net.SplitHostPort(u.Host)
+		ports[u.Scheme+":"+port] = true
+	}
+
+	var urls UrlsValue
+	for _, u := range srv {
+		host, port, _ := net.SplitHostPort(u.Host)
+		if !ports[u.Scheme+":"+port] {
+			continue
+		}
+		addrs, err := net.LookupHost(host)
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if local[addr] {
+				urls = append(urls, u)
+				break
+			}
+		}
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("no SRV record of %s points to this host on a listen port, set -advertise-client-urls", domain)
+	}
+	return urls, nil
+}
+
+// localAddrs returns the addresses of the network interfaces, and of
+// $ETCDB_ADVERTISE_HOST if set
+func localAddrs() (map[string]bool, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	local := make(map[string]bool)
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok {
+			local[ipnet.IP.String()] = true
+		}
+	}
+
+	if host := os.Getenv(advertiseHostEnv); host != "" {
+		resolved, err := net.LookupHost(host)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range resolved {
+			local[addr] = true
+		}
+	}
+	return local, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"os"
+
+	"github.com/rancher/etcdb/models"
+)
+
+// features describes the optional subsystems of this instance for
+// /v2/admin/features. Subsystems that etcdb doesn't implement are listed as
+// disabled, so that clients can check for them without knowing the version.
+func features() models.Features {
+	return models.Features{
+		"auth":           {Enabled: false},
+		"v3":             {Enabled: false},
+		"streamingWatch": {Enabled: false},
+		"webhooks":       {Enabled: false},
+
+		"transactions":     {Enabled: true, Endpoint: "/v2/txn"},
+		"bulkSet":          {Enabled: true, Endpoint: "/v2/bulk"},
+		"subscriptions":    {Enabled: true, Endpoint: "/v2/watch"},
+		"locks":            {Enabled: true, Endpoint: "/v2/lock"},
+		"leader":           {Enabled: true, Endpoint: "/v2/leader"},
+		"compaction":       {Enabled: true, Endpoint: "/v2/admin/compact"},
+		"keyspaceUsage":    {Enabled: true, Endpoint: "/v2/admin/usage"},
+		"storeStats":       {Enabled: true, Endpoint: "/v2/stats/store"},
+		"history":          {Enabled: true},
+		"exists":           {Enabled: true},
+		"noValueOnSuccess": {Enabled: true},
+
+		"quotas": {
+			Enabled: *quotaKeys > 0 || *quotaBytes > 0 || len(*quotaPrefixes) > 0,
+			Settings: map[string]interface{}{
+				"maxKeys":   *quotaKeys,
+				"maxBytes":  *quotaBytes,
+				"softRatio": *quotaSoftRatio,
+				"prefixes":  []string(*quotaPrefixes),
+			},
+		},
+		"recycleBin": {
+			Enabled:  *recycleGrace > 0,
+			Endpoint: "/v2/admin/recycle",
+			Settings: map[string]interface{}{"grace": recycleGrace.String()},
+		},
+		"inOrderSequence":  {Enabled: *inOrderSequence},
+		"valueChecksums":   {Enabled: *valueChecksums},
+		"debugConditions":  {Enabled: *debugConditions},
+		"prefixMetrics":    {Enabled: *prefixMetrics},
+		"vaultCredentials": {Enabled: *vaultDBCreds != ""},
+		"awsIAMAuth":       {Enabled: *dbAuth == "aws-iam"},
+		"templates":        {Enabled: *templatesConfig != ""},
+		"basicAuth":        {Enabled: *requireBasicAuth != "" || *basicAuthFile != ""},
+		"jwtAuth":          {Enabled: *jwtJWKSURL != ""},
+		"maxRequestBytes": {
+			Enabled:  *maxRequestBytes > 0,
+			Settings: map[string]interface{}{"bytes": *maxRequestBytes},
+		},
+		"gracefulRestart": {
+			Enabled:  true,
+			Settings: map[string]interface{}{"reusePort": *reusePort, "drainTimeout": drainTimeout.String()},
+		},
+		"systemdNotify": {
+			Enabled:  os.Getenv("NOTIFY_SOCKET") != "",
+			Settings: map[string]interface{}{"watchdog": os.Getenv("WATCHDOG_USEC") != ""},
+		},
+		"statsd": {
+			Enabled:  *statsdAddress != "",
+			Settings: map[string]interface{}{"address": *statsdAddress, "prefix": *statsdPrefix, "tags": []string(*statsdTags), "interval": statsdInterval.String()},
+		},
+		"slowRequestLog": {
+			Enabled:  *slowRequestThreshold > 0,
+			Settings: map[string]interface{}{"threshold": slowRequestThreshold.String()},
+		},
+		"logOutput": {
+			Enabled:  true,
+			Settings: map[string]interface{}{"output": *logOutput},
+		},
+		"http2": {
+			Enabled:  *http2,
+			Settings: map[string]interface{}{"maxConcurrentStreams": *http2MaxStreams},
+		},
+		"backups": {
+			Enabled:  *backupURL != "",
+			Settings: map[string]interface{}{"interval": backupInterval.String(), "keep": *backupKeep, "maxAge": backupMaxAge.String()},
+		},
+		"discoverySrv": {
+			Enabled:  *discoverySrv != "",
+			Settings: map[string]interface{}{"domain": *discoverySrv, "name": *discoverySrvName},
+		},
+		"mirror": {
+			Enabled:  *mirrorEndpoint != "",
+			Settings: map[string]interface{}{"endpoint": *mirrorEndpoint, "prefixes": *mirrorPrefixes},
+		},
+		"readReplicas": {
+			Enabled:  len(*dbReplicas) > 0,
+			Settings: map[string]interface{}{"replicas": len(*dbReplicas), "maxLag": *maxReplicaLag},
+		},
+		"failover": {
+			Enabled:  len(*dbFailover) > 0,
+			Settings: map[string]interface{}{"datasources": len(*dbFailover), "probeInterval": dbProbeInterval.String(), "probeFailures": *dbProbeFailures},
+		},
+		"healthCheck": {
+			Enabled:  *dbHealthFailures > 0,
+			Settings: map[string]interface{}{"failures": *dbHealthFailures, "interval": dbHealthInterval.String()},
+		},
+		"circuitBreaker": {
+			Enabled: *breakerErrorRate > 0 || *breakerLatency > 0,
+			Settings: map[string]interface{}{
+				"errorRate":   *breakerErrorRate,
+				"latency":     breakerLatency.String(),
+				"window":      breakerWindow.String(),
+				"minRequests": *breakerMinRequests,
+				"openTime":    breakerOpenTime.String(),
+			},
+		},
+		"readCache": {
+			Enabled:  *readCacheSize > 0,
+			Settings: map[string]interface{}{"size": *readCacheSize, "ttl": readCacheTTL.String(), "dirs": *readCacheDirs},
+		},
+	}
+}
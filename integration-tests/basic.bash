@@ -32,7 +32,7 @@ setup() {
   docker run --rm -it \
     --link "$PREFIX-postgres:db" \
     "$PREFIX-etcdb" \
-    -init-db postgres "$db_conn"
+    init postgres "$db_conn"
 
   docker run --name "$PREFIX-etcdb" -d \
     --link "$PREFIX-postgres:db" \
@@ -0,0 +1,61 @@
+//go:build clients
+
+// Package clients tests etcdb's wire compatibility with etcd v2 client
+// libraries, against a running etcdb server at ETCDB_URL. The tests are
+// skipped if it isn't set.
+//
+// The Go client is used directly. Other clients are covered by fixtures in
+// testdata of the requests they send, and the parts of the responses they
+// depend on.
+package clients
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// prefix is the directory used for the test keys, deleted before each test
+const prefix = "/etcdb-clients"
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// endpoint returns the etcdb client URL, after deleting the test keys, or
+// skips the test if there is none.
+func endpoint(t *testing.T) string {
+	url := strings.TrimRight(os.Getenv("ETCDB_URL"), "/")
+	if url == "" {
+		t.Skip("ETCDB_URL is not set")
+	}
+
+	req, err := http.NewRequest("DELETE", url+"/v2/keys"+prefix+"?recursive=true", nil)
+	ok(t, err)
+	resp, err := httpClient.Do(req)
+	ok(t, err)
+	resp.Body.Close()
+
+	return url
+}
+
+func ok(tb testing.TB, err error) {
+	if err != nil {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d: unexpected error: %s\033[39m\n\n", filepath.Base(file), line, err.Error())
+		tb.FailNow()
+	}
+}
+
+// equals fails the test if exp is not equal to act.
+func equals(tb testing.TB, exp, act interface{}) {
+	if !reflect.DeepEqual(exp, act) {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d:\n\n\texp: %#v\n\n\tgot: %#v\033[39m\n\n", filepath.Base(file), line, exp, act)
+		tb.FailNow()
+	}
+}
@@ -0,0 +1,195 @@
+//go:build clients
+
+package clients
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// A fixture is a request recorded from a client library, and the parts of the
+// response that the library depends on.
+//
+// Expected values are matched exactly, except for strings that are patterns:
+// "<any>" matches any value, "<index>" any number, and "$name" a value that
+// is captured the first time it is matched, and must be the same afterwards.
+// Captured values, and $prefix, are also substituted in the request. Objects
+// only need to have the expected fields.
+type fixture struct {
+	Name    string            `json:"name"`
+	Method  string            `json:"method"`
+	Key     string            `json:"key"`
+	Params  map[string]string `json:"params"`
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+	Body    interface{}       `json:"body"`
+}
+
+func loadFixtures(t *testing.T, name string) []fixture {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	ok(t, err)
+	var fixtures []fixture
+	ok(t, json.Unmarshal(data, &fixtures))
+	return fixtures
+}
+
+type matcher struct {
+	vars map[string]string
+}
+
+func (m *matcher) substitute(s string) string {
+	for name, value := range m.vars {
+		s = strings.Replace(s, "$"+name, value, -1)
+	}
+	return s
+}
+
+// match returns a description of each difference between the expected and
+// actual values at the path.
+func (m *matcher) match(path string, exp, act interface{}) []string {
+	if s, isString := exp.(string); isString {
+		switch {
+		case s == "<any>":
+			if act == nil {
+				return []string{path + ": missing"}
+			}
+			return nil
+		case s == "<index>":
+			// headers are always strings
+			if header, isString := act.(string); isString {
+				if _, err := strconv.ParseInt(header, 10, 64); err == nil {
+					return nil
+				}
+			}
+			if _, isNumber := act.(float64); !isNumber {
+				return []string{fmt.Sprintf("%s: expected an index, got %#v", path, act)}
+			}
+			return nil
+		case strings.HasPrefix(s, "$") && !strings.Contains(s, "/"):
+			name := s[1:]
+			value := fmt.Sprint(act)
+			if f, isNumber := act.(float64); isNumber {
+				value = fmt.Sprint(int64(f))
+			}
+			if captured, ok := m.vars[name]; ok {
+				if captured != value {
+					return []string{fmt.Sprintf("%s: expected %s %s, got %s", path, s, captured, value)}
+				}
+				return nil
+			}
+			m.vars[name] = value
+			return nil
+		}
+		exp = m.substitute(s)
+	}
+
+	switch exp := exp.(type) {
+	case map[string]interface{}:
+		obj, isObject := act.(map[string]interface{})
+		if !isObject {
+			return []string{fmt.Sprintf("%s: expected an object, got %#v", path, act)}
+		}
+		names := make([]string, 0, len(exp))
+		for name := range exp {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var diffs []string
+		for _, name := range names {
+			diffs = append(diffs, m.match(path+"."+name, exp[name], obj[name])...)
+		}
+		return diffs
+	case []interface{}:
+		list, isList := act.([]interface{})
+		if !isList || len(list) != len(exp) {
+			return []string{fmt.Sprintf("%s: expected %d elements, got %#v", path, len(exp), act)}
+		}
+		var diffs []string
+		for i := range exp {
+			diffs = append(diffs, m.match(fmt.Sprintf("%s[%d]", path, i), exp[i], list[i])...)
+		}
+		return diffs
+	}
+
+	if fmt.Sprint(exp) != fmt.Sprint(act) {
+		return []string{fmt.Sprintf("%s: expected %#v, got %#v", path, exp, act)}
+	}
+	return nil
+}
+
+// request sends the fixture's request the way the client libraries do:
+// params in the query string for GET and DELETE, and as a form otherwise.
+func (m *matcher) request(base string, f fixture) (*http.Response, error) {
+	params := url.Values{}
+	for name, value := range f.Params {
+		params.Set(name, m.substitute(value))
+	}
+
+	u := base + "/v2/keys" + prefix + f.Key
+	var body string
+	if f.Method == "GET" || f.Method == "DELETE" {
+		if len(params) > 0 {
+			u += "?" + params.Encode()
+		}
+	} else {
+		body = params.Encode()
+	}
+
+	req, err := http.NewRequest(f.Method, u, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	return httpClient.Do(req)
+}
+
+func runFixtures(t *testing.T, name string) {
+	base := endpoint(t)
+	m := &matcher{vars: map[string]string{"prefix": prefix}}
+
+	for _, f := range loadFixtures(t, name) {
+		resp, err := m.request(base, f)
+		ok(t, err)
+		data, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		ok(t, err)
+
+		var diffs []string
+		if resp.StatusCode != f.Status {
+			diffs = append(diffs, fmt.Sprintf("status: expected %d, got %d", f.Status, resp.StatusCode))
+		}
+		for header, exp := range f.Headers {
+			var act interface{}
+			if value := resp.Header.Get(header); value != "" {
+				act = value
+			}
+			diffs = append(diffs, m.match(header, exp, act)...)
+		}
+		if f.Body != nil {
+			var body interface{}
+			if err := json.Unmarshal(data, &body); err != nil {
+				diffs = append(diffs, fmt.Sprintf("body: %v in %q", err, data))
+			} else {
+				diffs = append(diffs, m.match("body", f.Body, body)...)
+			}
+		}
+
+		if len(diffs) > 0 {
+			t.Errorf("%s: %s %s\n\t%s", f.Name, f.Method, f.Key, strings.Join(diffs, "\n\t"))
+		}
+	}
+}
+
+func TestFixtures_PythonEtcd(t *testing.T) {
+	runFixtures(t, "python-etcd.json")
+}
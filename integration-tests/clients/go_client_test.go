@@ -0,0 +1,215 @@
+//go:build clients
+
+package clients
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/client"
+)
+
+func keysAPI(t *testing.T) client.KeysAPI {
+	c, err := client.New(client.Config{
+		Endpoints:               []string{endpoint(t)},
+		Transport:               client.DefaultTransport,
+		HeaderTimeoutPerRequest: 5 * time.Second,
+	})
+	ok(t, err)
+	return client.NewKeysAPI(c)
+}
+
+// expectCode fails the test if err isn't an etcd error with the code
+func expectCode(t *testing.T, code int, err error) client.Error {
+	etcdErr, isEtcdErr := err.(client.Error)
+	equals(t, true, isEtcdErr)
+	equals(t, code, etcdErr.Code)
+	return etcdErr
+}
+
+func TestGoClient_SetGet(t *testing.T) {
+	kapi := keysAPI(t)
+	ctx := context.Background()
+
+	_, err := kapi.Get(ctx, prefix+"/foo", nil)
+	equals(t, true, client.IsKeyNotFound(err))
+
+	resp, err := kapi.Set(ctx, prefix+"/foo", "bar", nil)
+	ok(t, err)
+	equals(t, "set", resp.Action)
+	equals(t, "bar", resp.Node.Value)
+	// the index is read from the X-Etcd-Index header
+	equals(t, resp.Node.ModifiedIndex, resp.Index)
+
+	resp, err = kapi.Get(ctx, prefix+"/foo", nil)
+	ok(t, err)
+	equals(t, "get", resp.Action)
+	equals(t, prefix+"/foo", resp.Node.Key)
+	equals(t, "bar", resp.Node.Value)
+}
+
+func TestGoClient_Create(t *testing.T) {
+	kapi := keysAPI(t)
+	ctx := context.Background()
+
+	resp, err := kapi.Create(ctx, prefix+"/foo", "bar")
+	ok(t, err)
+	equals(t, "create", resp.Action)
+
+	_, err = kapi.Create(ctx, prefix+"/foo", "baz")
+	etcdErr := expectCode(t, client.ErrorCodeNodeExist, err)
+	equals(t, prefix+"/foo", etcdErr.Cause)
+	equals(t, resp.Index, etcdErr.Index)
+
+	_, err = kapi.Update(ctx, prefix+"/missing", "bar")
+	expectCode(t, client.ErrorCodeKeyNotFound, err)
+}
+
+func TestGoClient_CompareAndSwap(t *testing.T) {
+	kapi := keysAPI(t)
+	ctx := context.Background()
+
+	set, err := kapi.Set(ctx, prefix+"/foo", "bar", nil)
+	ok(t, err)
+
+	resp, err := kapi.Set(ctx, prefix+"/foo", "baz", &client.SetOptions{PrevValue: "bar"})
+	ok(t, err)
+	equals(t, "compareAndSwap", resp.Action)
+	equals(t, "bar", resp.PrevNode.Value)
+
+	_, err = kapi.Set(ctx, prefix+"/foo", "x", &client.SetOptions{PrevIndex: set.Node.ModifiedIndex})
+	expectCode(t, client.ErrorCodeTestFailed, err)
+
+	_, err = kapi.Delete(ctx, prefix+"/foo", &client.DeleteOptions{PrevValue: "bar"})
+	expectCode(t, client.ErrorCodeTestFailed, err)
+
+	resp, err = kapi.Delete(ctx, prefix+"/foo", &client.DeleteOptions{PrevValue: "baz"})
+	ok(t, err)
+	equals(t, "compareAndDelete", resp.Action)
+}
+
+func TestGoClient_Directories(t *testing.T) {
+	kapi := keysAPI(t)
+	ctx := context.Background()
+
+	_, err := kapi.Set(ctx, prefix+"/dir", "", &client.SetOptions{Dir: true})
+	ok(t, err)
+	_, err = kapi.Set(ctx, prefix+"/dir/b", "2", nil)
+	ok(t, err)
+	_, err = kapi.Set(ctx, prefix+"/dir/a", "1", nil)
+	ok(t, err)
+
+	resp, err := kapi.Get(ctx, prefix+"/dir", &client.GetOptions{Recursive: true, Sort: true})
+	ok(t, err)
+	equals(t, true, resp.Node.Dir)
+	equals(t, 2, len(resp.Node.Nodes))
+	equals(t, prefix+"/dir/a", resp.Node.Nodes[0].Key)
+	equals(t, prefix+"/dir/b", resp.Node.Nodes[1].Key)
+
+	_, err = kapi.Delete(ctx, prefix+"/dir", nil)
+	expectCode(t, client.ErrorCodeNotFile, err)
+
+	resp, err = kapi.Delete(ctx, prefix+"/dir", &client.DeleteOptions{Dir: true, Recursive: true})
+	ok(t, err)
+	equals(t, "delete", resp.Action)
+	equals(t, true, resp.PrevNode.Dir)
+}
+
+func TestGoClient_CreateInOrder(t *testing.T) {
+	kapi := keysAPI(t)
+	ctx := context.Background()
+
+	first, err := kapi.CreateInOrder(ctx, prefix+"/queue", "first", nil)
+	ok(t, err)
+	equals(t, "create", first.Action)
+	second, err := kapi.CreateInOrder(ctx, prefix+"/queue", "second", &client.CreateInOrderOptions{TTL: time.Minute})
+	ok(t, err)
+	equals(t, int64(60), second.Node.TTL)
+
+	resp, err := kapi.Get(ctx, prefix+"/queue", &client.GetOptions{Sort: true})
+	ok(t, err)
+	equals(t, 2, len(resp.Node.Nodes))
+	equals(t, first.Node.Key, resp.Node.Nodes[0].Key)
+	equals(t, second.Node.Key, resp.Node.Nodes[1].Key)
+}
+
+func TestGoClient_TTL(t *testing.T) {
+	kapi := keysAPI(t)
+	ctx := context.Background()
+
+	resp, err := kapi.Set(ctx, prefix+"/foo", "bar", &client.SetOptions{TTL: 100 * time.Second})
+	ok(t, err)
+	equals(t, int64(100), resp.Node.TTL)
+	equals(t, true, resp.Node.Expiration != nil)
+}
+
+func TestGoClient_Watch(t *testing.T) {
+	kapi := keysAPI(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	set, err := kapi.Set(ctx, prefix+"/dir/foo", "bar", nil)
+	ok(t, err)
+
+	// the watcher follows the changes by the modified index of each one
+	w := kapi.Watcher(prefix+"/dir", &client.WatcherOptions{AfterIndex: set.Index - 1, Recursive: true})
+
+	resp, err := w.Next(ctx)
+	ok(t, err)
+	equals(t, "set", resp.Action)
+	equals(t, prefix+"/dir/foo", resp.Node.Key)
+
+	go kapi.Delete(ctx, prefix+"/dir/foo", nil)
+
+	resp, err = w.Next(ctx)
+	ok(t, err)
+	equals(t, "delete", resp.Action)
+	equals(t, "bar", resp.PrevNode.Value)
+}
+
+func TestGoClient_GetIndexStartsWatch(t *testing.T) {
+	kapi := keysAPI(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	set, err := kapi.Set(ctx, prefix+"/foo", "bar", nil)
+	ok(t, err)
+	_, err = kapi.Set(ctx, prefix+"/other", "baz", nil)
+	ok(t, err)
+
+	// the GET's index is the store's index, not the node's
+	get, err := kapi.Get(ctx, prefix+"/foo", nil)
+	ok(t, err)
+	equals(t, set.Node.ModifiedIndex, get.Node.ModifiedIndex)
+	equals(t, true, get.Index > get.Node.ModifiedIndex)
+
+	// so a watch after it gets the next change, without EventIndexCleared
+	w := kapi.Watcher(prefix+"/foo", &client.WatcherOptions{AfterIndex: get.Index})
+	go kapi.Set(ctx, prefix+"/foo", "next", nil)
+
+	resp, err := w.Next(ctx)
+	ok(t, err)
+	equals(t, "next", resp.Node.Value)
+	equals(t, resp.Node.ModifiedIndex, resp.Index)
+}
+
+func TestGoClient_WatchIndex(t *testing.T) {
+	kapi := keysAPI(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	set, err := kapi.Set(ctx, prefix+"/foo", "bar", nil)
+	ok(t, err)
+	last, err := kapi.Set(ctx, prefix+"/other", "baz", nil)
+	ok(t, err)
+
+	// like etcd, a change that already happened has the index of the
+	// watcher, once it has polled the changes
+	time.Sleep(2 * time.Second)
+	w := kapi.Watcher(prefix+"/foo", &client.WatcherOptions{AfterIndex: set.Index - 1})
+	resp, err := w.Next(ctx)
+	ok(t, err)
+	equals(t, set.Node.ModifiedIndex, resp.Node.ModifiedIndex)
+	equals(t, true, resp.Index >= last.Index)
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/rancher/etcdb/backend"
+	"github.com/rancher/etcdb/client"
+	"github.com/rancher/etcdb/models"
+)
+
+// keysTarget is what the get, set, rm, ls and watch commands use: the client
+// of a running server, or with -offline, the database
+type keysTarget struct {
+	client *client.Client
+	store  *backend.SqlBackend
+}
+
+// keysCommand parses the arguments of a keys command with the flag set, and
+// returns the target and the arguments for the command, from min to max of
+// them: the target is the -endpoint server, or with -offline, the database of
+// the <postgres|mysql> [datasource] arguments after them.
+func keysCommand(name, synopsis, description string, min, max int, fs *flag.FlagSet, args []string) (*keysTarget, []string) {
+	endpoint := fs.String("endpoint", envDefault("ETCDB_ENDPOINT", "http://127.0.0.1:2379"), "Client URL of the server ($ETCDB_ENDPOINT).")
+	offline := fs.Bool("offline", false, "Use the database directly instead of a server, with its <postgres|mysql> [datasource] after the arguments.")
+	dbFlags(fs)
+	fs.Usage = func() {
+		cmd := filepath.Base(os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage of %s %s:\n\n", cmd, name)
+		fmt.Fprintf(os.Stderr, "  %s %s [options] %s\n", cmd, name, synopsis)
+		fmt.Fprintf(os.Stderr, "  %s %s -offline [options] %s <postgres|mysql> [datasource]\n\n", cmd, name, synopsis)
+		fmt.Fprintf(os.Stderr, "  %s\n\n", description)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	// with -offline, the database arguments start at the dialect
+	keys, dbArgs := fs.Args(), []string(nil)
+	if *offline {
+		for i, arg := range keys {
+			if isDialect(arg) {
+				keys, dbArgs = keys[:i], keys[i:]
+				break
+			}
+		}
+		if len(dbArgs) < 1 || len(dbArgs) > 2 {
+			fs.Usage()
+			os.Exit(2)
+		}
+	}
+	if len(keys) < min || len(keys) > max {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if !*offline {
+		return &keysTarget{client: client.New(*endpoint)}, keys
+	}
+	store, err := connect(dbArgs)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return &keysTarget{store: store}, keys
+}
+
+func isDialect(name string) bool {
+	for _, dialect := range backend.Dialects() {
+		if name == dialect {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *keysTarget) Close() {
+	if t.store != nil {
+		t.store.Close()
+	}
+}
+
+func (t *keysTarget) get(key string, recursive bool) (*models.Node, error) {
+	if t.store != nil {
+		node, _, err := t.store.GetConsistent(key, recursive)
+		return node, err
+	}
+	res, err := t.client.Get(context.Background(), key, &client.GetOptions{Recursive: recursive, Sorted: true})
+	if err != nil {
+		return nil, err
+	}
+	return &res.Node, nil
+}
+
+func (t *keysTarget) set(key, value string, ttl *int64) (*models.Node, error) {
+	if t.store != nil {
+		var node *models.Node
+		var err error
+		if ttl != nil {
+			node, _, err = t.store.SetTTL(key, value, *ttl, backend.Always)
+		} else {
+			node, _, err = t.store.Set(key, value, backend.Always)
+		}
+		return node, err
+	}
+	res, err := t.client.Set(context.Background(), key, value, &client.SetOptions{TTL: ttl})
+	if err != nil {
+		return nil, err
+	}
+	return &res.Node, nil
+}
+
+func (t *keysTarget) remove(key string, dir, recursive bool) error {
+	if t.store != nil {
+		var err error
+		if dir || recursive {
+			_, _, err = t.store.RmDir(key, recursive, backend.Always)
+		} else {
+			_, _, err = t.store.Delete(key, backend.Always)
+		}
+		return err
+	}
+	_, err := t.client.Delete(context.Background(), key, &client.DeleteOptions{Dir: dir, Recursive: recursive})
+	return err
+}
+
+// watch returns a function waiting for the next change to the key, or the
+// keys under it, after the index, or after the current index if 0
+func (t *keysTarget) watch(key string, recursive bool, afterIndex int64) func() (*models.ActionUpdate, error) {
+	if t.store == nil {
+		w := t.client.Watch(key, &client.WatchOptions{Recursive: recursive, AfterIndex: afterIndex})
+		return func() (*models.ActionUpdate, error) {
+			return w.Next(context.Background())
+		}
+	}
+
+	cw := backend.Watch(t.store, *watchPoll)
+	next := int64(0)
+	if afterIndex > 0 {
+		next = afterIndex + 1
+	}
+	return func() (*models.ActionUpdate, error) {
+		res, err := cw.NextChange(key, recursive, next)
+		if err != nil {
+			return nil, err
+		}
+		next = res.Node.ModifiedIndex + 1
+		return res, nil
+	}
+}
+
+func getCommand(args []string) {
+	fs := flag.NewFlagSet("get", flag.ExitOnError)
+	target, keys := keysCommand("get", "<key>", "Prints the value of the key.", 1, 1, fs, args)
+	defer target.Close()
+
+	node, err := target.get(keys[0], false)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if node.Dir {
+		log.Fatalln(node.Key + ": is a directory")
+	}
+	fmt.Println(node.Value)
+}
+
+func setCommand(args []string) {
+	fs := flag.NewFlagSet("set", flag.ExitOnError)
+	ttl := fs.Int64("ttl", 0, "Time to live of the key in seconds, or 0 to keep it until deleted.")
+	target, keys := keysCommand("set", "<key> <value>", "Sets the value of the key, and prints it.", 2, 2, fs, args)
+	defer target.Close()
+
+	var ttlp *int64
+	if *ttl > 0 {
+		ttlp = ttl
+	}
+	node, err := target.set(keys[0], keys[1], ttlp)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	fmt.Println(node.Value)
+}
+
+func rmCommand(args []string) {
+	fs := flag.NewFlagSet("rm", flag.ExitOnError)
+	dir := fs.Bool("dir", false, "Remove the key if it is an empty directory.")
+	recursive := fs.Bool("r", false, "Remove the key even if it is a directory with keys under it.")
+	target, keys := keysCommand("rm", "<key>", "Removes the key.", 1, 1, fs, args)
+	defer target.Close()
+
+	if err := target.remove(keys[0], *dir, *recursive); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+func lsCommand(args []string) {
+	fs := flag.NewFlagSet("ls", flag.ExitOnError)
+	recursive := fs.Bool("r", false, "List the keys under the subdirectories too.")
+	target, keys := keysCommand("ls", "[key]", "Lists the keys in the directory, / by default, in order. Directories end with a slash.", 0, 1, fs, args)
+	defer target.Close()
+
+	key := "/"
+	if len(keys) > 0 {
+		key = keys[0]
+	}
+	node, err := target.get(key, *recursive)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if !node.Dir {
+		fmt.Println(node.Key)
+		return
+	}
+	printNodes(node.Nodes)
+}
+
+// printNodes prints the keys of the nodes sorted, and those under the
+// directories after each of them
+func printNodes(nodes []*models.Node) {
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Key < nodes[j].Key })
+	for _, node := range nodes {
+		if node.Dir {
+			fmt.Println(node.Key + "/")
+			printNodes(node.Nodes)
+		} else {
+			fmt.Println(node.Key)
+		}
+	}
+}
+
+func watchCommand(args []string) {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	recursive := fs.Bool("r", false, "Watch the keys under the key too, if it is a directory.")
+	afterIndex := fs.Int64("after-index", 0, "Start after the index, instead of after the current one.")
+	count := fs.Int("n", 0, "Exit after the number of changes, or 0 to keep watching.")
+	target, keys := keysCommand("watch", "<key>", "Prints the changes to the key as they happen, a line each with the index, action and key, followed by the value unless it was deleted.", 1, 1, fs, args)
+	defer target.Close()
+
+	next := target.watch(keys[0], *recursive, *afterIndex)
+	for n := 0; *count == 0 || n < *count; n++ {
+		res, err := next()
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if res.Node.Dir || res.Action == "delete" || res.Action == "expire" || res.Action == "compareAndDelete" {
+			fmt.Println(res.Node.ModifiedIndex, res.Action, res.Node.Key)
+		} else {
+			fmt.Println(res.Node.ModifiedIndex, res.Action, res.Node.Key, res.Node.Value)
+		}
+	}
+}
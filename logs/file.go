@@ -0,0 +1,139 @@
+package logs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotatedSuffix is the time layout appended to the names of rotated files,
+// which sort by it
+const rotatedSuffix = ".20060102-150405.000"
+
+// File is a log file that is rotated by size and age. Rotated files are
+// renamed with the time of the rotation appended, and the oldest are removed.
+type File struct {
+	path     string
+	rotation Rotation
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	opened time.Time
+	now    func() time.Time
+}
+
+// OpenFile opens the log file at the path for appending, creating it if it
+// doesn't exist.
+func OpenFile(path string, rotation Rotation) (*File, error) {
+	f := &File{path: path, rotation: rotation, now: time.Now}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *File) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.f = file
+	f.size = info.Size()
+	f.opened = f.now()
+	return nil
+}
+
+// Write writes a log line, rotating the file first if the line would make it
+// larger than MaxBytes, or it is older than MaxAge
+func (f *File) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.due(int64(len(p))) {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.f.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *File) due(n int64) bool {
+	if f.size == 0 {
+		return false
+	}
+	if f.rotation.MaxBytes > 0 && f.size+n > f.rotation.MaxBytes {
+		return true
+	}
+	return f.rotation.MaxAge > 0 && f.now().Sub(f.opened) >= f.rotation.MaxAge
+}
+
+func (f *File) rotate() error {
+	if err := f.f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.path, f.path+f.now().UTC().Format(rotatedSuffix)); err != nil {
+		return err
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	return f.prune()
+}
+
+// prune removes the oldest rotated files beyond MaxFiles
+func (f *File) prune() error {
+	if f.rotation.MaxFiles <= 0 {
+		return nil
+	}
+	rotated, err := f.Rotated()
+	if err != nil {
+		return err
+	}
+	for len(rotated) > f.rotation.MaxFiles {
+		if err := os.Remove(rotated[0]); err != nil {
+			return err
+		}
+		rotated = rotated[1:]
+	}
+	return nil
+}
+
+// Rotated lists the paths of the rotated files, oldest first
+func (f *File) Rotated() ([]string, error) {
+	dir, base := filepath.Split(f.path)
+	infos, err := ioutil.ReadDir(filepath.Clean(dir))
+	if err != nil {
+		return nil, err
+	}
+	var rotated []string
+	for _, info := range infos {
+		name := info.Name()
+		if !strings.HasPrefix(name, base) {
+			continue
+		}
+		if _, err := time.Parse(rotatedSuffix, name[len(base):]); err == nil {
+			rotated = append(rotated, filepath.Join(dir, name))
+		}
+	}
+	sort.Strings(rotated)
+	return rotated, nil
+}
+
+// Close closes the file
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.f.Close()
+}
@@ -0,0 +1,67 @@
+package logs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// journalSocket is where journald receives entries with its native protocol
+var journalSocket = "/run/systemd/journal/socket"
+
+// syslog priorities of the journal entries
+const (
+	priorityErr  = 3
+	priorityInfo = 6
+)
+
+// journalWriter sends each log line as a journal entry with the error or
+// info priority
+type journalWriter struct {
+	conn *net.UnixConn
+}
+
+func openJournal() (io.Writer, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return journalWriter{conn}, nil
+}
+
+func (j journalWriter) Write(p []byte) (int, error) {
+	line := strings.TrimSuffix(string(p), "\n")
+	priority := priorityInfo
+	if isError(line) {
+		priority = priorityErr
+	}
+	if _, err := j.conn.Write(journalEntry(line, priority)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// journalEntry encodes the fields of an entry. Values with newlines are
+// sized instead of ending at the newline.
+func journalEntry(message string, priority int) []byte {
+	var buf bytes.Buffer
+	for _, field := range [][2]string{
+		{"MESSAGE", message},
+		{"PRIORITY", strconv.Itoa(priority)},
+		{"SYSLOG_IDENTIFIER", Identifier},
+	} {
+		buf.WriteString(field[0])
+		if strings.Contains(field[1], "\n") {
+			buf.WriteByte('\n')
+			binary.Write(&buf, binary.LittleEndian, uint64(len(field[1])))
+		} else {
+			buf.WriteByte('=')
+		}
+		buf.WriteString(field[1])
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
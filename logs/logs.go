@@ -0,0 +1,71 @@
+// Package logs writes the log to the log output targets of the server: the
+// standard streams, a file rotated by size and age, syslog or journald.
+package logs
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// Identifier is the program name given to syslog and journald
+const Identifier = "etcdb"
+
+// Rotation configures the rotation of file outputs
+type Rotation struct {
+	// MaxBytes rotates the file before it grows larger, if positive
+	MaxBytes int64
+	// MaxAge rotates the file once it was written to for that long, if
+	// positive
+	MaxAge time.Duration
+	// MaxFiles is the number of rotated files kept, or all if 0
+	MaxFiles int
+}
+
+// Open returns the writer for the output, which is stderr, stdout,
+// file:<path>, syslog or journald. It also reports whether the output adds
+// its own timestamps, so that the log's can be left out.
+func Open(output string, rotation Rotation) (w io.Writer, timestamped bool, err error) {
+	switch {
+	case output == "" || output == "stderr":
+		return os.Stderr, false, nil
+	case output == "stdout":
+		return os.Stdout, false, nil
+	case strings.HasPrefix(output, "file:"):
+		path := strings.TrimPrefix(output, "file:")
+		if path == "" {
+			return nil, false, fmt.Errorf("missing path in %s", output)
+		}
+		f, err := OpenFile(path, rotation)
+		return f, false, err
+	case output == "syslog":
+		w, err := openSyslog()
+		return w, true, err
+	case output == "journald":
+		w, err := openJournal()
+		return w, true, err
+	}
+	return nil, false, fmt.Errorf("unknown log output %q, expected stderr, stdout, file:<path>, syslog or journald", output)
+}
+
+// SetOutput makes the standard logger write to the output
+func SetOutput(output string, rotation Rotation) error {
+	w, timestamped, err := Open(output, rotation)
+	if err != nil {
+		return err
+	}
+	if timestamped {
+		log.SetFlags(0)
+	}
+	log.SetOutput(w)
+	return nil
+}
+
+// isError reports whether the log line is about an error, by the "error"
+// that error messages start with
+func isError(line string) bool {
+	return strings.HasPrefix(line, "error")
+}
@@ -0,0 +1,122 @@
+package logs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func Test_File_RotatesBySize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "etcdb-logs")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	path := filepath.Join(dir, "etcdb.log")
+	f, err := OpenFile(path, Rotation{MaxBytes: 10, MaxFiles: 2})
+	ok(t, err)
+	defer f.Close()
+	f.now = func() time.Time { return now }
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		now = now.Add(time.Second)
+		_, err := f.Write([]byte(line))
+		ok(t, err)
+	}
+
+	// the first rotated file was removed
+	rotated, err := f.Rotated()
+	ok(t, err)
+	equals(t, []string{path + ".20260102-030408.000", path + ".20260102-030409.000"}, rotated)
+	data, err := ioutil.ReadFile(rotated[0])
+	ok(t, err)
+	equals(t, "second\n", string(data))
+	data, err = ioutil.ReadFile(path)
+	ok(t, err)
+	equals(t, "fourth\n", string(data))
+}
+
+func Test_File_RotatesByAge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "etcdb-logs")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	path := filepath.Join(dir, "etcdb.log")
+	f, err := OpenFile(path, Rotation{MaxAge: time.Hour})
+	ok(t, err)
+	defer f.Close()
+	f.now = func() time.Time { return now }
+	f.opened = now
+
+	_, err = f.Write([]byte("old\n"))
+	ok(t, err)
+	now = now.Add(30 * time.Minute)
+	_, err = f.Write([]byte("still\n"))
+	ok(t, err)
+	now = now.Add(30 * time.Minute)
+	_, err = f.Write([]byte("new\n"))
+	ok(t, err)
+
+	rotated, err := f.Rotated()
+	ok(t, err)
+	equals(t, 1, len(rotated))
+	data, err := ioutil.ReadFile(rotated[0])
+	ok(t, err)
+	equals(t, "old\nstill\n", string(data))
+}
+
+func Test_Open_Unknown(t *testing.T) {
+	if _, _, err := Open("kafka", Rotation{}); err == nil {
+		t.Fatal("expected an unknown output to fail")
+	}
+}
+
+func Test_Journal(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("journald is only on Linux")
+	}
+	dir, err := ioutil.TempDir("", "etcdb-logs")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+
+	journalSocket = filepath.Join(dir, "socket")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+	ok(t, err)
+	defer conn.Close()
+
+	w, timestamped, err := Open("journald", Rotation{})
+	ok(t, err)
+	equals(t, true, timestamped)
+	_, err = w.Write([]byte("error reading:\nboth lines\n"))
+	ok(t, err)
+
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	ok(t, err)
+	equals(t, "MESSAGE\n\x19\x00\x00\x00\x00\x00\x00\x00error reading:\nboth lines\nPRIORITY=3\nSYSLOG_IDENTIFIER=etcdb\n", string(buf[:n]))
+}
+
+// ok fails the test if an err is not nil.
+func ok(tb testing.TB, err error) {
+	if err != nil {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d: unexpected error: %s\033[39m\n\n", filepath.Base(file), line, err.Error())
+		tb.FailNow()
+	}
+}
+
+// equals fails the test if exp is not equal to act.
+func equals(tb testing.TB, exp, act interface{}) {
+	if !reflect.DeepEqual(exp, act) {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d:\n\n\texp: %#v\n\n\tgot: %#v\033[39m\n\n", filepath.Base(file), line, exp, act)
+		tb.FailNow()
+	}
+}
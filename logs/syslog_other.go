@@ -0,0 +1,12 @@
+//go:build windows || plan9
+
+package logs
+
+import (
+	"errors"
+	"io"
+)
+
+func openSyslog() (io.Writer, error) {
+	return nil, errors.New("syslog isn't supported on this platform")
+}
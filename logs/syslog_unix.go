@@ -0,0 +1,36 @@
+//go:build !windows && !plan9
+
+package logs
+
+import (
+	"io"
+	"log/syslog"
+	"strings"
+)
+
+// syslogWriter sends each log line with the error or info priority
+type syslogWriter struct {
+	w *syslog.Writer
+}
+
+func openSyslog() (io.Writer, error) {
+	w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, Identifier)
+	if err != nil {
+		return nil, err
+	}
+	return syslogWriter{w}, nil
+}
+
+func (s syslogWriter) Write(p []byte) (int, error) {
+	line := strings.TrimSuffix(string(p), "\n")
+	var err error
+	if isError(line) {
+		err = s.w.Err(line)
+	} else {
+		err = s.w.Info(line)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
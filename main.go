@@ -1,46 +1,78 @@
 package main
 
 import (
-	"encoding/json"
+	"expvar"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
-	"github.com/gorilla/mux"
-
 	"github.com/rancher/etcdb/backend"
-	"github.com/rancher/etcdb/models"
+	"github.com/rancher/etcdb/client"
+	"github.com/rancher/etcdb/logs"
 	"github.com/rancher/etcdb/restapi"
 	"github.com/rancher/etcdb/restapi/operations"
+	"github.com/rancher/etcdb/statsd"
+	"github.com/rancher/etcdb/templates"
 )
 
 type UrlsValue []url.URL
 
+// listenFdOption is the option of listen URLs that selects an inherited
+// listener by its name in LISTEN_FDNAMES or its file descriptor number,
+// instead of by the URL's address
+const listenFdOption = "listen-fd"
+
+// Set parses the comma separated URLs. The errors name the URL that is
+// malformed, and how.
 func (uv *UrlsValue) Set(s string) error {
 	vals := strings.Split(s, ",")
 	urls := make([]url.URL, len(vals))
 
 	for i, val := range vals {
 		val = strings.TrimSpace(val)
+		if val == "" {
+			return fmt.Errorf("URL %d of %d is empty: %s", i+1, len(vals), s)
+		}
 		u, err := url.Parse(val)
 		if err != nil {
-			return err
+			if uerr, ok := err.(*url.Error); ok {
+				err = uerr.Err
+			}
+			return fmt.Errorf("invalid URL %s: %v", val, err)
 		}
-		if u.Scheme != "http" {
-			return fmt.Errorf("URLs must use the http scheme: %s", val)
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("URLs must use the http or https scheme: %s", val)
+		}
+		for name, values := range u.Query() {
+			switch {
+			case name == listenFdOption:
+				if values[0] == "" {
+					return fmt.Errorf("the %s option must be a file descriptor name or number: %s", name, val)
+				}
+			case tlsURLOptions[name]:
+				if u.Scheme != "https" {
+					return fmt.Errorf("only https URLs can include the %s option: %s", name, val)
+				}
+			default:
+				return fmt.Errorf("URLs cannot include the %s option: %s", name, val)
+			}
 		}
 		if u.Path != "" {
 			return fmt.Errorf("URLs cannot include a path: %s", val)
 		}
-		if _, _, err := net.SplitHostPort(u.Host); err != nil {
-			return fmt.Errorf("URLs must include a port: %s", val)
+		if err := checkHostPort(u.Host); err != nil {
+			return fmt.Errorf("%v: %s", err, val)
 		}
 
 		urls[i] = *u
@@ -50,6 +82,38 @@ func (uv *UrlsValue) Set(s string) error {
 	return nil
 }
 
+// checkHostPort checks that the host of a URL is empty or 0.0.0.0 for all
+// interfaces, an IP address, with IPv6 ones in brackets, or a hostname, and
+// that it has a port
+func checkHostPort(hostport string) error {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		if strings.Count(hostport, ":") > 1 && !strings.HasPrefix(hostport, "[") {
+			return fmt.Errorf("IPv6 addresses must be in brackets, like [::1]:2379")
+		}
+		return fmt.Errorf("URLs must include a port")
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	if host == "" {
+		return nil
+	}
+	if strings.Contains(hostport, "[") {
+		// a zone like fe80::1%eth0 names the interface of a link-local address
+		if ip := net.ParseIP(strings.SplitN(host, "%", 2)[0]); ip == nil || ip.To4() != nil {
+			return fmt.Errorf("invalid IPv6 address %q", host)
+		}
+		return nil
+	}
+	for _, label := range strings.Split(host, ".") {
+		if label == "" || strings.Trim(label, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_") != "" {
+			return fmt.Errorf("invalid host %q", host)
+		}
+	}
+	return nil
+}
+
 func (uv *UrlsValue) String() string {
 	// for flags, join with just comma since spaces are less shell-friendly
 	return uv.Join(",")
@@ -70,139 +134,722 @@ func UrlsFlag(name, value, usage string) *UrlsValue {
 	return urls
 }
 
+// StringsValue is a flag that can be repeated, for values that may contain
+// commas like datasources.
+type StringsValue []string
+
+func (sv *StringsValue) Set(s string) error {
+	*sv = append(*sv, s)
+	return nil
+}
+
+func (sv *StringsValue) String() string {
+	return strings.Join(*sv, " ")
+}
+
+func StringsFlag(name, usage string) *StringsValue {
+	strs := &StringsValue{}
+	flag.Var(strs, name, usage)
+	return strs
+}
+
+func envDefault(name, value string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return value
+}
+
 var defaultClientUrls = "http://localhost:2379,http://localhost:4001"
 
-var initDb = flag.Bool("init-db", false, "Initialize the DB schema and exit.")
+var initDb = flag.Bool("init-db", false, "Initialize the DB schema and exit, like the init command.")
+var strictSchema = flag.Bool("strict-schema", false, "Exit at startup unless the schema is complete and of the current version, like the check command, for schemas created with the schema command.")
+var dryRunSchema = flag.Bool("dry-run", false, "With -init-db, print the statements that would be run, without running them.")
+var checkDb = flag.Bool("check-db", false, "Check the DB schema and exit, like the check command.")
 var watchPoll = flag.Duration("watch-poll", 1*time.Second, "Poll rate for watches.")
-var listenClientUrls = UrlsFlag("listen-client-urls", defaultClientUrls, "List of URLs to listen on for client traffic.")
-var advertiseClientUrls = UrlsFlag("advertise-client-urls", defaultClientUrls, "List of public URLs available to access the client.")
+var watchTimeout = flag.Duration("watch-timeout", 0, "How long a wait=true watch waits for a change before an empty response, which clients retry. Waits forever when 0.")
+var memberName = flag.String("name", "", "Name of this instance in the members table. Defaults to the advertised client URLs.")
+var heartbeatInterval = flag.Duration("heartbeat-interval", 10*time.Second, "How often to refresh this instance's heartbeat in the members table.")
+var memberGrace = flag.Duration("member-grace", 1*time.Minute, "How long after its last heartbeat an instance is removed from /v2/machines.")
+var memberMissedHeartbeats = flag.Int("member-missed-heartbeats", 0, "Number of missed heartbeats after which an instance is removed from /v2/machines, instead of -member-grace when set.")
+var recycleGrace = flag.Duration("recycle-grace", 0, "How long recursively deleted keys can be restored from the recycle bin. Disabled when 0.")
+var clockSkewPolicy = flag.String("clock-skew-policy", "freeze", "Handling of jumps in the database clock: off, log, or freeze to also stop expiring keys for as long as the jump.")
+var clockSkewTolerance = flag.Duration("clock-skew-tolerance", 5*time.Second, "Largest database clock jump that is ignored.")
+var expirationObjective = flag.Duration("expiration-objective", 5*time.Second, "Lateness after which key expirations are counted as missed in /debug/vars. Not counted when 0.")
+var inOrderSequence = flag.Bool("in-order-sequence", false, "Name keys created in order (POST) with a counter per directory instead of the global index.")
+var valueChecksums = flag.Bool("value-checksums", false, "Store a checksum of each key and value, for the fsck command to detect corrupted values.")
+var trimInterval = flag.Duration("trim-interval", 1*time.Minute, "How often to remove old changes and deleted keys in the background. When 0, they are removed on every change.")
+var maintenanceInterval = flag.Duration("maintenance-interval", 0, "How often to vacuum (Postgres) or optimize (MySQL) the tables. Disabled when 0.")
+var quotaKeys = flag.Int64("quota-keys", 0, "Maximum number of keys, not counting directories. Unlimited when 0.")
+var quotaBytes = flag.Int64("quota-bytes", 0, "Maximum total size of the key values in bytes. Unlimited when 0.")
+var quotaPrefixes = StringsFlag("quota-prefix", "Quota for the keys under a prefix, as prefix:max-keys:max-bytes with 0 for unlimited, e.g. /tenants/a:1000:0. Can be repeated.")
+var quotaSoftRatio = flag.Float64("quota-soft-ratio", 0.8, "Fraction of a quota after which writes get an X-Etcdb-Quota-Warning header.")
+var quotaRefresh = flag.Duration("quota-refresh", backend.DefaultQuotaRefresh, "How often to measure the usage for the quotas again, counting the expired keys and the writes of other instances.")
+var debugConditions = flag.Bool("debug-conditions", false, "Allow debug=true on writes, which adds the condition and previous node to the cause of failed compares.")
+var prefixMetrics = flag.Bool("prefix-metrics", false, "Count key requests and bytes by top-level key prefix in /debug/vars.")
+var unknownParams = flag.String("unknown-params", "ignore", "Handling of unrecognized request parameters: ignore, log, or reject. They are always counted in /debug/vars.")
+var http2 = flag.Bool("http2", false, "Serve unencrypted HTTP/2 (h2c) with prior knowledge besides HTTP/1.1, so that clients can send many watches over few connections.")
+var http2MaxStreams = flag.Int("http2-max-streams", 1000, "Maximum concurrent requests, like watches, per HTTP/2 connection.")
+var idleTimeout = flag.Duration("idle-timeout", 0, "How long keep-alive connections are kept open without requests. No timeout when 0.")
+var tcpKeepAlive = flag.Duration("tcp-keepalive", 15*time.Second, "Period of TCP keep-alive probes, which notice clients that went away while their watches wait. Disabled when negative.")
+var maxRequestBytes = flag.Int64("max-request-bytes", 1572864, "Maximum size of request bodies, like etcd's 1.5 MiB. Larger requests get error 112. Unlimited when 0.")
+var maxBulkRequestBytes = flag.Int64("max-bulk-request-bytes", 32<<20, "Maximum size of /v2/bulk request bodies, instead of -max-request-bytes. Unlimited when 0.")
+var statsdAddress = flag.String("statsd-address", envDefault("ETCDB_STATSD_ADDRESS", ""), "host:port of a statsd or DogStatsD agent to send the /debug/vars numbers to over UDP. Disabled when empty ($ETCDB_STATSD_ADDRESS).")
+var statsdPrefix = flag.String("statsd-prefix", "etcdb", "Prefix of the statsd metric names.")
+var statsdTags = StringsFlag("statsd-tag", "DogStatsD tag added to the metrics, as name:value or name. Can be repeated.")
+var statsdInterval = flag.Duration("statsd-interval", 10*time.Second, "How often to send the metrics to -statsd-address.")
+var breakerErrorRate = flag.Float64("breaker-error-rate", 0, "Part of the requests, from 0 to 1, failing with a 5xx status that opens the circuit breaker, which sheds the requests to the database with 503 and Retry-After. Not checked when 0.")
+var breakerLatency = flag.Duration("breaker-latency", 0, "Average duration of the requests, except watches, that opens the circuit breaker. Not checked when 0.")
+var breakerWindow = flag.Duration("breaker-window", 10*time.Second, "Period over which the circuit breaker counts the errors and the latency.")
+var breakerMinRequests = flag.Int("breaker-min-requests", 20, "Number of requests in a -breaker-window before the circuit breaker can open.")
+var breakerOpenTime = flag.Duration("breaker-open-time", 5*time.Second, "How long the circuit breaker sheds the requests, before letting one through to check the database.")
+var slowRequestThreshold = flag.Duration("slow-request-threshold", 0, "Requests taking longer are logged with their X-Request-ID, except watches. Not logged when 0.")
+var logOutput = flag.String("log-output", envDefault("ETCDB_LOG_OUTPUT", "stderr"), "Where to log: stderr, stdout, file:<path>, syslog or journald ($ETCDB_LOG_OUTPUT).")
+var logMaxSize = flag.Int64("log-max-size", 100<<20, "Size in bytes at which a file:<path> log is rotated. Not rotated by size when 0.")
+var logMaxAge = flag.Duration("log-max-age", 0, "Age at which a file:<path> log is rotated. Not rotated by age when 0.")
+var logMaxFiles = flag.Int("log-max-files", 5, "Number of rotated logs kept. All are kept when 0.")
+var reusePort = flag.Bool("reuse-port", false, "Listen with SO_REUSEPORT, so that a new version can be started on the same addresses before this one is stopped.")
+var drainTimeout = flag.Duration("drain-timeout", 30*time.Second, "How long to wait for requests in flight on SIGINT or SIGTERM, after ending the watches. Waits until they are done when 0.")
+var disableKeepAlives = flag.Bool("disable-keepalives", false, "Close HTTP/1.1 connections after each request.")
+var mirrorEndpoint = flag.String("mirror-endpoint", "", "Client URL of another etcd or etcdb to replay the changes to. Run the mirror on only one of the instances sharing a database.")
+var mirrorName = flag.String("mirror-name", "mirror", "Name of the subscription keeping the mirror's position.")
+var mirrorPrefixes = StringsFlag("mirror-prefix", "Key prefix to mirror, can be repeated. All keys are mirrored when omitted.")
+var backupURL = flag.String("backup-url", envDefault("ETCDB_BACKUP_URL", ""), "Object store to write snapshots to: s3://bucket/prefix?region=region, gs://bucket/prefix, azure://account/container/prefix or file:///path. Disabled when empty ($ETCDB_BACKUP_URL).")
+var backupInterval = flag.Duration("backup-interval", 1*time.Hour, "How often to write a snapshot to -backup-url.")
+var backupKeep = flag.Int("backup-keep", 24, "Number of the newest snapshots to keep. All are kept when 0.")
+var backupMaxAge = flag.Duration("backup-max-age", 0, "Age after which snapshots are removed, except the newest. Kept when 0.")
+var templatesConfig = flag.String("templates", "", "JSON file of templates to render to files with the keys under their prefixes, again after each change. Disabled when empty.")
+var discoverySrv = flag.String("discovery-srv", "", "Domain whose _etcd-client._tcp SRV records list the client URLs of the instances. This instance advertises those pointing to it, unless -advertise-client-urls is set.")
+var discoverySrvName = flag.String("discovery-srv-name", "", "Suffix of the SRV service name, to use _etcd-client-<name>._tcp records.")
+var certFile = flag.String("cert-file", "", "TLS certificate file for https listen URLs without a cert-file option.")
+var keyFile = flag.String("key-file", "", "TLS key file for https listen URLs without a key-file option.")
+var trustedCAFile = flag.String("trusted-ca-file", "", "CA certificates file to require and verify client certificates with, for https listen URLs without a trusted-ca-file option.")
+var requireBasicAuth = flag.String("require-basic-auth", envDefault("ETCDB_REQUIRE_BASIC_AUTH", ""), "user:password required with basic auth for writes, which otherwise get etcd error 110 ($ETCDB_REQUIRE_BASIC_AUTH).")
+var basicAuthFile = flag.String("basic-auth-file", "", "htpasswd file of the users allowed to write, with {SHA}, MD5 ($apr1$) or plain text passwords.")
+var jwtJWKSURL = flag.String("jwt-jwks-url", "", "URL of the JWKS to verify Authorization: Bearer tokens with, which all requests then require. Disabled when empty.")
+var jwtIssuer = flag.String("jwt-issuer", "", "Issuer (iss) that tokens must have.")
+var jwtAudience = flag.String("jwt-audience", "", "Audience (aud) that tokens must have, if set.")
+var jwtPermissions = flag.String("jwt-permissions", "", "JSON file with the claim of the tokens' roles, and the key prefixes each role can read and write.")
+var listenClientUrls = UrlsFlag("listen-client-urls", defaultClientUrls, "List of URLs to listen on for client traffic. https URLs serve TLS, with the -cert-file, -key-file and -trusted-ca-file, or the same options of the URL like https://10.0.0.1:2379?cert-file=a.crt&key-file=a.key. The listen-fd option serves a socket passed by systemd, by its name or file descriptor number, like http://10.0.0.1:2379?listen-fd=etcdb.")
+var advertiseClientUrls = UrlsFlag("advertise-client-urls", defaultClientUrls, "List of public URLs available to access the client. When omitted and listening on 0.0.0.0, the host is $ETCDB_ADVERTISE_HOST or the primary interface's address.")
+
+var dbHost = flag.String("db-host", envDefault("ETCDB_DB_HOST", ""), "Database host, used when no datasource is given ($ETCDB_DB_HOST).")
+var dbPort = flag.String("db-port", envDefault("ETCDB_DB_PORT", ""), "Database port ($ETCDB_DB_PORT).")
+var dbUser = flag.String("db-user", envDefault("ETCDB_DB_USER", ""), "Database user ($ETCDB_DB_USER).")
+var dbPassword = flag.String("db-password", "", "Database password ($ETCDB_DB_PASSWORD).")
+var dbPasswordFile = flag.String("db-password-file", envDefault("ETCDB_DB_PASSWORD_FILE", ""), "File containing the database password, like a mounted secret. Read again on SIGHUP to reconnect with a rotated password ($ETCDB_DB_PASSWORD_FILE).")
+var dbName = flag.String("db-name", envDefault("ETCDB_DB_NAME", ""), "Database name ($ETCDB_DB_NAME).")
+var dbOptions = flag.String("db-options", envDefault("ETCDB_DB_OPTIONS", ""), "Comma separated name=value driver options, e.g. sslmode=disable ($ETCDB_DB_OPTIONS).")
+
+var vaultAddr = flag.String("vault-addr", envDefault("VAULT_ADDR", ""), "Vault server URL, for database credentials from -vault-db-creds ($VAULT_ADDR).")
+var vaultToken = flag.String("vault-token", envDefault("VAULT_TOKEN", ""), "Vault token ($VAULT_TOKEN).")
+var vaultDBCreds = flag.String("vault-db-creds", envDefault("ETCDB_VAULT_DB_CREDS", ""), "Vault path of dynamic database credentials, like database/creds/etcdb. They replace -db-user and -db-password, and are renewed or replaced before they expire ($ETCDB_VAULT_DB_CREDS).")
+var dbReplicas = StringsFlag("db-replica", "Datasource of a read-only replica that plain GETs are read from, can be repeated. Writes, waits and quorum=true reads use the primary.")
+var dbFailover = StringsFlag("db-failover", "Datasource of a standby to fail over to when the primary can't be reached, like a warm standby promoted to primary. Can be repeated, and is tried in order.")
+var dbProbeInterval = flag.Duration("db-probe-interval", 5*time.Second, "How often the database is pinged, with -db-failover. A ping taking longer fails.")
+var dbProbeFailures = flag.Int("db-probe-failures", 3, "Number of failed pings in a row after which the next -db-failover datasource is switched to.")
+var dbHealthFailures = flag.Int("db-health-failures", 3, "Number of database connection errors in a row after which requests fail fast with 503 Service Unavailable, while the connection pool is reset until the database can be reached. Disabled when 0.")
+var dbHealthInterval = flag.Duration("db-health-interval", 1*time.Second, "How often the connection pool is reset while the database can't be reached, with -db-health-failures.")
+var maxReplicaLag = flag.Int64("max-replica-lag", 0, "How many indexes a replica can be behind the latest index seen by this instance before reads go to the primary.")
+var readCacheSize = flag.Int("read-cache-size", 0, "Number of files read by plain GETs to keep in memory, until they change or -read-cache-ttl passes. Disabled when 0.")
+var readCacheTTL = flag.Duration("read-cache-ttl", 10*time.Second, "Longest time a file is kept in the read cache, in case a change of another instance is missed.")
+var readCacheDirs = flag.Bool("read-cache-dirs", false, "Also keep the listings of directories read by non-recursive GETs in the read cache of -read-cache-size.")
+var dbAuth = flag.String("db-auth", envDefault("ETCDB_DB_AUTH", "password"), "Database authentication: password, or aws-iam to connect to RDS with IAM auth tokens generated from the AWS_* environment variables ($ETCDB_DB_AUTH).")
+var awsRegion = flag.String("aws-region", envDefault("AWS_REGION", ""), "AWS region of the RDS database, for -db-auth aws-iam ($AWS_REGION).")
+
+// connConfig builds the driver-independent connection config from the db-*
+// flags.
+func connConfig() (*backend.ConnConfig, error) {
+	options, err := backend.ParseOptions(*dbOptions)
+	if err != nil {
+		return nil, err
+	}
+	// the password isn't the flag's default, which -h would print
+	password := *dbPassword
+	if password == "" {
+		password = os.Getenv("ETCDB_DB_PASSWORD")
+	}
+	config := &backend.ConnConfig{
+		Host:     *dbHost,
+		User:     *dbUser,
+		Password: password,
+		DBName:   *dbName,
+		Options:  options,
+	}
+	if *dbPasswordFile != "" {
+		password, err := ioutil.ReadFile(*dbPasswordFile)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading the database password: %v", err)
+		}
+		config.Password = strings.TrimRight(string(password), "\r\n")
+	}
+	if *dbPort != "" {
+		config.Port, err = strconv.Atoi(*dbPort)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid database port: %s", *dbPort)
+		}
+	}
+	return config, nil
+}
+
+// connect opens the database from the <postgres|mysql> [datasource]
+// arguments, building the datasource from the db-* flags if it is omitted.
+func connect(args []string) (*backend.SqlBackend, error) {
+	dbDriver := args[0]
+
+	if len(args) == 2 {
+		if authName() != "password" {
+			return nil, fmt.Errorf("Vault or IAM credentials can't be used with a datasource argument, use the -db-* options")
+		}
+		dbDataSource := args[1]
+		fmt.Fprintln(os.Stderr, "connecting to database:", dbDriver, dbDataSource)
+		return backend.New(dbDriver, dbDataSource)
+	}
+
+	config, err := connConfig()
+	if err != nil {
+		return nil, err
+	}
+	provider, err := credentialsProvider(dbDriver, config)
+	if err != nil {
+		return nil, err
+	}
+	if provider != nil {
+		fmt.Fprintf(os.Stderr, "connecting to database with %s credentials: %s %s %s\n", authName(), dbDriver, config.Host, config.DBName)
+		return backend.NewWithCredentials(dbDriver, config, provider)
+	}
+	fmt.Fprintln(os.Stderr, "connecting to database:", dbDriver, config.Host, config.DBName)
+	return backend.NewFromConfig(dbDriver, config)
+}
+
+// authName names the source of the database credentials
+func authName() string {
+	if *vaultDBCreds != "" {
+		return "vault"
+	}
+	return *dbAuth
+}
+
+// credentialsProvider returns the provider of the credentials selected with
+// -db-auth or -vault-db-creds, or nil for a static password. It sets the
+// connection options the provider needs, unless they are given.
+func credentialsProvider(dbDriver string, config *backend.ConnConfig) (backend.CredentialsProvider, error) {
+	switch *dbAuth {
+	case "password":
+		if *vaultDBCreds != "" {
+			return &backend.VaultCredentials{Addr: *vaultAddr, Token: *vaultToken, Path: *vaultDBCreds}, nil
+		}
+		return nil, nil
+	case "aws-iam":
+		if config.Host == "" || config.User == "" || *awsRegion == "" {
+			return nil, fmt.Errorf("IAM authentication requires -db-host, -db-user and -aws-region")
+		}
+		// the tokens must be sent over TLS, and in cleartext for MySQL
+		setDefault := func(name, value string) {
+			if _, ok := config.Options[name]; !ok {
+				config.Options[name] = value
+			}
+		}
+		port := config.Port
+		switch dbDriver {
+		case "postgres":
+			setDefault("sslmode", "require")
+			if port == 0 {
+				port = 5432
+			}
+		case "mysql":
+			setDefault("tls", "true")
+			setDefault("allowCleartextPasswords", "true")
+			if port == 0 {
+				port = 3306
+			}
+		}
+		return &backend.RDSIAMCredentials{Host: config.Host, Port: port, User: config.User, Region: *awsRegion}, nil
+	}
+	return nil, fmt.Errorf("invalid value for -db-auth: %s", *dbAuth)
+}
+
+// parsePrefixQuotas parses prefix:max-keys:max-bytes quotas
+func parsePrefixQuotas(values []string) ([]backend.PrefixQuota, error) {
+	var quotas []backend.PrefixQuota
+	for _, value := range values {
+		parts := strings.Split(value, ":")
+		if len(parts) != 3 || !strings.HasPrefix(parts[0], "/") {
+			return nil, fmt.Errorf("expected prefix:max-keys:max-bytes, got %q", value)
+		}
+		maxKeys, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		maxBytes, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		quotas = append(quotas, backend.PrefixQuota{Prefix: parts[0], MaxKeys: maxKeys, MaxBytes: maxBytes})
+	}
+	return quotas, nil
+}
+
+// reconnectOnHangup reconnects to the database on SIGHUP, with the password
+// read again from the -db-password-file, so that it can be rotated without a
+// restart.
+func reconnectOnHangup(store *backend.SqlBackend, args []string) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	for range hangup {
+		if len(args) == 2 {
+			log.Println("etcdb: not reconnecting on SIGHUP, the datasource was given as an argument")
+			continue
+		}
+		if authName() != "password" {
+			log.Println("etcdb: not reconnecting on SIGHUP, the", authName(), "credentials are replaced automatically")
+			continue
+		}
+		if err := reconnect(store, args[0]); err != nil {
+			log.Println("etcdb: error reconnecting to the database, keeping the old connections:", err)
+			continue
+		}
+		log.Println("etcdb: reconnected to the database")
+	}
+}
+
+func reconnect(store *backend.SqlBackend, dbDriver string) error {
+	config, err := connConfig()
+	if err != nil {
+		return err
+	}
+	dataSource, err := config.DataSource(dbDriver)
+	if err != nil {
+		return err
+	}
+	return store.Reconnect(dataSource)
+}
 
 func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			cmd.run(os.Args[2:])
+			return
+		}
+	}
+	serve(os.Args[1:])
+}
+
+// extensions are called with the registry after the built-in routes are
+// added, to add, replace or wrap operations. Forks can append to them from the
+// init function of a file of their own, instead of changing serve.
+var extensions []func(reg *restapi.Registry, store *backend.SqlBackend, watcher *backend.ChangeWatcher)
+
+// breakerPaths are the routes that read or write the database, which the
+// circuit breaker sheds while it is overloaded. Watches are let through by the
+// breaker itself.
+var breakerPaths = []string{
+	"/v2/keys{key:/.*}",
+	"/v2/keys",
+	"/v2/txn",
+	"/v2/bulk",
+	"/v2/admin/recycle",
+	"/v2/admin/recycle/{index:[0-9]+}",
+	"/v2/admin/usage",
+	"/v2/admin/compact",
+}
+
+// serve runs the server with its command line arguments
+func serve(args []string) {
 	flag.Usage = func() {
 		executable := os.Args[0]
 		cmd := filepath.Base(executable)
 
 		fmt.Fprintf(os.Stderr, "Usage of %s:\n\n", executable)
-		fmt.Fprintf(os.Stderr, "  %s [options] <postgres|mysql> <datasource>\n\n", cmd)
+		fmt.Fprintf(os.Stderr, "  %s [serve] [options] <postgres|mysql> [datasource]\n", cmd)
+		fmt.Fprintf(os.Stderr, "  %s <command> [options] <postgres|mysql> [datasource]\n\n", cmd)
 		flag.PrintDefaults()
 
+		fmt.Fprintln(os.Stderr, "\n  Commands:")
+		fmt.Fprintln(os.Stderr, commandList())
+
 		fmt.Fprintln(os.Stderr, "\n  Examples:")
 		fmt.Fprintf(os.Stderr, "    %s postgres \"user=username password=password host=hostname dbname=dbname sslmode=disable\"\n", cmd)
 		fmt.Fprintf(os.Stderr, "    %s mysql username:password@tcp(hostname:3306)/dbname\n", cmd)
+		fmt.Fprintf(os.Stderr, "    %s -db-host hostname -db-user username -db-password password -db-name dbname mysql\n", cmd)
+		fmt.Fprintf(os.Stderr, "    %s init -db-host hostname -db-user username -db-password password -db-name dbname mysql\n", cmd)
+
+		fmt.Fprintln(os.Stderr, "\n  When the datasource is omitted, it is built from the -db-* options.")
 
 		fmt.Fprintln(os.Stderr, "\n  Datasource formats:")
 		fmt.Fprintln(os.Stderr, "    postgres: https://godoc.org/github.com/lib/pq#hdr-Connection_String_Parameters")
 		fmt.Fprintln(os.Stderr, "    mysql: https://github.com/go-sql-driver/mysql#dsn-data-source-name")
 	}
 
-	flag.Parse()
-	if flag.NArg() != 2 {
+	flag.CommandLine.Parse(args)
+	if flag.NArg() < 1 || flag.NArg() > 2 {
 		flag.Usage()
 		os.Exit(2)
 	}
 
-	dbDriver := flag.Arg(0)
-	dbDataSource := flag.Arg(1)
+	// the Windows service logs to the event log unless told otherwise
+	if isFlagSet("log-output") || os.Getenv("ETCDB_LOG_OUTPUT") != "" {
+		if err := logs.SetOutput(*logOutput, logs.Rotation{MaxBytes: *logMaxSize, MaxAge: *logMaxAge, MaxFiles: *logMaxFiles}); err != nil {
+			fmt.Fprintln(os.Stderr, "invalid -log-output:", err)
+			os.Exit(2)
+		}
+	}
+
+	switch *unknownParams {
+	case "ignore", "log", "reject":
+	default:
+		fmt.Fprintf(os.Stderr, "invalid value for -unknown-params: %s\n", *unknownParams)
+		os.Exit(2)
+	}
+	restapi.UnknownParamsPolicy = *unknownParams
+
+	// the heartbeats are compared in whole seconds
+	grace := *memberGrace
+	if *memberMissedHeartbeats > 0 {
+		grace = time.Duration(*memberMissedHeartbeats) * *heartbeatInterval
+		if grace < time.Second {
+			fmt.Fprintf(os.Stderr, "invalid value for -member-missed-heartbeats: %d, the grace period of %s must be at least 1s\n", *memberMissedHeartbeats, grace)
+			os.Exit(2)
+		}
+	} else if grace < time.Second {
+		fmt.Fprintf(os.Stderr, "invalid value for -member-grace: %s, must be at least 1s\n", *memberGrace)
+		os.Exit(2)
+	}
+
+	switch backend.ClockSkewPolicy(*clockSkewPolicy) {
+	case backend.ClockSkewOff, backend.ClockSkewLog, backend.ClockSkewFreeze:
+	default:
+		fmt.Fprintf(os.Stderr, "invalid value for -clock-skew-policy: %s\n", *clockSkewPolicy)
+		os.Exit(2)
+	}
 
-	fmt.Println("connecting to database:", dbDriver, dbDataSource)
-	store, err := backend.New(dbDriver, dbDataSource)
+	store, err := connect(flag.Args())
 	if err != nil {
 		log.Fatalln(err)
 	}
 
-	if *initDb {
-		fmt.Println("initializing db schema...")
-		err = store.CreateSchema()
-		if err != nil {
-			log.Fatalln(err)
+	store.SetRecycleGrace(*recycleGrace)
+	prefixQuotas, err := parsePrefixQuotas(*quotaPrefixes)
+	if err != nil {
+		log.Fatalln("invalid -quota-prefix:", err)
+	}
+	store.SetQuota(backend.Quota{MaxKeys: *quotaKeys, MaxBytes: *quotaBytes, SoftRatio: *quotaSoftRatio, Prefixes: prefixQuotas, RefreshInterval: *quotaRefresh})
+	store.SetClockSkewPolicy(backend.ClockSkewPolicy(*clockSkewPolicy), *clockSkewTolerance)
+	store.SetExpirationObjective(*expirationObjective)
+	store.SetInOrderSequence(*inOrderSequence)
+	store.SetValueChecksums(*valueChecksums)
+	store.SetMaxReplicaLag(*maxReplicaLag)
+	if *readCacheSize > 0 {
+		readCache := backend.NewReadCache(*readCacheSize, *readCacheTTL)
+		readCache.SetDirs(*readCacheDirs)
+		store.SetReadCache(readCache)
+	}
+	for _, dataSource := range *dbReplicas {
+		if err := store.AddReplica(dataSource); err != nil {
+			log.Fatalln("error opening replica:", err)
 		}
+	}
+
+	if len(*dbFailover) > 0 && authName() != "password" {
+		log.Fatalln("-db-failover can't be used with", authName(), "credentials")
+	}
+	for _, dataSource := range *dbFailover {
+		store.AddFailover(dataSource)
+	}
+	store.SetHealthCheck(*dbHealthFailures, *dbHealthInterval)
+
+	go reconnectOnHangup(store, flag.Args())
+
+	// -init-db and -check-db are kept from before the init and check commands
+	if *checkDb || *initDb {
+		initSchema(store, *checkDb, *dryRunSchema)
 		return
 	}
 
+	if *strictSchema {
+		checkSchema(store)
+	}
+
+	repairs, err := store.Recover()
+	if err != nil {
+		log.Fatalln("error checking database consistency:", err)
+	}
+	for _, repair := range repairs {
+		log.Println("etcdb: repaired database:", repair)
+	}
+
+	backend.StartHousekeeping(store, *trimInterval, *maintenanceInterval)
+	if len(*dbFailover) > 0 {
+		backend.StartFailover(store, *dbProbeInterval, *dbProbeFailures)
+	}
+
+	if *statsdAddress != "" {
+		if _, err := statsd.Start(*statsdAddress, *statsdPrefix, *statsdTags, *statsdInterval); err != nil {
+			log.Fatalln("error starting the statsd emitter:", err)
+		}
+	}
+
+	if *backupURL != "" {
+		objects, err := backend.OpenObjectStore(*backupURL)
+		if err != nil {
+			log.Fatalln("error opening the backup object store:", err)
+		}
+		backend.StartBackups(store, objects, *backupInterval, backend.BackupRetention{Keep: *backupKeep, MaxAge: *backupMaxAge})
+	}
+
 	cw := backend.Watch(store, *watchPoll)
+	cw.SetTimeout(*watchTimeout)
 
-	r := mux.NewRouter()
+	if *mirrorEndpoint != "" {
+		backend.StartMirror(cw, client.New(*mirrorEndpoint), *mirrorName, *mirrorPrefixes)
+		log.Println("etcdb: mirroring changes to", *mirrorEndpoint)
+	}
+
+	if *templatesConfig != "" {
+		resources, err := templates.LoadResources(*templatesConfig)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if _, err := templates.Start(store, cw, resources); err != nil {
+			log.Fatalln("error parsing templates:", err)
+		}
+	}
 
-	r.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
+	reg := restapi.NewRegistry()
+
+	reg.Handle("/version", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprint(w, "2")
-	})
+	}))
 
-	r.HandleFunc("/v2/machines", func(w http.ResponseWriter, r *http.Request) {
-		// for etcdctl it expects a comma and space separator instead of comma-only
-		fmt.Fprint(w, advertiseClientUrls.Join(", "))
-	})
+	reg.Handle("/debug/vars", expvar.Handler())
 
-	r.HandleFunc("/v2/keys{key:/.*}", func(rw http.ResponseWriter, r *http.Request) {
-		var op operations.Operation
-		switch r.Method {
-		case "GET":
-			op = &operations.GetNode{Store: store, Watcher: cw}
-		case "PUT":
-			op = &operations.SetNode{Store: store}
-		case "POST":
-			op = &operations.CreateInOrderNode{Store: store}
-		case "DELETE":
-			op = &operations.DeleteNode{Store: store}
-		default:
-			rw.Header().Set("Allow", "GET, PUT, POST, DELETE")
-			rw.WriteHeader(http.StatusMethodNotAllowed)
-			return
+	if !isFlagSet("advertise-client-urls") {
+		var detected UrlsValue
+		if *discoverySrv != "" {
+			detected, err = srvAdvertiseUrls(*discoverySrv, *discoverySrvName, *listenClientUrls)
+		} else {
+			detected, err = detectAdvertiseUrls(*listenClientUrls)
+		}
+		if err != nil {
+			log.Fatalln("error detecting the client URLs to advertise:", err)
+		}
+		if detected != nil {
+			*advertiseClientUrls = detected
 		}
+	}
 
-		res := func() interface{} {
-			if err := restapi.Unmarshal(r, op.Params()); err != nil {
-				return models.InvalidField(err.Error())
-			}
+	name := *memberName
+	if name == "" {
+		name = advertiseClientUrls.String()
+	}
+	advertised := strings.Split(advertiseClientUrls.String(), ",")
+	members := backend.Register(store, name, advertised, *heartbeatInterval, grace)
 
-			res, err := op.Call()
-			if _, ok := err.(models.Error); ok {
-				return err
-			} else if err != nil {
+	reg.Handle("/v2/machines", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		urls, err := members.ClientURLs()
+		if err != nil {
+			log.Println("error listing members:", err)
+		}
+		if len(urls) == 0 && *discoverySrv != "" {
+			srv, err := srvClientUrls(*discoverySrv, *discoverySrvName)
+			if err != nil {
 				log.Println(err)
-				return models.RaftInternalError(err.Error())
+			} else if len(srv) > 0 {
+				urls = strings.Split(srv.String(), ",")
 			}
+		}
+		if len(urls) == 0 {
+			urls = advertised
+		}
+		// for etcdctl it expects a comma and space separator instead of comma-only
+		fmt.Fprint(w, strings.Join(urls, ", "))
+	}))
+
+	keysMethods := restapi.Methods{
+		"GET": func() operations.Operation { return &operations.GetNode{Store: store, Watcher: cw} },
+		"PUT": func() operations.Operation {
+			return &operations.SetNode{Store: store, DebugConditions: *debugConditions}
+		},
+		"POST": func() operations.Operation { return &operations.CreateInOrderNode{Store: store} },
+		"DELETE": func() operations.Operation {
+			return &operations.DeleteNode{Store: store, DebugConditions: *debugConditions}
+		},
+	}
+	reg.AddMethods("/v2/keys{key:/.*}", keysMethods)
+	// like etcd, /v2/keys without a slash is the root
+	reg.AddMethods("/v2/keys", keysMethods)
+	if *prefixMetrics {
+		reg.Wrap("/v2/keys{key:/.*}", restapi.CountPrefixes)
+		reg.Wrap("/v2/keys", restapi.CountPrefixes)
+	}
+
+	reg.AddMethods("/v2/txn", restapi.Methods{
+		"POST": func() operations.Operation { return &operations.Txn{Store: store} },
+	})
 
-			return res
-		}()
+	reg.AddMethods("/v2/bulk", restapi.Methods{
+		"POST": func() operations.Operation { return &operations.BulkSet{Store: store} },
+	})
 
-		js, _ := json.Marshal(res)
+	reg.AddMethods("/v2/watch", restapi.Methods{
+		"POST": func() operations.Operation { return &operations.WatchKeys{Watcher: cw} },
+	})
 
-		rw.Header().Set("Content-Type", "application/json")
+	reg.AddMethods("/v2/subscriptions/{name}", restapi.Methods{
+		"GET":    func() operations.Operation { return &operations.PollSubscription{Store: store, Watcher: cw} },
+		"PUT":    func() operations.Operation { return &operations.SaveSubscription{Store: store} },
+		"DELETE": func() operations.Operation { return &operations.DeleteSubscription{Store: store} },
+	})
 
-		if err, ok := res.(models.Error); ok {
-			rw.Header().Add("X-Etcd-Index", fmt.Sprint(err.Index))
+	reg.AddMethods("/v2/admin/recycle", restapi.Methods{
+		"GET": func() operations.Operation { return &operations.ListRecycled{Store: store} },
+	})
 
-			switch err.ErrorCode {
-			default:
-				rw.WriteHeader(http.StatusBadRequest)
-			case 100:
-				rw.WriteHeader(http.StatusNotFound)
-			case 101:
-				rw.WriteHeader(http.StatusPreconditionFailed)
-			case 102:
-				rw.WriteHeader(http.StatusForbidden)
-			case 105:
-				rw.WriteHeader(http.StatusPreconditionFailed)
-			case 108:
-				rw.WriteHeader(http.StatusForbidden)
-			case 300:
-				rw.WriteHeader(http.StatusInternalServerError)
-			}
-		}
+	reg.AddMethods("/v2/admin/recycle/{index:[0-9]+}", restapi.Methods{
+		"POST": func() operations.Operation { return &operations.RestoreRecycled{Store: store} },
+	})
+
+	reg.AddMethods("/v2/stats/store", restapi.Methods{
+		"GET": func() operations.Operation { return &operations.GetStoreStats{Watcher: cw} },
+	})
+
+	reg.AddMethods("/v2/admin/watches", restapi.Methods{
+		"GET": func() operations.Operation { return &operations.ListWatches{Watcher: cw} },
+	})
 
-		fmt.Fprintln(rw, string(js))
+	enabled := features()
+	reg.AddMethods("/v2/admin/features", restapi.Methods{
+		"GET": func() operations.Operation { return &operations.ListFeatures{Features: enabled} },
 	})
 
+	reg.AddMethods("/v2/admin/usage", restapi.Methods{
+		"GET": func() operations.Operation { return &operations.KeyspaceUsage{Store: store} },
+	})
+
+	reg.AddMethods("/v2/admin/compact", restapi.Methods{
+		"POST": func() operations.Operation { return &operations.Compact{Store: store} },
+	})
+
+	locks := backend.NewLocks(store, cw)
+
+	lockMethods := restapi.Methods{
+		"GET":    func() operations.Operation { return &operations.GetLock{Locks: locks} },
+		"POST":   func() operations.Operation { return &operations.AcquireLock{Locks: locks} },
+		"PUT":    func() operations.Operation { return &operations.RenewLock{Locks: locks} },
+		"DELETE": func() operations.Operation { return &operations.ReleaseLock{Locks: locks} },
+	}
+	reg.AddMethods("/v2/lock{key:/.*}", lockMethods)
+	// also serve the lock module's original path for older clients
+	reg.AddMethods("/mod/v2/lock{key:/.*}", lockMethods)
+
+	elections := backend.NewElections(store, cw)
+
+	leaderMethods := restapi.Methods{
+		"GET":    func() operations.Operation { return &operations.GetLeader{Elections: elections} },
+		"PUT":    func() operations.Operation { return &operations.CampaignLeader{Elections: elections} },
+		"DELETE": func() operations.Operation { return &operations.ResignLeader{Elections: elections} },
+	}
+	reg.AddMethods("/v2/leader{key:/.*}", leaderMethods)
+	reg.AddMethods("/mod/v2/leader{key:/.*}", leaderMethods)
+
+	for _, extend := range extensions {
+		extend(reg, store, cw)
+	}
+	if *breakerErrorRate > 0 || *breakerLatency > 0 {
+		breaker := &restapi.Breaker{
+			ErrorRate:   *breakerErrorRate,
+			Latency:     *breakerLatency,
+			Window:      *breakerWindow,
+			MinRequests: *breakerMinRequests,
+			OpenTime:    *breakerOpenTime,
+		}
+		for _, path := range breakerPaths {
+			reg.Wrap(path, breaker.Handler)
+		}
+	}
+	var r http.Handler = reg.Router()
+	r = restapi.LimitRequestBytes(*maxRequestBytes, map[string]int64{"/v2/bulk": *maxBulkRequestBytes})(r)
+
+	users := restapi.BasicAuthUsers{}
+	if *requireBasicAuth != "" {
+		if err := users.AddUser(*requireBasicAuth); err != nil {
+			log.Fatalln("invalid -require-basic-auth:", err)
+		}
+	}
+	if *basicAuthFile != "" {
+		if err := users.LoadHtpasswd(*basicAuthFile); err != nil {
+			log.Fatalln("error reading -basic-auth-file:", err)
+		}
+	}
+	if len(users) > 0 {
+		r = restapi.RequireBasicAuth(users)(r)
+	}
+	if *jwtJWKSURL != "" {
+		if len(users) > 0 {
+			log.Fatalln("-jwt-jwks-url can't be used with basic auth")
+		}
+		if *jwtIssuer == "" || *jwtPermissions == "" {
+			log.Fatalln("-jwt-issuer and -jwt-permissions are required with -jwt-jwks-url")
+		}
+		perms, err := restapi.LoadJWTPermissions(*jwtPermissions)
+		if err != nil {
+			log.Fatalln("error reading -jwt-permissions:", err)
+		}
+		auth := &restapi.JWTAuth{JWKSURL: *jwtJWKSURL, Issuer: *jwtIssuer, Audience: *jwtAudience, Permissions: perms}
+		r = auth.Handler(r)
+	}
+	if *slowRequestThreshold > 0 {
+		r = restapi.LogSlowRequests(*slowRequestThreshold)(r)
+	}
+	r = restapi.RequestID(r)
+
 	log.Println("etcdb: advertise client URLs", advertiseClientUrls.String())
 
 	listenErr := make(chan error)
+	opts := restapi.ServerOptions{
+		HTTP2:                *http2,
+		MaxConcurrentStreams: *http2MaxStreams,
+		IdleTimeout:          *idleTimeout,
+		KeepAlive:            *tcpKeepAlive,
+		DisableKeepAlives:    *disableKeepAlives,
+		ReusePort:            *reusePort,
+	}
 
+	inherited, err := restapi.InheritedListeners()
+	if err != nil {
+		log.Fatalln("error using the inherited listeners:", err)
+	}
+
+	var servers []server
 	for _, u := range *listenClientUrls {
-		go func(u url.URL) {
-			log.Println("etcdb: listening for client requests on", u.String())
-			listenErr <- http.ListenAndServe(u.Host, r)
-		}(u)
+		listenOpts := opts
+		tlsConfig, err := listenTLS(u)
+		if err != nil {
+			log.Fatalln("error configuring TLS for", u.Host+":", err)
+		}
+		listenOpts.TLS = tlsConfig
+
+		l, err := listen(inherited, u, listenOpts)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		s := server{l, restapi.NewServer(u.Host, r, listenOpts), u.Query().Get(listenFdOption)}
+		servers = append(servers, s)
+
+		go func(u url.URL, s server) {
+			log.Printf("etcdb: listening for client requests on %s://%s", u.Scheme, u.Host)
+			if err := restapi.Serve(s.http, s.listener); err != http.ErrServerClosed {
+				listenErr <- err
+			}
+		}(u, s)
 	}
+	closeUnused(inherited, servers)
+	go drainOnShutdown(servers, cw, members, *drainTimeout)
+	parent := parentPid()
+	notifyReady(store, parent)
+	startWatchdog(store, cw, parent)
+	notifyParent(parent)
 
 	if err := <-listenErr; err != nil {
 		log.Fatalln(err)
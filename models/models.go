@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"fmt"
 	"time"
 )
@@ -14,6 +15,138 @@ type ActionUpdate struct {
 	Action   string `json:"action"`
 	Node     Node   `json:"node"`
 	PrevNode *Node  `json:"prevNode,omitempty"`
+	// EtcdIndex is the X-Etcd-Index of a response, which isn't in the body
+	EtcdIndex int64 `json:"-"`
+}
+
+// ActionOnly is the response to a successful write with noValueOnSuccess,
+// which like in etcd has only the action, without the nodes
+type ActionOnly struct {
+	Action string `json:"action"`
+}
+
+// DryRun reports the keys an operation would change, without changing them.
+type DryRun struct {
+	Action string   `json:"action"`
+	DryRun bool     `json:"dryRun"`
+	Node   Node     `json:"node"`
+	Count  int      `json:"count"`
+	Keys   []string `json:"keys"`
+}
+
+// RecycledDelete is a recursive delete that can be restored until the
+// expiration.
+type RecycledDelete struct {
+	Index      int64     `json:"index"`
+	Key        string    `json:"key"`
+	Expiration time.Time `json:"expiration"`
+}
+
+// Compaction reports the rows removed by compacting the history up to the
+// index.
+type Compaction struct {
+	Index   int64 `json:"index"`
+	Nodes   int64 `json:"nodes"`
+	Changes int64 `json:"changes"`
+}
+
+// KeyspaceUsage reports the keys, directories and total value bytes under a
+// top-level key, and the depth of the deepest path.
+type KeyspaceUsage struct {
+	Prefix   string `json:"prefix,omitempty"`
+	Keys     int64  `json:"keys"`
+	Dirs     int64  `json:"dirs"`
+	Bytes    int64  `json:"bytes"`
+	MaxDepth int64  `json:"maxDepth"`
+}
+
+// Keyspace reports the usage of the whole keyspace, and by top-level key from
+// the most bytes to the least.
+type Keyspace struct {
+	Total    KeyspaceUsage    `json:"total"`
+	Prefixes []*KeyspaceUsage `json:"prefixes"`
+}
+
+// BulkResult reports the number of keys set by a bulk set, and the index of
+// the last one.
+type BulkResult struct {
+	Count int   `json:"count"`
+	Index int64 `json:"index"`
+}
+
+// TxnResult has the results of each operation of a transaction, in order.
+type TxnResult struct {
+	Results []*ActionUpdate `json:"results"`
+}
+
+// WatcherState describes the waiting watches and the buffered changes of the
+// change watcher.
+type WatcherState struct {
+	Watches       []*WatchState  `json:"watches"`
+	WatchCount    int            `json:"watchCount"`
+	KeyCounts     map[string]int `json:"keyCounts"`
+	Subscriptions int            `json:"subscriptions"`
+	Changes       ChangeBuffer   `json:"changes"`
+}
+
+// WatchState describes a waiting watch. Age is how long it has waited.
+type WatchState struct {
+	Key        string    `json:"key"`
+	Recursive  bool      `json:"recursive,omitempty"`
+	WaitIndex  int64     `json:"waitIndex,omitempty"`
+	Started    time.Time `json:"started"`
+	Age        string    `json:"age"`
+	RemoteAddr string    `json:"remoteAddr,omitempty"`
+}
+
+// ChangeBuffer describes the buffer of recent changes that watches are served
+// from. The indexes are 0 when it is empty.
+type ChangeBuffer struct {
+	Size       int   `json:"size"`
+	Capacity   int   `json:"capacity"`
+	FirstIndex int64 `json:"firstIndex"`
+	LastIndex  int64 `json:"lastIndex"`
+}
+
+// StoreStats counts the operations on keys like etcd's store statistics, for
+// the dashboards made for /v2/stats/store. Watchers is the number of watches
+// waiting.
+type StoreStats struct {
+	GetsSuccess             int64 `json:"getsSuccess"`
+	GetsFail                int64 `json:"getsFail"`
+	SetsSuccess             int64 `json:"setsSuccess"`
+	SetsFail                int64 `json:"setsFail"`
+	DeleteSuccess           int64 `json:"deleteSuccess"`
+	DeleteFail              int64 `json:"deleteFail"`
+	UpdateSuccess           int64 `json:"updateSuccess"`
+	UpdateFail              int64 `json:"updateFail"`
+	CreateSuccess           int64 `json:"createSuccess"`
+	CreateFail              int64 `json:"createFail"`
+	CompareAndSwapSuccess   int64 `json:"compareAndSwapSuccess"`
+	CompareAndSwapFail      int64 `json:"compareAndSwapFail"`
+	CompareAndDeleteSuccess int64 `json:"compareAndDeleteSuccess"`
+	CompareAndDeleteFail    int64 `json:"compareAndDeleteFail"`
+	ExpireCount             int64 `json:"expireCount"`
+	Watchers                int   `json:"watchers"`
+}
+
+// Features lists the optional subsystems of an instance by name, so that
+// clients and other instances can check what it supports.
+type Features map[string]Feature
+
+// Feature describes whether an optional subsystem is enabled, the path of its
+// endpoint if it has one, and its settings.
+type Feature struct {
+	Enabled  bool                   `json:"enabled"`
+	Endpoint string                 `json:"endpoint,omitempty"`
+	Settings map[string]interface{} `json:"settings,omitempty"`
+}
+
+// WatchBatch is a batch of events for a watch subscription. NextIndex is the
+// index to continue watching from in the next request.
+type WatchBatch struct {
+	Events    []*ActionUpdate `json:"events"`
+	NextIndex int64           `json:"nextIndex"`
 }
 
 type Node struct {
@@ -27,6 +160,36 @@ type Node struct {
 	Nodes         []*Node    `json:"nodes,omitempty"`
 }
 
+// etcdNode is a Node as etcd writes it, with the fields in etcd's order
+type etcdNode struct {
+	Key           string     `json:"key,omitempty"`
+	Value         *string    `json:"value,omitempty"`
+	Dir           bool       `json:"dir,omitempty"`
+	Expiration    *time.Time `json:"expiration,omitempty"`
+	TTL           *int64     `json:"ttl,omitempty"`
+	Nodes         []*Node    `json:"nodes,omitempty"`
+	ModifiedIndex int64      `json:"modifiedIndex,omitempty"`
+	CreatedIndex  int64      `json:"createdIndex,omitempty"`
+}
+
+// MarshalJSON writes the node byte for byte like etcd does: the root has no
+// key, directories have no value, and the indexes come last.
+func (n Node) MarshalJSON() ([]byte, error) {
+	js := etcdNode{
+		Key:           n.Key,
+		Dir:           n.Dir,
+		Expiration:    n.Expiration,
+		TTL:           n.TTL,
+		Nodes:         n.Nodes,
+		ModifiedIndex: n.ModifiedIndex,
+		CreatedIndex:  n.CreatedIndex,
+	}
+	if !n.Dir {
+		js.Value = &n.Value
+	}
+	return json.Marshal(js)
+}
+
 // TODO could reuse implementations from etcd code itself?
 
 type Error struct {
@@ -61,6 +224,16 @@ func KeyExists(key string, index int64) Error {
 	return Error{105, "Key already exists", key, index}
 }
 
+// QuotaExceeded is an etcdb extension, for writes refused by the quota
+func QuotaExceeded(cause string, index int64) Error {
+	return Error{111, "Quota exceeded", cause, index}
+}
+
+// RequestTooLarge is an etcdb extension, for request bodies over the limit
+func RequestTooLarge(limit int64) Error {
+	return Error{112, "Request too large", fmt.Sprintf("the request body is over %d bytes", limit), 0}
+}
+
 func RootReadOnly(index int64) Error {
 	return Error{107, "Root is read only", "/", index}
 }
@@ -69,6 +242,10 @@ func DirectoryNotEmpty(key string, index int64) Error {
 	return Error{108, "Directory not empty", key, index}
 }
 
+func Unauthorized(cause string) Error {
+	return Error{110, "The request requires user authentication", cause, 0}
+}
+
 func InvalidField(cause string) Error {
 	return Error{209, "Invalid field", cause, 0}
 }
@@ -77,6 +254,18 @@ func RaftInternalError(cause string) Error {
 	return Error{300, "Raft Internal Error", cause, 0}
 }
 
+// DatabaseUnavailable is an etcdb extension, for requests refused without
+// trying while the database can't be reached
+func DatabaseUnavailable(cause string) Error {
+	return Error{302, "Database unavailable", cause, 0}
+}
+
+// DatabaseOverloaded is an etcdb extension, for requests refused without
+// trying while the database is too slow or failing too often
+func DatabaseOverloaded(cause string) Error {
+	return Error{303, "Database overloaded", cause, 0}
+}
+
 func EventIndexCleared(oldest, requested, index int64) Error {
 	cause := fmt.Sprintf("the requested history has been cleared [%v/%v]", oldest, requested)
 	return Error{401, "The event in requested index is outdated and cleared", cause, index}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestNode_MarshalJSON(t *testing.T) {
+	ttl := int64(100)
+	expiration := time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)
+	action := Action{Action: "get", Node: Node{Dir: true, Nodes: []*Node{
+		{Key: "/dir", Dir: true, Expiration: &expiration, TTL: &ttl, ModifiedIndex: 5, CreatedIndex: 4,
+			Nodes: []*Node{{Key: "/dir/empty", ModifiedIndex: 6, CreatedIndex: 6}}},
+		{Key: "/foo", Value: "bar", ModifiedIndex: 3, CreatedIndex: 2},
+	}}}
+
+	// etcd 2.3's output for the same nodes
+	exp := `{"action":"get","node":{"dir":true,"nodes":[` +
+		`{"key":"/dir","dir":true,"expiration":"2016-01-02T03:04:05Z","ttl":100,` +
+		`"nodes":[{"key":"/dir/empty","value":"","modifiedIndex":6,"createdIndex":6}],"modifiedIndex":5,"createdIndex":4},` +
+		`{"key":"/foo","value":"bar","modifiedIndex":3,"createdIndex":2}]}}`
+
+	js, err := json.Marshal(action)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(js) != exp {
+		t.Fatalf("expected %s, got %s", exp, js)
+	}
+
+	// and it reads back the same
+	var read Action
+	if err := json.Unmarshal(js, &read); err != nil {
+		t.Fatal(err)
+	}
+	js, _ = json.Marshal(read)
+	if string(js) != exp {
+		t.Fatalf("expected %s after reading it back, got %s", exp, js)
+	}
+}
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"sort"
+	"strings"
+)
+
+// SortNodes sorts the children of the node by key, recursively, as etcd does
+// for sorted=true. Names that are numbers, like in-order keys, are ordered
+// numerically, since unlike etcd's they aren't zero padded.
+func SortNodes(node *Node) {
+	sort.Sort(byKey(node.Nodes))
+	for _, child := range node.Nodes {
+		SortNodes(child)
+	}
+}
+
+type byKey []*Node
+
+func (n byKey) Len() int      { return len(n) }
+func (n byKey) Swap(i, j int) { n[i], n[j] = n[j], n[i] }
+func (n byKey) Less(i, j int) bool {
+	a, b := baseName(n[i].Key), baseName(n[j].Key)
+	if isNumber(a) && isNumber(b) && len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
+func baseName(key string) string {
+	return key[strings.LastIndex(key, "/")+1:]
+}
+
+func isNumber(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
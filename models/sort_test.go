@@ -0,0 +1,35 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func keys(nodes []*Node) []string {
+	var keys []string
+	for _, node := range nodes {
+		keys = append(keys, node.Key)
+	}
+	return keys
+}
+
+func TestSortNodes(t *testing.T) {
+	node := &Node{Key: "/q", Dir: true, Nodes: []*Node{
+		{Key: "/q/10"},
+		{Key: "/q/b", Dir: true, Nodes: []*Node{{Key: "/q/b/y"}, {Key: "/q/b/x"}}},
+		{Key: "/q/9"},
+		{Key: "/q/a"},
+		{Key: "/q/100"},
+	}}
+
+	SortNodes(node)
+
+	exp := []string{"/q/9", "/q/10", "/q/100", "/q/a", "/q/b"}
+	if act := keys(node.Nodes); !reflect.DeepEqual(exp, act) {
+		t.Fatalf("expected %v, got %v", exp, act)
+	}
+	exp = []string{"/q/b/x", "/q/b/y"}
+	if act := keys(node.Nodes[4].Nodes); !reflect.DeepEqual(exp, act) {
+		t.Fatalf("expected %v, got %v", exp, act)
+	}
+}
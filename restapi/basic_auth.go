@@ -0,0 +1,187 @@
+package restapi
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/rancher/etcdb/models"
+)
+
+// BasicAuthUsers are the passwords of the users allowed to write, by user
+// name. A password is either in plain text, or hashed like in an htpasswd
+// file, with {SHA} or $apr1$.
+type BasicAuthUsers map[string]string
+
+// AddUser adds a user:password pair
+func (users BasicAuthUsers) AddUser(userPassword string) error {
+	parts := strings.SplitN(userPassword, ":", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("expected user:password")
+	}
+	users[parts[0]] = parts[1]
+	return nil
+}
+
+// LoadHtpasswd adds the users of an htpasswd file. Only {SHA}, $apr1$ and
+// plain text passwords are supported, and the other hashes, like bcrypt or
+// crypt, are refused instead of being compared as plain text.
+func (users BasicAuthUsers) LoadHtpasswd(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		parts := strings.SplitN(text, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return fmt.Errorf("%s:%d: expected user:password", path, line)
+		}
+		if unsupportedHash(parts[1]) {
+			return fmt.Errorf("%s:%d: unsupported password hash, use htpasswd -m or -s", path, line)
+		}
+		users[parts[0]] = parts[1]
+	}
+	return scanner.Err()
+}
+
+// unsupportedHash is true if the htpasswd password is hashed with anything but
+// {SHA} or $apr1$. Like in Apache, 13 characters of the crypt alphabet are a
+// crypt hash.
+func unsupportedHash(password string) bool {
+	switch {
+	case strings.HasPrefix(password, "{SHA}"), strings.HasPrefix(password, "$apr1$"):
+		return false
+	case strings.HasPrefix(password, "$"), strings.HasPrefix(password, "{"):
+		return true
+	}
+	if len(password) != 13 {
+		return false
+	}
+	for _, c := range password {
+		if !strings.ContainsRune(cryptAlphabet, c) {
+			return false
+		}
+	}
+	return true
+}
+
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// Check checks the user's password
+func (users BasicAuthUsers) Check(user, password string) bool {
+	hashed, ok := users[user]
+	if !ok {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(hashed, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		password = "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+	case strings.HasPrefix(hashed, "$apr1$"):
+		salt := strings.SplitN(strings.TrimPrefix(hashed, "$apr1$"), "$", 2)[0]
+		password = apr1(password, salt)
+	}
+	return subtle.ConstantTimeCompare([]byte(hashed), []byte(password)) == 1
+}
+
+// apr1 hashes the password with Apache's MD5 based algorithm
+func apr1(password, salt string) string {
+	const magic = "$apr1$"
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+
+	h := md5.New()
+	h.Write([]byte(password + magic + salt))
+	alt := md5.Sum([]byte(password + salt + password))
+	for i := len(password); i > 0; i -= 16 {
+		if i > 16 {
+			h.Write(alt[:])
+		} else {
+			h.Write(alt[:i])
+		}
+	}
+	for i := len(password); i > 0; i >>= 1 {
+		if i&1 == 1 {
+			h.Write([]byte{0})
+		} else {
+			h.Write([]byte{password[0]})
+		}
+	}
+	sum := h.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		h := md5.New()
+		if i&1 == 1 {
+			h.Write([]byte(password))
+		} else {
+			h.Write(sum)
+		}
+		if i%3 != 0 {
+			h.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			h.Write([]byte(password))
+		}
+		if i&1 == 1 {
+			h.Write(sum)
+		} else {
+			h.Write([]byte(password))
+		}
+		sum = h.Sum(nil)
+	}
+
+	var out []byte
+	encode := func(a, b, c byte, n int) {
+		v := uint(a)<<16 | uint(b)<<8 | uint(c)
+		for ; n > 0; n-- {
+			out = append(out, cryptAlphabet[v&0x3f])
+			v >>= 6
+		}
+	}
+	encode(sum[0], sum[6], sum[12], 4)
+	encode(sum[1], sum[7], sum[13], 4)
+	encode(sum[2], sum[8], sum[14], 4)
+	encode(sum[3], sum[9], sum[15], 4)
+	encode(sum[4], sum[10], sum[5], 4)
+	encode(0, 0, sum[11], 2)
+	return magic + salt + "$" + string(out)
+}
+
+// BasicAuthReadPaths are the paths where POST requests only read, so they
+// don't require authentication
+var BasicAuthReadPaths = map[string]bool{"/v2/watch": true}
+
+// RequireBasicAuth requires the basic auth of one of the users for writes,
+// which are requests with methods other than GET and HEAD, and responds to
+// writes without it with etcd's error 110.
+func RequireBasicAuth(users BasicAuthUsers) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			read := r.Method == "GET" || r.Method == "HEAD" ||
+				r.Method == "POST" && BasicAuthReadPaths[r.URL.Path]
+			if !read {
+				user, password, ok := r.BasicAuth()
+				if !ok || !users.Check(user, password) {
+					rw.Header().Set("WWW-Authenticate", `Basic realm="etcdb"`)
+					WriteError(rw, models.Unauthorized("Insufficient credentials"))
+					return
+				}
+			}
+			h.ServeHTTP(rw, r)
+		})
+	}
+}
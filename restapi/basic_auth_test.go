@@ -0,0 +1,69 @@
+package restapi
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBasicAuthUsers_Check(t *testing.T) {
+	dir, err := ioutil.TempDir("", "etcdb-htpasswd")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "htpasswd")
+	ok(t, ioutil.WriteFile(path, []byte(`# users
+sha:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=
+md5:$apr1$saltsalt$LrttParrLPdxvgutaSXWJ0
+`), 0600))
+
+	users := BasicAuthUsers{}
+	ok(t, users.AddUser("root:pass:word"))
+	ok(t, users.LoadHtpasswd(path))
+
+	equals(t, true, users.Check("root", "pass:word"))
+	equals(t, true, users.Check("sha", "secret"))
+	equals(t, true, users.Check("md5", "secret"))
+	equals(t, false, users.Check("md5", "wrong"))
+	equals(t, false, users.Check("nobody", "secret"))
+}
+
+func TestBasicAuthUsers_UnsupportedHash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "etcdb-htpasswd")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "htpasswd")
+
+	for _, hashed := range []string{
+		"$2y$05$abcdefghijklmnopqrstuu",
+		"$5$rounds=5000$salt$hash",
+		"{SSHA}abcdefgh",
+		"abJnggxhB/yWI",
+	} {
+		ok(t, ioutil.WriteFile(path, []byte("user:"+hashed+"\n"), 0600))
+		if err := (BasicAuthUsers{}).LoadHtpasswd(path); err == nil {
+			t.Fatalf("expected %s to be refused", hashed)
+		}
+	}
+}
+
+func TestRequireBasicAuth(t *testing.T) {
+	h := RequireBasicAuth(BasicAuthUsers{"root": "secret"})(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write([]byte("ok"))
+	}))
+
+	equals(t, "ok", serve(h, "GET", "/v2/keys/foo").Body.String())
+	equals(t, "ok", serve(h, "POST", "/v2/watch").Body.String())
+
+	rw := serve(h, "PUT", "/v2/keys/foo")
+	equals(t, http.StatusUnauthorized, rw.Code)
+	equals(t, `{"errorCode":110,"message":"The request requires user authentication","cause":"Insufficient credentials","index":0}`+"\n", rw.Body.String())
+
+	req := httptest.NewRequest("DELETE", "/v2/keys/foo", nil)
+	req.SetBasicAuth("root", "secret")
+	rw = httptest.NewRecorder()
+	h.ServeHTTP(rw, req)
+	equals(t, "ok", rw.Body.String())
+}
@@ -0,0 +1,205 @@
+package restapi
+
+import (
+	"expvar"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rancher/etcdb/models"
+)
+
+// BreakerStats shows the "state" of the circuit breaker: closed, open or
+// half-open, published with expvar. It counts the "trips" opening it, the
+// requests "shed" while it is open, and the "closes" after the database
+// recovered.
+var BreakerStats = expvar.NewMap("breaker")
+
+var breakerState = new(expvar.String)
+
+func init() {
+	breakerState.Set("closed")
+	BreakerStats.Set("state", breakerState)
+}
+
+const (
+	breakerClosed = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+var breakerStateNames = []string{"closed", "open", "half-open"}
+
+// Breaker is a circuit breaker that sheds the requests while the database is
+// overloaded, instead of queueing them and making it slower still. Requests
+// are counted in windows, and when enough of them fail with a 5xx status or
+// take too long on average, it opens: requests are refused with error 303,
+// status 503 and a Retry-After header for the OpenTime. Then a single request
+// is let through, and closes it if it succeeds in time, or opens it again. A
+// trial request that panics counts as failed, and one still running after
+// the OpenTime is given up on with another trial, whose result is the only
+// one that counts.
+// Watches are always let through and not counted, since they wait for a
+// change.
+type Breaker struct {
+	// ErrorRate is the part of the requests, from 0 to 1, that open the
+	// breaker when they fail, or 0 to ignore the errors
+	ErrorRate float64
+	// Latency is the average duration of the requests that opens the
+	// breaker, or 0 to ignore the latency
+	Latency     time.Duration
+	Window      time.Duration
+	MinRequests int
+	OpenTime    time.Duration
+
+	mu    sync.Mutex
+	state int
+	// the counts of the current window
+	windowStart time.Time
+	requests    int
+	failures    int
+	total       time.Duration
+	// opened is when the breaker last opened
+	opened time.Time
+	// trial is true while the request of a half-open breaker is running,
+	// which started at trialStarted. Each trial gets the next generation,
+	// so that the results of the ones given up on are ignored.
+	trial        bool
+	trialStarted time.Time
+	generation   uint64
+}
+
+// Handler sheds the requests to the handler while the breaker is open
+func (b *Breaker) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if operationName(r) == "watch" {
+			h.ServeHTTP(rw, r)
+			return
+		}
+
+		generation, retryAfter, ok := b.allow(time.Now())
+		if !ok {
+			BreakerStats.Add("shed", 1)
+			seconds := int(math.Ceil(retryAfter.Seconds()))
+			rw.Header().Set("Retry-After", strconv.Itoa(seconds))
+			WriteError(rw, models.DatabaseOverloaded(fmt.Sprintf("the circuit breaker is open, retry after %ds", seconds)))
+			return
+		}
+
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: rw, status: http.StatusOK}
+		completed := false
+		// recorded even if the handler panics, which net/http recovers
+		// from, so that a trial request always ends
+		defer func() {
+			b.record(generation, time.Now(), !completed || sw.status >= 500, time.Since(start))
+		}()
+		h.ServeHTTP(sw, r)
+		completed = true
+	})
+}
+
+// allow reports whether a request can go through at the time, or else how
+// long until the breaker lets one through. The generation of a trial request
+// is returned for recording its result, and is 0 for the other requests.
+func (b *Breaker) allow(now time.Time) (uint64, time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if wait := b.opened.Add(b.OpenTime).Sub(now); wait > 0 {
+			return 0, wait, false
+		}
+		b.setState(breakerHalfOpen)
+		return b.startTrial(now), 0, true
+	case breakerHalfOpen:
+		if wait := b.trialStarted.Add(b.OpenTime).Sub(now); b.trial && wait > 0 {
+			return 0, wait, false
+		}
+		return b.startTrial(now), 0, true
+	}
+	return 0, 0, true
+}
+
+func (b *Breaker) startTrial(now time.Time) uint64 {
+	b.generation++
+	b.trial, b.trialStarted = true, now
+	return b.generation
+}
+
+// record counts the result of a request that was let through, with the
+// generation allow returned
+func (b *Breaker) record(generation uint64, now time.Time, failed bool, took time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	slow := b.Latency > 0 && took > b.Latency
+	switch b.state {
+	case breakerOpen:
+		// a request let through before the breaker opened, or a trial that
+		// was given up on
+		return
+	case breakerHalfOpen:
+		if generation != b.generation {
+			// not the current trial
+			return
+		}
+		b.trial = false
+		if failed || slow {
+			b.trip(now, fmt.Sprintf("the trial request failed or took %s", took))
+			return
+		}
+		log.Printf("circuit breaker closed after %s", now.Sub(b.opened).Round(time.Millisecond))
+		BreakerStats.Add("closes", 1)
+		b.setState(breakerClosed)
+		b.resetWindow(now)
+		return
+	}
+	if generation != 0 {
+		// a trial given up on, after another one closed the breaker
+		return
+	}
+
+	if now.Sub(b.windowStart) > b.Window {
+		b.resetWindow(now)
+	}
+	b.requests++
+	b.total += took
+	if failed {
+		b.failures++
+	}
+	if b.requests < b.MinRequests {
+		return
+	}
+
+	if b.ErrorRate > 0 && float64(b.failures) >= b.ErrorRate*float64(b.requests) {
+		b.trip(now, fmt.Sprintf("%d of %d requests failed", b.failures, b.requests))
+	} else if average := b.total / time.Duration(b.requests); b.Latency > 0 && average > b.Latency {
+		b.trip(now, fmt.Sprintf("the average latency of %d requests is %s", b.requests, average.Round(time.Millisecond)))
+	}
+}
+
+func (b *Breaker) trip(now time.Time, reason string) {
+	log.Printf("circuit breaker open for %s: %s", b.OpenTime, reason)
+	BreakerStats.Add("trips", 1)
+	b.setState(breakerOpen)
+	b.opened = now
+	b.resetWindow(now)
+}
+
+func (b *Breaker) setState(state int) {
+	b.state = state
+	breakerState.Set(breakerStateNames[state])
+}
+
+func (b *Breaker) resetWindow(now time.Time) {
+	b.windowStart = now
+	b.requests = 0
+	b.failures = 0
+	b.total = 0
+}
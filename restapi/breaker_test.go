@@ -0,0 +1,151 @@
+package restapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBreaker_ErrorRate(t *testing.T) {
+	b := &Breaker{ErrorRate: 0.5, Window: time.Minute, MinRequests: 4, OpenTime: 5 * time.Second}
+	now := time.Now()
+
+	b.record(0, now, true, time.Millisecond)
+	b.record(0, now, true, time.Millisecond)
+	b.record(0, now, false, time.Millisecond)
+	_, _, allowed := b.allow(now)
+	equals(t, true, allowed)
+
+	b.record(0, now, false, time.Millisecond)
+	_, retryAfter, allowed := b.allow(now.Add(time.Second))
+	equals(t, false, allowed)
+	equals(t, 4*time.Second, retryAfter)
+
+	// a single trial request once open for the OpenTime
+	trial, _, allowed := b.allow(now.Add(5 * time.Second))
+	equals(t, true, allowed)
+	_, _, allowed = b.allow(now.Add(5 * time.Second))
+	equals(t, false, allowed)
+
+	// that fails, and opens it again
+	b.record(trial, now.Add(6*time.Second), true, time.Millisecond)
+	_, _, allowed = b.allow(now.Add(10 * time.Second))
+	equals(t, false, allowed)
+
+	trial, _, allowed = b.allow(now.Add(11 * time.Second))
+	equals(t, true, allowed)
+	b.record(trial, now.Add(11*time.Second), false, time.Millisecond)
+	_, _, allowed = b.allow(now.Add(11 * time.Second))
+	equals(t, true, allowed)
+	equals(t, breakerClosed, b.state)
+}
+
+func TestBreaker_Latency(t *testing.T) {
+	b := &Breaker{Latency: 100 * time.Millisecond, Window: time.Minute, MinRequests: 2, OpenTime: time.Second}
+	now := time.Now()
+
+	b.record(0, now, false, 50*time.Millisecond)
+	b.record(0, now, false, 140*time.Millisecond)
+	_, _, allowed := b.allow(now)
+	equals(t, true, allowed)
+
+	b.record(0, now, false, 200*time.Millisecond)
+	_, _, allowed = b.allow(now)
+	equals(t, false, allowed)
+}
+
+func TestBreaker_Window(t *testing.T) {
+	b := &Breaker{ErrorRate: 0.5, Window: time.Second, MinRequests: 2, OpenTime: time.Second}
+	now := time.Now()
+
+	// the failures of the last window don't count anymore
+	b.record(0, now, true, time.Millisecond)
+	b.record(0, now.Add(2*time.Second), true, time.Millisecond)
+	_, _, allowed := b.allow(now.Add(2 * time.Second))
+	equals(t, true, allowed)
+}
+
+func TestBreaker_Handler(t *testing.T) {
+	b := &Breaker{ErrorRate: 1, Window: time.Minute, MinRequests: 1, OpenTime: 1500 * time.Millisecond}
+	h := b.Handler(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusInternalServerError)
+	}))
+	get := func(target string) *httptest.ResponseRecorder {
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, httptest.NewRequest("GET", target, nil))
+		return rw
+	}
+
+	equals(t, http.StatusInternalServerError, get("/v2/keys/foo").Code)
+
+	rw := get("/v2/keys/foo")
+	equals(t, http.StatusServiceUnavailable, rw.Code)
+	equals(t, "2", rw.Header().Get("Retry-After"))
+	equals(t, `{"errorCode":303,"message":"Database overloaded","cause":"the circuit breaker is open, retry after 2s","index":0}`+"\n", rw.Body.String())
+
+	// watches are let through
+	equals(t, http.StatusInternalServerError, get("/v2/keys/foo?wait=true").Code)
+}
+
+func TestBreaker_TrialNotRecorded(t *testing.T) {
+	b := &Breaker{ErrorRate: 1, Window: time.Minute, MinRequests: 1, OpenTime: 5 * time.Second}
+	now := time.Now()
+
+	b.record(0, now, true, time.Millisecond)
+	first, _, allowed := b.allow(now.Add(5 * time.Second))
+	equals(t, true, allowed)
+
+	// the trial never ends, so another is let through after the OpenTime
+	_, retryAfter, allowed := b.allow(now.Add(7 * time.Second))
+	equals(t, false, allowed)
+	equals(t, 3*time.Second, retryAfter)
+	second, _, allowed := b.allow(now.Add(10 * time.Second))
+	equals(t, true, allowed)
+	equals(t, breakerHalfOpen, b.state)
+
+	// the first trial ending late doesn't count for the second
+	b.record(first, now.Add(11*time.Second), false, time.Millisecond)
+	equals(t, breakerHalfOpen, b.state)
+	equals(t, true, b.trial)
+	b.record(second, now.Add(11*time.Second), true, time.Millisecond)
+	equals(t, breakerOpen, b.state)
+}
+
+func TestBreaker_EarlierRequestNotTrial(t *testing.T) {
+	b := &Breaker{ErrorRate: 1, Window: time.Minute, MinRequests: 1, OpenTime: 5 * time.Second}
+	now := time.Now()
+
+	earlier, _, allowed := b.allow(now)
+	equals(t, true, allowed)
+	b.record(0, now, true, time.Millisecond)
+	trial, _, allowed := b.allow(now.Add(5 * time.Second))
+	equals(t, true, allowed)
+
+	// a request let through while closed ends during the trial
+	b.record(earlier, now.Add(6*time.Second), false, time.Millisecond)
+	equals(t, breakerHalfOpen, b.state)
+	b.record(trial, now.Add(6*time.Second), false, time.Millisecond)
+	equals(t, breakerClosed, b.state)
+}
+
+func TestBreaker_TrialPanics(t *testing.T) {
+	b := &Breaker{ErrorRate: 1, Window: time.Minute, MinRequests: 1, OpenTime: time.Millisecond}
+	h := b.Handler(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	get := func() {
+		defer func() { recover() }()
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v2/keys/foo", nil))
+	}
+
+	get()
+	equals(t, breakerOpen, b.state)
+
+	// the trial panics too, and opens the breaker again instead of leaving it
+	// half-open
+	time.Sleep(2 * time.Millisecond)
+	get()
+	equals(t, breakerOpen, b.state)
+	equals(t, false, b.trial)
+}
@@ -1,14 +1,24 @@
 package restapi
 
 import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"io"
 	"net/http"
 	"net/url"
 	"reflect"
+	"sort"
 	"strconv"
 
 	"github.com/gorilla/mux"
+	"github.com/rancher/etcdb/backend"
 )
 
+// UnknownParamCounts counts requests by the name of any parameters that
+// weren't recognized, published with expvar.
+var UnknownParamCounts = expvar.NewMap("unknownParams")
+
 // Unmarshal decodes values from the request into a tagged struct.
 //
 // Similar to json.Unmarshal, but reads the values from the request, based on
@@ -18,13 +28,60 @@ import (
 //   `path:"key"` -- gorilla/mux route parameters
 //   `query:"key"` -- URL query parameters
 //   `formData:"key"` -- form POST data
+//   `body:"name"` -- the JSON request body
+//
+// The key route parameter is cleaned with backend.CleanKey, and is the root
+// on routes without one, like /v2/keys. The path is already decoded, so %2F
+// is a slash and keys with spaces, '?' or unicode arrive as they were before
+// encoding.
+//
+// The body of a struct with a body field is always decoded as JSON, whatever
+// its Content-Type, since curl -d sends JSON as a form. Its form parameters
+// are then only those of the query.
 func Unmarshal(r *http.Request, o interface{}) error {
-	r.ParseForm()
+	if bodyField(reflect.TypeOf(o).Elem()) >= 0 {
+		if err := unmarshalBody(r.Body, o); err != nil {
+			return err
+		}
+		r.Form = r.URL.Query()
+	} else {
+		var tooLarge *http.MaxBytesError
+		if err := r.ParseForm(); errors.As(err, &tooLarge) {
+			return err
+		}
+	}
 	// using r.Form instead of r.PostForm, since etcd seems to allow
 	// parameters set in either
 	return unmarshal(mux.Vars(r), r.URL.Query(), r.Form, o)
 }
 
+// bodyField returns the index of the field of the struct type tagged with
+// body, or -1 if there isn't one.
+func bodyField(typ reflect.Type) int {
+	for i := 0; i < typ.NumField(); i++ {
+		if typ.Field(i).Tag.Get("body") != "" {
+			return i
+		}
+	}
+	return -1
+}
+
+// unmarshalBody decodes the JSON body into the field tagged with body. An
+// empty body leaves the field unset.
+func unmarshalBody(body io.Reader, o interface{}) error {
+	v := reflect.ValueOf(o).Elem()
+
+	i := bodyField(v.Type())
+	if i < 0 {
+		return nil
+	}
+	err := json.NewDecoder(body).Decode(v.Field(i).Addr().Interface())
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
 func unmarshal(path map[string]string, query, form url.Values, o interface{}) error {
 	v := reflect.ValueOf(o)
 	typ := v.Type().Elem()
@@ -35,6 +92,9 @@ func unmarshal(path map[string]string, query, form url.Values, o interface{}) er
 		var value string
 		if key := field.Tag.Get("path"); key != "" {
 			value = path[key]
+			if key == "key" {
+				value = backend.CleanKey(value)
+			}
 		} else if key := field.Tag.Get("query"); key != "" {
 			value = query.Get(key)
 		} else if key := field.Tag.Get("formData"); key != "" {
@@ -52,6 +112,36 @@ func unmarshal(path map[string]string, query, form url.Values, o interface{}) er
 	return nil
 }
 
+// UnknownParams returns the names of the query and form parameters in the
+// request that don't match any field of the tagged struct. Unmarshal must be
+// called on the request first.
+func UnknownParams(r *http.Request, o interface{}) []string {
+	return unknownParams(r.Form, o)
+}
+
+func unknownParams(form url.Values, o interface{}) []string {
+	typ := reflect.TypeOf(o).Elem()
+
+	known := make(map[string]bool)
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if key := field.Tag.Get("query"); key != "" {
+			known[key] = true
+		} else if key := field.Tag.Get("formData"); key != "" {
+			known[key] = true
+		}
+	}
+
+	var unknown []string
+	for key := range form {
+		if !known[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
 func assign(v reflect.Value, value string) error {
 	switch v.Kind() {
 	case reflect.String:
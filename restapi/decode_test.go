@@ -2,10 +2,17 @@ package restapi
 
 import (
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"path/filepath"
 	"reflect"
 	"runtime"
+	"strings"
 	"testing"
+
+	"github.com/rancher/etcdb/backend"
+	"github.com/rancher/etcdb/restapi/operations"
 )
 
 func TestUnmarshal_Path(t *testing.T) {
@@ -194,6 +201,182 @@ func TestAssign_Uint64(t *testing.T) {
 	equals(t, uint64(42), v)
 }
 
+func TestUnmarshal_Body(t *testing.T) {
+	v := struct {
+		Body struct {
+			Keys []string `json:"keys"`
+		} `body:"body"`
+	}{}
+
+	ok(t, unmarshalBody(strings.NewReader(`{"keys": ["/foo", "/bar"]}`), &v))
+
+	equals(t, []string{"/foo", "/bar"}, v.Body.Keys)
+}
+
+func TestUnmarshal_EmptyBody(t *testing.T) {
+	v := struct {
+		Body struct {
+			Keys []string `json:"keys"`
+		} `body:"body"`
+	}{}
+
+	ok(t, unmarshalBody(strings.NewReader(""), &v))
+
+	equals(t, 0, len(v.Body.Keys))
+}
+
+func TestUnmarshal_InvalidBody(t *testing.T) {
+	v := struct {
+		Body struct {
+			Keys []string `json:"keys"`
+		} `body:"body"`
+	}{}
+
+	err := unmarshalBody(strings.NewReader(`{"keys": "/foo"}`), &v)
+
+	if err == nil {
+		t.Fatal("expected an error for an invalid body, but got nil")
+	}
+}
+
+func TestUnmarshal_FormEncodedBody(t *testing.T) {
+	v := struct {
+		Timeout int `query:"timeout"`
+		Body    struct {
+			Keys []string `json:"keys"`
+		} `body:"body"`
+	}{}
+
+	// like curl -d, which sends the JSON as a form
+	r := httptest.NewRequest("POST", "/v2/watch?timeout=5", strings.NewReader(`{"keys": ["/foo", "/bar"]}`))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	ok(t, Unmarshal(r, &v))
+
+	equals(t, []string{"/foo", "/bar"}, v.Body.Keys)
+	equals(t, 5, v.Timeout)
+	equals(t, 0, len(UnknownParams(r, &v)))
+}
+
+func TestUnknownParams(t *testing.T) {
+	v := struct {
+		Key       string `path:"key"`
+		Recursive bool   `query:"recursive"`
+		Value     string `formData:"value"`
+	}{}
+
+	unknown := unknownParams(map[string][]string{
+		"recursive": {"true"},
+		"recurive":  {"true"},
+		"value":     {"bar"},
+		"key":       {"/foo"},
+	}, &v)
+
+	// path parameters can't be given in the query
+	equals(t, []string{"key", "recurive"}, unknown)
+}
+
+func TestUnknownParams_None(t *testing.T) {
+	v := struct {
+		Recursive bool `query:"recursive"`
+	}{}
+
+	unknown := unknownParams(map[string][]string{
+		"recursive": {"true"},
+	}, &v)
+
+	equals(t, 0, len(unknown))
+}
+
+// weirdKeys are keys that a client could encode in different ways, or that
+// are easily mangled by decoding the path twice
+var weirdKeys = []string{
+	"/with space",
+	"/question?mark",
+	"/hash#tag",
+	"/percent%2F",
+	"/100%",
+	"/plus+sign",
+	"/semi;colon",
+	"/amp&er=sand",
+	"/quote'\"",
+	"/日本語/キー",
+	"/emoji \U0001F600",
+	"/tab\there",
+	"/..dots../.hidden",
+}
+
+type keyOp struct {
+	params struct {
+		Key string `path:"key"`
+	}
+}
+
+func (op *keyOp) Params() interface{} {
+	return &op.params
+}
+
+func (op *keyOp) Call() (interface{}, error) {
+	return op.params.Key, nil
+}
+
+func keyRouter() http.Handler {
+	reg := NewRegistry()
+	reg.Add("/v2/keys{key:/.*}", "PUT", func() operations.Operation { return &keyOp{} })
+	reg.Add("/v2/keys", "PUT", func() operations.Operation { return &keyOp{} })
+	return reg.Router()
+}
+
+// escapeKey encodes each segment of the key for a request path, like etcd
+// clients do
+func escapeKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+func TestUnmarshal_WeirdKeys(t *testing.T) {
+	r := keyRouter()
+	for _, key := range weirdKeys {
+		rw := serve(r, "PUT", "/v2/keys"+escapeKey(key))
+		equals(t, http.StatusOK, rw.Code)
+		equals(t, key, rw.Body.String())
+	}
+
+	// the same key written in other ways
+	for target, expected := range map[string]string{
+		"/v2/keys/a%2Fb":          "/a/b",
+		"/v2/keys/a%20b?dir=true": "/a b",
+		"/v2/keys/a%3Fb":          "/a?b",
+		"/v2/keys/a%252F":         "/a%2F",
+		"/v2/keys//a/./b/../c/":   "/a/c",
+		"/v2/keys/%E6%97%A5":      "/日",
+		"/v2/keys/":               "/",
+		"/v2/keys":                "/",
+	} {
+		equals(t, expected, serve(r, "PUT", target).Body.String())
+	}
+}
+
+func FuzzUnmarshal_Key(f *testing.F) {
+	for _, key := range weirdKeys {
+		f.Add(key)
+	}
+	f.Add("//a/./b/../c/")
+
+	f.Fuzz(func(t *testing.T, key string) {
+		// the .* of the route templates doesn't match newlines
+		if strings.Contains(key, "\n") {
+			return
+		}
+		// any key makes the same round trip through a request path
+		rw := serve(keyRouter(), "PUT", "/v2/keys"+escapeKey(key))
+		equals(t, backend.CleanKey(key), rw.Body.String())
+	})
+}
+
 // ok fails the test if an err is not nil.
 func ok(tb testing.TB, err error) {
 	if err != nil {
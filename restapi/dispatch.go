@@ -0,0 +1,161 @@
+package restapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/rancher/etcdb/models"
+	"github.com/rancher/etcdb/restapi/operations"
+)
+
+// UnknownParamsPolicy is the handling of request parameters that aren't
+// recognized by the operation: "ignore", "log", or "reject". They are always
+// counted in UnknownParamCounts.
+var UnknownParamsPolicy = "ignore"
+
+// Methods dispatches requests to a new operation for the request's method.
+type Methods map[string]func() operations.Operation
+
+// ServeHTTP dispatches the request, or responds with 405 Method Not Allowed if
+// there is no operation for its method.
+func (m Methods) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	newOp, ok := m[r.Method]
+	if !ok {
+		allowed := make([]string, 0, len(m))
+		for method := range m {
+			allowed = append(allowed, method)
+		}
+		sort.Strings(allowed)
+		rw.Header().Set("Allow", strings.Join(allowed, ", "))
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	Dispatch(newOp(), rw, r)
+}
+
+// Dispatch decodes the request parameters into the operation, calls it, and
+// writes the result. Errors are written in the etcd JSON error format, string
+// results as plain text, and an EmptyResult without a body.
+func Dispatch(op operations.Operation, rw http.ResponseWriter, r *http.Request) {
+	res := func() interface{} {
+		if err := Unmarshal(r, op.Params()); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				return models.RequestTooLarge(tooLarge.Limit)
+			}
+			return models.InvalidField(err.Error())
+		}
+
+		if unknown := UnknownParams(r, op.Params()); len(unknown) > 0 {
+			for _, name := range unknown {
+				UnknownParamCounts.Add(name, 1)
+			}
+			switch UnknownParamsPolicy {
+			case "log":
+				log.Printf("unknown parameters %s in %s %s%s", strings.Join(unknown, ", "), r.Method, r.URL.Path, requestIDSuffix(r))
+			case "reject":
+				return models.InvalidField("unknown parameters: " + strings.Join(unknown, ", "))
+			}
+		}
+
+		if ra, ok := op.(operations.RemoteAddrOperation); ok {
+			ra.SetRemoteAddr(r.RemoteAddr)
+		}
+
+		res, err := op.Call()
+		if _, ok := err.(models.Error); ok {
+			return err
+		} else if err != nil {
+			log.Printf("error in %s %s%s: %v", r.Method, r.URL.Path, requestIDSuffix(r), err)
+			return models.RaftInternalError(err.Error())
+		}
+
+		return res
+	}()
+
+	if h, ok := op.(operations.HeaderOperation); ok {
+		for name, values := range h.Headers() {
+			rw.Header()[name] = values
+		}
+	}
+
+	if _, ok := res.(operations.EmptyResult); ok {
+		rw.Header().Set("Content-Type", "application/json")
+		return
+	}
+
+	if text, ok := res.(string); ok {
+		rw.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(rw, text)
+		return
+	}
+
+	if err, ok := res.(models.Error); ok {
+		WriteError(rw, err)
+		return
+	}
+
+	js, _ := json.Marshal(res)
+
+	rw.Header().Set("Content-Type", "application/json")
+
+	if s, ok := op.(operations.StatusOperation); ok {
+		rw.WriteHeader(s.Status())
+	}
+
+	fmt.Fprintln(rw, string(js))
+}
+
+// requestIDSuffix returns the request's ID for log lines, if it has one
+func requestIDSuffix(r *http.Request) string {
+	if id := r.Header.Get(RequestIDHeader); id != "" {
+		return " (request " + id + ")"
+	}
+	return ""
+}
+
+// WriteError writes the error in the etcd JSON error format, with its status
+// and index, and the request ID that RequestID set on the response.
+func WriteError(rw http.ResponseWriter, err models.Error) {
+	js, _ := json.Marshal(struct {
+		models.Error
+		RequestID string `json:"requestId,omitempty"`
+	}{err, rw.Header().Get(RequestIDHeader)})
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("X-Etcd-Index", fmt.Sprint(err.Index))
+	rw.WriteHeader(StatusCode(err))
+	fmt.Fprintln(rw, string(js))
+}
+
+// StatusCode returns the HTTP status etcd uses for the error.
+func StatusCode(err models.Error) int {
+	switch err.ErrorCode {
+	case 100:
+		return http.StatusNotFound
+	case 101:
+		return http.StatusPreconditionFailed
+	case 102:
+		return http.StatusForbidden
+	case 105:
+		return http.StatusPreconditionFailed
+	case 108:
+		return http.StatusForbidden
+	case 110:
+		return http.StatusUnauthorized
+	case 111:
+		return http.StatusInsufficientStorage
+	case 112:
+		return http.StatusRequestEntityTooLarge
+	case 300:
+		return http.StatusInternalServerError
+	case 302, 303:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,129 @@
+package restapi
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rancher/etcdb/models"
+	"github.com/rancher/etcdb/restapi/operations"
+)
+
+type testOp struct {
+	params struct {
+		Name string `query:"name"`
+	}
+	result interface{}
+	err    error
+}
+
+func (op *testOp) Params() interface{} {
+	return &op.params
+}
+
+func (op *testOp) Call() (interface{}, error) {
+	return op.result, op.err
+}
+
+func dispatch(op operations.Operation, method, target string) *httptest.ResponseRecorder {
+	rw := httptest.NewRecorder()
+	Dispatch(op, rw, httptest.NewRequest(method, target, nil))
+	return rw
+}
+
+func TestDispatch_JSON(t *testing.T) {
+	rw := dispatch(&testOp{result: map[string]int{"a": 1}}, "GET", "/")
+
+	equals(t, http.StatusOK, rw.Code)
+	equals(t, "application/json", rw.Header().Get("Content-Type"))
+	equals(t, `{"a":1}`+"\n", rw.Body.String())
+}
+
+func TestDispatch_Text(t *testing.T) {
+	rw := dispatch(&testOp{result: "leader"}, "GET", "/")
+
+	equals(t, "text/plain", rw.Header().Get("Content-Type"))
+	equals(t, "leader", rw.Body.String())
+}
+
+func TestDispatch_EtcdError(t *testing.T) {
+	rw := dispatch(&testOp{err: models.NotFound("/foo", 7)}, "GET", "/")
+
+	equals(t, http.StatusNotFound, rw.Code)
+	equals(t, "7", rw.Header().Get("X-Etcd-Index"))
+	equals(t, true, strings.Contains(rw.Body.String(), `"errorCode":100`))
+}
+
+func TestDispatch_OtherError(t *testing.T) {
+	rw := dispatch(&testOp{err: errors.New("connection refused")}, "GET", "/")
+
+	equals(t, http.StatusInternalServerError, rw.Code)
+	equals(t, true, strings.Contains(rw.Body.String(), `"cause":"connection refused"`))
+}
+
+func TestDispatch_RejectUnknownParams(t *testing.T) {
+	UnknownParamsPolicy = "reject"
+	defer func() { UnknownParamsPolicy = "ignore" }()
+
+	rw := dispatch(&testOp{result: "ok"}, "GET", "/?name=a&nmae=b")
+
+	equals(t, http.StatusBadRequest, rw.Code)
+	equals(t, true, strings.Contains(rw.Body.String(), "unknown parameters: nmae"))
+}
+
+func TestMethods_NotAllowed(t *testing.T) {
+	m := Methods{
+		"PUT": func() operations.Operation { return &testOp{result: "put"} },
+		"GET": func() operations.Operation { return &testOp{result: "get"} },
+	}
+
+	rw := httptest.NewRecorder()
+	m.ServeHTTP(rw, httptest.NewRequest("PUT", "/", nil))
+	equals(t, "put", rw.Body.String())
+
+	rw = httptest.NewRecorder()
+	m.ServeHTTP(rw, httptest.NewRequest("DELETE", "/", nil))
+	equals(t, http.StatusMethodNotAllowed, rw.Code)
+	equals(t, "GET, PUT", rw.Header().Get("Allow"))
+}
+
+type headerOp struct {
+	testOp
+}
+
+func (op *headerOp) Headers() http.Header {
+	return http.Header{"X-Test": {"value"}}
+}
+
+func TestDispatch_Headers(t *testing.T) {
+	rw := dispatch(&headerOp{testOp{result: "ok"}}, "GET", "/")
+
+	equals(t, "value", rw.Header().Get("X-Test"))
+}
+
+type statusOp struct {
+	testOp
+}
+
+func (op *statusOp) Status() int {
+	return http.StatusCreated
+}
+
+func TestDispatch_Status(t *testing.T) {
+	rw := dispatch(&statusOp{testOp{result: map[string]int{"a": 1}}}, "PUT", "/")
+	equals(t, http.StatusCreated, rw.Code)
+
+	rw = dispatch(&statusOp{testOp{err: models.KeyExists("/foo", 3)}}, "PUT", "/")
+	equals(t, http.StatusPreconditionFailed, rw.Code)
+}
+
+func TestDispatch_EmptyResult(t *testing.T) {
+	rw := dispatch(&headerOp{testOp{result: operations.EmptyResult{}}}, "GET", "/")
+
+	equals(t, http.StatusOK, rw.Code)
+	equals(t, "application/json", rw.Header().Get("Content-Type"))
+	equals(t, "value", rw.Header().Get("X-Test"))
+	equals(t, "", rw.Body.String())
+}
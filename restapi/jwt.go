@@ -0,0 +1,383 @@
+package restapi
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rancher/etcdb/backend"
+	"github.com/rancher/etcdb/models"
+)
+
+// jwksRefresh is how long the keys of the JWKS are cached
+const jwksRefresh = time.Hour
+
+// jwksMinRefresh limits how often the JWKS is fetched again for tokens signed
+// with an unknown key
+const jwksMinRefresh = time.Minute
+
+// jwtLeeway is the clock skew allowed when checking the expiration and not
+// before times of tokens
+const jwtLeeway = time.Minute
+
+// PrefixPermissions are the key prefixes that can be read, and written. Write
+// permission includes read permission.
+type PrefixPermissions struct {
+	Read  []string `json:"read,omitempty"`
+	Write []string `json:"write,omitempty"`
+}
+
+// JWTPermissions grant the permissions of roles to the tokens with the role
+// in their Claim, like groups or roles. The claim is either a string, which is
+// split on spaces like a scope, or an array of strings.
+type JWTPermissions struct {
+	Claim string                       `json:"claim"`
+	Roles map[string]PrefixPermissions `json:"roles"`
+}
+
+// JWTPublicPaths don't require a token, so that clients can discover the
+// server
+var JWTPublicPaths = map[string]bool{"/version": true, "/v2/machines": true}
+
+// jwtKeyPaths are the paths whose key follows in the path, to check the
+// permissions for. Other paths need permission for the root.
+var jwtKeyPaths = []string{"/v2/keys", "/v2/lock", "/mod/v2/lock", "/v2/leader", "/mod/v2/leader"}
+
+// JWTAuth requires requests to have an Authorization: Bearer token signed
+// with one of the keys of the JWKS, from the issuer, and checks the
+// permissions its claims grant for the request's key. The Audience, if set,
+// must be one of the token's audiences.
+type JWTAuth struct {
+	JWKSURL     string
+	Issuer      string
+	Audience    string
+	Permissions JWTPermissions
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+// LoadJWTPermissions reads the permissions from a JSON file
+func LoadJWTPermissions(path string) (JWTPermissions, error) {
+	var perms JWTPermissions
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return perms, err
+	}
+	if err := json.Unmarshal(data, &perms); err != nil {
+		return perms, fmt.Errorf("invalid JWT permissions %s: %v", path, err)
+	}
+	if perms.Claim == "" {
+		return perms, fmt.Errorf("the claim of the JWT permissions %s is required", path)
+	}
+	return perms, nil
+}
+
+// Handler requires a token with permission for the request's key, and
+// responds with etcd's error 110 otherwise. GET and HEAD requests, and POST
+// requests to BasicAuthReadPaths, need read permission, and others write
+// permission.
+func (a *JWTAuth) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if JWTPublicPaths[r.URL.Path] {
+			h.ServeHTTP(rw, r)
+			return
+		}
+
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || token == r.Header.Get("Authorization") {
+			rw.Header().Set("WWW-Authenticate", `Bearer realm="etcdb"`)
+			WriteError(rw, models.Unauthorized("Insufficient credentials"))
+			return
+		}
+		claims, err := a.Verify(token, time.Now())
+		if err != nil {
+			rw.Header().Set("WWW-Authenticate", `Bearer realm="etcdb", error="invalid_token"`)
+			WriteError(rw, models.Unauthorized("invalid token: "+err.Error()))
+			return
+		}
+
+		write := !(r.Method == "GET" || r.Method == "HEAD" ||
+			r.Method == "POST" && BasicAuthReadPaths[r.URL.Path])
+		key := jwtKey(r.URL.Path)
+		if !a.Permissions.Allowed(claims, key, write) {
+			WriteError(rw, models.Unauthorized("permission denied for "+key))
+			return
+		}
+		h.ServeHTTP(rw, r)
+	})
+}
+
+// jwtKey returns the key of the request path, cleaned like the operations
+// clean it so that .. can't escape a permitted prefix, or the root for paths
+// without one
+func jwtKey(path string) string {
+	for _, prefix := range jwtKeyPaths {
+		if path == prefix {
+			return "/"
+		}
+		if strings.HasPrefix(path, prefix+"/") {
+			return backend.CleanKey(strings.TrimPrefix(path, prefix))
+		}
+	}
+	return "/"
+}
+
+// Allowed checks if the roles of the claims grant read, or write, permission
+// for the key
+func (p JWTPermissions) Allowed(claims map[string]interface{}, key string, write bool) bool {
+	var roles []string
+	switch v := claims[p.Claim].(type) {
+	case string:
+		roles = strings.Fields(v)
+	case []interface{}:
+		for _, role := range v {
+			if s, ok := role.(string); ok {
+				roles = append(roles, s)
+			}
+		}
+	}
+
+	for _, role := range roles {
+		perms, ok := p.Roles[role]
+		if !ok {
+			continue
+		}
+		if matchPrefixes(perms.Write, key) || !write && matchPrefixes(perms.Read, key) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchPrefixes(prefixes []string, key string) bool {
+	for _, prefix := range prefixes {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if prefix == "" || key == prefix || strings.HasPrefix(key, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify checks the token's signature, issuer, audience and times, and returns
+// its claims
+func (a *JWTAuth) Verify(token string, now time.Time) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed signature")
+	}
+
+	key, err := a.key(header.Kid, now)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if iss, _ := claims["iss"].(string); iss != a.Issuer {
+		return nil, fmt.Errorf("unexpected issuer %q", iss)
+	}
+	if a.Audience != "" && !hasAudience(claims["aud"], a.Audience) {
+		return nil, errors.New("unexpected audience")
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, errors.New("no expiration time")
+	}
+	if now.Add(-jwtLeeway).After(time.Unix(int64(exp), 0)) {
+		return nil, errors.New("expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(jwtLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return nil, errors.New("not valid yet")
+	}
+	return claims, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return errors.New("malformed token")
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return errors.New("malformed token")
+	}
+	return nil
+}
+
+func hasAudience(aud interface{}, audience string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == audience
+	case []interface{}:
+		for _, a := range v {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// verifySignature checks an RS256/384/512 or ES256/384/512 signature. Other
+// algorithms, like none and the HMAC ones, are refused.
+func verifySignature(alg string, key crypto.PublicKey, signed string, signature []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	}
+	if hash == 0 {
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	switch {
+	case strings.HasPrefix(alg, "RS"):
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("the key isn't an RSA key")
+		}
+		if rsa.VerifyPKCS1v15(rsaKey, hash, digest, signature) != nil {
+			return errors.New("invalid signature")
+		}
+		return nil
+	case strings.HasPrefix(alg, "ES"):
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature)%2 != 0 {
+			return errors.New("the key isn't an EC key")
+		}
+		half := len(signature) / 2
+		r, s := new(big.Int).SetBytes(signature[:half]), new(big.Int).SetBytes(signature[half:])
+		if !ecdsa.Verify(ecKey, digest, r, s) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported algorithm %q", alg)
+}
+
+// key returns the JWKS key with the ID, fetching the JWKS again when the
+// cached keys are old, or don't have the ID
+func (a *JWTAuth) key(kid string, now time.Time) (crypto.PublicKey, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	key, ok := a.keys[kid]
+	stale := now.Sub(a.fetched) > jwksRefresh
+	if (!ok && now.Sub(a.fetched) > jwksMinRefresh) || stale {
+		keys, err := fetchJWKS(a.JWKSURL)
+		if err != nil {
+			if ok {
+				// keep using the cached key until the JWKS is back
+				return key, nil
+			}
+			return nil, fmt.Errorf("error fetching JWKS: %v", err)
+		}
+		a.keys, a.fetched = keys, now
+		key, ok = keys[kid]
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	return key, nil
+}
+
+// fetchJWKS fetches the RSA and EC public keys of a JWKS, by key ID
+func fetchJWKS(url string) (map[string]crypto.PublicKey, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range jwks.Keys {
+		switch k.Kty {
+		case "RSA":
+			n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+			e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			var curve elliptic.Curve
+			switch k.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				continue
+			}
+			x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+			y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+	return keys, nil
+}
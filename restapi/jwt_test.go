@@ -0,0 +1,139 @@
+package restapi
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testIssuer struct {
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+	server *httptest.Server
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	ok(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ok(t, err)
+
+	enc := base64.RawURLEncoding.EncodeToString
+	jwks := map[string]interface{}{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "n": enc(rsaKey.N.Bytes()), "e": enc(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": enc(ecKey.X.Bytes()), "y": enc(ecKey.Y.Bytes())},
+	}}
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(rw).Encode(jwks)
+	}))
+	return &testIssuer{rsaKey, ecKey, server}
+}
+
+func (i *testIssuer) token(t *testing.T, kid string, claims map[string]interface{}) string {
+	header := map[string]string{"alg": "RS256", "kid": kid}
+	if kid == "ec" {
+		header["alg"] = "ES256"
+	}
+	h, err := json.Marshal(header)
+	ok(t, err)
+	c, err := json.Marshal(claims)
+	ok(t, err)
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+
+	digest := sha256.Sum256([]byte(signed))
+	var signature []byte
+	if kid == "ec" {
+		r, s, err := ecdsa.Sign(rand.Reader, i.ecKey, digest[:])
+		ok(t, err)
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	} else {
+		signature, err = rsa.SignPKCS1v15(rand.Reader, i.rsaKey, crypto.SHA256, digest[:])
+		ok(t, err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestJWTAuth_Verify(t *testing.T) {
+	issuer := newTestIssuer(t)
+	defer issuer.server.Close()
+	auth := &JWTAuth{JWKSURL: issuer.server.URL, Issuer: "https://sso", Audience: "etcdb"}
+	now := time.Now()
+	claims := func(iss string, aud interface{}, exp time.Time) map[string]interface{} {
+		return map[string]interface{}{"iss": iss, "aud": aud, "exp": exp.Unix()}
+	}
+
+	_, err := auth.Verify(issuer.token(t, "rsa", claims("https://sso", "etcdb", now.Add(time.Hour))), now)
+	ok(t, err)
+	_, err = auth.Verify(issuer.token(t, "ec", claims("https://sso", []string{"other", "etcdb"}, now.Add(time.Hour))), now)
+	ok(t, err)
+
+	for name, token := range map[string]string{
+		"issuer":    issuer.token(t, "rsa", claims("https://other", "etcdb", now.Add(time.Hour))),
+		"audience":  issuer.token(t, "rsa", claims("https://sso", "other", now.Add(time.Hour))),
+		"expired":   issuer.token(t, "rsa", claims("https://sso", "etcdb", now.Add(-time.Hour))),
+		"key":       issuer.token(t, "unknown", claims("https://sso", "etcdb", now.Add(time.Hour))),
+		"signature": issuer.token(t, "rsa", claims("https://sso", "etcdb", now.Add(time.Hour)))[:20] + "x",
+		"none":      "eyJhbGciOiJub25lIn0.e30.",
+	} {
+		if _, err := auth.Verify(token, now); err == nil {
+			t.Errorf("expected the %s to be refused", name)
+		}
+	}
+}
+
+func TestJWTAuth_Handler(t *testing.T) {
+	issuer := newTestIssuer(t)
+	defer issuer.server.Close()
+	auth := &JWTAuth{JWKSURL: issuer.server.URL, Issuer: "https://sso", Permissions: JWTPermissions{
+		Claim: "groups",
+		Roles: map[string]PrefixPermissions{
+			"apps": {Read: []string{"/app"}, Write: []string{"/app/config/"}},
+			"ops":  {Write: []string{"/"}},
+		},
+	}}
+	h := auth.Handler(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write([]byte("ok"))
+	}))
+	request := func(method, target string, groups ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		if groups != nil {
+			token := issuer.token(t, "rsa", map[string]interface{}{"iss": "https://sso", "exp": time.Now().Add(time.Hour).Unix(), "groups": groups})
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+		return rw
+	}
+
+	equals(t, "ok", request("GET", "/version").Body.String())
+	rw := request("GET", "/v2/keys/app")
+	equals(t, http.StatusUnauthorized, rw.Code)
+	equals(t, `Bearer realm="etcdb"`, rw.Header().Get("WWW-Authenticate"))
+
+	equals(t, "ok", request("GET", "/v2/keys/app/name", "apps").Body.String())
+	equals(t, "ok", request("PUT", "/v2/keys/app/config/port", "apps").Body.String())
+	equals(t, http.StatusUnauthorized, request("PUT", "/v2/keys/app/name", "apps").Code)
+	equals(t, http.StatusUnauthorized, request("GET", "/v2/keys/application", "apps").Code)
+	// the key is cleaned before checking it, like the operations clean it
+	equals(t, http.StatusUnauthorized, request("GET", "/v2/keys/app/../secret", "apps").Code)
+	equals(t, http.StatusUnauthorized, request("PUT", "/v2/keys/app/config/../name", "apps").Code)
+	equals(t, "ok", request("PUT", "/v2/keys/app//config/./port", "apps").Body.String())
+	equals(t, http.StatusUnauthorized, request("POST", "/v2/txn", "apps").Code)
+	equals(t, "ok", request("POST", "/v2/txn", "other", "ops").Body.String())
+
+	rw = request("DELETE", "/v2/keys/app", "apps")
+	equals(t, true, strings.Contains(rw.Body.String(), `"cause":"permission denied for /app"`))
+}
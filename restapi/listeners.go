@@ -0,0 +1,186 @@
+package restapi
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFdsStart is the first file descriptor passed with socket activation,
+// after stdin, stdout and stderr
+const listenFdsStart = 3
+
+// InheritedListener is a listening socket passed with the LISTEN_FDS
+// protocol, with its file descriptor number and its name in LISTEN_FDNAMES,
+// if it was given one
+type InheritedListener struct {
+	net.Listener
+	FD   int
+	Name string
+}
+
+// File returns a copy of the listener's file descriptor, like the File of the
+// net.TCPListener it wraps
+func (l *InheritedListener) File() (*os.File, error) {
+	fl, ok := l.Listener.(interface{ File() (*os.File, error) })
+	if !ok {
+		return nil, fmt.Errorf("can't pass on a %T listener", l.Listener)
+	}
+	return fl.File()
+}
+
+// InheritedListeners returns the listening sockets passed by systemd socket
+// activation, or by a previous etcdb handing them off, with the LISTEN_FDS
+// protocol. LISTEN_PID is checked if set, since a previous etcdb can't know
+// the pid of its successor. The variables are removed from the environment
+// so that they aren't passed on again. The listeners are *InheritedListener,
+// named after LISTEN_FDNAMES if it has a name for each of them.
+func InheritedListeners() ([]net.Listener, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	if pid := os.Getenv("LISTEN_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	count := os.Getenv("LISTEN_FDS")
+	if count == "" {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS: %q", count)
+	}
+
+	var names []string
+	if fdnames := os.Getenv("LISTEN_FDNAMES"); fdnames != "" {
+		names = strings.Split(fdnames, ":")
+	}
+
+	var listeners []net.Listener
+	for fd := listenFdsStart; fd < listenFdsStart+n; fd++ {
+		f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		// FileListener uses a copy of the descriptor
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("inherited file descriptor %d: %v", fd, err)
+		}
+		inherited := &InheritedListener{Listener: l, FD: fd}
+		if len(names) == n {
+			inherited.Name = names[fd-listenFdsStart]
+		}
+		listeners = append(listeners, inherited)
+	}
+	return listeners, nil
+}
+
+// ListenerEnv returns the environment to pass the files of ListenerFiles to a
+// successor in, as its ExtraFiles: the environment without LISTEN_
+// variables, and with LISTEN_FDS. names are the names of the files for
+// LISTEN_FDNAMES, which is only set if one of them isn't empty.
+func ListenerEnv(environ []string, files []*os.File, names []string) []string {
+	var env []string
+	for _, v := range environ {
+		if !strings.HasPrefix(v, "LISTEN_") {
+			env = append(env, v)
+		}
+	}
+	env = append(env, "LISTEN_FDS="+strconv.Itoa(len(files)))
+	for _, name := range names {
+		if name != "" {
+			return append(env, "LISTEN_FDNAMES="+fdNames(names))
+		}
+	}
+	return env
+}
+
+// fdNames joins the names for LISTEN_FDNAMES, with systemd's "unknown" for
+// the files without one
+func fdNames(names []string) string {
+	named := make([]string, len(names))
+	for i, name := range names {
+		named[i] = name
+		if name == "" {
+			named[i] = "unknown"
+		}
+	}
+	return strings.Join(named, ":")
+}
+
+// ListenerFiles returns copies of the listeners' file descriptors, to pass
+// them to a successor. The copies must be closed once it was started.
+func ListenerFiles(listeners []net.Listener) ([]*os.File, error) {
+	var files []*os.File
+	for _, l := range listeners {
+		fl, ok := l.(interface{ File() (*os.File, error) })
+		if !ok {
+			return nil, fmt.Errorf("can't pass on a %T listener", l)
+		}
+		f, err := fl.File()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// FindListener returns the listener listening on the host:port address, or
+// nil if none does. An empty host, or 0.0.0.0 and ::, match any listener on
+// the port that listens on all addresses.
+func FindListener(listeners []net.Listener, addr string) net.Listener {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil
+	}
+	for _, l := range listeners {
+		lhost, lport, err := net.SplitHostPort(l.Addr().String())
+		if err != nil || lport != port {
+			continue
+		}
+		if lhost == host || isUnspecified(host) && isUnspecified(lhost) {
+			return l
+		}
+		if ips, err := net.LookupIP(host); err == nil {
+			for _, ip := range ips {
+				if ip.Equal(net.ParseIP(lhost)) {
+					return l
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// FindInherited returns the inherited listener selected by the listen-fd
+// option of a URL: the one named fd in LISTEN_FDNAMES, or else the one with
+// the file descriptor number fd.
+func FindInherited(listeners []net.Listener, fd string) (net.Listener, error) {
+	for _, l := range listeners {
+		if inherited, ok := l.(*InheritedListener); ok && inherited.Name == fd {
+			return l, nil
+		}
+	}
+	if n, err := strconv.Atoi(fd); err == nil {
+		for _, l := range listeners {
+			if inherited, ok := l.(*InheritedListener); ok && inherited.FD == n {
+				return l, nil
+			}
+		}
+	}
+	if len(listeners) == 0 {
+		return nil, fmt.Errorf("no file descriptor %s was inherited, LISTEN_FDS isn't set", fd)
+	}
+	return nil, fmt.Errorf("no file descriptor %s was inherited, LISTEN_FDS has %d from %d", fd, len(listeners), listenFdsStart)
+}
+
+func isUnspecified(host string) bool {
+	if host == "" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsUnspecified()
+}
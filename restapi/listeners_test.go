@@ -0,0 +1,100 @@
+package restapi
+
+import (
+	"net"
+	"os"
+	"testing"
+)
+
+func TestListen_ReusePort(t *testing.T) {
+	l, err := Listen("127.0.0.1:0", ServerOptions{ReusePort: true})
+	ok(t, err)
+	defer l.Close()
+
+	again, err := Listen(l.Addr().String(), ServerOptions{ReusePort: true})
+	ok(t, err)
+	again.Close()
+
+	if _, err := Listen(l.Addr().String(), ServerOptions{}); err == nil {
+		t.Fatal("expected listening without ReusePort to fail")
+	}
+}
+
+func TestFindListener(t *testing.T) {
+	local, err := net.Listen("tcp", "127.0.0.1:0")
+	ok(t, err)
+	defer local.Close()
+	all, err := net.Listen("tcp", ":0")
+	ok(t, err)
+	defer all.Close()
+	listeners := []net.Listener{local, all}
+
+	_, localPort, _ := net.SplitHostPort(local.Addr().String())
+	_, allPort, _ := net.SplitHostPort(all.Addr().String())
+
+	equals(t, local, FindListener(listeners, "127.0.0.1:"+localPort))
+	equals(t, local, FindListener(listeners, "localhost:"+localPort))
+	equals(t, all, FindListener(listeners, "0.0.0.0:"+allPort))
+	equals(t, all, FindListener(listeners, ":"+allPort))
+	equals(t, nil, FindListener(listeners, "0.0.0.0:"+localPort))
+}
+
+func TestListenerFiles_Inherited(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	ok(t, err)
+	defer l.Close()
+
+	files, err := ListenerFiles([]net.Listener{l})
+	ok(t, err)
+	defer files[0].Close()
+
+	env := ListenerEnv([]string{"HOME=/root", "LISTEN_PID=1", "LISTEN_FDNAMES=etcdb"}, files, []string{""})
+	equals(t, []string{"HOME=/root", "LISTEN_FDS=1"}, env)
+
+	// no inherited listeners without LISTEN_FDS, or for another process
+	os.Setenv("LISTEN_FDS", "1")
+	os.Setenv("LISTEN_PID", "1")
+	inherited, err := InheritedListeners()
+	ok(t, err)
+	equals(t, 0, len(inherited))
+	equals(t, "", os.Getenv("LISTEN_FDS"))
+}
+
+func TestListenerEnv_Names(t *testing.T) {
+	env := ListenerEnv(nil, make([]*os.File, 3), []string{"etcdb", "", "4"})
+	equals(t, []string{"LISTEN_FDS=3", "LISTEN_FDNAMES=etcdb:unknown:4"}, env)
+}
+
+func TestFindInherited(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	ok(t, err)
+	defer l.Close()
+	named := &InheritedListener{Listener: l, FD: 3, Name: "etcdb"}
+	// a successor names the listener after the listen-fd option it was
+	// found by, which may not be its file descriptor number anymore
+	renumbered := &InheritedListener{Listener: l, FD: 4, Name: "3"}
+	listeners := []net.Listener{named, renumbered}
+
+	found, err := FindInherited(listeners, "etcdb")
+	ok(t, err)
+	equals(t, named, found)
+
+	found, err = FindInherited(listeners, "3")
+	ok(t, err)
+	equals(t, renumbered, found)
+
+	found, err = FindInherited(listeners, "4")
+	ok(t, err)
+	equals(t, renumbered, found)
+
+	_, err = FindInherited(listeners, "5")
+	equals(t, "no file descriptor 5 was inherited, LISTEN_FDS has 2 from 3", err.Error())
+
+	_, err = FindInherited(nil, "etcdb")
+	equals(t, "no file descriptor etcdb was inherited, LISTEN_FDS isn't set", err.Error())
+
+	// it can be passed on
+	f, err := named.File()
+	ok(t, err)
+	f.Close()
+}
@@ -0,0 +1,38 @@
+package operations
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/rancher/etcdb/backend"
+)
+
+type AcquireLock struct {
+	params struct {
+		Key     string `path:"key"`
+		Value   string `formData:"value"`
+		TTL     int64  `formData:"ttl"`
+		Timeout *int64 `formData:"timeout"`
+	}
+	Locks *backend.Locks
+}
+
+func (op *AcquireLock) Params() interface{} {
+	return &op.params
+}
+
+// Call blocks until the lock is acquired, and returns the lock index as text
+// like etcd's lock module.
+func (op *AcquireLock) Call() (interface{}, error) {
+	timeout := time.Duration(-1)
+	if op.params.Timeout != nil {
+		timeout = time.Duration(*op.params.Timeout) * time.Second
+	}
+
+	index, err := op.Locks.Acquire(op.params.Key, op.params.Value, op.params.TTL, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return strconv.FormatInt(index, 10), nil
+}
@@ -0,0 +1,40 @@
+package operations
+
+import (
+	"strings"
+
+	"github.com/rancher/etcdb/backend"
+	"github.com/rancher/etcdb/models"
+)
+
+type BulkSet struct {
+	params struct {
+		Values map[string]backend.BulkValue `body:"values"`
+	}
+	Store backend.Store
+}
+
+func (op *BulkSet) Params() interface{} {
+	return &op.params
+}
+
+// Call sets all of the keys in one transaction, and returns the count and the
+// last index.
+func (op *BulkSet) Call() (interface{}, error) {
+	if len(op.params.Values) == 0 {
+		return nil, models.InvalidField("values required")
+	}
+	values := make(map[string]backend.BulkValue, len(op.params.Values))
+	for key, v := range op.params.Values {
+		if !strings.HasPrefix(key, "/") {
+			return nil, models.InvalidField("keys must start with /: " + key)
+		}
+		cleaned := backend.CleanKey(key)
+		if _, ok := values[cleaned]; ok {
+			return nil, models.InvalidField("duplicate key " + cleaned + ": " + key)
+		}
+		values[cleaned] = v
+	}
+
+	return op.Store.BulkSet(values)
+}
@@ -0,0 +1,38 @@
+package operations
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/rancher/etcdb/backend"
+)
+
+type CampaignLeader struct {
+	params struct {
+		Key     string `path:"key"`
+		Name    string `formData:"name"`
+		TTL     int64  `formData:"ttl"`
+		Timeout *int64 `formData:"timeout"`
+	}
+	Elections *backend.Elections
+}
+
+func (op *CampaignLeader) Params() interface{} {
+	return &op.params
+}
+
+// Call blocks until the name is the leader, and returns the election key's
+// modified index as text.
+func (op *CampaignLeader) Call() (interface{}, error) {
+	timeout := time.Duration(-1)
+	if op.params.Timeout != nil {
+		timeout = time.Duration(*op.params.Timeout) * time.Second
+	}
+
+	node, err := op.Elections.Campaign(op.params.Key, op.params.Name, op.params.TTL, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return strconv.FormatInt(node.ModifiedIndex, 10), nil
+}
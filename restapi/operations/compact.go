@@ -0,0 +1,42 @@
+package operations
+
+import (
+	"time"
+
+	"github.com/rancher/etcdb/backend"
+	"github.com/rancher/etcdb/models"
+)
+
+type Compact struct {
+	params struct {
+		Index *int64 `formData:"index"`
+		Age   string `formData:"age"`
+	}
+	Store backend.Store
+}
+
+func (op *Compact) Params() interface{} {
+	return &op.params
+}
+
+// Call compacts the history up to the index, or up to the last change older
+// than the age, and reports the rows removed.
+func (op *Compact) Call() (interface{}, error) {
+	if (op.params.Index == nil) == (op.params.Age == "") {
+		return nil, models.InvalidField("one of index or age required")
+	}
+
+	if op.params.Index != nil {
+		return op.Store.Compact(*op.params.Index)
+	}
+
+	age, err := time.ParseDuration(op.params.Age)
+	if err != nil {
+		return nil, models.InvalidField("invalid age: " + op.params.Age)
+	}
+	index, err := op.Store.IndexBefore(age)
+	if err != nil {
+		return nil, err
+	}
+	return op.Store.Compact(index)
+}
@@ -1,17 +1,26 @@
 package operations
 
 import (
+	"net/http"
+
 	"github.com/rancher/etcdb/backend"
 	"github.com/rancher/etcdb/models"
 )
 
 type CreateInOrderNode struct {
 	params struct {
-		Key   string `path:"key"`
-		Value string `formData:"value"`
-		TTL   *int64 `formData:"ttl"`
+		Key       string  `path:"key"`
+		Value     string  `formData:"value"`
+		TTL       *int64  `formData:"ttl"`
+		PrevValue *string `formData:"prevValue"`
+		PrevIndex *int64  `formData:"prevIndex"`
+		PrevExist *bool   `formData:"prevExist"`
 	}
-	Store *backend.SqlBackend
+	Store backend.Store
+
+	// index and key are the index and key of the created node
+	index int64
+	key   string
 }
 
 func (op *CreateInOrderNode) Params() interface{} {
@@ -19,13 +28,27 @@ func (op *CreateInOrderNode) Params() interface{} {
 }
 
 func (op *CreateInOrderNode) Call() (interface{}, error) {
-	node, err := op.Store.CreateInOrder(op.params.Key, op.params.Value, op.params.TTL)
+	params := op.params
+	condition := backend.SetConditionFor(params.PrevValue, params.PrevIndex, params.PrevExist)
+
+	node, err := op.Store.CreateInOrder(params.Key, params.Value, params.TTL, condition)
+	countStoreOp("create", err)
 	if err != nil {
 		return nil, err
 	}
+	op.index = node.ModifiedIndex
+	op.key = node.Key
 
 	return &models.Action{
 		Action: "create",
 		Node:   *node,
 	}, nil
 }
+
+func (op *CreateInOrderNode) Headers() http.Header {
+	return writeHeaders(op.Store, op.index, op.key)
+}
+
+func (op *CreateInOrderNode) Status() int {
+	return http.StatusCreated
+}
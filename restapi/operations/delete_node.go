@@ -1,6 +1,8 @@
 package operations
 
 import (
+	"net/http"
+
 	"github.com/rancher/etcdb/backend"
 	"github.com/rancher/etcdb/models"
 )
@@ -12,8 +14,15 @@ type DeleteNode struct {
 		PrevIndex *int64  `query:"prevIndex"`
 		Dir       bool    `query:"dir"`
 		Recursive bool    `query:"recursive"`
+		DryRun    bool    `query:"dryRun"`
+		Debug     bool    `query:"debug"`
 	}
-	Store *backend.SqlBackend
+	Store backend.Store
+	// DebugConditions allows the debug parameter
+	DebugConditions bool
+
+	// index is the index of the change
+	index int64
 }
 
 func (op *DeleteNode) Params() interface{} {
@@ -21,16 +30,17 @@ func (op *DeleteNode) Params() interface{} {
 }
 
 func (op *DeleteNode) Call() (interface{}, error) {
-	var condition backend.DeleteCondition
 	params := op.params
+	condition := backend.DeleteConditionFor(params.PrevValue, params.PrevIndex)
+	if params.Debug {
+		if !op.DebugConditions {
+			return nil, models.InvalidField("debug is not enabled")
+		}
+		condition = backend.TraceDelete(condition)
+	}
 
-	switch {
-	case params.PrevValue != nil:
-		condition = backend.PrevValue(*params.PrevValue)
-	case params.PrevIndex != nil:
-		condition = backend.PrevIndex(*params.PrevIndex)
-	default:
-		condition = backend.Always
+	if params.DryRun {
+		return op.Store.DryRunDelete(params.Key, params.Dir || params.Recursive, params.Recursive, condition)
 	}
 
 	var node *models.Node
@@ -42,17 +52,25 @@ func (op *DeleteNode) Call() (interface{}, error) {
 	} else {
 		node, index, err = op.Store.Delete(params.Key, condition)
 	}
+	countStoreOp(condition.DeleteActionName(), err)
 	if err != nil {
 		return nil, err
 	}
+	op.index = index
 
 	return &models.ActionUpdate{
 		Action: condition.DeleteActionName(),
+		// like etcd, the deleted node is still marked as a directory
 		Node: models.Node{
 			Key:           params.Key,
 			CreatedIndex:  node.CreatedIndex,
 			ModifiedIndex: index,
+			Dir:           node.Dir,
 		},
 		PrevNode: node,
 	}, nil
 }
+
+func (op *DeleteNode) Headers() http.Header {
+	return indexHeaders(op.Store, op.index)
+}
@@ -0,0 +1,21 @@
+package operations
+
+import "github.com/rancher/etcdb/backend"
+
+type DeleteSubscription struct {
+	params struct {
+		Name string `path:"name"`
+	}
+	Store backend.Store
+}
+
+func (op *DeleteSubscription) Params() interface{} {
+	return &op.params
+}
+
+func (op *DeleteSubscription) Call() (interface{}, error) {
+	if err := op.Store.DeleteSubscription(op.params.Name); err != nil {
+		return nil, err
+	}
+	return "", nil
+}
@@ -0,0 +1,19 @@
+package operations
+
+import "github.com/rancher/etcdb/backend"
+
+type GetLeader struct {
+	params struct {
+		Key string `path:"key"`
+	}
+	Elections *backend.Elections
+}
+
+func (op *GetLeader) Params() interface{} {
+	return &op.params
+}
+
+// Call returns the name of the current leader as text.
+func (op *GetLeader) Call() (interface{}, error) {
+	return op.Elections.Leader(op.params.Key)
+}
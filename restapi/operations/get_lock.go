@@ -0,0 +1,33 @@
+package operations
+
+import (
+	"strconv"
+
+	"github.com/rancher/etcdb/backend"
+)
+
+type GetLock struct {
+	params struct {
+		Key   string `path:"key"`
+		Field string `formData:"field"`
+	}
+	Locks *backend.Locks
+}
+
+func (op *GetLock) Params() interface{} {
+	return &op.params
+}
+
+// Call returns the current lock holder's value, or its lock index when the
+// field parameter is "index".
+func (op *GetLock) Call() (interface{}, error) {
+	node, err := op.Locks.Holder(op.params.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	if op.params.Field == "index" {
+		return strconv.FormatInt(backend.LockIndex(node), 10), nil
+	}
+	return node.Value, nil
+}
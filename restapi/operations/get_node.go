@@ -1,20 +1,34 @@
 package operations
 
 import (
+	"net/http"
+
 	"github.com/rancher/etcdb/backend"
 	"github.com/rancher/etcdb/models"
 )
 
 type GetNode struct {
 	params struct {
-		Key       string `path:"key"`
-		Wait      bool   `query:"wait"`
-		WaitIndex *int64 `query:"waitIndex"`
-		Recursive bool   `query:"recursive"`
-		Sorted    bool   `query:"sorted"`
+		Key        string `path:"key"`
+		Wait       bool   `query:"wait"`
+		WaitIndex  *int64 `query:"waitIndex"`
+		Recursive  bool   `query:"recursive"`
+		Sorted     bool   `query:"sorted"`
+		AtIndex    *int64 `query:"atIndex"`
+		Exists     bool   `query:"exists"`
+		Quorum     bool   `query:"quorum"`
+		Consistent bool   `query:"consistent"`
+		// KeyGlob is an etcdb extension limiting watches to the keys
+		// matching it
+		KeyGlob string `query:"keyGlob"`
 	}
-	Store   *backend.SqlBackend
+	Store   backend.Store
 	Watcher *backend.ChangeWatcher
+
+	remoteAddr string
+	// readIndex is the index the node was read at, or the watcher's index
+	// for watches
+	readIndex int64
 }
 
 func (op *GetNode) Params() interface{} {
@@ -27,16 +41,56 @@ func (op *GetNode) Call() (interface{}, error) {
 		if op.params.WaitIndex != nil {
 			waitIndex = *op.params.WaitIndex
 		}
-		return op.Watcher.NextChange(op.params.Key, op.params.Recursive, waitIndex)
+		action, err := op.Watcher.NextChangeGlob(op.params.Key, op.params.Recursive, waitIndex, op.remoteAddr, op.params.KeyGlob)
+		if err == backend.ErrWatchTimeout {
+			return EmptyResult{}, nil
+		}
+		if action != nil {
+			op.readIndex = action.EtcdIndex
+		}
+		return action, err
+	}
+
+	if op.params.Exists {
+		err := op.Store.Exists(op.params.Key)
+		countStoreOp("get", err)
+		if err != nil {
+			return nil, err
+		}
+		return &models.Action{
+			Action: "exists",
+			Node:   models.Node{Key: op.params.Key},
+		}, nil
 	}
 
-	node, err := op.Store.Get(op.params.Key, op.params.Recursive)
+	var node *models.Node
+	var err error
+	switch {
+	case op.params.AtIndex != nil && *op.params.AtIndex > 0:
+		node, err = op.Store.GetAt(op.params.Key, op.params.Recursive, *op.params.AtIndex)
+	case op.params.Quorum || op.params.Consistent:
+		node, op.readIndex, err = op.Store.GetConsistent(op.params.Key, op.params.Recursive)
+	default:
+		node, op.readIndex, err = op.Store.GetReplica(op.params.Key, op.params.Recursive)
+	}
+	countStoreOp("get", err)
 	if err != nil {
 		return nil, err
 	}
+	if op.params.Sorted {
+		models.SortNodes(node)
+	}
 
 	return &models.Action{
 		Action: "get",
 		Node:   *node,
 	}, nil
 }
+
+func (op *GetNode) Headers() http.Header {
+	return indexHeaders(op.Store, op.readIndex)
+}
+
+func (op *GetNode) SetRemoteAddr(addr string) {
+	op.remoteAddr = addr
+}
@@ -0,0 +1,17 @@
+package operations
+
+import "github.com/rancher/etcdb/backend"
+
+type KeyspaceUsage struct {
+	params struct{}
+	Store  backend.Store
+}
+
+func (op *KeyspaceUsage) Params() interface{} {
+	return &op.params
+}
+
+// Call reports the usage of the keyspace by top-level key.
+func (op *KeyspaceUsage) Call() (interface{}, error) {
+	return op.Store.KeyspaceUsage()
+}
@@ -0,0 +1,17 @@
+package operations
+
+import "github.com/rancher/etcdb/models"
+
+type ListFeatures struct {
+	params   struct{}
+	Features models.Features
+}
+
+func (op *ListFeatures) Params() interface{} {
+	return &op.params
+}
+
+// Call lists the optional subsystems and whether they are enabled.
+func (op *ListFeatures) Call() (interface{}, error) {
+	return op.Features, nil
+}
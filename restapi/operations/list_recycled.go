@@ -0,0 +1,17 @@
+package operations
+
+import "github.com/rancher/etcdb/backend"
+
+type ListRecycled struct {
+	params struct{}
+	Store  backend.Store
+}
+
+func (op *ListRecycled) Params() interface{} {
+	return &op.params
+}
+
+// Call lists the recursive deletes that can be restored.
+func (op *ListRecycled) Call() (interface{}, error) {
+	return op.Store.Recycled()
+}
@@ -0,0 +1,20 @@
+package operations
+
+import "github.com/rancher/etcdb/backend"
+
+type ListWatches struct {
+	params struct {
+		Limit int `query:"limit"`
+	}
+	Watcher *backend.ChangeWatcher
+}
+
+func (op *ListWatches) Params() interface{} {
+	return &op.params
+}
+
+// Call lists the waiting watches, oldest first, with counts and the state of
+// the change buffer.
+func (op *ListWatches) Call() (interface{}, error) {
+	return op.Watcher.State(op.params.Limit), nil
+}
@@ -1,5 +1,12 @@
 package operations
 
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/rancher/etcdb/backend"
+)
+
 // The Operation interface represents a REST operation.
 type Operation interface {
 	// Params supplies an interface that will be populated by restapi.Unmarshal()
@@ -9,3 +16,53 @@ type Operation interface {
 	// Call returns the result of the REST operation.
 	Call() (interface{}, error)
 }
+
+// A HeaderOperation also sets response headers, which are read after Call().
+type HeaderOperation interface {
+	Operation
+
+	Headers() http.Header
+}
+
+// A RemoteAddrOperation is given the client's address before Call().
+type RemoteAddrOperation interface {
+	Operation
+
+	SetRemoteAddr(addr string)
+}
+
+// A StatusOperation sets the status of successful responses, which are read
+// after Call(), instead of 200 OK.
+type StatusOperation interface {
+	Operation
+
+	Status() int
+}
+
+// An EmptyResult is written as a JSON response without a body, which is how
+// etcd ends watches that timed out. Clients retry the watch.
+type EmptyResult struct{}
+
+// indexHeaders returns the X-Etcd-Index header, which etcd sets on every keys
+// response. It is the index the response is as of, if known, or else the
+// store's current index.
+func indexHeaders(store backend.Store, index int64) http.Header {
+	h := http.Header{}
+	if index > 0 {
+		h.Set("X-Etcd-Index", fmt.Sprint(index))
+	} else if index, err := store.CurrentIndex(); err == nil {
+		h.Set("X-Etcd-Index", fmt.Sprint(index))
+	}
+	return h
+}
+
+// writeHeaders returns the index header for the index of the write, and the
+// quota warning header if the usage is close to the quota of the store or of
+// a prefix the written key is under.
+func writeHeaders(store backend.Store, index int64, key string) http.Header {
+	h := indexHeaders(store, index)
+	if warning := store.QuotaWarning(key); warning != "" {
+		h.Set("X-Etcdb-Quota-Warning", warning)
+	}
+	return h
+}
@@ -0,0 +1,46 @@
+package operations
+
+import (
+	"time"
+
+	"github.com/rancher/etcdb/backend"
+)
+
+type PollSubscription struct {
+	params struct {
+		Name    string `path:"name"`
+		Timeout *int64 `query:"timeout"`
+	}
+	Store   backend.Store
+	Watcher *backend.ChangeWatcher
+}
+
+func (op *PollSubscription) Params() interface{} {
+	return &op.params
+}
+
+// Call waits for the next batch of events for the named subscription, and
+// moves its cursor past them once they are delivered.
+func (op *PollSubscription) Call() (interface{}, error) {
+	sub, err := op.Store.GetSubscription(op.params.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := time.Duration(-1)
+	if op.params.Timeout != nil {
+		timeout = time.Duration(*op.params.Timeout) * time.Second
+	}
+
+	batch, err := op.Watcher.Subscribe(sub, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	err = op.Store.SetSubscriptionCursor(op.params.Name, batch.NextIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	return batch, nil
+}
@@ -0,0 +1,36 @@
+package operations
+
+import (
+	"github.com/rancher/etcdb/backend"
+	"github.com/rancher/etcdb/models"
+)
+
+type ReleaseLock struct {
+	params struct {
+		Key   string  `path:"key"`
+		Index *int64  `formData:"index"`
+		Value *string `formData:"value"`
+	}
+	Locks *backend.Locks
+}
+
+func (op *ReleaseLock) Params() interface{} {
+	return &op.params
+}
+
+func (op *ReleaseLock) Call() (interface{}, error) {
+	var err error
+	switch {
+	case op.params.Index != nil:
+		err = op.Locks.Release(op.params.Key, *op.params.Index)
+	case op.params.Value != nil:
+		err = op.Locks.ReleaseValue(op.params.Key, *op.params.Value)
+	default:
+		return nil, models.InvalidField("index or value required")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return "", nil
+}
@@ -0,0 +1,37 @@
+package operations
+
+import (
+	"github.com/rancher/etcdb/backend"
+	"github.com/rancher/etcdb/models"
+)
+
+type RenewLock struct {
+	params struct {
+		Key   string  `path:"key"`
+		Index *int64  `formData:"index"`
+		Value *string `formData:"value"`
+		TTL   int64   `formData:"ttl"`
+	}
+	Locks *backend.Locks
+}
+
+func (op *RenewLock) Params() interface{} {
+	return &op.params
+}
+
+func (op *RenewLock) Call() (interface{}, error) {
+	var err error
+	switch {
+	case op.params.Index != nil:
+		err = op.Locks.Renew(op.params.Key, *op.params.Index, op.params.TTL)
+	case op.params.Value != nil:
+		err = op.Locks.RenewValue(op.params.Key, *op.params.Value, op.params.TTL)
+	default:
+		return nil, models.InvalidField("index or value required")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return "", nil
+}
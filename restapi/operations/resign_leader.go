@@ -0,0 +1,22 @@
+package operations
+
+import "github.com/rancher/etcdb/backend"
+
+type ResignLeader struct {
+	params struct {
+		Key  string `path:"key"`
+		Name string `formData:"name"`
+	}
+	Elections *backend.Elections
+}
+
+func (op *ResignLeader) Params() interface{} {
+	return &op.params
+}
+
+func (op *ResignLeader) Call() (interface{}, error) {
+	if err := op.Elections.Resign(op.params.Key, op.params.Name); err != nil {
+		return nil, err
+	}
+	return "", nil
+}
@@ -0,0 +1,27 @@
+package operations
+
+import (
+	"github.com/rancher/etcdb/backend"
+	"github.com/rancher/etcdb/models"
+)
+
+type RestoreRecycled struct {
+	params struct {
+		Index int64 `path:"index"`
+	}
+	Store backend.Store
+}
+
+func (op *RestoreRecycled) Params() interface{} {
+	return &op.params
+}
+
+// Call restores the recursive delete at the index, and returns the restored
+// node like a create.
+func (op *RestoreRecycled) Call() (interface{}, error) {
+	node, err := op.Store.Restore(op.params.Index)
+	if err != nil {
+		return nil, err
+	}
+	return &models.ActionUpdate{Action: "create", Node: *node}, nil
+}
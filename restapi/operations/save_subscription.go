@@ -0,0 +1,28 @@
+package operations
+
+import "github.com/rancher/etcdb/backend"
+
+type SaveSubscription struct {
+	params struct {
+		Name string               `path:"name"`
+		Body backend.Subscription `body:"subscription"`
+	}
+	Store backend.Store
+}
+
+func (op *SaveSubscription) Params() interface{} {
+	return &op.params
+}
+
+func (op *SaveSubscription) Call() (interface{}, error) {
+	if err := op.params.Body.Validate(); err != nil {
+		return nil, err
+	}
+
+	err := op.Store.SaveSubscription(op.params.Name, &op.params.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return op.Store.GetSubscription(op.params.Name)
+}
@@ -1,6 +1,8 @@
 package operations
 
 import (
+	"net/http"
+
 	"github.com/rancher/etcdb/backend"
 	"github.com/rancher/etcdb/models"
 )
@@ -14,8 +16,17 @@ type SetNode struct {
 		PrevValue *string `formData:"prevValue"`
 		PrevIndex *int64  `formData:"prevIndex"`
 		PrevExist *bool   `formData:"prevExist"`
+		Debug     bool    `formData:"debug"`
+		// NoValueOnSuccess leaves the nodes out of a successful response
+		NoValueOnSuccess bool `formData:"noValueOnSuccess"`
 	}
-	Store *backend.SqlBackend
+	Store backend.Store
+	// DebugConditions allows the debug parameter
+	DebugConditions bool
+
+	created bool
+	// index is the index of the change
+	index int64
 }
 
 func (op *SetNode) Params() interface{} {
@@ -23,18 +34,13 @@ func (op *SetNode) Params() interface{} {
 }
 
 func (op *SetNode) Call() (interface{}, error) {
-	var condition backend.SetCondition
 	params := op.params
-
-	switch {
-	case params.PrevExist != nil:
-		condition = backend.PrevExist(*params.PrevExist)
-	case params.PrevValue != nil:
-		condition = backend.PrevValue(*params.PrevValue)
-	case params.PrevIndex != nil:
-		condition = backend.PrevIndex(*params.PrevIndex)
-	default:
-		condition = backend.Always
+	condition := backend.SetConditionFor(params.PrevValue, params.PrevIndex, params.PrevExist)
+	if params.Debug {
+		if !op.DebugConditions {
+			return nil, models.InvalidField("debug is not enabled")
+		}
+		condition = backend.TraceSet(condition)
 	}
 
 	var node, prevNode *models.Node
@@ -48,13 +54,32 @@ func (op *SetNode) Call() (interface{}, error) {
 		node, prevNode, err = op.Store.Set(params.Key, params.Value, condition)
 	}
 
+	countStoreOp(condition.SetActionName(), err)
 	if err != nil {
 		return nil, err
 	}
 
+	op.created = prevNode == nil
+	op.index = node.ModifiedIndex
+
+	if params.NoValueOnSuccess {
+		return &models.ActionOnly{Action: condition.SetActionName()}, nil
+	}
 	return &models.ActionUpdate{
 		Action:   condition.SetActionName(),
 		Node:     *node,
 		PrevNode: prevNode,
 	}, nil
 }
+
+func (op *SetNode) Headers() http.Header {
+	return writeHeaders(op.Store, op.index, op.params.Key)
+}
+
+// Status is 201 Created when there was no previous node, as in etcd
+func (op *SetNode) Status() int {
+	if op.created {
+		return http.StatusCreated
+	}
+	return http.StatusOK
+}
@@ -0,0 +1,67 @@
+package operations
+
+import (
+	"expvar"
+
+	"github.com/rancher/etcdb/backend"
+	"github.com/rancher/etcdb/models"
+)
+
+// StoreStats counts the operations on keys by their etcd action and whether
+// they failed, with the names of etcd's store statistics, published with
+// expvar. GetStoreStats serves them in etcd's format.
+var StoreStats = expvar.NewMap("store")
+
+// countStoreOp counts an operation with the etcd action
+func countStoreOp(action string, err error) {
+	switch action {
+	case "get":
+		action = "gets"
+	case "set":
+		action = "sets"
+	}
+	if err != nil {
+		StoreStats.Add(action+"Fail", 1)
+	} else {
+		StoreStats.Add(action+"Success", 1)
+	}
+}
+
+type GetStoreStats struct {
+	params  struct{}
+	Watcher *backend.ChangeWatcher
+}
+
+func (op *GetStoreStats) Params() interface{} {
+	return &op.params
+}
+
+// Call returns the counts of the operations on keys since this instance
+// started, and of the expirations it purged, like etcd's /v2/stats/store.
+func (op *GetStoreStats) Call() (interface{}, error) {
+	return &models.StoreStats{
+		GetsSuccess:             storeCount(StoreStats, "getsSuccess"),
+		GetsFail:                storeCount(StoreStats, "getsFail"),
+		SetsSuccess:             storeCount(StoreStats, "setsSuccess"),
+		SetsFail:                storeCount(StoreStats, "setsFail"),
+		DeleteSuccess:           storeCount(StoreStats, "deleteSuccess"),
+		DeleteFail:              storeCount(StoreStats, "deleteFail"),
+		UpdateSuccess:           storeCount(StoreStats, "updateSuccess"),
+		UpdateFail:              storeCount(StoreStats, "updateFail"),
+		CreateSuccess:           storeCount(StoreStats, "createSuccess"),
+		CreateFail:              storeCount(StoreStats, "createFail"),
+		CompareAndSwapSuccess:   storeCount(StoreStats, "compareAndSwapSuccess"),
+		CompareAndSwapFail:      storeCount(StoreStats, "compareAndSwapFail"),
+		CompareAndDeleteSuccess: storeCount(StoreStats, "compareAndDeleteSuccess"),
+		CompareAndDeleteFail:    storeCount(StoreStats, "compareAndDeleteFail"),
+		ExpireCount:             storeCount(backend.ExpirationStats, "count"),
+		Watchers:                op.Watcher.State(1).WatchCount,
+	}, nil
+}
+
+func storeCount(stats *expvar.Map, name string) int64 {
+	if count, ok := stats.Get(name).(*expvar.Int); ok {
+		return count.Value()
+	}
+	return 0
+}
@@ -0,0 +1,37 @@
+package operations
+
+import (
+	"github.com/rancher/etcdb/backend"
+	"github.com/rancher/etcdb/models"
+)
+
+type Txn struct {
+	params struct {
+		Body struct {
+			Ops []backend.TxnOp `json:"ops"`
+		} `body:"txn"`
+	}
+	Store backend.Store
+}
+
+func (op *Txn) Params() interface{} {
+	return &op.params
+}
+
+// Call applies all of the operations atomically, and returns their results.
+func (op *Txn) Call() (interface{}, error) {
+	if len(op.params.Body.Ops) == 0 {
+		return nil, models.InvalidField("ops required")
+	}
+
+	for i := range op.params.Body.Ops {
+		op.params.Body.Ops[i].Key = backend.CleanKey(op.params.Body.Ops[i].Key)
+	}
+
+	results, err := op.Store.Txn(op.params.Body.Ops)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.TxnResult{Results: results}, nil
+}
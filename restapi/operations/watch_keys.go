@@ -0,0 +1,38 @@
+package operations
+
+import (
+	"time"
+
+	"github.com/rancher/etcdb/backend"
+)
+
+type WatchKeys struct {
+	params struct {
+		Body struct {
+			backend.Subscription
+			// Timeout in seconds to wait for events before returning an empty
+			// batch. Waits forever if not set.
+			Timeout *int64 `json:"timeout"`
+		} `body:"subscription"`
+	}
+	Watcher *backend.ChangeWatcher
+}
+
+func (op *WatchKeys) Params() interface{} {
+	return &op.params
+}
+
+func (op *WatchKeys) Call() (interface{}, error) {
+	body := &op.params.Body
+
+	if err := body.Validate(); err != nil {
+		return nil, err
+	}
+
+	timeout := time.Duration(-1)
+	if body.Timeout != nil {
+		timeout = time.Duration(*body.Timeout) * time.Second
+	}
+
+	return op.Watcher.Subscribe(&body.Subscription, timeout)
+}
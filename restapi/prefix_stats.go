@@ -0,0 +1,107 @@
+package restapi
+
+import (
+	"expvar"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/rancher/etcdb/backend"
+)
+
+// PrefixStats counts the requests for keys by top-level key prefix, like
+// /registry, published with expvar. For each prefix there are counts by
+// operation (get, watch, exists, set, create and delete), and the request and
+// response bytes. Only requests through a CountPrefixes handler are counted.
+var PrefixStats = expvar.NewMap("prefixes")
+
+// MaxPrefixes limits the number of prefixes counted separately, so that keys
+// at the top level don't each get their own stats. Requests for other
+// prefixes are counted under "other".
+var MaxPrefixes = 100
+
+var prefixesMu sync.Mutex
+
+// CountPrefixes counts the requests to the keys handler in PrefixStats. The
+// key is read from the "key" route variable.
+func CountPrefixes(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		stats := prefixStats(topPrefix(backend.CleanKey(mux.Vars(r)["key"])))
+		stats.Add(operationName(r), 1)
+		if r.ContentLength > 0 {
+			stats.Add("bytes_in", r.ContentLength)
+		}
+
+		cw := &countingWriter{ResponseWriter: rw}
+		h.ServeHTTP(cw, r)
+		stats.Add("bytes_out", cw.count)
+	})
+}
+
+// topPrefix returns the first path segment of the key, or "/" for the root
+func topPrefix(key string) string {
+	key = strings.TrimPrefix(key, "/")
+	if i := strings.Index(key, "/"); i >= 0 {
+		key = key[:i]
+	}
+	return "/" + key
+}
+
+func prefixStats(prefix string) *expvar.Map {
+	if stats, ok := PrefixStats.Get(prefix).(*expvar.Map); ok {
+		return stats
+	}
+
+	prefixesMu.Lock()
+	defer prefixesMu.Unlock()
+
+	if stats, ok := PrefixStats.Get(prefix).(*expvar.Map); ok {
+		return stats
+	}
+	count := 0
+	PrefixStats.Do(func(expvar.KeyValue) { count++ })
+	if count >= MaxPrefixes && prefix != "other" {
+		prefix = "other"
+		if stats, ok := PrefixStats.Get(prefix).(*expvar.Map); ok {
+			return stats
+		}
+	}
+
+	stats := new(expvar.Map).Init()
+	PrefixStats.Set(prefix, stats)
+	return stats
+}
+
+// operationName names the keys operation of the request
+func operationName(r *http.Request) string {
+	switch r.Method {
+	case "GET":
+		query := r.URL.Query()
+		if query.Get("wait") == "true" {
+			return "watch"
+		}
+		if query.Get("exists") == "true" {
+			return "exists"
+		}
+		return "get"
+	case "PUT":
+		return "set"
+	case "POST":
+		return "create"
+	case "DELETE":
+		return "delete"
+	}
+	return "other"
+}
+
+type countingWriter struct {
+	http.ResponseWriter
+	count int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.count += int64(n)
+	return n, err
+}
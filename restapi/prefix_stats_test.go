@@ -0,0 +1,69 @@
+package restapi
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestTopPrefix(t *testing.T) {
+	equals(t, "/registry", topPrefix("/registry/pods/a"))
+	equals(t, "/foo", topPrefix("/foo"))
+	equals(t, "/", topPrefix("/"))
+	equals(t, "/", topPrefix(""))
+}
+
+func prefixStat(prefix, name string) string {
+	stats, ok := PrefixStats.Get(prefix).(*expvar.Map)
+	if !ok {
+		return ""
+	}
+	if v := stats.Get(name); v != nil {
+		return v.String()
+	}
+	return ""
+}
+
+func TestCountPrefixes(t *testing.T) {
+	PrefixStats.Init()
+
+	r := mux.NewRouter()
+	r.Handle("/v2/keys{key:/.*}", CountPrefixes(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, "12345")
+	})))
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest("GET", "/v2/keys/registry/a", nil),
+		httptest.NewRequest("GET", "/v2/keys/registry/b?wait=true", nil),
+		httptest.NewRequest("PUT", "/v2/keys/registry/a", strings.NewReader("value=abc")),
+		httptest.NewRequest("DELETE", "/v2/keys/rancher", nil),
+	} {
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	equals(t, "1", prefixStat("/registry", "get"))
+	equals(t, "1", prefixStat("/registry", "watch"))
+	equals(t, "1", prefixStat("/registry", "set"))
+	equals(t, "9", prefixStat("/registry", "bytes_in"))
+	equals(t, "15", prefixStat("/registry", "bytes_out"))
+	equals(t, "1", prefixStat("/rancher", "delete"))
+}
+
+func TestCountPrefixes_Max(t *testing.T) {
+	PrefixStats.Init()
+	MaxPrefixes = 2
+	defer func() { MaxPrefixes = 100 }()
+
+	prefixStats("/a").Add("get", 1)
+	prefixStats("/b").Add("get", 1)
+	prefixStats("/c").Add("get", 1)
+	prefixStats("/d").Add("get", 1)
+
+	equals(t, "", prefixStat("/c", "get"))
+	equals(t, "2", prefixStat("other", "get"))
+}
@@ -0,0 +1,107 @@
+package restapi
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/rancher/etcdb/restapi/operations"
+)
+
+// A Registry collects the operations for each path and method, and any other
+// handlers, and creates the router for them. Paths are gorilla/mux route
+// templates, like /v2/keys{key:/.*}. The operations can be added, replaced or
+// wrapped until Router is called, so that programs can change the API without
+// setting up the routes themselves.
+type Registry struct {
+	// paths are in the order they were first added, which is the order the
+	// routes are matched in
+	paths    []string
+	methods  map[string]Methods
+	handlers map[string]http.Handler
+	wrappers map[string][]func(http.Handler) http.Handler
+}
+
+// NewRegistry creates an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{
+		methods:  make(map[string]Methods),
+		handlers: make(map[string]http.Handler),
+		wrappers: make(map[string][]func(http.Handler) http.Handler),
+	}
+}
+
+func (reg *Registry) addPath(path string) {
+	for _, p := range reg.paths {
+		if p == path {
+			return
+		}
+	}
+	reg.paths = append(reg.paths, path)
+}
+
+// Add adds the operation for the method on the path, replacing any operation
+// or handler already added for them.
+func (reg *Registry) Add(path, method string, newOp func() operations.Operation) {
+	reg.addPath(path)
+	delete(reg.handlers, path)
+	if reg.methods[path] == nil {
+		reg.methods[path] = Methods{}
+	}
+	reg.methods[path][method] = newOp
+}
+
+// AddMethods adds the operations for each of the methods on the path
+func (reg *Registry) AddMethods(path string, methods Methods) {
+	for method, newOp := range methods {
+		reg.Add(path, method, newOp)
+	}
+}
+
+// Handle adds a handler for all of the requests to the path, replacing any
+// operations already added for it.
+func (reg *Registry) Handle(path string, h http.Handler) {
+	reg.addPath(path)
+	delete(reg.methods, path)
+	reg.handlers[path] = h
+}
+
+// Operation returns the operation added for the method on the path, or nil,
+// so that it can be wrapped and added again.
+func (reg *Registry) Operation(path, method string) func() operations.Operation {
+	return reg.methods[path][method]
+}
+
+// Remove removes the operation for the method on the path
+func (reg *Registry) Remove(path, method string) {
+	delete(reg.methods[path], method)
+}
+
+// Wrap wraps the handler of the path, like with CountPrefixes. Wrappers added
+// later wrap the earlier ones.
+func (reg *Registry) Wrap(path string, wrap func(http.Handler) http.Handler) {
+	reg.wrappers[path] = append(reg.wrappers[path], wrap)
+}
+
+// Router creates a router for the paths. Paths without any operations or
+// handler left aren't routed. Paths with duplicate slashes or . and ..
+// elements are routed as they are instead of redirected, which would lose
+// the body of a PUT, and the keys in them are cleaned by Unmarshal.
+func (reg *Registry) Router() *mux.Router {
+	r := mux.NewRouter().SkipClean(true)
+	for _, path := range reg.paths {
+		var h http.Handler
+		if methods := reg.methods[path]; len(methods) > 0 {
+			h = methods
+		} else if handler, ok := reg.handlers[path]; ok {
+			h = handler
+		} else {
+			continue
+		}
+
+		for _, wrap := range reg.wrappers[path] {
+			h = wrap(h)
+		}
+		r.Handle(path, h)
+	}
+	return r
+}
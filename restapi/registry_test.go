@@ -0,0 +1,72 @@
+package restapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rancher/etcdb/restapi/operations"
+)
+
+func serve(h http.Handler, method, target string) *httptest.ResponseRecorder {
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, httptest.NewRequest(method, target, nil))
+	return rw
+}
+
+func TestRegistry_AddAndReplace(t *testing.T) {
+	reg := NewRegistry()
+	reg.AddMethods("/v2/flush", Methods{
+		"GET":  func() operations.Operation { return &testOp{result: "get"} },
+		"POST": func() operations.Operation { return &testOp{result: "flush"} },
+	})
+	reg.Add("/v2/flush", "POST", func() operations.Operation { return &testOp{result: "replaced"} })
+
+	r := reg.Router()
+	equals(t, "get", serve(r, "GET", "/v2/flush").Body.String())
+	equals(t, "replaced", serve(r, "POST", "/v2/flush").Body.String())
+	equals(t, http.StatusMethodNotAllowed, serve(r, "DELETE", "/v2/flush").Code)
+}
+
+func TestRegistry_WrapOperation(t *testing.T) {
+	reg := NewRegistry()
+	reg.Add("/v2/flush", "POST", func() operations.Operation { return &testOp{result: "flush"} })
+
+	newOp := reg.Operation("/v2/flush", "POST")
+	reg.Add("/v2/flush", "POST", func() operations.Operation {
+		op := newOp().(*testOp)
+		op.result = "wrapped " + op.result.(string)
+		return op
+	})
+
+	equals(t, "wrapped flush", serve(reg.Router(), "POST", "/v2/flush").Body.String())
+}
+
+func TestRegistry_Wrap(t *testing.T) {
+	reg := NewRegistry()
+	reg.Handle("/version", http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write([]byte("2"))
+	}))
+	header := func(value string) func(http.Handler) http.Handler {
+		return func(h http.Handler) http.Handler {
+			return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+				rw.Header().Add("X-Wrapped", value)
+				h.ServeHTTP(rw, r)
+			})
+		}
+	}
+	reg.Wrap("/version", header("inner"))
+	reg.Wrap("/version", header("outer"))
+
+	rw := serve(reg.Router(), "GET", "/version")
+	equals(t, "2", rw.Body.String())
+	equals(t, []string{"outer", "inner"}, rw.Header()["X-Wrapped"])
+}
+
+func TestRegistry_Remove(t *testing.T) {
+	reg := NewRegistry()
+	reg.Add("/v2/flush", "POST", func() operations.Operation { return &testOp{result: "flush"} })
+	reg.Remove("/v2/flush", "POST")
+
+	equals(t, http.StatusNotFound, serve(reg.Router(), "POST", "/v2/flush").Code)
+}
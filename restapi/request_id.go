@@ -0,0 +1,87 @@
+package restapi
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"time"
+)
+
+// RequestIDHeader is the header identifying a request in the logs of the
+// client, etcdb and error responses
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength limits the request IDs taken from clients
+const maxRequestIDLength = 128
+
+// RequestID sets the X-Request-ID header of the request and the response to
+// the client's, or to a new random ID if the client didn't send a valid one.
+// Dispatch and WriteError read it to log it and add it to error responses.
+func RequestID(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+			r.Header.Set(RequestIDHeader, id)
+		}
+		rw.Header().Set(RequestIDHeader, id)
+		h.ServeHTTP(rw, r)
+	})
+}
+
+// validRequestID reports whether the ID is short and only of printable ASCII,
+// so that it can't break up log lines
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// LogSlowRequests logs the requests that took longer than the threshold, with
+// their ID, status and duration. Watches are left out, since they wait for a
+// change.
+func LogSlowRequests(threshold time.Duration) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			if operationName(r) == "watch" {
+				h.ServeHTTP(rw, r)
+				return
+			}
+			start := time.Now()
+			sw := &statusWriter{ResponseWriter: rw, status: http.StatusOK}
+			h.ServeHTTP(sw, r)
+			if took := time.Since(start); took > threshold {
+				log.Printf("slow request %s: %s %s %d took %s", r.Header.Get(RequestIDHeader), r.Method, r.URL.Path, sw.status, took)
+			}
+		})
+	}
+}
+
+// statusWriter records the status of the response
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,67 @@
+package restapi
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rancher/etcdb/models"
+)
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	h := RequestID(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Get(RequestIDHeader)
+		WriteError(rw, models.NotFound("/foo", 3))
+	}))
+	request := func(id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/v2/keys/foo", nil)
+		if id != "" {
+			req.Header.Set(RequestIDHeader, id)
+		}
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+		return rw
+	}
+
+	rw := request("app-42")
+	equals(t, "app-42", seen)
+	equals(t, "app-42", rw.Header().Get(RequestIDHeader))
+	equals(t, `{"errorCode":100,"message":"Key not found","cause":"/foo","index":3,"requestId":"app-42"}`+"\n", rw.Body.String())
+
+	// a new ID replaces a missing or invalid one
+	for _, id := range []string{"", "has space", strings.Repeat("x", 129)} {
+		rw = request(id)
+		equals(t, 32, len(seen))
+		equals(t, seen, rw.Header().Get(RequestIDHeader))
+	}
+}
+
+func TestLogSlowRequests(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	h := RequestID(LogSlowRequests(10 * time.Millisecond)(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/keys/slow" {
+			time.Sleep(20 * time.Millisecond)
+		}
+		rw.WriteHeader(http.StatusCreated)
+	})))
+	for _, target := range []string{"/v2/keys/fast", "/v2/keys/slow", "/v2/keys/slow?wait=true"} {
+		req := httptest.NewRequest("PUT", target, nil)
+		if strings.Contains(target, "wait") {
+			req.Method = "GET"
+		}
+		req.Header.Set(RequestIDHeader, "req-"+target[len("/v2/keys/"):])
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	equals(t, 1, strings.Count(buf.String(), "slow request"))
+	equals(t, true, strings.Contains(buf.String(), "slow request req-slow: PUT /v2/keys/slow 201 took"))
+}
@@ -0,0 +1,34 @@
+package restapi
+
+import (
+	"net/http"
+
+	"github.com/rancher/etcdb/models"
+)
+
+// LimitRequestBytes limits request bodies to max bytes, so that a huge value
+// can't exhaust the memory, or exceed the database's packet size. The paths
+// have their own limits instead, for endpoints like /v2/bulk that take many
+// values at once. A limit of 0 is unlimited. Requests whose Content-Length is
+// over the limit are refused right away, and the others fail once their body
+// is read past it, with error 112 and status 413.
+func LimitRequestBytes(max int64, paths map[string]int64) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			limit := max
+			if pathLimit, ok := paths[r.URL.Path]; ok {
+				limit = pathLimit
+			}
+			if limit <= 0 {
+				h.ServeHTTP(rw, r)
+				return
+			}
+			if r.ContentLength > limit {
+				WriteError(rw, models.RequestTooLarge(limit))
+				return
+			}
+			r.Body = http.MaxBytesReader(rw, r.Body, limit)
+			h.ServeHTTP(rw, r)
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package restapi
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLimitRequestBytes(t *testing.T) {
+	h := LimitRequestBytes(16, map[string]int64{"/v2/bulk": 64})(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		Dispatch(&testOp{result: "ok"}, rw, r)
+	}))
+	post := func(path string, body io.Reader) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", path, body)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+		return rw
+	}
+
+	equals(t, "ok", post("/v2/keys/foo", strings.NewReader("name=small")).Body.String())
+
+	rw := post("/v2/keys/foo", strings.NewReader("name="+strings.Repeat("x", 100)))
+	equals(t, http.StatusRequestEntityTooLarge, rw.Code)
+	equals(t, `{"errorCode":112,"message":"Request too large","cause":"the request body is over 16 bytes","index":0}`+"\n", rw.Body.String())
+
+	// without a Content-Length, the limit applies when reading
+	rw = post("/v2/keys/foo", io.MultiReader(strings.NewReader("name="), strings.NewReader(strings.Repeat("x", 100))))
+	equals(t, http.StatusRequestEntityTooLarge, rw.Code)
+
+	// the bulk endpoint has its own limit
+	equals(t, "ok", post("/v2/bulk", strings.NewReader("name="+strings.Repeat("x", 50))).Body.String())
+	rw = post("/v2/bulk", strings.NewReader("name="+strings.Repeat("x", 100)))
+	equals(t, http.StatusRequestEntityTooLarge, rw.Code)
+}
+
+func TestLimitRequestBytes_Unlimited(t *testing.T) {
+	h := LimitRequestBytes(0, map[string]int64{"/v2/bulk": 16})(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		Dispatch(&testOp{result: "ok"}, rw, r)
+	}))
+
+	req := httptest.NewRequest("PUT", "/v2/keys/foo", strings.NewReader("name="+strings.Repeat("x", 100)))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, req)
+	equals(t, "ok", rw.Body.String())
+}
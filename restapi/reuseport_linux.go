@@ -0,0 +1,4 @@
+package restapi
+
+// soReusePort is SO_REUSEPORT, which the syscall package lacks on Linux
+const soReusePort = 0xf
@@ -0,0 +1,91 @@
+package restapi
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+)
+
+// ServerOptions tune the client connections, mostly for many concurrent
+// watches, which each hold a request open.
+type ServerOptions struct {
+	// HTTP2 serves unencrypted HTTP/2 (h2c) with prior knowledge besides
+	// HTTP/1.1, so that a client can send many watches over one connection
+	HTTP2 bool
+	// MaxConcurrentStreams limits the requests per HTTP/2 connection, or is
+	// the default of 250 if 0
+	MaxConcurrentStreams int
+	// IdleTimeout closes keep-alive connections without requests for that
+	// long, or never if 0
+	IdleTimeout time.Duration
+	// KeepAlive is the period of TCP keep-alive probes, which notice clients
+	// that went away while their watches wait. The default of 15 seconds is
+	// used if 0, and probes are disabled if negative.
+	KeepAlive time.Duration
+	// DisableKeepAlives closes the connections after each HTTP/1.1 request
+	DisableKeepAlives bool
+	// TLS serves HTTPS with the config if set, and with HTTP2 also HTTP/2
+	// negotiated with ALPN
+	TLS *tls.Config
+	// ReusePort sets SO_REUSEPORT on the listening sockets, so that another
+	// process can listen on the same address, like a new version started
+	// before this one is drained
+	ReusePort bool
+}
+
+// NewServer creates a server for the handler with the options
+func NewServer(addr string, h http.Handler, opts ServerOptions) *http.Server {
+	s := &http.Server{
+		Addr:        addr,
+		Handler:     h,
+		IdleTimeout: opts.IdleTimeout,
+		Protocols:   new(http.Protocols),
+	}
+	s.Protocols.SetHTTP1(true)
+	if opts.HTTP2 {
+		if opts.TLS != nil {
+			s.Protocols.SetHTTP2(true)
+		} else {
+			s.Protocols.SetUnencryptedHTTP2(true)
+		}
+		s.HTTP2 = &http.HTTP2Config{MaxConcurrentStreams: opts.MaxConcurrentStreams}
+	}
+	s.TLSConfig = opts.TLS
+	s.SetKeepAlivesEnabled(!opts.DisableKeepAlives)
+	return s
+}
+
+// ListenAndServe serves the handler on the address with the options, like
+// http.ListenAndServe, or http.ListenAndServeTLS with TLS set.
+func ListenAndServe(addr string, h http.Handler, opts ServerOptions) error {
+	l, err := Listen(addr, opts)
+	if err != nil {
+		return err
+	}
+	return Serve(NewServer(addr, h, opts), l)
+}
+
+// Listen listens on the TCP address with the keep-alive and reuse-port
+// options.
+func Listen(addr string, opts ServerOptions) (net.Listener, error) {
+	if addr == "" {
+		addr = ":http"
+	}
+	lc := net.ListenConfig{KeepAlive: opts.KeepAlive}
+	if opts.ReusePort {
+		lc.Control = reusePort
+	}
+	return lc.Listen(context.Background(), "tcp", addr)
+}
+
+// Serve serves the server on the listener, with TLS if the server has a
+// TLSConfig.
+func Serve(s *http.Server, l net.Listener) error {
+	if s.TLSConfig != nil {
+		// the certificates are in the config
+		return s.ServeTLS(l, "", "")
+	}
+	return s.Serve(l)
+}
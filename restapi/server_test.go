@@ -0,0 +1,84 @@
+package restapi
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func serveTest(t *testing.T, opts ServerOptions) (string, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	ok(t, err)
+
+	s := NewServer(l.Addr().String(), http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, r.Proto)
+	}), opts)
+	if opts.TLS != nil {
+		go s.ServeTLS(l, "", "")
+		return "https://" + l.Addr().String(), func() { s.Close() }
+	}
+	go s.Serve(l)
+	return "http://" + l.Addr().String(), func() { s.Close() }
+}
+
+func get(client *http.Client, url string) (string, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	return string(body), err
+}
+
+func h2cClient() *http.Client {
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	return &http.Client{Transport: &http.Transport{Protocols: protocols}}
+}
+
+func TestServer_HTTP2(t *testing.T) {
+	url, stop := serveTest(t, ServerOptions{HTTP2: true})
+	defer stop()
+
+	proto, err := get(h2cClient(), url)
+	ok(t, err)
+	equals(t, "HTTP/2.0", proto)
+
+	// HTTP/1.1 clients are still served
+	proto, err = get(http.DefaultClient, url)
+	ok(t, err)
+	equals(t, "HTTP/1.1", proto)
+}
+
+func TestServer_HTTP1Only(t *testing.T) {
+	url, stop := serveTest(t, ServerOptions{})
+	defer stop()
+
+	if _, err := get(h2cClient(), url); err == nil {
+		t.Fatal("expected HTTP/2 to fail without the HTTP2 option")
+	}
+}
+
+func TestServer_TLS(t *testing.T) {
+	// borrows the test certificate, and a client trusting it
+	ts := httptest.NewTLSServer(http.NotFoundHandler())
+	defer ts.Close()
+
+	url, stop := serveTest(t, ServerOptions{HTTP2: true, TLS: &tls.Config{Certificates: ts.TLS.Certificates}})
+	defer stop()
+
+	client := ts.Client()
+	proto, err := get(client, url)
+	ok(t, err)
+	equals(t, "HTTP/1.1", proto)
+
+	h2 := &http.Transport{TLSClientConfig: client.Transport.(*http.Transport).TLSClientConfig, ForceAttemptHTTP2: true}
+	proto, err = get(&http.Client{Transport: h2}, url)
+	ok(t, err)
+	equals(t, "HTTP/2.0", proto)
+}
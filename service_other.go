@@ -0,0 +1,6 @@
+//go:build !windows
+
+package main
+
+// platformCommands are the subcommands only available on some platforms
+var platformCommands map[string]command
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// platformCommands are the subcommands only available on some platforms
+var platformCommands = map[string]command{
+	"service": {"install, uninstall or run etcdb as a Windows service", serviceCommand},
+}
+
+func serviceCommand(args []string) {
+	fs := flag.NewFlagSet("service", flag.ExitOnError)
+	name := fs.String("service-name", "etcdb", "Name of the Windows service, and source of its event log entries.")
+	fs.Usage = func() {
+		cmd := filepath.Base(os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage of %s service:\n\n", cmd)
+		fmt.Fprintf(os.Stderr, "  %s service [options] install [serve options] <postgres|mysql> [datasource]\n", cmd)
+		fmt.Fprintf(os.Stderr, "  %s service [options] uninstall\n", cmd)
+		fmt.Fprintf(os.Stderr, "  %s service [options] run [serve options] <postgres|mysql> [datasource]\n\n", cmd)
+		fmt.Fprintf(os.Stderr, "  install registers a service starting automatically with the serve arguments,\n")
+		fmt.Fprintf(os.Stderr, "  which run uses when started by the service manager.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var err error
+	switch fs.Arg(0) {
+	case "install":
+		err = installService(*name, fs.Args()[1:])
+	case "uninstall":
+		err = uninstallService(*name)
+	case "run":
+		err = runService(*name, fs.Args()[1:])
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// installService registers the service to run this executable with the serve
+// arguments, and the event log source for its logs.
+func installService(name string, args []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	if s, err := m.OpenService(name); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s already exists", name)
+	}
+	config := mgr.Config{
+		DisplayName: name,
+		Description: "etcd API server backed by a SQL database",
+		StartType:   mgr.StartAutomatic,
+	}
+	s, err := m.CreateService(name, exe, config, append([]string{"service", "-service-name", name, "run"}, args...)...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	if err := eventlog.InstallAsEventCreate(name, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+		s.Delete()
+		return fmt.Errorf("installing the event log source: %v", err)
+	}
+	log.Println("installed the service", name)
+	return nil
+}
+
+// uninstallService removes the service and its event log source
+func uninstallService(name string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", name)
+	}
+	defer s.Close()
+	if err := s.Delete(); err != nil {
+		return err
+	}
+	if err := eventlog.Remove(name); err != nil {
+		return fmt.Errorf("removing the event log source: %v", err)
+	}
+	log.Println("uninstalled the service", name)
+	return nil
+}
+
+// runService serves as the service when started by the service manager, with
+// the logs going to the event log, or like serve otherwise.
+func runService(name string, args []string) error {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		return err
+	}
+	if !isService {
+		serve(args)
+		return nil
+	}
+
+	elog, err := eventlog.Open(name)
+	if err != nil {
+		return err
+	}
+	defer elog.Close()
+	log.SetOutput(eventLogWriter{elog})
+
+	return svc.Run(name, &service{args: args})
+}
+
+// service runs serve for the service manager, and drains on stop requests
+type service struct {
+	args []string
+}
+
+func (s *service) Execute(_ []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+
+	drained := make(chan struct{})
+	afterDrain = func() {
+		close(drained)
+		// Execute returns, and the process exits once the service
+		// manager knows that the service stopped
+		select {}
+	}
+	go serve(s.args)
+
+	accepts := svc.AcceptStop | svc.AcceptShutdown
+	status <- svc.Status{State: svc.Running, Accepts: accepts}
+	for {
+		select {
+		case <-drained:
+			return false, 0
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				status <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				select {
+				case shutdown <- syscall.SIGTERM:
+				default:
+					// already draining
+				}
+			}
+		}
+	}
+}
+
+// eventLogWriter writes log lines as information events
+type eventLogWriter struct {
+	elog *eventlog.Log
+}
+
+func (w eventLogWriter) Write(p []byte) (int, error) {
+	if err := w.elog.Info(1, string(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
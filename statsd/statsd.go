@@ -0,0 +1,153 @@
+// Package statsd pushes the expvar metrics of /debug/vars to a statsd or
+// DogStatsD agent.
+package statsd
+
+import (
+	"bytes"
+	"expvar"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxPacketSize keeps the datagrams within the MTU of most networks
+const maxPacketSize = 1432
+
+// Emitter periodically sends the numbers of the published expvar variables
+// as gauges, named after their path in /debug/vars below the prefix, e.g.
+// etcdb.quota.keys. Counters are sent as their running totals, like in
+// /debug/vars.
+type Emitter struct {
+	conn   net.Conn
+	prefix string
+	// tags are added to every metric in the DogStatsD format
+	tags     []string
+	interval time.Duration
+	stop     chan struct{}
+}
+
+// Start creates and starts an Emitter sending over UDP to the address every
+// interval. The tags are name:value or name, and only understood by
+// DogStatsD.
+func Start(addr, prefix string, tags []string, interval time.Duration) (*Emitter, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	e := &Emitter{
+		conn:     conn,
+		prefix:   prefix,
+		tags:     tags,
+		interval: interval,
+		stop:     make(chan struct{}),
+	}
+	go e.Run()
+	return e, nil
+}
+
+// Stop stops the emitter loop
+func (e *Emitter) Stop() {
+	close(e.stop)
+}
+
+// Run sends the metrics every interval until stopped
+func (e *Emitter) Run() {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-e.stop:
+			e.conn.Close()
+			return
+		case <-ticker.C:
+			if err := e.send(); err != nil {
+				log.Println("error sending metrics to statsd:", err)
+			}
+		}
+	}
+}
+
+// send writes the lines of the metrics in as few datagrams as fit them
+func (e *Emitter) send() error {
+	var packet bytes.Buffer
+	for _, line := range e.lines(Gauges(e.prefix)) {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > maxPacketSize {
+			if _, err := e.conn.Write(packet.Bytes()); err != nil {
+				return err
+			}
+			packet.Reset()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	if packet.Len() == 0 {
+		return nil
+	}
+	_, err := e.conn.Write(packet.Bytes())
+	return err
+}
+
+// lines formats the gauges in the statsd line format, sorted by name
+func (e *Emitter) lines(gauges map[string]float64) []string {
+	tags := ""
+	if len(e.tags) > 0 {
+		tags = "|#" + strings.Join(e.tags, ",")
+	}
+	lines := make([]string, 0, len(gauges))
+	for name, value := range gauges {
+		lines = append(lines, name+":"+strconv.FormatFloat(value, 'f', -1, 64)+"|g"+tags)
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+// Gauges returns the numbers of the published expvar variables and their
+// maps by their dotted names below the prefix. Other variables, like
+// cmdline and memstats, are left out.
+func Gauges(prefix string) map[string]float64 {
+	gauges := make(map[string]float64)
+	expvar.Do(func(kv expvar.KeyValue) {
+		addGauges(gauges, join(prefix, kv.Key), kv.Value)
+	})
+	return gauges
+}
+
+func addGauges(gauges map[string]float64, name string, v expvar.Var) {
+	switch v := v.(type) {
+	case *expvar.Int:
+		gauges[name] = float64(v.Value())
+	case *expvar.Float:
+		gauges[name] = v.Value()
+	case *expvar.Map:
+		v.Do(func(kv expvar.KeyValue) {
+			addGauges(gauges, join(name, kv.Key), kv.Value)
+		})
+	}
+}
+
+// join adds the key to the name, with the characters that have a meaning in
+// the statsd format replaced
+func join(name, key string) string {
+	key = strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', '@', '#', ',', ' ', '\n':
+			return '_'
+		}
+		return r
+	}, strings.Trim(key, "/"))
+	key = strings.Replace(key, "/", ".", -1)
+	if name == "" {
+		return key
+	}
+	if key == "" {
+		return name
+	}
+	return fmt.Sprintf("%s.%s", name, key)
+}
@@ -0,0 +1,72 @@
+package statsd
+
+import (
+	"expvar"
+	"fmt"
+	"net"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testStats = expvar.NewMap("statsdtest")
+
+func init() {
+	testStats.Add("requests", 3)
+	testStats.AddFloat("seconds", 1.5)
+	prefixes := new(expvar.Map).Init()
+	prefixes.Add("app|x", 2)
+	testStats.Set("prefixes", prefixes)
+}
+
+func Test_Gauges(t *testing.T) {
+	gauges := Gauges("etcdb")
+	equals(t, float64(3), gauges["etcdb.statsdtest.requests"])
+	equals(t, 1.5, gauges["etcdb.statsdtest.seconds"])
+	equals(t, float64(2), gauges["etcdb.statsdtest.prefixes.app_x"])
+	_, ok := gauges["etcdb.cmdline"]
+	equals(t, false, ok)
+}
+
+func Test_Emitter(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	ok(t, err)
+	defer conn.Close()
+
+	e, err := Start(conn.LocalAddr().String(), "etcdb", []string{"env:test", "canary"}, 10*time.Millisecond)
+	ok(t, err)
+	defer e.Stop()
+
+	buf := make([]byte, maxPacketSize)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	ok(t, err)
+	lines := strings.Split(string(buf[:n]), "\n")
+	equals(t, true, len(lines) > 0)
+	for _, line := range lines {
+		equals(t, true, strings.HasPrefix(line, "etcdb."))
+		equals(t, true, strings.HasSuffix(line, "|g|#env:test,canary"))
+	}
+	equals(t, true, strings.Contains(string(buf[:n]), "etcdb.statsdtest.requests:3|g|#env:test,canary"))
+}
+
+// ok fails the test if an err is not nil.
+func ok(tb testing.TB, err error) {
+	if err != nil {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d: unexpected error: %s\033[39m\n\n", filepath.Base(file), line, err.Error())
+		tb.FailNow()
+	}
+}
+
+// equals fails the test if exp is not equal to act.
+func equals(tb testing.TB, exp, act interface{}) {
+	if !reflect.DeepEqual(exp, act) {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d:\n\n\texp: %#v\n\n\tgot: %#v\033[39m\n\n", filepath.Base(file), line, exp, act)
+		tb.FailNow()
+	}
+}
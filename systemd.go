@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/rancher/etcdb/backend"
+)
+
+// sdNotify sends the state to the systemd notification socket, when etcdb
+// runs as a Type=notify service. It does nothing without $NOTIFY_SOCKET.
+func sdNotify(state string) error {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return nil
+	}
+	if addr[0] == '@' {
+		// abstract socket
+		addr = "\x00" + addr[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// notifyReady tells systemd that the instance serves, once the database
+// answers and its schema is current. A successor started on SIGUSR2 also
+// becomes the service's main process, which requires NotifyAccess=all.
+func notifyReady(store *backend.SqlBackend, parent int) {
+	status, err := store.CheckSchema()
+	if err != nil {
+		log.Println("error checking db schema, not notifying systemd of readiness:", err)
+		return
+	}
+	if !status.Current() {
+		log.Printf("db schema version %d is not current, not notifying systemd of readiness, run the migrate command", status.Version)
+		return
+	}
+	state := "READY=1"
+	if parent != 0 {
+		state = "MAINPID=" + strconv.Itoa(os.Getpid()) + "\n" + state
+	}
+	if err := sdNotify(state); err != nil {
+		log.Println("error notifying systemd:", err)
+	}
+}
+
+// watchdogInterval returns the systemd watchdog timeout for this process, or
+// 0 if the watchdog is off. $WATCHDOG_PID is the pid of the previous etcdb for
+// a successor started on SIGUSR2.
+func watchdogInterval(parent int) time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) && pid != strconv.Itoa(parent) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// errUnresponsive is returned by checkHealth when a check didn't finish in
+// time
+var errUnresponsive = errors.New("no response")
+
+// checkHealth checks that the database answers queries and that the
+// watcher's loop isn't stuck, within the timeout.
+func checkHealth(store *backend.SqlBackend, watcher *backend.ChangeWatcher, timeout time.Duration) error {
+	done := make(chan error, 1)
+	go func() {
+		if _, err := store.CurrentIndex(); err != nil {
+			done <- err
+			return
+		}
+		watcher.State(1)
+		done <- nil
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return errUnresponsive
+	}
+}
+
+// startWatchdog sends WATCHDOG=1 to systemd twice per watchdog timeout while
+// the instance is healthy, so that systemd restarts it when it is wedged.
+func startWatchdog(store *backend.SqlBackend, watcher *backend.ChangeWatcher, parent int) {
+	interval := watchdogInterval(parent)
+	if interval == 0 {
+		return
+	}
+	log.Println("etcdb: sending systemd watchdog keep-alives every", interval/2)
+	go func() {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := checkHealth(store, watcher, interval/2); err != nil {
+				log.Println("etcdb: unhealthy, skipping the systemd watchdog keep-alive:", err)
+				continue
+			}
+			if err := sdNotify("WATCHDOG=1"); err != nil {
+				log.Println("error notifying systemd:", err)
+			}
+		}
+	}()
+}
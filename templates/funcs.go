@@ -0,0 +1,135 @@
+package templates
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/rancher/etcdb/models"
+)
+
+// A KV is a key and its value, as returned by gets
+type KV struct {
+	Key   string
+	Value string
+}
+
+// placeholderFuncs declares the functions of Funcs for parsing, before the
+// keys are known
+var placeholderFuncs = Funcs(nil)
+
+// addNodes adds the node and the nodes under it to the map by key
+func addNodes(nodes map[string]*models.Node, node *models.Node) {
+	nodes[node.Key] = node
+	for _, child := range node.Nodes {
+		addNodes(nodes, child)
+	}
+}
+
+// Funcs are the functions for templates to read the nodes, by key, and some
+// helpers for strings and paths:
+//
+//	getv key [default]  the value of the key, or the default if it is missing
+//	exists key          if the key exists
+//	gets pattern        the keys matching the path.Match pattern, with values
+//	getvs pattern       the values of the keys matching the pattern
+//	ls dir              the names of the keys in the directory
+//	lsdir dir           the names of the directories in the directory
+//	json value          the value decoded from JSON
+//	base, dir, join, split, replace, contains, hasPrefix, toUpper, toLower
+func Funcs(nodes map[string]*models.Node) template.FuncMap {
+	return template.FuncMap{
+		"getv": func(key string, def ...string) (string, error) {
+			if node, ok := nodes[path.Clean(key)]; ok && !node.Dir {
+				return node.Value, nil
+			}
+			if len(def) > 0 {
+				return def[0], nil
+			}
+			return "", fmt.Errorf("key not found: %s", key)
+		},
+		"exists": func(key string) bool {
+			_, ok := nodes[path.Clean(key)]
+			return ok
+		},
+		"gets": func(pattern string) ([]KV, error) {
+			var kvs []KV
+			for _, key := range sortedKeys(nodes) {
+				if node := nodes[key]; !node.Dir {
+					matched, err := path.Match(pattern, key)
+					if err != nil {
+						return nil, err
+					}
+					if matched {
+						kvs = append(kvs, KV{key, node.Value})
+					}
+				}
+			}
+			return kvs, nil
+		},
+		"getvs": func(pattern string) ([]string, error) {
+			var values []string
+			for _, key := range sortedKeys(nodes) {
+				if node := nodes[key]; !node.Dir {
+					matched, err := path.Match(pattern, key)
+					if err != nil {
+						return nil, err
+					}
+					if matched {
+						values = append(values, node.Value)
+					}
+				}
+			}
+			return values, nil
+		},
+		"ls": func(dir string) []string {
+			return children(nodes, dir, false)
+		},
+		"lsdir": func(dir string) []string {
+			return children(nodes, dir, true)
+		},
+		"json": func(value string) (interface{}, error) {
+			var v interface{}
+			err := json.Unmarshal([]byte(value), &v)
+			return v, err
+		},
+		"base":      path.Base,
+		"dir":       path.Dir,
+		"join":      strings.Join,
+		"split":     strings.Split,
+		"replace":   strings.Replace,
+		"contains":  strings.Contains,
+		"hasPrefix": strings.HasPrefix,
+		"toUpper":   strings.ToUpper,
+		"toLower":   strings.ToLower,
+	}
+}
+
+func sortedKeys(nodes map[string]*models.Node) []string {
+	keys := make([]string, 0, len(nodes))
+	for key := range nodes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// children returns the sorted names of the nodes in the directory, only of
+// directories if dirs is set
+func children(nodes map[string]*models.Node, dir string, dirs bool) []string {
+	node, ok := nodes[path.Clean(dir)]
+	if !ok {
+		return nil
+	}
+	var names []string
+	for _, child := range node.Nodes {
+		if !dirs || child.Dir {
+			names = append(names, path.Base(child.Key))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
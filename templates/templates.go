@@ -0,0 +1,262 @@
+// Package templates renders files from templates of the keys under prefixes,
+// again after every change to them, and runs commands to check and reload
+// them, like a built-in confd.
+package templates
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/rancher/etcdb/backend"
+	"github.com/rancher/etcdb/models"
+)
+
+// Stats counts the files rendered and changed, the reloads, and the errors,
+// with expvar.
+var Stats = expvar.NewMap("templates")
+
+// changePoll is how long a resource waits for changes before checking if it
+// was stopped
+const changePoll = 10 * time.Second
+
+// retryDelay is the delay before rendering again after an error
+const retryDelay = 5 * time.Second
+
+// A Resource is a template rendered to a file with the keys under its prefixes
+type Resource struct {
+	// Src is the template file, with the syntax of text/template and the
+	// functions of Funcs
+	Src string `json:"src"`
+	// Dest is the file written
+	Dest string `json:"dest"`
+	// Keys are the prefixes of the keys available to the template
+	Keys []string `json:"keys"`
+	// Mode is the octal mode of the file, 0644 if empty
+	Mode string `json:"mode,omitempty"`
+	// CheckCmd checks the rendered file before it replaces Dest, if set.
+	// {{.src}} is replaced with the rendered file's path.
+	CheckCmd string `json:"checkCmd,omitempty"`
+	// ReloadCmd is run after Dest was changed, if set
+	ReloadCmd string `json:"reloadCmd,omitempty"`
+}
+
+// LoadResources reads a JSON array of resources from the file
+func LoadResources(path string) ([]Resource, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var resources []Resource
+	if err := json.Unmarshal(data, &resources); err != nil {
+		return nil, fmt.Errorf("invalid templates config %s: %v", path, err)
+	}
+	return resources, nil
+}
+
+// A Renderer renders its resources after each change to their keys
+type Renderer struct {
+	store     *backend.SqlBackend
+	watcher   *backend.ChangeWatcher
+	resources []*resource
+	stop      chan struct{}
+}
+
+type resource struct {
+	Resource
+	tmpl *template.Template
+	mode os.FileMode
+}
+
+// Start parses the templates of the resources, and starts rendering them. The
+// files are rendered once at first, and then after changes to their keys.
+func Start(store *backend.SqlBackend, watcher *backend.ChangeWatcher, resources []Resource) (*Renderer, error) {
+	r := &Renderer{store: store, watcher: watcher, stop: make(chan struct{})}
+	for _, res := range resources {
+		parsed, err := parse(res)
+		if err != nil {
+			return nil, err
+		}
+		r.resources = append(r.resources, parsed)
+	}
+	for _, res := range r.resources {
+		go r.run(res)
+	}
+	return r, nil
+}
+
+// Stop stops rendering the resources
+func (r *Renderer) Stop() {
+	close(r.stop)
+}
+
+func parse(res Resource) (*resource, error) {
+	if res.Src == "" || res.Dest == "" || len(res.Keys) == 0 {
+		return nil, fmt.Errorf("template resources need src, dest and keys")
+	}
+	for _, key := range res.Keys {
+		if !strings.HasPrefix(key, "/") {
+			return nil, fmt.Errorf("template keys must start with /: %s", key)
+		}
+	}
+
+	mode := os.FileMode(0644)
+	if res.Mode != "" {
+		m, err := strconv.ParseUint(res.Mode, 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mode for %s: %s", res.Dest, res.Mode)
+		}
+		mode = os.FileMode(m)
+	}
+
+	tmpl, err := template.New(filepath.Base(res.Src)).Funcs(placeholderFuncs).ParseFiles(res.Src)
+	if err != nil {
+		return nil, err
+	}
+	return &resource{Resource: res, tmpl: tmpl, mode: mode}, nil
+}
+
+// run renders the resource, and again after each change to its keys, until
+// stopped
+func (r *Renderer) run(res *resource) {
+	var sub *backend.Subscription
+	for {
+		select {
+		case <-r.stop:
+			return
+		default:
+		}
+
+		if sub == nil {
+			index, err := r.render(res)
+			if err != nil {
+				log.Printf("error rendering %s: %v", res.Dest, err)
+				Stats.Add("errors", 1)
+				select {
+				case <-r.stop:
+					return
+				case <-time.After(retryDelay):
+				}
+				continue
+			}
+			sub = &backend.Subscription{SinceIndex: index + 1}
+			for _, key := range res.Keys {
+				sub.Keys = append(sub.Keys, backend.WatchKey{Key: key, Recursive: true})
+			}
+		}
+
+		batch, err := r.watcher.Subscribe(sub, changePoll)
+		if err != nil {
+			// render from the current keys again, which also handles changes
+			// that are no longer in the history
+			sub = nil
+			continue
+		}
+		if len(batch.Events) > 0 {
+			sub = nil
+			continue
+		}
+		sub.SinceIndex = batch.NextIndex
+	}
+}
+
+// render renders the resource from the current keys, and returns the index
+// they are as of
+func (r *Renderer) render(res *resource) (int64, error) {
+	nodes := make(map[string]*models.Node)
+	var index int64
+	for _, key := range res.Keys {
+		node, nodeIndex, err := r.store.GetConsistent(key, true)
+		if etcdErr, ok := err.(models.Error); ok && etcdErr.ErrorCode == 100 {
+			node, nodeIndex = nil, etcdErr.Index
+		} else if err != nil {
+			return 0, err
+		}
+		if node != nil {
+			addNodes(nodes, node)
+		}
+		// changes between the reads of the prefixes render the file again
+		if index == 0 || nodeIndex < index {
+			index = nodeIndex
+		}
+	}
+
+	var buf bytes.Buffer
+	tmpl, err := res.tmpl.Clone()
+	if err != nil {
+		return 0, err
+	}
+	if err := tmpl.Funcs(Funcs(nodes)).Execute(&buf, nil); err != nil {
+		return 0, err
+	}
+	Stats.Add("renders", 1)
+	return index, res.write(buf.Bytes())
+}
+
+// write replaces the file with the data, after the check command succeeds,
+// and runs the reload command. Nothing is done if the file already has the
+// data.
+func (res *resource) write(data []byte) error {
+	if current, err := ioutil.ReadFile(res.Dest); err == nil && bytes.Equal(current, data) {
+		return nil
+	}
+
+	// the rendered file is in the same directory, so that it can be renamed
+	tmp, err := ioutil.TempFile(filepath.Dir(res.Dest), "."+filepath.Base(res.Dest))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), res.mode); err != nil {
+		return err
+	}
+
+	if res.CheckCmd != "" {
+		if err := runCommand(strings.Replace(res.CheckCmd, "{{.src}}", tmp.Name(), -1)); err != nil {
+			return fmt.Errorf("check failed: %v", err)
+		}
+	}
+	if err := os.Rename(tmp.Name(), res.Dest); err != nil {
+		return err
+	}
+	log.Printf("rendered %s", res.Dest)
+	Stats.Add("changes", 1)
+
+	if res.ReloadCmd != "" {
+		if err := runCommand(res.ReloadCmd); err != nil {
+			return fmt.Errorf("reload failed: %v", err)
+		}
+		Stats.Add("reloads", 1)
+	}
+	return nil
+}
+
+func runCommand(command string) error {
+	shell := []string{"/bin/sh", "-c"}
+	if runtime.GOOS == "windows" {
+		shell = []string{"cmd", "/C"}
+	}
+	out, err := exec.Command(shell[0], shell[1], command).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %v: %s", command, err, bytes.TrimSpace(out))
+	}
+	return nil
+}
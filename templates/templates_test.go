@@ -0,0 +1,124 @@
+package templates
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/rancher/etcdb/models"
+)
+
+func testNodes() map[string]*models.Node {
+	nodes := make(map[string]*models.Node)
+	addNodes(nodes, &models.Node{Key: "/app", Dir: true, Nodes: []*models.Node{
+		{Key: "/app/name", Value: "web"},
+		{Key: "/app/config", Value: `{"port": 80}`},
+		{Key: "/app/upstreams", Dir: true, Nodes: []*models.Node{
+			{Key: "/app/upstreams/b", Value: "10.0.0.2"},
+			{Key: "/app/upstreams/a", Value: "10.0.0.1"},
+		}},
+	}})
+	return nodes
+}
+
+// testResource parses the template text into a resource writing to a file
+// in the directory
+func testResource(t *testing.T, dir, text string) *resource {
+	src := filepath.Join(dir, "test.tmpl")
+	ok(t, ioutil.WriteFile(src, []byte(text), 0644))
+	res, err := parse(Resource{Src: src, Dest: filepath.Join(dir, "test.conf"), Keys: []string{"/app"}})
+	ok(t, err)
+	return res
+}
+
+func execute(t *testing.T, res *resource, nodes map[string]*models.Node) string {
+	var buf bytes.Buffer
+	ok(t, res.tmpl.Funcs(Funcs(nodes)).Execute(&buf, nil))
+	return buf.String()
+}
+
+func TestFuncs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "etcdb-templates")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+
+	res := testResource(t, dir, `name {{getv "/app/name"}}
+missing {{getv "/app/missing" "default"}} {{exists "/app/missing"}}
+{{range gets "/app/upstreams/*"}}server {{base .Key}} {{.Value}}
+{{end}}{{join (getvs "/app/upstreams/*") ","}}
+{{ls "/app"}} {{lsdir "/app"}}
+port {{(json (getv "/app/config")).port}}`)
+
+	equals(t, `name web
+missing default false
+server a 10.0.0.1
+server b 10.0.0.2
+10.0.0.1,10.0.0.2
+[config name upstreams] [upstreams]
+port 80`, execute(t, res, testNodes()))
+}
+
+func TestFuncs_MissingKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "etcdb-templates")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+
+	res := testResource(t, dir, `{{getv "/app/missing"}}`)
+	var buf bytes.Buffer
+	if err := res.tmpl.Funcs(Funcs(testNodes())).Execute(&buf, nil); err == nil {
+		t.Fatal("expected an error for a missing key")
+	}
+}
+
+func TestWrite_CheckAndReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "etcdb-templates")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+
+	res := testResource(t, dir, "")
+	reloaded := filepath.Join(dir, "reloaded")
+	res.CheckCmd = "grep -q ok {{.src}}"
+	res.ReloadCmd = "echo reload >> " + reloaded
+
+	ok(t, res.write([]byte("ok 1\n")))
+	// unchanged, so not reloaded again
+	ok(t, res.write([]byte("ok 1\n")))
+	if err := res.write([]byte("bad\n")); err == nil {
+		t.Fatal("expected the check to fail")
+	}
+
+	data, err := ioutil.ReadFile(res.Dest)
+	ok(t, err)
+	equals(t, "ok 1\n", string(data))
+	data, err = ioutil.ReadFile(reloaded)
+	ok(t, err)
+	equals(t, "reload\n", string(data))
+
+	// the rendered files were removed
+	files, err := filepath.Glob(filepath.Join(dir, ".test.conf*"))
+	ok(t, err)
+	equals(t, 0, len(files))
+}
+
+// ok fails the test if an err is not nil.
+func ok(tb testing.TB, err error) {
+	if err != nil {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d: unexpected error: %s\033[39m\n\n", filepath.Base(file), line, err.Error())
+		tb.FailNow()
+	}
+}
+
+// equals fails the test if exp is not equal to act.
+func equals(tb testing.TB, exp, act interface{}) {
+	if !reflect.DeepEqual(exp, act) {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d:\n\n\texp: %#v\n\n\tgot: %#v\033[39m\n\n", filepath.Base(file), line, exp, act)
+		tb.FailNow()
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+)
+
+// tlsURLOptions are the options of https listen URLs, which override the
+// flags of the same names for that URL
+var tlsURLOptions = map[string]bool{"cert-file": true, "key-file": true, "trusted-ca-file": true}
+
+// listenTLS returns the TLS config of an https listen URL, or nil for http
+func listenTLS(u url.URL) (*tls.Config, error) {
+	if u.Scheme != "https" {
+		return nil, nil
+	}
+	options := u.Query()
+	option := func(name, value string) string {
+		if v := options.Get(name); v != "" {
+			return v
+		}
+		return value
+	}
+	cert, key, ca := option("cert-file", *certFile), option("key-file", *keyFile), option("trusted-ca-file", *trustedCAFile)
+
+	if cert == "" || key == "" {
+		return nil, errors.New("https URLs need -cert-file and -key-file, or their URL options")
+	}
+	pair, err := tls.LoadX509KeyPair(cert, key)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{pair}, MinVersion: tls.VersionTLS12}
+
+	if ca != "" {
+		pem, err := ioutil.ReadFile(ca)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", ca)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rancher/etcdb/backend"
+	"github.com/rancher/etcdb/restapi"
+)
+
+// parentPidEnv names the environment variable with the pid of the etcdb that
+// started this one on SIGUSR2, which is told to drain once this one serves.
+const parentPidEnv = "ETCDB_PARENT_PID"
+
+// shutdown receives the signals to drain on, and the stop requests of the
+// Windows service manager
+var shutdown = make(chan os.Signal, 1)
+
+// afterDrain exits once drained. The Windows service replaces it to report
+// the stop to the service manager before exiting.
+var afterDrain = func() { os.Exit(0) }
+
+// server is a client listener with its HTTP server, and the listen-fd option
+// of its URL, which a successor finds the listener by
+type server struct {
+	listener net.Listener
+	http     *http.Server
+	fd       string
+}
+
+// listen returns the inherited listener selected by the URL's listen-fd
+// option, or else the one on its address, or listens on the address
+func listen(inherited []net.Listener, u url.URL, opts restapi.ServerOptions) (net.Listener, error) {
+	if fd := u.Query().Get(listenFdOption); fd != "" {
+		l, err := restapi.FindInherited(inherited, fd)
+		if err != nil {
+			return nil, fmt.Errorf("error listening on %s: %v", u.String(), err)
+		}
+		log.Println("etcdb: using the inherited listener", fd, "on", l.Addr())
+		return l, nil
+	}
+	if l := restapi.FindListener(inherited, u.Host); l != nil {
+		log.Println("etcdb: using the inherited listener on", l.Addr())
+		return l, nil
+	}
+	return restapi.Listen(u.Host, opts)
+}
+
+// closeUnused closes the inherited listeners that aren't served
+func closeUnused(inherited []net.Listener, servers []server) {
+	for _, l := range inherited {
+		used := false
+		for _, s := range servers {
+			used = used || s.listener == l
+		}
+		if !used {
+			log.Println("etcdb: closing the inherited listener on", l.Addr(), "not in -listen-client-urls")
+			l.Close()
+		}
+	}
+}
+
+// parentPid returns the pid of the etcdb that started this one on SIGUSR2, or
+// 0.
+func parentPid() int {
+	pid, err := strconv.Atoi(os.Getenv(parentPidEnv))
+	os.Unsetenv(parentPidEnv)
+	if err != nil || pid != os.Getppid() {
+		return 0
+	}
+	return pid
+}
+
+// notifyParent tells the etcdb that started this one on SIGUSR2 to drain,
+// now that this one serves on the listeners.
+func notifyParent(pid int) {
+	if pid == 0 {
+		return
+	}
+	log.Println("etcdb: taking over from", pid)
+	if err := signalDrain(pid); err != nil {
+		log.Println("error telling the previous etcdb to drain:", err)
+	}
+}
+
+// drainOnShutdown drains the instance on SIGINT or SIGTERM before exiting. It
+// leaves the members so that clients aren't sent to it, stops accepting
+// connections, ends the waiting watches so that their clients retry them with
+// another instance, and waits up to the timeout for the other requests.
+//
+// On SIGUSR2, which Windows doesn't have, it starts a new etcdb with the same
+// arguments and the listeners, which tells this one to drain once it serves.
+// The members entry is then left to the new one.
+func drainOnShutdown(servers []server, watcher *backend.ChangeWatcher, members *backend.Membership, timeout time.Duration) {
+	signal.Notify(shutdown, syscall.SIGINT, syscall.SIGTERM)
+	upgrade := make(chan os.Signal, 1)
+	if len(upgradeSignals) > 0 {
+		signal.Notify(upgrade, upgradeSignals...)
+	}
+
+	handedOff := false
+	for {
+		select {
+		case <-upgrade:
+			if err := startSuccessor(servers); err != nil {
+				log.Println("error starting the new etcdb, still serving:", err)
+				continue
+			}
+			handedOff = true
+		case sig := <-shutdown:
+			log.Println("etcdb: draining on", sig)
+			if handedOff {
+				members.Handoff()
+			} else {
+				if err := sdNotify("STOPPING=1"); err != nil {
+					log.Println("error notifying systemd:", err)
+				}
+				members.Stop()
+			}
+			drain(servers, watcher, timeout)
+			afterDrain()
+		}
+	}
+}
+
+// startSuccessor starts a new etcdb from the same executable path and
+// arguments, passing it the listeners.
+func startSuccessor(servers []server) error {
+	var listeners []net.Listener
+	var names []string
+	for _, s := range servers {
+		listeners = append(listeners, s.listener)
+		names = append(names, s.fd)
+	}
+	files, err := restapi.ListenerFiles(listeners)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	cmd := exec.Command(os.Args[0], os.Args[1:]...)
+	cmd.Env = append(restapi.ListenerEnv(os.Environ(), files, names), parentPidEnv+"="+strconv.Itoa(os.Getpid()))
+	cmd.ExtraFiles = files
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	log.Println("etcdb: started the new etcdb as", cmd.Process.Pid)
+	// the new etcdb outlives this one, which only reaps it if it fails
+	go cmd.Wait()
+	return nil
+}
+
+func drain(servers []server, watcher *backend.ChangeWatcher, timeout time.Duration) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var wg sync.WaitGroup
+	for _, s := range servers {
+		wg.Add(1)
+		go func(s *http.Server) {
+			defer wg.Done()
+			// closes the listener right away, and the connections once
+			// their requests are done
+			if err := s.Shutdown(ctx); err != nil {
+				log.Println("etcdb: requests still in flight after -drain-timeout:", err)
+			}
+		}(s.http)
+	}
+	watcher.Drain()
+	wg.Wait()
+}
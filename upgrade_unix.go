@@ -0,0 +1,16 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// upgradeSignals start a new etcdb that takes over the listeners
+var upgradeSignals = []os.Signal{syscall.SIGUSR2}
+
+// signalDrain tells the etcdb with the pid to drain
+func signalDrain(pid int) error {
+	return syscall.Kill(pid, syscall.SIGTERM)
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// upgradeSignals is empty, since Windows has no SIGUSR2 to start a new etcdb
+// that takes over the listeners
+var upgradeSignals []os.Signal
+
+// signalDrain isn't supported on Windows, where there is no previous etcdb
+// to drain
+func signalDrain(pid int) error {
+	return errors.New("signals aren't supported on Windows")
+}